@@ -0,0 +1,379 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Package genieacs is a client of the GenieACS NBI (port 7557)
+package genieacs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	TaskGetParameterValues = "getParameterValues"
+	TaskSetParameterValues = "setParameterValues"
+	TaskRefreshObject      = "refreshObject"
+	TaskReboot             = "reboot"
+	TaskFactoryReset       = "factoryReset"
+	TaskDownload           = "download"
+	TaskAddObject          = "addObject"
+	TaskDeleteObject       = "deleteObject"
+
+	FileTypeFirmware = "1 Firmware Upgrade Image"
+)
+
+// Task
+// A GenieACS task, the fields are used by the task name
+type Task struct {
+	ID              string          `json:"_id,omitempty" bson:"_id,omitempty"`
+	Device          string          `json:"device,omitempty" bson:"device,omitempty"`
+	Name            string          `json:"name" bson:"name"`
+	Timestamp       string          `json:"timestamp,omitempty" bson:"timestamp,omitempty"`
+	ParameterNames  []string        `json:"parameterNames,omitempty" bson:"parameterNames,omitempty"`
+	ParameterValues [][]interface{} `json:"parameterValues,omitempty" bson:"parameterValues,omitempty"`
+	ObjectName      string          `json:"objectName,omitempty" bson:"objectName,omitempty"`
+	FileType        string          `json:"fileType,omitempty" bson:"fileType,omitempty"`
+	FileName        string          `json:"fileName,omitempty" bson:"fileName,omitempty"`
+	TargetFileName  string          `json:"targetFileName,omitempty" bson:"targetFileName,omitempty"`
+}
+
+// Validate
+// check the fields required by the task name
+func (t *Task) Validate() error {
+	switch t.Name {
+	case TaskGetParameterValues:
+		if len(t.ParameterNames) == 0 {
+			return fmt.Errorf("%s parameterNames is empty", t.Name)
+		}
+	case TaskSetParameterValues:
+		if len(t.ParameterValues) == 0 {
+			return fmt.Errorf("%s parameterValues is empty", t.Name)
+		}
+		for _, pv := range t.ParameterValues {
+			if len(pv) < 2 || len(pv) > 3 {
+				return fmt.Errorf("%s parameterValues item must be [name, value, type]", t.Name)
+			}
+			if name, ok := pv[0].(string); !ok || name == "" {
+				return fmt.Errorf("%s parameter name is empty", t.Name)
+			}
+		}
+	case TaskRefreshObject, TaskAddObject, TaskDeleteObject:
+		if t.ObjectName == "" {
+			return fmt.Errorf("%s objectName is empty", t.Name)
+		}
+	case TaskDownload:
+		if t.FileName == "" {
+			return fmt.Errorf("%s fileName is empty", t.Name)
+		}
+		if t.FileType == "" {
+			t.FileType = FileTypeFirmware
+		}
+	case TaskReboot, TaskFactoryReset:
+	default:
+		return fmt.Errorf("unsupported task %s", t.Name)
+	}
+	return nil
+}
+
+// Preset
+// A GenieACS preset, the precondition is a filter expression like
+// Tags.pilot IS NOT NULL AND DeviceID.ProductClass = "hEX"
+type Preset struct {
+	ID             string                `json:"_id,omitempty" bson:"_id,omitempty"`
+	Channel        string                `json:"channel" bson:"channel"`
+	Weight         int                   `json:"weight" bson:"weight"`
+	Schedule       string                `json:"schedule" bson:"schedule"`
+	Events         map[string]bool       `json:"events" bson:"events"`
+	Precondition   string                `json:"precondition" bson:"precondition"`
+	Configurations []PresetConfiguration `json:"configurations" bson:"configurations"`
+}
+
+// PresetConfiguration
+type PresetConfiguration struct {
+	Type string        `json:"type" bson:"type"`
+	Name string        `json:"name,omitempty" bson:"name,omitempty"`
+	Args []interface{} `json:"args" bson:"args"`
+}
+
+// Fault
+// A fault of a device session or task, the channel of a task fault is task_<id>
+type Fault struct {
+	ID        string      `json:"_id" bson:"_id"`
+	Device    string      `json:"device" bson:"device"`
+	Channel   string      `json:"channel" bson:"channel"`
+	Code      string      `json:"code" bson:"code"`
+	Message   string      `json:"message" bson:"message"`
+	Detail    interface{} `json:"detail,omitempty" bson:"detail,omitempty"`
+	Retries   int         `json:"retries" bson:"retries"`
+	Timestamp string      `json:"timestamp" bson:"timestamp"`
+}
+
+// File
+// A file of the GenieACS file server, the id is the file name of the download tasks
+type File struct {
+	ID       string       `json:"_id" bson:"_id"`
+	Length   int64        `json:"length" bson:"length"`
+	Metadata FileMetadata `json:"metadata" bson:"metadata"`
+}
+
+// FileMetadata
+type FileMetadata struct {
+	FileType     string `json:"fileType" bson:"fileType"`
+	Oui          string `json:"oui" bson:"oui"`
+	ProductClass string `json:"productClass" bson:"productClass"`
+	Version      string `json:"version" bson:"version"`
+}
+
+// TaskId
+// the task id of a task fault
+func (f Fault) TaskId() string {
+	if strings.HasPrefix(f.Channel, "task_") {
+		return f.Channel[5:]
+	}
+	return ""
+}
+
+// TaskResult
+// Executed is set when the device processed the task in the connection
+// request session, otherwise the task is queued until the next inform
+type TaskResult struct {
+	Task     Task
+	Executed bool
+	Status   string
+}
+
+// Client
+type Client struct {
+	Url        string
+	Username   string
+	Password   string
+	HttpClient *http.Client
+}
+
+func NewClient(nbiurl, username, password string, timeout time.Duration) *Client {
+	return &Client{
+		Url:        strings.TrimRight(nbiurl, "/"),
+		Username:   username,
+		Password:   password,
+		HttpClient: &http.Client{Timeout: timeout},
+	}
+}
+
+func (c *Client) do(method, path string, query url.Values, body interface{}) (*http.Response, []byte, error) {
+	var reader io.Reader
+	var contentType = "application/json"
+	switch b := body.(type) {
+	case nil:
+	case []byte:
+		// the provision scripts
+		reader = bytes.NewReader(b)
+		contentType = "application/javascript"
+	default:
+		bs, err := json.Marshal(body)
+		if err != nil {
+			return nil, nil, err
+		}
+		reader = bytes.NewReader(bs)
+	}
+	u := c.Url + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, u, reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.Username != "" {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+	resp, err := c.HttpClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode >= 300 {
+		return resp, data, fmt.Errorf("genieacs %s %s error, %s %s", method, path, resp.Status, strings.TrimSpace(string(data)))
+	}
+	return resp, data, nil
+}
+
+// AddTask
+// POST /devices/<id>/tasks, with connectionRequest the device is asked to
+// connect and the task runs in that session if it completes within the timeout
+func (c *Client) AddTask(deviceId string, task *Task, connectionRequest bool, timeout time.Duration) (*TaskResult, error) {
+	query := url.Values{}
+	if connectionRequest {
+		query.Set("connection_request", "")
+		if timeout > 0 {
+			query.Set("timeout", strconv.FormatInt(int64(timeout/time.Millisecond), 10))
+		}
+	}
+	resp, data, err := c.do(http.MethodPost, "/devices/"+url.PathEscape(deviceId)+"/tasks", query, task)
+	if err != nil {
+		return nil, err
+	}
+	result := &TaskResult{Executed: resp.StatusCode == http.StatusOK, Status: resp.Status}
+	if err = json.Unmarshal(data, &result.Task); err != nil {
+		return nil, fmt.Errorf("decode genieacs task error, %s", err.Error())
+	}
+	return result, nil
+}
+
+func queryValues(query interface{}) (url.Values, error) {
+	values := url.Values{}
+	if query != nil {
+		bs, err := json.Marshal(query)
+		if err != nil {
+			return nil, err
+		}
+		values.Set("query", string(bs))
+	}
+	return values, nil
+}
+
+// GetTasks
+// GET /tasks/?query=<mongo query>
+func (c *Client) GetTasks(query interface{}) ([]Task, error) {
+	values, err := queryValues(query)
+	if err != nil {
+		return nil, err
+	}
+	_, data, err := c.do(http.MethodGet, "/tasks/", values, nil)
+	if err != nil {
+		return nil, err
+	}
+	tasks := make([]Task, 0)
+	err = json.Unmarshal(data, &tasks)
+	return tasks, err
+}
+
+// GetDevices
+// GET /devices/?query=<mongo query>&projection=<paths>, the devices are
+// nested parameter documents like the GenieACS devices collection
+func (c *Client) GetDevices(query interface{}, projection []string) ([]map[string]interface{}, error) {
+	values, err := queryValues(query)
+	if err != nil {
+		return nil, err
+	}
+	if len(projection) > 0 {
+		values.Set("projection", strings.Join(projection, ","))
+	}
+	_, data, err := c.do(http.MethodGet, "/devices/", values, nil)
+	if err != nil {
+		return nil, err
+	}
+	devices := make([]map[string]interface{}, 0)
+	err = json.Unmarshal(data, &devices)
+	return devices, err
+}
+
+// DeleteTask
+func (c *Client) DeleteTask(id string) error {
+	_, _, err := c.do(http.MethodDelete, "/tasks/"+url.PathEscape(id), nil, nil)
+	return err
+}
+
+// RetryTask
+// clear the fault of the task, it runs again in the next session
+func (c *Client) RetryTask(id string) error {
+	_, _, err := c.do(http.MethodPost, "/tasks/"+url.PathEscape(id)+"/retry", nil, nil)
+	return err
+}
+
+// GetFaults
+// GET /faults/?query=<mongo query>
+func (c *Client) GetFaults(query interface{}) ([]Fault, error) {
+	values, err := queryValues(query)
+	if err != nil {
+		return nil, err
+	}
+	_, data, err := c.do(http.MethodGet, "/faults/", values, nil)
+	if err != nil {
+		return nil, err
+	}
+	faults := make([]Fault, 0)
+	err = json.Unmarshal(data, &faults)
+	return faults, err
+}
+
+// GetFiles
+// GET /files/?query=<mongo query>
+func (c *Client) GetFiles(query interface{}) ([]File, error) {
+	values, err := queryValues(query)
+	if err != nil {
+		return nil, err
+	}
+	_, data, err := c.do(http.MethodGet, "/files/", values, nil)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]File, 0)
+	err = json.Unmarshal(data, &files)
+	return files, err
+}
+
+// DeleteFault
+func (c *Client) DeleteFault(id string) error {
+	_, _, err := c.do(http.MethodDelete, "/faults/"+url.PathEscape(id), nil, nil)
+	return err
+}
+
+// PutPreset
+// create or replace the preset
+func (c *Client) PutPreset(preset *Preset) error {
+	if preset.ID == "" {
+		return fmt.Errorf("preset name is empty")
+	}
+	_, _, err := c.do(http.MethodPut, "/presets/"+url.PathEscape(preset.ID), nil, preset)
+	return err
+}
+
+// DeletePreset
+func (c *Client) DeletePreset(name string) error {
+	_, _, err := c.do(http.MethodDelete, "/presets/"+url.PathEscape(name), nil, nil)
+	return err
+}
+
+// PutProvision
+// create or replace the provision script
+func (c *Client) PutProvision(name, script string) error {
+	if name == "" {
+		return fmt.Errorf("provision name is empty")
+	}
+	_, _, err := c.do(http.MethodPut, "/provisions/"+url.PathEscape(name), nil, []byte(script))
+	return err
+}
+
+// DeleteProvision
+func (c *Client) DeleteProvision(name string) error {
+	_, _, err := c.do(http.MethodDelete, "/provisions/"+url.PathEscape(name), nil, nil)
+	return err
+}
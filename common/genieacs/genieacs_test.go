@@ -0,0 +1,150 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package genieacs_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ca17/teamsacs/common/genieacs"
+	"github.com/ca17/teamsacs/common/genieacs/genieacstest"
+)
+
+func TestTaskValidate(t *testing.T) {
+	valid := []genieacs.Task{
+		{Name: genieacs.TaskReboot},
+		{Name: genieacs.TaskGetParameterValues, ParameterNames: []string{"Device.DeviceInfo."}},
+		{Name: genieacs.TaskSetParameterValues, ParameterValues: [][]interface{}{{"Device.ManagementServer.PeriodicInformInterval", 300, "xsd:unsignedInt"}}},
+		{Name: genieacs.TaskAddObject, ObjectName: "Device.IP.Interface"},
+		{Name: genieacs.TaskDownload, FileName: "routeros-7.1.npk"},
+	}
+	for _, task := range valid {
+		if err := task.Validate(); err != nil {
+			t.Errorf("%s: %s", task.Name, err)
+		}
+	}
+	invalid := []genieacs.Task{
+		{Name: "upload"},
+		{Name: genieacs.TaskGetParameterValues},
+		{Name: genieacs.TaskSetParameterValues, ParameterValues: [][]interface{}{{"Device.X"}}},
+		{Name: genieacs.TaskRefreshObject},
+		{Name: genieacs.TaskDownload},
+	}
+	for _, task := range invalid {
+		if err := task.Validate(); err == nil {
+			t.Errorf("%s must be invalid", task.Name)
+		}
+	}
+	download := genieacs.Task{Name: genieacs.TaskDownload, FileName: "fw.bin"}
+	_ = download.Validate()
+	if download.FileType != genieacs.FileTypeFirmware {
+		t.Errorf("download default file type %s", download.FileType)
+	}
+}
+
+func TestClientTasks(t *testing.T) {
+	server := genieacstest.NewServer("CPE-0001", "CPE-0002")
+	defer server.Close()
+	server.SetOnline("CPE-0001", true)
+	server.Exec = func(device string, task genieacs.Task) *genieacs.Fault {
+		if task.Name == genieacs.TaskFactoryReset {
+			return &genieacs.Fault{Code: "cwmp.9001", Message: "Request denied"}
+		}
+		return nil
+	}
+	client := genieacs.NewClient(server.URL+"/", "", "", time.Second*5)
+
+	// executed in the connection request session
+	result, err := client.AddTask("CPE-0001", &genieacs.Task{Name: genieacs.TaskReboot}, true, time.Second*3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Executed || result.Task.ID == "" || result.Task.Device != "CPE-0001" {
+		t.Fatalf("reboot result %+v", result)
+	}
+
+	// the offline device queues the task
+	result, err = client.AddTask("CPE-0002", &genieacs.Task{Name: genieacs.TaskRefreshObject, ObjectName: "Device."}, true, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Executed {
+		t.Fatal("offline device task must be queued")
+	}
+	tasks, err := client.GetTasks(map[string]interface{}{"device": "CPE-0002"})
+	if err != nil || len(tasks) != 1 || tasks[0].ID != result.Task.ID {
+		t.Fatalf("queued tasks %+v %v", tasks, err)
+	}
+
+	// faulted task
+	result, err = client.AddTask("CPE-0001", &genieacs.Task{Name: genieacs.TaskFactoryReset}, true, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Executed {
+		t.Fatal("faulted task must not be executed")
+	}
+	faults, err := client.GetFaults(map[string]interface{}{"device": map[string]interface{}{"$in": []string{"CPE-0001"}}})
+	if err != nil || len(faults) != 1 {
+		t.Fatalf("faults %+v %v", faults, err)
+	}
+	if faults[0].TaskId() != result.Task.ID || faults[0].Code != "cwmp.9001" {
+		t.Fatalf("fault error %+v", faults[0])
+	}
+	if err = client.RetryTask(result.Task.ID); err != nil {
+		t.Fatal(err)
+	}
+	if faults, _ = client.GetFaults(nil); len(faults) != 0 {
+		t.Fatalf("retry must clear the fault, %+v", faults)
+	}
+	if err = client.DeleteTask(result.Task.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = client.AddTask("CPE-9999", &genieacs.Task{Name: genieacs.TaskReboot}, false, 0)
+	if err == nil || !strings.Contains(err.Error(), "404") {
+		t.Fatalf("unknown device must fail, %v", err)
+	}
+}
+
+func TestClientDevices(t *testing.T) {
+	server := genieacstest.NewServer("CPE-0001", "CPE-0002")
+	defer server.Close()
+	server.SetParam("CPE-0001", "Device.DeviceInfo.SoftwareVersion", "6.46.8")
+	server.SetDeviceField("CPE-0002", "_tags", []interface{}{"pilot"})
+
+	client := genieacs.NewClient(server.URL, "", "", time.Second*5)
+	devices, err := client.GetDevices(map[string]interface{}{"_id": "CPE-0001"}, []string{"Device.DeviceInfo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(devices) != 1 || devices[0]["_id"] != "CPE-0001" {
+		t.Fatalf("devices %v", devices)
+	}
+	info := devices[0]["Device"].(map[string]interface{})["DeviceInfo"].(map[string]interface{})
+	if info["SoftwareVersion"].(map[string]interface{})["_value"] != "6.46.8" {
+		t.Fatalf("device info %v", info)
+	}
+	devices, err = client.GetDevices(map[string]interface{}{"_tags": "pilot"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(devices) != 1 || devices[0]["_id"] != "CPE-0002" {
+		t.Fatalf("devices %v", devices)
+	}
+}
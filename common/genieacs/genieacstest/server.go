@@ -0,0 +1,449 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Package genieacstest provides a local GenieACS NBI stub for tests
+package genieacstest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ca17/teamsacs/common/genieacs"
+)
+
+// Server
+// an in-memory GenieACS NBI, devices answer the connection request when
+// they are online, Exec decides the fault of an executed task
+type Server struct {
+	*httptest.Server
+	lock       sync.Mutex
+	seq        int
+	online     map[string]bool
+	devices    map[string]map[string]interface{}
+	presets    map[string]genieacs.Preset
+	provisions map[string]string
+	tasks      []genieacs.Task
+	faults     []genieacs.Fault
+	files      []genieacs.File
+	executed   []genieacs.Task
+
+	// Exec returns the fault of a task, nil for success
+	Exec func(device string, task genieacs.Task) *genieacs.Fault
+}
+
+// NewServer
+// start the stub with the known devices
+func NewServer(devices ...string) *Server {
+	s := &Server{
+		online:     make(map[string]bool),
+		devices:    make(map[string]map[string]interface{}),
+		presets:    make(map[string]genieacs.Preset),
+		provisions: make(map[string]string),
+	}
+	for _, d := range devices {
+		s.online[d] = false
+		s.devices[d] = map[string]interface{}{"_id": d, "_tags": []interface{}{}}
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// SetOnline
+// an online device answers the connection request
+func (s *Server) SetOnline(device string, online bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.online[device] = online
+}
+
+// SetParam
+// set the value of a parameter of the device document, like Device.DeviceInfo.SoftwareVersion
+func (s *Server) SetParam(device, path string, value interface{}) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	node := s.devices[device]
+	if node == nil {
+		return
+	}
+	for _, name := range strings.Split(path, ".") {
+		child, ok := node[name].(map[string]interface{})
+		if !ok {
+			child = map[string]interface{}{}
+			node[name] = child
+		}
+		node = child
+	}
+	node["_value"] = value
+	node["_timestamp"] = time.Now().UTC().Format(time.RFC3339)
+	node["_writable"] = false
+}
+
+// SetDeviceField
+// set a top level field of the device document, like _deviceId or _tags
+func (s *Server) SetDeviceField(device, name string, value interface{}) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if doc := s.devices[device]; doc != nil {
+		doc[name] = value
+	}
+}
+
+// Presets
+func (s *Server) Presets() map[string]genieacs.Preset {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	result := make(map[string]genieacs.Preset, len(s.presets))
+	for k, v := range s.presets {
+		result[k] = v
+	}
+	return result
+}
+
+// Provisions
+// the provision scripts by name
+func (s *Server) Provisions() map[string]string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	result := make(map[string]string, len(s.provisions))
+	for k, v := range s.provisions {
+		result[k] = v
+	}
+	return result
+}
+
+// Tasks
+// the queued tasks
+func (s *Server) Tasks() []genieacs.Task {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]genieacs.Task(nil), s.tasks...)
+}
+
+// Faults
+func (s *Server) Faults() []genieacs.Fault {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]genieacs.Fault(nil), s.faults...)
+}
+
+// AddFile
+// a file uploaded to the file server
+func (s *Server) AddFile(file genieacs.File) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.files = append(s.files, file)
+}
+
+// Executed
+// the tasks processed by the devices
+func (s *Server) Executed() []genieacs.Task {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]genieacs.Task(nil), s.executed...)
+}
+
+// Inform
+// the device starts a session and processes the queued tasks,
+// faulted tasks stay in the queue
+func (s *Server) Inform(device string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if doc := s.devices[device]; doc != nil {
+		doc["_lastInform"] = time.Now().UTC().Format(time.RFC3339)
+	}
+	s.processTasks(device)
+}
+
+func (s *Server) processTasks(device string) {
+	queued := make([]genieacs.Task, 0, len(s.tasks))
+	for _, t := range s.tasks {
+		if t.Device != device || s.hasFault(t.ID) {
+			queued = append(queued, t)
+			continue
+		}
+		if s.Exec != nil {
+			if fault := s.Exec(device, t); fault != nil {
+				fault.ID = device + ":task_" + t.ID
+				fault.Device = device
+				fault.Channel = "task_" + t.ID
+				fault.Timestamp = time.Now().UTC().Format(time.RFC3339)
+				s.faults = append(s.faults, *fault)
+				queued = append(queued, t)
+				continue
+			}
+		}
+		s.executed = append(s.executed, t)
+	}
+	s.tasks = queued
+}
+
+func (s *Server) hasFault(taskId string) bool {
+	for _, f := range s.faults {
+		if f.Channel == "task_"+taskId {
+			return true
+		}
+	}
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// match
+// the equality and $in conditions of a query on string fields
+func match(query map[string]interface{}, field func(name string) string) bool {
+	for k, cond := range query {
+		v := field(k)
+		switch c := cond.(type) {
+		case string:
+			if v != c {
+				return false
+			}
+		case map[string]interface{}:
+			in, _ := c["$in"].([]interface{})
+			found := false
+			for _, item := range in {
+				if item == v {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func parseQuery(r *http.Request) (map[string]interface{}, error) {
+	query := make(map[string]interface{})
+	if q := r.URL.Query().Get("query"); q != "" {
+		if err := json.Unmarshal([]byte(q), &query); err != nil {
+			return nil, err
+		}
+	}
+	return query, nil
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case len(parts) == 3 && parts[0] == "devices" && parts[2] == "tasks" && r.Method == http.MethodPost:
+		s.addTask(w, r, parts[1])
+	case len(parts) == 1 && parts[0] == "devices" && r.Method == http.MethodGet:
+		query, err := parseQuery(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		tag, _ := query["_tags"].(string)
+		delete(query, "_tags")
+		result := make([]map[string]interface{}, 0)
+		for id, doc := range s.devices {
+			if tag != "" && !hasTag(doc, tag) {
+				continue
+			}
+			if match(query, func(name string) string {
+				if name == "_id" {
+					return id
+				}
+				return ""
+			}) {
+				result = append(result, doc)
+			}
+		}
+		writeJSON(w, http.StatusOK, result)
+	case len(parts) == 2 && parts[0] == "presets" && r.Method == http.MethodPut:
+		var preset genieacs.Preset
+		if err := json.NewDecoder(r.Body).Decode(&preset); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		preset.ID = parts[1]
+		s.presets[parts[1]] = preset
+		w.WriteHeader(http.StatusOK)
+	case len(parts) == 2 && parts[0] == "presets" && r.Method == http.MethodDelete:
+		delete(s.presets, parts[1])
+		w.WriteHeader(http.StatusOK)
+	case len(parts) == 2 && parts[0] == "provisions" && r.Method == http.MethodPut:
+		script, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.provisions[parts[1]] = string(script)
+		w.WriteHeader(http.StatusOK)
+	case len(parts) == 2 && parts[0] == "provisions" && r.Method == http.MethodDelete:
+		delete(s.provisions, parts[1])
+		w.WriteHeader(http.StatusOK)
+	case len(parts) == 1 && parts[0] == "tasks" && r.Method == http.MethodGet:
+		query, err := parseQuery(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		result := make([]genieacs.Task, 0)
+		for _, t := range s.tasks {
+			if match(query, func(name string) string {
+				switch name {
+				case "_id":
+					return t.ID
+				case "device":
+					return t.Device
+				case "name":
+					return t.Name
+				}
+				return ""
+			}) {
+				result = append(result, t)
+			}
+		}
+		writeJSON(w, http.StatusOK, result)
+	case len(parts) == 2 && parts[0] == "tasks" && r.Method == http.MethodDelete:
+		for i, t := range s.tasks {
+			if t.ID == parts[1] {
+				s.tasks = append(s.tasks[:i], s.tasks[i+1:]...)
+				s.removeFault("task_" + t.ID)
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+		}
+		http.Error(w, "Task not found", http.StatusNotFound)
+	case len(parts) == 3 && parts[0] == "tasks" && parts[2] == "retry" && r.Method == http.MethodPost:
+		s.removeFault("task_" + parts[1])
+		w.WriteHeader(http.StatusOK)
+	case len(parts) == 1 && parts[0] == "faults" && r.Method == http.MethodGet:
+		query, err := parseQuery(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		result := make([]genieacs.Fault, 0)
+		for _, f := range s.faults {
+			if match(query, func(name string) string {
+				switch name {
+				case "_id":
+					return f.ID
+				case "device":
+					return f.Device
+				case "channel":
+					return f.Channel
+				}
+				return ""
+			}) {
+				result = append(result, f)
+			}
+		}
+		writeJSON(w, http.StatusOK, result)
+	case len(parts) == 1 && parts[0] == "files" && r.Method == http.MethodGet:
+		query, err := parseQuery(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		result := make([]genieacs.File, 0)
+		for _, f := range s.files {
+			if match(query, func(name string) string {
+				if name == "_id" {
+					return f.ID
+				}
+				return ""
+			}) {
+				result = append(result, f)
+			}
+		}
+		writeJSON(w, http.StatusOK, result)
+	case len(parts) == 2 && parts[0] == "faults" && r.Method == http.MethodDelete:
+		for _, f := range s.faults {
+			if f.ID == parts[1] {
+				s.removeFault(f.Channel)
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+		}
+		http.Error(w, "Fault not found", http.StatusNotFound)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func hasTag(doc map[string]interface{}, tag string) bool {
+	tags, _ := doc["_tags"].([]interface{})
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) removeFault(channel string) {
+	faults := s.faults[:0]
+	for _, f := range s.faults {
+		if f.Channel != channel {
+			faults = append(faults, f)
+		}
+	}
+	s.faults = faults
+}
+
+func (s *Server) addTask(w http.ResponseWriter, r *http.Request, device string) {
+	if _, ok := s.online[device]; !ok {
+		http.Error(w, "No such device", http.StatusNotFound)
+		return
+	}
+	var task genieacs.Task
+	if err := json.NewDecoder(r.Body).Decode(&task); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.seq++
+	task.ID = fmt.Sprintf("%024x", s.seq)
+	task.Device = device
+	task.Timestamp = time.Now().UTC().Format(time.RFC3339)
+	s.tasks = append(s.tasks, task)
+	if _, ok := r.URL.Query()["connection_request"]; !ok {
+		writeJSON(w, http.StatusAccepted, task)
+		return
+	}
+	if !s.online[device] {
+		writeJSON(w, http.StatusAccepted, task)
+		return
+	}
+	s.processTasks(device)
+	for _, t := range s.tasks {
+		if t.ID == task.ID {
+			// faulted
+			writeJSON(w, http.StatusAccepted, task)
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, task)
+}
@@ -44,16 +44,14 @@ func (s *MailSender) SendMail(mailTo []string, subject string, body string, file
 		if s.Mailtos == nil || len(s.Mailtos) ==0 {
 			return fmt.Errorf("Mail receiver not configured")
 		}
-		m.SetHeader("To", s.Mailtos...)
+		mailTo = s.Mailtos
 	}
+	m.SetHeader("To", mailTo...)
 
 	m.SetHeader("Subject", subject)
 	m.SetBody("text/html", body)
 
 	if files != nil && len(files) > 0 {
-		m := gomail.NewMessage(
-			gomail.SetEncoding(gomail.Base64),
-		)
 		for _, filename := range files {
 			if !common.FileExists(filename) {
 				return fmt.Errorf("file %s not exists", filename)
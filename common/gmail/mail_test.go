@@ -0,0 +1,75 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package gmail
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/ca17/teamsacs/common/gmail/mailtest"
+)
+
+func TestSendMail(t *testing.T) {
+	server, err := mailtest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	dir, _ := ioutil.TempDir("", "gmail")
+	defer os.RemoveAll(dir)
+	attachment := path.Join(dir, "report.txt")
+	_ = ioutil.WriteFile(attachment, []byte("report"), 0644)
+
+	sender := &MailSender{Server: server.Host, Port: server.Port, Usernam: "noreply@teamsacs.com", Alias: "TeamsACS"}
+	err = sender.SendMail([]string{"user01@teamsacs.com"}, "test", "<p>hello</p>", []string{attachment})
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages := server.Messages()
+	if len(messages) != 1 {
+		t.Fatalf("received %d messages, expected 1", len(messages))
+	}
+	msg := messages[0]
+	if len(msg.To) != 1 || msg.To[0] != "user01@teamsacs.com" {
+		t.Fatalf("recipients error %v", msg.To)
+	}
+	if !strings.Contains(msg.Data, "To: user01@teamsacs.com") {
+		t.Fatal("missing To header")
+	}
+	if !strings.Contains(msg.Data, `filename="report.txt"`) {
+		t.Fatal("attachment not in the message")
+	}
+}
+
+func TestSendMailDefaultReceiver(t *testing.T) {
+	server, err := mailtest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	sender := &MailSender{Server: server.Host, Port: server.Port, Usernam: "noreply@teamsacs.com", Mailtos: []string{"admin@teamsacs.com"}}
+	if err = sender.SendMail(nil, "test", "hello", nil); err != nil {
+		t.Fatal(err)
+	}
+	if msgs := server.Messages(); len(msgs) != 1 || !strings.Contains(msgs[0].Data, "To: admin@teamsacs.com") {
+		t.Fatalf("default receiver error %+v", msgs)
+	}
+}
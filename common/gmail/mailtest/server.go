@@ -0,0 +1,157 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Package mailtest provides a local smtp stub for mail tests
+package mailtest
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Message
+// a mail received by the stub server
+type Message struct {
+	From string
+	To   []string
+	Data string
+}
+
+// Server
+// a minimal smtp server without auth and starttls
+type Server struct {
+	Host     string
+	Port     int
+	listener net.Listener
+	lock     sync.Mutex
+	messages []Message
+	wg       sync.WaitGroup
+}
+
+// NewServer
+// start a smtp stub on a random local port
+func NewServer() (*Server, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	addr := ln.Addr().(*net.TCPAddr)
+	s := &Server{Host: "127.0.0.1", Port: addr.Port, listener: ln}
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// Addr
+func (s *Server) Addr() string {
+	return net.JoinHostPort(s.Host, strconv.Itoa(s.Port))
+}
+
+// Messages
+// the received messages
+func (s *Server) Messages() []Message {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]Message{}, s.messages...)
+}
+
+// Close
+func (s *Server) Close() error {
+	err := s.listener.Close()
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handle(conn)
+		}()
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	reply := func(line string) {
+		w.WriteString(line + "\r\n")
+		w.Flush()
+	}
+	reply("220 mailtest ESMTP")
+	var msg Message
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		cmd := strings.ToUpper(line)
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 mailtest")
+		case strings.HasPrefix(cmd, "MAIL FROM:"):
+			msg = Message{From: trimAddr(line[10:])}
+			reply("250 OK")
+		case strings.HasPrefix(cmd, "RCPT TO:"):
+			msg.To = append(msg.To, trimAddr(line[8:]))
+			reply("250 OK")
+		case cmd == "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			var data strings.Builder
+			for {
+				dl, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if dl == ".\r\n" || dl == ".\n" {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(dl, "."))
+			}
+			msg.Data = data.String()
+			s.lock.Lock()
+			s.messages = append(s.messages, msg)
+			s.lock.Unlock()
+			reply("250 OK")
+		case cmd == "RSET", cmd == "NOOP":
+			reply("250 OK")
+		case cmd == "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("502 Command not implemented")
+		}
+	}
+}
+
+func trimAddr(s string) string {
+	s = strings.TrimSpace(s)
+	if i := strings.Index(s, " "); i > 0 {
+		s = s[:i]
+	}
+	return strings.Trim(s, "<>")
+}
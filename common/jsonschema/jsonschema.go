@@ -0,0 +1,408 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Package jsonschema
+// A JSON Schema validator of the keywords used to describe data documents:
+// type, properties, required, additionalProperties, enum, minLength, maxLength,
+// pattern, format, minimum, maximum, exclusiveMinimum, exclusiveMaximum,
+// items, minItems and maxItems. A string value of an integer, number or boolean
+// type is coerced, the values of Excel imports and query strings are strings.
+package jsonschema
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ca17/teamsacs/common/validutil"
+)
+
+var (
+	schemaTypes   = []string{"string", "integer", "number", "boolean", "object", "array", "null"}
+	schemaFormats = map[string]func(string) bool{
+		"email":     func(v string) bool { return validutil.IsEmail(v) },
+		"ipv4":      func(v string) bool { return validutil.IsIP4(v) },
+		"ipv6":      func(v string) bool { return validutil.IsIP6(v) },
+		"uri":       func(v string) bool { return validutil.IsURL(v) },
+		"mac":       func(v string) bool { _, err := net.ParseMAC(v); return err == nil },
+		"date":      func(v string) bool { _, err := time.Parse("2006-01-02", v); return err == nil },
+		"datetime":  func(v string) bool { _, err := time.Parse("2006-01-02 15:04:05", v); return err == nil },
+		"date-time": func(v string) bool { _, err := time.Parse(time.RFC3339, v); return err == nil },
+	}
+)
+
+// Schema
+type Schema struct {
+	Schema               string             `json:"$schema,omitempty"`
+	Title                string             `json:"title,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Type                 TypeList           `json:"type,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *bool              `json:"additionalProperties,omitempty"`
+	Enum                 []interface{}      `json:"enum,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	ExclusiveMinimum     *float64           `json:"exclusiveMinimum,omitempty"`
+	ExclusiveMaximum     *float64           `json:"exclusiveMaximum,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	MinItems             *int               `json:"minItems,omitempty"`
+	MaxItems             *int               `json:"maxItems,omitempty"`
+	Default              interface{}        `json:"default,omitempty"`
+
+	pattern *regexp.Regexp
+}
+
+// TypeList
+// the type keyword, a type name or an array of type names
+type TypeList []string
+
+func (t *TypeList) UnmarshalJSON(b []byte) error {
+	var name string
+	if err := json.Unmarshal(b, &name); err == nil {
+		*t = TypeList{name}
+		return nil
+	}
+	var names []string
+	if err := json.Unmarshal(b, &names); err != nil {
+		return fmt.Errorf("type must be a string or an array of strings")
+	}
+	*t = names
+	return nil
+}
+
+func (t TypeList) MarshalJSON() ([]byte, error) {
+	if len(t) == 1 {
+		return json.Marshal(t[0])
+	}
+	return json.Marshal([]string(t))
+}
+
+// Has
+func (t TypeList) Has(name string) bool {
+	for _, v := range t {
+		if v == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Error
+// The validation error of a value, the path is like address.city or tags[1]
+type Error struct {
+	Path    string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Parse
+// parse and check the schema
+func Parse(b []byte) (*Schema, error) {
+	var s = new(Schema)
+	if err := json.Unmarshal(b, s); err != nil {
+		return nil, fmt.Errorf("invalid schema, %s", err.Error())
+	}
+	if err := s.compile(""); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Schema) compile(path string) error {
+	name := path
+	if name == "" {
+		name = "root"
+	}
+	for _, t := range s.Type {
+		if !inStrings(t, schemaTypes) {
+			return fmt.Errorf("%s: unknown type %s", name, t)
+		}
+	}
+	if s.Pattern != "" {
+		re, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return fmt.Errorf("%s: invalid pattern, %s", name, err.Error())
+		}
+		s.pattern = re
+	}
+	if _, ok := schemaFormats[s.Format]; s.Format != "" && !ok {
+		return fmt.Errorf("%s: unknown format %s", name, s.Format)
+	}
+	for _, r := range s.Required {
+		if _, ok := s.Properties[r]; !ok && s.AdditionalProperties != nil && !*s.AdditionalProperties {
+			return fmt.Errorf("%s: required property %s is not defined", name, r)
+		}
+	}
+	for pname, p := range s.Properties {
+		if p == nil {
+			return fmt.Errorf("%s: property %s is empty", name, pname)
+		}
+		if err := p.compile(joinPath(path, pname)); err != nil {
+			return err
+		}
+	}
+	if s.Items != nil {
+		return s.Items.compile(path + "[]")
+	}
+	return nil
+}
+
+// Property
+// the schema of a dotted property path, nil if not defined
+func (s *Schema) Property(path string) *Schema {
+	cur := s
+	for _, name := range strings.Split(path, ".") {
+		if cur == nil || cur.Properties == nil {
+			return nil
+		}
+		cur = cur.Properties[name]
+	}
+	return cur
+}
+
+// Validate
+// validate the document and return it with the coerced values, the required
+// properties are not checked when partial, e.g. an update of some fields
+func (s *Schema) Validate(doc map[string]interface{}, partial bool) (map[string]interface{}, []Error) {
+	errs := make([]Error, 0)
+	v := s.validate("", doc, partial, &errs)
+	result, _ := v.(map[string]interface{})
+	return result, errs
+}
+
+// Coerce
+// convert a string value to the type of the schema
+func (s *Schema) Coerce(v interface{}) (interface{}, error) {
+	str, ok := v.(string)
+	if !ok || len(s.Type) == 0 || s.Type.Has("string") {
+		return v, nil
+	}
+	switch {
+	case s.Type.Has("integer"):
+		i, err := strconv.ParseInt(strings.TrimSpace(str), 10, 64)
+		if err != nil {
+			return v, fmt.Errorf("must be an integer")
+		}
+		return i, nil
+	case s.Type.Has("number"):
+		f, err := strconv.ParseFloat(strings.TrimSpace(str), 64)
+		if err != nil {
+			return v, fmt.Errorf("must be a number")
+		}
+		return f, nil
+	case s.Type.Has("boolean"):
+		b, err := strconv.ParseBool(strings.TrimSpace(str))
+		if err != nil {
+			return v, fmt.Errorf("must be a boolean")
+		}
+		return b, nil
+	}
+	return v, nil
+}
+
+func (s *Schema) validate(path string, v interface{}, partial bool, errs *[]Error) interface{} {
+	fail := func(format string, args ...interface{}) {
+		name := path
+		if name == "" {
+			name = "root"
+		}
+		*errs = append(*errs, Error{Path: name, Message: fmt.Sprintf(format, args...)})
+	}
+	v, err := s.Coerce(v)
+	if err != nil {
+		fail(err.Error())
+		return v
+	}
+	if len(s.Type) > 0 && !s.matchType(v) {
+		fail("must be %s", strings.Join(s.Type, " or "))
+		return v
+	}
+	if len(s.Enum) > 0 && !s.inEnum(v) {
+		fail("must be one of %s", enumString(s.Enum))
+	}
+	switch t := v.(type) {
+	case string:
+		n := len([]rune(t))
+		if s.MinLength != nil && n < *s.MinLength {
+			fail("at least %d characters", *s.MinLength)
+		}
+		if s.MaxLength != nil && n > *s.MaxLength {
+			fail("at most %d characters", *s.MaxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(t) {
+			fail("does not match %s", s.Pattern)
+		}
+		if check, ok := schemaFormats[s.Format]; ok && t != "" && !check(t) {
+			fail("invalid %s format", s.Format)
+		}
+	case map[string]interface{}:
+		return s.validateObject(path, t, partial, errs)
+	case []interface{}:
+		if s.MinItems != nil && len(t) < *s.MinItems {
+			fail("at least %d items", *s.MinItems)
+		}
+		if s.MaxItems != nil && len(t) > *s.MaxItems {
+			fail("at most %d items", *s.MaxItems)
+		}
+		if s.Items != nil {
+			for i := range t {
+				t[i] = s.Items.validate(fmt.Sprintf("%s[%d]", path, i), t[i], false, errs)
+			}
+		}
+	default:
+		if f, ok := toFloat(v); ok {
+			if s.Minimum != nil && f < *s.Minimum {
+				fail("must be >= %v", *s.Minimum)
+			}
+			if s.Maximum != nil && f > *s.Maximum {
+				fail("must be <= %v", *s.Maximum)
+			}
+			if s.ExclusiveMinimum != nil && f <= *s.ExclusiveMinimum {
+				fail("must be > %v", *s.ExclusiveMinimum)
+			}
+			if s.ExclusiveMaximum != nil && f >= *s.ExclusiveMaximum {
+				fail("must be < %v", *s.ExclusiveMaximum)
+			}
+		}
+	}
+	return v
+}
+
+func (s *Schema) validateObject(path string, obj map[string]interface{}, partial bool, errs *[]Error) interface{} {
+	if !partial {
+		for _, name := range s.Required {
+			if v, ok := obj[name]; !ok || v == nil || v == "" {
+				*errs = append(*errs, Error{Path: joinPath(path, name), Message: "is required"})
+			}
+		}
+	}
+	names := make([]string, 0, len(obj))
+	for name := range obj {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		p, ok := s.Properties[name]
+		if !ok {
+			if s.AdditionalProperties != nil && !*s.AdditionalProperties && name != "_id" {
+				*errs = append(*errs, Error{Path: joinPath(path, name), Message: "is not allowed"})
+			}
+			continue
+		}
+		obj[name] = p.validate(joinPath(path, name), obj[name], partial, errs)
+	}
+	return obj
+}
+
+func (s *Schema) matchType(v interface{}) bool {
+	for _, t := range s.Type {
+		switch t {
+		case "string":
+			if _, ok := v.(string); ok {
+				return true
+			}
+		case "integer":
+			if f, ok := toFloat(v); ok && f == math.Trunc(f) {
+				return true
+			}
+		case "number":
+			if _, ok := toFloat(v); ok {
+				return true
+			}
+		case "boolean":
+			if _, ok := v.(bool); ok {
+				return true
+			}
+		case "object":
+			if _, ok := v.(map[string]interface{}); ok {
+				return true
+			}
+		case "array":
+			if _, ok := v.([]interface{}); ok {
+				return true
+			}
+		case "null":
+			if v == nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (s *Schema) inEnum(v interface{}) bool {
+	for _, e := range s.Enum {
+		if e == v {
+			return true
+		}
+		ef, ok1 := toFloat(e)
+		vf, ok2 := toFloat(v)
+		if ok1 && ok2 && ef == vf {
+			return true
+		}
+	}
+	return false
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch t := v.(type) {
+	case int:
+		return float64(t), true
+	case int32:
+		return float64(t), true
+	case int64:
+		return float64(t), true
+	case float32:
+		return float64(t), true
+	case float64:
+		return t, true
+	}
+	return 0, false
+}
+
+func enumString(values []interface{}) string {
+	strs := make([]string, 0, len(values))
+	for _, v := range values {
+		strs = append(strs, fmt.Sprint(v))
+	}
+	return strings.Join(strs, ", ")
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+func inStrings(v string, list []string) bool {
+	for _, s := range list {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,109 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package jsonschema
+
+import (
+	"testing"
+)
+
+const testSchema = `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "type": "object",
+  "required": ["name", "ipaddr"],
+  "additionalProperties": false,
+  "properties": {
+    "name": {"type": "string", "minLength": 2, "maxLength": 16, "pattern": "^[a-z0-9-]+$"},
+    "ipaddr": {"type": "string", "format": "ipv4"},
+    "port": {"type": "integer", "minimum": 1, "maximum": 65535},
+    "ratio": {"type": "number", "exclusiveMaximum": 1},
+    "enabled": {"type": "boolean"},
+    "level": {"type": "string", "enum": ["gold", "silver"]},
+    "tags": {"type": "array", "maxItems": 2, "items": {"type": "string", "minLength": 1}},
+    "contact": {"type": "object", "properties": {"email": {"type": "string", "format": "email"}}}
+  }
+}`
+
+func TestValidate(t *testing.T) {
+	s, err := Parse([]byte(testSchema))
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, errs := s.Validate(map[string]interface{}{
+		"_id":     "1",
+		"name":    "olt-01",
+		"ipaddr":  "10.0.0.1",
+		"port":    "8080",
+		"ratio":   0.5,
+		"enabled": "true",
+		"level":   "gold",
+		"tags":    []interface{}{"a", "b"},
+		"contact": map[string]interface{}{"email": "noc@example.com"},
+	}, false)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if doc["port"] != int64(8080) || doc["enabled"] != true {
+		t.Fatal(doc)
+	}
+
+	_, errs = s.Validate(map[string]interface{}{
+		"name":    "OLT",
+		"port":    70000.0,
+		"ratio":   "1",
+		"level":   "bronze",
+		"tags":    []interface{}{"a", "", "c"},
+		"contact": map[string]interface{}{"email": "noc"},
+		"vendor":  "x",
+	}, false)
+	expected := map[string]bool{
+		"ipaddr": true, "name": true, "port": true, "ratio": true, "level": true,
+		"tags": true, "tags[1]": true, "contact.email": true, "vendor": true,
+	}
+	if len(errs) != len(expected) {
+		t.Fatal(errs)
+	}
+	for _, e := range errs {
+		if !expected[e.Path] {
+			t.Fatal("unexpected error", e)
+		}
+	}
+
+	_, errs = s.Validate(map[string]interface{}{"port": "abc"}, true)
+	if len(errs) != 1 || errs[0].Path != "port" {
+		t.Fatal(errs)
+	}
+}
+
+func TestParse(t *testing.T) {
+	for _, src := range []string{
+		`{"type": "text"}`,
+		`{"properties": {"name": {"pattern": "("}}}`,
+		`{"properties": {"name": {"format": "phone"}}}`,
+		`{"type": 1}`,
+	} {
+		if _, err := Parse([]byte(src)); err == nil {
+			t.Fatal("expected error", src)
+		}
+	}
+	s, _ := Parse([]byte(testSchema))
+	if p := s.Property("contact.email"); p == nil || p.Format != "email" {
+		t.Fatal(p)
+	}
+	if v, err := s.Property("port").Coerce("22"); err != nil || v != int64(22) {
+		t.Fatal(v, err)
+	}
+}
@@ -36,6 +36,9 @@ type File struct {
 
 	Location *time.Location
 
+	// rotate every hour instead of every day
+	hourly bool
+
 	// info about currently opened file
 	day     int
 	path    string
@@ -73,7 +76,7 @@ func (f *File) open() error {
 	} else {
 		f.path = t.Format(f.pathFormat)
 	}
-	f.day = t.YearDay()
+	f.day = f.period(t)
 
 	// we can't assume that the dir for the file already exists
 	dir := filepath.Dir(f.path)
@@ -92,10 +95,18 @@ func (f *File) open() error {
 	return err
 }
 
+// period returns the rotation period of t, the day of year or the hour of year
+func (f *File) period(t time.Time) int {
+	if f.hourly {
+		return t.YearDay()*24 + t.Hour() + 1
+	}
+	return t.YearDay()
+}
+
 // rotate on new day
 func (f *File) reopenIfNeeded() error {
 	t := time.Now().In(f.Location)
-	if t.YearDay() == f.day {
+	if f.period(t) == f.day {
 		return nil
 	}
 	err := f.close(true)
@@ -136,11 +147,23 @@ func NewFileWithPathGenerator(pathGenerator func(time.Time) string, onClose func
 	return newFile("", pathGenerator, onClose)
 }
 
+// NewHourlyFileWithPathGenerator is like NewFileWithPathGenerator but the file
+// is rotated every hour. pathGenerator should return a path unique in a given hour
+// e.g. time.Format of "2006010215.txt".
+func NewHourlyFileWithPathGenerator(pathGenerator func(time.Time) string, onClose func(path string, didRotate bool)) (*File, error) {
+	return newRotateFile("", pathGenerator, onClose, true)
+}
+
 func newFile(pathFormat string, pathGenerator func(time.Time) string, onClose func(path string, didRotate bool)) (*File, error) {
+	return newRotateFile(pathFormat, pathGenerator, onClose, false)
+}
+
+func newRotateFile(pathFormat string, pathGenerator func(time.Time) string, onClose func(path string, didRotate bool), hourly bool) (*File, error) {
 	f := &File{
 		pathFormat:    pathFormat,
 		pathGenerator: pathGenerator,
 		Location:      time.UTC,
+		hourly:        hourly,
 	}
 	// force early failure if we can't open the file
 	// note that we don't set onClose yet so that it won't get called due to
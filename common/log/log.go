@@ -33,6 +33,9 @@ const ModuleSystem = "System"
 
 var log = logging.MustGetLogger(ModuleSystem)
 
+// leveled backends created by SetupLog, kept for level reload
+var leveledBackends []logging.LeveledBackend
+
 func SetupLog(level logging.Level, syslogaddr string, logdir string, module string) {
 
 	var format = logging.MustStringFormatter(
@@ -45,17 +48,29 @@ func SetupLog(level logging.Level, syslogaddr string, logdir string, module stri
 	bs := SetupSyslog(level, syslogaddr, module)
 	bf := FileSyslog(level, logdir, module)
 
+	leveledBackends = leveledBackends[:0]
 	if bs != nil {
 		Backends = append(Backends, bs)
+		leveledBackends = append(leveledBackends, bs)
 	}
 	if bf != nil {
 		Backends = append(Backends, bf)
+		leveledBackends = append(leveledBackends, bf)
 	}
 	logging.SetBackend(Backends...)
 	logging.SetLevel(level, module)
 	log = logging.MustGetLogger(module)
 }
 
+// SetLevel
+// change the log level at runtime, such as on SIGHUP
+func SetLevel(level logging.Level, module string) {
+	for _, b := range leveledBackends {
+		b.SetLevel(level, module)
+	}
+	logging.SetLevel(level, module)
+}
+
 func clearLogs(logsdir string, prefix string) {
 	daydirs, err := ioutil.ReadDir(logsdir)
 	if err != nil {
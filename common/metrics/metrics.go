@@ -0,0 +1,131 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "teamsacs"
+
+var (
+	// RadiusRequests radius requests by server, code and vendor
+	RadiusRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "radius",
+		Name:      "requests_total",
+		Help:      "Total number of radius requests received.",
+	}, []string{"server", "code", "vendor"})
+
+	// RadiusAuthResults radius auth accept/reject by reason category
+	RadiusAuthResults = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "radius",
+		Name:      "auth_results_total",
+		Help:      "Total number of radius auth results by reason category.",
+	}, []string{"result", "reason"})
+
+	// RadiusHandleDuration radius handler latency
+	RadiusHandleDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "radius",
+		Name:      "handle_duration_seconds",
+		Help:      "Radius request handler latency in seconds.",
+		Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"server"})
+
+	// SyslogMessages syslog messages received per listener
+	SyslogMessages = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "syslog",
+		Name:      "messages_total",
+		Help:      "Total number of syslog messages received.",
+	}, []string{"listener"})
+
+	// SyslogDropped syslog messages dropped per listener
+	SyslogDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "syslog",
+		Name:      "messages_dropped_total",
+		Help:      "Total number of syslog messages dropped by read or parse errors.",
+	}, []string{"listener"})
+
+	// NbiRequestDuration nbi request latency by route
+	NbiRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "nbi",
+		Name:      "request_duration_seconds",
+		Help:      "NBI request latency in seconds.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	// MongoErrors mongodb operation errors by command
+	MongoErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "mongodb",
+		Name:      "operation_errors_total",
+		Help:      "Total number of failed mongodb operations.",
+	}, []string{"command"})
+)
+
+var registry = prometheus.NewRegistry()
+
+func init() {
+	registry.MustRegister(
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		prometheus.NewGoCollector(),
+		RadiusRequests,
+		RadiusAuthResults,
+		RadiusHandleDuration,
+		SyslogMessages,
+		SyslogDropped,
+		NbiRequestDuration,
+		MongoErrors,
+	)
+}
+
+// Register register a custom collector, such as the online session collector
+func Register(c prometheus.Collector) error {
+	return registry.Register(c)
+}
+
+// Handler the /metrics http handler
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// ListenMetricsServer
+// The metrics server runs on its own port without jwt authentication
+func ListenMetricsServer(ctx context.Context, host string, port int) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler())
+	server := &http.Server{Addr: fmt.Sprintf("%s:%d", host, port), Handler: mux}
+	go func() {
+		<-ctx.Done()
+		_ = server.Close()
+	}()
+	err := server.ListenAndServe()
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
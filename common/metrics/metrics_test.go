@@ -0,0 +1,45 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package metrics
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	RadiusRequests.WithLabelValues("auth", "Access-Request", "14988").Inc()
+	SyslogDropped.WithLabelValues("rfc3164").Inc()
+	server := httptest.NewServer(Handler())
+	defer server.Close()
+	resp, err := server.Client().Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	for _, name := range []string{
+		`teamsacs_radius_requests_total{code="Access-Request",server="auth",vendor="14988"} 1`,
+		`teamsacs_syslog_messages_dropped_total{listener="rfc3164"} 1`,
+	} {
+		if !strings.Contains(string(body), name) {
+			t.Fatalf("metrics %s not found", name)
+		}
+	}
+}
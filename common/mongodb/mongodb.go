@@ -20,14 +20,21 @@ import (
 	"context"
 	"time"
 
+	"go.mongodb.org/mongo-driver/event"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 
+	"github.com/ca17/teamsacs/common/metrics"
 	"github.com/ca17/teamsacs/config"
 )
 
 func GetMongodbClient(cfg config.MongodbConfig) (*mongo.Client, error) {
-	client, err := mongo.NewClient(options.Client().ApplyURI(cfg.Url))
+	monitor := &event.CommandMonitor{
+		Failed: func(ctx context.Context, evt *event.CommandFailedEvent) {
+			metrics.MongoErrors.WithLabelValues(evt.CommandName).Inc()
+		},
+	}
+	client, err := mongo.NewClient(options.Client().ApplyURI(cfg.Url).SetMonitor(monitor))
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,268 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Package routeros is a client of the Mikrotik RouterOS API (8728, 8729 with tls)
+package routeros
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultPort    = 8728
+	DefaultTlsPort = 8729
+)
+
+var attrKeyRegexp = regexp.MustCompile(`^(\.id|[a-zA-Z0-9][a-zA-Z0-9\-.]*)$`)
+
+// Config
+// The connection settings of a device
+type Config struct {
+	Address  string
+	Username string
+	Password string
+	Tls      bool
+	// Fingerprint the sha256 of the device certificate, pins the self-signed
+	// certificate of the api-ssl service instead of the CA verification
+	Fingerprint string
+	// Insecure skips the certificate verification, must be set explicitly
+	Insecure bool
+	// TlsConfig overrides Fingerprint and Insecure when set
+	TlsConfig *tls.Config
+	Timeout   time.Duration
+}
+
+// Fingerprint
+// the hex sha256 of a DER certificate
+func Fingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:])
+}
+
+// NormalizeFingerprint
+// lower case the fingerprint and remove the separators, "AB:CD" -> "abcd"
+func NormalizeFingerprint(fp string) string {
+	fp = strings.ToLower(strings.TrimSpace(fp))
+	return strings.NewReplacer(":", "", " ", "", "-", "").Replace(fp)
+}
+
+// ValidFingerprint
+// a sha256 fingerprint has 64 hex digits
+func ValidFingerprint(fp string) bool {
+	bs, err := hex.DecodeString(NormalizeFingerprint(fp))
+	return err == nil && len(bs) == sha256.Size
+}
+
+// tlsConfig
+// pins the fingerprint if set, else verifies the certificate by the system CAs
+func (cfg Config) tlsConfig() (*tls.Config, error) {
+	if cfg.Fingerprint != "" {
+		if !ValidFingerprint(cfg.Fingerprint) {
+			return nil, fmt.Errorf("invalid certificate fingerprint %s", cfg.Fingerprint)
+		}
+		pin := NormalizeFingerprint(cfg.Fingerprint)
+		return &tls.Config{
+			// the chain is replaced by the fingerprint check
+			InsecureSkipVerify: true,
+			VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+				if len(rawCerts) == 0 {
+					return fmt.Errorf("no device certificate")
+				}
+				if fp := Fingerprint(rawCerts[0]); fp != pin {
+					return fmt.Errorf("certificate fingerprint mismatch %s", fp)
+				}
+				return nil
+			},
+		}, nil
+	}
+	return &tls.Config{InsecureSkipVerify: cfg.Insecure}, nil
+}
+
+// DeviceError
+// The !trap or !fatal reply of the device
+type DeviceError struct {
+	Word    string
+	Message string
+}
+
+func (e *DeviceError) Error() string {
+	return fmt.Sprintf("routeros %s: %s", e.Word, e.Message)
+}
+
+// Reply
+// The !re sentences and the !done attributes of a command
+type Reply struct {
+	Re   []map[string]string `json:"re"`
+	Done map[string]string   `json:"done"`
+}
+
+// Client
+// A RouterOS API connection, commands are executed one by one
+type Client struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	timeout time.Duration
+	lock    sync.Mutex
+}
+
+// Dial
+// connect and login the device
+func Dial(cfg Config) (*Client, error) {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = time.Second * 10
+	}
+	dialer := &net.Dialer{Timeout: cfg.Timeout}
+	var conn net.Conn
+	var err error
+	if cfg.Tls {
+		tlsConfig := cfg.TlsConfig
+		if tlsConfig == nil {
+			if tlsConfig, err = cfg.tlsConfig(); err != nil {
+				return nil, err
+			}
+		}
+		conn, err = tls.DialWithDialer(dialer, "tcp", cfg.Address, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", cfg.Address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := &Client{conn: conn, reader: bufio.NewReader(conn), timeout: cfg.Timeout}
+	if err = c.login(cfg.Username, cfg.Password); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// login
+// the plain login of RouterOS 6.43+, the md5 challenge of the old versions
+// is answered when the device returns =ret=
+func (c *Client) login(username, password string) error {
+	reply, err := c.Run("/login", "=name="+username, "=password="+password)
+	if err != nil {
+		return err
+	}
+	challenge, ok := reply.Done["ret"]
+	if !ok {
+		return nil
+	}
+	bs, err := hex.DecodeString(challenge)
+	if err != nil {
+		return fmt.Errorf("invalid login challenge %s", challenge)
+	}
+	h := md5.New()
+	h.Write([]byte{0})
+	h.Write([]byte(password))
+	h.Write(bs)
+	_, err = c.Run("/login", "=name="+username, "=response=00"+hex.EncodeToString(h.Sum(nil)))
+	return err
+}
+
+// Close
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Run
+// execute the command words and read the replies until !done
+func (c *Client) Run(words ...string) (*Reply, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	_ = c.conn.SetDeadline(time.Now().Add(c.timeout))
+	if err := WriteSentence(c.conn, words...); err != nil {
+		return nil, err
+	}
+	reply := &Reply{Re: make([]map[string]string, 0)}
+	var trap error
+	for {
+		ws, err := ReadWords(c.reader)
+		if err != nil {
+			return nil, err
+		}
+		s, err := ParseSentence(ws)
+		if err != nil {
+			return nil, err
+		}
+		switch s.Word {
+		case "!re":
+			reply.Re = append(reply.Re, s.Attrs)
+		case "!done":
+			if trap != nil {
+				return nil, trap
+			}
+			reply.Done = s.Attrs
+			return reply, nil
+		case "!trap":
+			// the trap is followed by !done
+			trap = &DeviceError{Word: s.Word, Message: s.Attrs["message"]}
+		case "!fatal":
+			msg := s.Attrs["message"]
+			if len(ws) > 1 && msg == "" {
+				msg = ws[1]
+			}
+			return nil, &DeviceError{Word: s.Word, Message: msg}
+		case "!empty":
+		default:
+			return nil, fmt.Errorf("unexpected reply %s", s.Word)
+		}
+	}
+}
+
+// Exec
+// execute the command with the attribute args
+func (c *Client) Exec(command string, args map[string]string) (*Reply, error) {
+	words, err := CommandWords(command, args)
+	if err != nil {
+		return nil, err
+	}
+	return c.Run(words...)
+}
+
+// CommandWords
+// build the command sentence, the keys are checked so an arg can not
+// turn into a query or api attribute word
+func CommandWords(command string, args map[string]string) ([]string, error) {
+	if len(command) < 2 || command[0] != '/' {
+		return nil, fmt.Errorf("invalid command %s", command)
+	}
+	keys := make([]string, 0, len(args))
+	for k := range args {
+		if !attrKeyRegexp.MatchString(k) {
+			return nil, fmt.Errorf("invalid attribute name %s", k)
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	words := []string{command}
+	for _, k := range keys {
+		words = append(words, "="+k+"="+args[k])
+	}
+	return words, nil
+}
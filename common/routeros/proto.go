@@ -0,0 +1,144 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package routeros
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Sentence
+// A RouterOS API sentence, the reply word (!re, !done, !trap, !fatal)
+// followed by the attribute words
+type Sentence struct {
+	Word  string
+	Tag   string
+	Attrs map[string]string
+	// Keys keep the attribute order
+	Keys []string
+}
+
+// EncodeLength
+// the variable length prefix of a word
+func EncodeLength(l int) []byte {
+	switch {
+	case l < 0x80:
+		return []byte{byte(l)}
+	case l < 0x4000:
+		l |= 0x8000
+		return []byte{byte(l >> 8), byte(l)}
+	case l < 0x200000:
+		l |= 0xC00000
+		return []byte{byte(l >> 16), byte(l >> 8), byte(l)}
+	case l < 0x10000000:
+		l |= 0xE0000000
+		return []byte{byte(l >> 24), byte(l >> 16), byte(l >> 8), byte(l)}
+	default:
+		return []byte{0xF0, byte(l >> 24), byte(l >> 16), byte(l >> 8), byte(l)}
+	}
+}
+
+// readLength
+func readLength(r *bufio.Reader) (int, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	var extra int
+	var l int
+	switch {
+	case b&0x80 == 0:
+		return int(b), nil
+	case b&0xC0 == 0x80:
+		extra, l = 1, int(b&0x3F)
+	case b&0xE0 == 0xC0:
+		extra, l = 2, int(b&0x1F)
+	case b&0xF0 == 0xE0:
+		extra, l = 3, int(b&0x0F)
+	case b == 0xF0:
+		extra, l = 4, 0
+	default:
+		return 0, fmt.Errorf("invalid word length prefix 0x%x", b)
+	}
+	for i := 0; i < extra; i++ {
+		c, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		l = l<<8 | int(c)
+	}
+	return l, nil
+}
+
+// WriteSentence
+// write the words and the terminating empty word
+func WriteSentence(w io.Writer, words ...string) error {
+	buf := make([]byte, 0, 64)
+	for _, word := range words {
+		buf = append(buf, EncodeLength(len(word))...)
+		buf = append(buf, word...)
+	}
+	buf = append(buf, 0)
+	_, err := w.Write(buf)
+	return err
+}
+
+// ReadWords
+// read the words of a sentence
+func ReadWords(r *bufio.Reader) ([]string, error) {
+	words := make([]string, 0, 8)
+	for {
+		l, err := readLength(r)
+		if err != nil {
+			return nil, err
+		}
+		if l == 0 {
+			return words, nil
+		}
+		word := make([]byte, l)
+		if _, err = io.ReadFull(r, word); err != nil {
+			return nil, err
+		}
+		words = append(words, string(word))
+	}
+}
+
+// ParseSentence
+// split the attribute words =key=value and the .tag
+func ParseSentence(words []string) (*Sentence, error) {
+	if len(words) == 0 {
+		return nil, fmt.Errorf("empty sentence")
+	}
+	s := &Sentence{Word: words[0], Attrs: make(map[string]string)}
+	for _, w := range words[1:] {
+		switch {
+		case strings.HasPrefix(w, ".tag="):
+			s.Tag = w[5:]
+		case strings.HasPrefix(w, "="):
+			kv := strings.SplitN(w[1:], "=", 2)
+			if len(kv) == 2 {
+				s.Attrs[kv[0]] = kv[1]
+			} else {
+				s.Attrs[kv[0]] = ""
+			}
+			s.Keys = append(s.Keys, kv[0])
+		}
+	}
+	return s, nil
+}
@@ -0,0 +1,207 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Package rostest provides an in-process RouterOS API server for tests
+package rostest
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/ca17/teamsacs/common/routeros"
+)
+
+// Handler
+// returns the !re attributes of a command, an error is replied as !trap
+type Handler func(args map[string]string) ([]map[string]string, error)
+
+// Server
+// a fake RouterOS API service with the plain login
+type Server struct {
+	Username string
+	Password string
+	listener net.Listener
+	cert     []byte
+	lock     sync.Mutex
+	handlers map[string]Handler
+	commands [][]string
+	wg       sync.WaitGroup
+}
+
+// NewServer
+// start a plain api server on a random local port
+func NewServer(username, password string) (*Server, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	return start(ln, username, password), nil
+}
+
+// NewTLSServer
+// start an api-ssl server with a self-signed certificate
+func NewTLSServer(username, password string) (*Server, error) {
+	cert, err := selfSignedCert()
+	if err != nil {
+		return nil, err
+	}
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		return nil, err
+	}
+	s := start(ln, username, password)
+	s.cert = cert.Certificate[0]
+	return s, nil
+}
+
+// Fingerprint
+// the sha256 fingerprint of the api-ssl certificate, empty for a plain server
+func (s *Server) Fingerprint() string {
+	if s.cert == nil {
+		return ""
+	}
+	return routeros.Fingerprint(s.cert)
+}
+
+func start(ln net.Listener, username, password string) *Server {
+	s := &Server{Username: username, Password: password, listener: ln, handlers: make(map[string]Handler)}
+	s.wg.Add(1)
+	go s.serve()
+	return s
+}
+
+func selfSignedCert() (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "routeros"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
+
+// Addr
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Handle
+// register the handler of a command, e.g. /interface/print
+func (s *Server) Handle(command string, h Handler) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.handlers[command] = h
+}
+
+// Commands
+// the command sentences received after login
+func (s *Server) Commands() [][]string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([][]string(nil), s.commands...)
+}
+
+// Close
+func (s *Server) Close() {
+	_ = s.listener.Close()
+	s.wg.Wait()
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handle(conn)
+		}()
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	logged := false
+	for {
+		words, err := routeros.ReadWords(r)
+		if err != nil {
+			return
+		}
+		sentence, err := routeros.ParseSentence(words)
+		if err != nil {
+			return
+		}
+		if sentence.Word == "/login" {
+			if sentence.Attrs["name"] != s.Username || sentence.Attrs["password"] != s.Password {
+				_ = routeros.WriteSentence(conn, "!trap", "=message=invalid user name or password (6)")
+				_ = routeros.WriteSentence(conn, "!done")
+				continue
+			}
+			logged = true
+			_ = routeros.WriteSentence(conn, "!done")
+			continue
+		}
+		if !logged {
+			_ = routeros.WriteSentence(conn, "!fatal", "not logged in")
+			return
+		}
+		s.lock.Lock()
+		s.commands = append(s.commands, words)
+		h, ok := s.handlers[sentence.Word]
+		s.lock.Unlock()
+		if !ok {
+			_ = routeros.WriteSentence(conn, "!trap", "=category=0", "=message=no such command prefix")
+			_ = routeros.WriteSentence(conn, "!done")
+			continue
+		}
+		items, err := h(sentence.Attrs)
+		if err != nil {
+			_ = routeros.WriteSentence(conn, "!trap", "=message="+err.Error())
+			_ = routeros.WriteSentence(conn, "!done")
+			continue
+		}
+		for _, item := range items {
+			re := []string{"!re"}
+			for k, v := range item {
+				re = append(re, "="+k+"="+v)
+			}
+			_ = routeros.WriteSentence(conn, re...)
+		}
+		_ = routeros.WriteSentence(conn, "!done")
+	}
+}
@@ -0,0 +1,163 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package routeros_test
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/ca17/teamsacs/common/routeros"
+	"github.com/ca17/teamsacs/common/routeros/rostest"
+)
+
+func TestWordLength(t *testing.T) {
+	for _, l := range []int{0, 1, 0x7f, 0x80, 0x3fff, 0x4000, 0x1fffff, 0x200000} {
+		word := strings.Repeat("a", l)
+		var buf bytes.Buffer
+		if err := routeros.WriteSentence(&buf, word, "=name=ether1"); err != nil {
+			t.Fatal(err)
+		}
+		words, err := routeros.ReadWords(bufio.NewReader(&buf))
+		if l == 0 {
+			// the empty word terminates the sentence
+			if err != nil || len(words) != 0 {
+				t.Fatalf("empty word, %v %v", words, err)
+			}
+			continue
+		}
+		if err != nil || len(words) != 2 || len(words[0]) != l || words[1] != "=name=ether1" {
+			t.Fatalf("length %d decode error %v", l, err)
+		}
+	}
+}
+
+func TestParseSentence(t *testing.T) {
+	s, err := routeros.ParseSentence([]string{"!re", "=name=ether1", "=comment=a=b", ".tag=3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Word != "!re" || s.Tag != "3" || s.Attrs["name"] != "ether1" || s.Attrs["comment"] != "a=b" {
+		t.Fatalf("sentence error %+v", s)
+	}
+}
+
+func TestCommandWords(t *testing.T) {
+	words, err := routeros.CommandWords("/ping", map[string]string{"count": "3", "address": "8.8.8.8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(words, " ") != "/ping =address=8.8.8.8 =count=3" {
+		t.Fatalf("words error %v", words)
+	}
+	for _, key := range []string{"?name", ".proplist=", "", "a b"} {
+		if _, err = routeros.CommandWords("/interface/print", map[string]string{key: "x"}); err == nil {
+			t.Fatalf("key %q must be rejected", key)
+		}
+	}
+	if _, err = routeros.CommandWords("interface print", nil); err == nil {
+		t.Fatal("command without slash must be rejected")
+	}
+}
+
+func testClient(t *testing.T, server *rostest.Server, tls bool) {
+	server.Handle("/interface/print", func(args map[string]string) ([]map[string]string, error) {
+		return []map[string]string{
+			{".id": "*1", "name": "ether1", "running": "true"},
+			{".id": "*2", "name": "ether2", "running": "false"},
+		}, nil
+	})
+	server.Handle("/ppp/active/remove", func(args map[string]string) ([]map[string]string, error) {
+		return nil, fmt.Errorf("no such item")
+	})
+	c, err := routeros.Dial(routeros.Config{Address: server.Addr(), Username: "admin", Password: "secret", Tls: tls, Fingerprint: server.Fingerprint()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	reply, err := c.Run("/interface/print")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reply.Re) != 2 || reply.Re[0]["name"] != "ether1" || reply.Re[1]["running"] != "false" {
+		t.Fatalf("reply error %+v", reply)
+	}
+	_, err = c.Exec("/ppp/active/remove", map[string]string{".id": "*9"})
+	if de, ok := err.(*routeros.DeviceError); !ok || de.Message != "no such item" {
+		t.Fatalf("expected trap, got %v", err)
+	}
+	// the connection is usable after a trap
+	if _, err = c.Run("/system/reboot"); err == nil || !strings.Contains(err.Error(), "no such command") {
+		t.Fatalf("expected unknown command trap, got %v", err)
+	}
+	cmds := server.Commands()
+	if len(cmds) != 3 || strings.Join(cmds[1], " ") != "/ppp/active/remove =.id=*9" {
+		t.Fatalf("commands error %v", cmds)
+	}
+}
+
+func TestClient(t *testing.T) {
+	server, err := rostest.NewServer("admin", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	testClient(t, server, false)
+
+	_, err = routeros.Dial(routeros.Config{Address: server.Addr(), Username: "admin", Password: "bad"})
+	if err == nil || !strings.Contains(err.Error(), "invalid user name or password") {
+		t.Fatalf("expected login error, got %v", err)
+	}
+}
+
+func TestTLSClient(t *testing.T) {
+	server, err := rostest.NewTLSServer("admin", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	testClient(t, server, true)
+
+	// the self-signed certificate is refused without a pinned fingerprint
+	cfg := routeros.Config{Address: server.Addr(), Username: "admin", Password: "secret", Tls: true}
+	if _, err = routeros.Dial(cfg); err == nil {
+		t.Fatal("unverified certificate must fail")
+	}
+	cfg.Fingerprint = strings.Repeat("ab", 32)
+	if _, err = routeros.Dial(cfg); err == nil || !strings.Contains(err.Error(), "fingerprint mismatch") {
+		t.Fatalf("expected fingerprint mismatch, got %v", err)
+	}
+	cfg.Fingerprint = ""
+	cfg.Insecure = true
+	c, err := routeros.Dial(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+}
+
+func TestNormalizeFingerprint(t *testing.T) {
+	fp := strings.ToUpper(strings.Repeat("0a:", 31)) + "0A"
+	if !routeros.ValidFingerprint(fp) || routeros.NormalizeFingerprint(fp) != strings.Repeat("0a", 32) {
+		t.Fatalf("normalize error %s", routeros.NormalizeFingerprint(fp))
+	}
+	if routeros.ValidFingerprint("0a0b") || routeros.ValidFingerprint(strings.Repeat("zz", 32)) {
+		t.Fatal("invalid fingerprint accepted")
+	}
+}
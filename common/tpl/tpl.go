@@ -115,6 +115,24 @@ func (ct *CommonTemplate) ParseDir(dir string, nameprifix string) error {
 	return nil
 }
 
+// ParseString
+// parse a named template from text, an existing definition is replaced
+func (ct *CommonTemplate) ParseString(name string, text string) error {
+	t, err := ct.Templates.Parse(fmt.Sprintf(`{{define "%s"}}%s{{end}}`, name, text))
+	if err != nil {
+		return err
+	}
+	ct.Templates = t
+	return nil
+}
+
+// RenderString
+func (ct *CommonTemplate) RenderString(name string, data interface{}) (string, error) {
+	var buff strings.Builder
+	err := ct.Templates.ExecuteTemplate(&buff, name, data)
+	return buff.String(), err
+}
+
 func (t *CommonTemplate) Render(w io.Writer, name string, data interface{}, c echo.Context) error {
 	return t.Templates.ExecuteTemplate(w, name, data)
 }
@@ -77,6 +77,121 @@ type SyslogdConfig struct {
 	Debug       bool   `yaml:"debug" json:"debug"`
 }
 
+type CdrConfig struct {
+	Enabled  bool     `yaml:"enabled" json:"enabled"`
+	Rotate   string   `yaml:"rotate" json:"rotate"`
+	Interval int      `yaml:"interval" json:"interval"`
+	Formats  []string `yaml:"formats" json:"formats"`
+	Columns  []string `yaml:"columns" json:"columns"`
+}
+
+type MetricsConfig struct {
+	Enabled bool   `yaml:"enabled" json:"enabled"`
+	Host    string `yaml:"host" json:"host"`
+	Port    int    `yaml:"port" json:"port"`
+}
+
+type PortaldConfig struct {
+	Enabled  bool   `yaml:"enabled" json:"enabled"`
+	Host     string `yaml:"host" json:"host"`
+	Port     int    `yaml:"port" json:"port"`
+	WebPort  int    `yaml:"web_port" json:"web_port"`
+	BrasPort int    `yaml:"bras_port" json:"bras_port"`
+	Version  int    `yaml:"version" json:"version"`
+	Secret   string `yaml:"secret" json:"secret"`
+	Timeout  int    `yaml:"timeout" json:"timeout"`
+	Debug    bool   `yaml:"debug" json:"debug"`
+}
+
+type MailConfig struct {
+	Server   string   `yaml:"server" json:"server"`
+	Port     int      `yaml:"port" json:"port"`
+	Tls      bool     `yaml:"tls" json:"tls"`
+	Username string   `yaml:"username" json:"username"`
+	Alias    string   `yaml:"alias" json:"alias"`
+	Password string   `yaml:"password" json:"password"`
+	Mailtos  []string `yaml:"mailtos" json:"mailtos"`
+}
+
+type NotifyConfig struct {
+	Enabled      bool `yaml:"enabled" json:"enabled"`
+	Interval     int  `yaml:"interval" json:"interval"`
+	ExpireDays   int  `yaml:"expire_days" json:"expire_days"`
+	QuotaPercent int  `yaml:"quota_percent" json:"quota_percent"`
+}
+
+type BillingConfig struct {
+	Enabled  bool `yaml:"enabled" json:"enabled"`
+	Interval int  `yaml:"interval" json:"interval"`
+}
+
+type TacacsdConfig struct {
+	Enabled bool   `yaml:"enabled" json:"enabled"`
+	Host    string `yaml:"host" json:"host"`
+	Port    int    `yaml:"port" json:"port"`
+	Debug   bool   `yaml:"debug" json:"debug"`
+}
+
+type SnmptrapdConfig struct {
+	Enabled    bool   `yaml:"enabled" json:"enabled"`
+	Host       string `yaml:"host" json:"host"`
+	Port       int    `yaml:"port" json:"port"`
+	Community  string `yaml:"community" json:"community"`
+	MibDir     string `yaml:"mib_dir" json:"mib_dir"`
+	MaxRecodes int    `yaml:"max_recodes" json:"max_recodes"`
+	Debug      bool   `yaml:"debug" json:"debug"`
+}
+
+type FlowdConfig struct {
+	Enabled       bool   `yaml:"enabled" json:"enabled"`
+	Host          string `yaml:"host" json:"host"`
+	Port          int    `yaml:"port" json:"port"`
+	FlushInterval int    `yaml:"flush_interval" json:"flush_interval"`
+	HistoryDays   int    `yaml:"history_days" json:"history_days"`
+	Debug         bool   `yaml:"debug" json:"debug"`
+	// Exporters the addresses of the exporters that are not a VPE
+	Exporters []string `yaml:"exporters" json:"exporters"`
+}
+
+type RouterosConfig struct {
+	Timeout   int      `yaml:"timeout" json:"timeout"`
+	Allowlist []string `yaml:"allowlist" json:"allowlist"`
+	// TlsInsecure skips the api-ssl certificate verification of the devices
+	// without a pinned fingerprint
+	TlsInsecure bool `yaml:"tls_insecure" json:"tls_insecure"`
+}
+
+type GenieacsConfig struct {
+	Enabled                  bool   `yaml:"enabled" json:"enabled"`
+	NbiUrl                   string `yaml:"nbi_url" json:"nbi_url"`
+	Username                 string `yaml:"username" json:"username"`
+	Password                 string `yaml:"password" json:"password"`
+	Timeout                  int    `yaml:"timeout" json:"timeout"`
+	ConnectionRequestTimeout int    `yaml:"connection_request_timeout" json:"connection_request_timeout"`
+	SyncInterval             int    `yaml:"sync_interval" json:"sync_interval"`
+	InventoryInterval        int    `yaml:"inventory_interval" json:"inventory_interval"`
+}
+
+type CpeMonitorConfig struct {
+	Enabled        bool     `yaml:"enabled" json:"enabled"`
+	Interval       int      `yaml:"interval" json:"interval"`
+	InformInterval int      `yaml:"inform_interval" json:"inform_interval"`
+	LateFactor     float64  `yaml:"late_factor" json:"late_factor"`
+	OfflineFactor  float64  `yaml:"offline_factor" json:"offline_factor"`
+	HoldDown       int      `yaml:"hold_down" json:"hold_down"`
+	FlapWindow     int      `yaml:"flap_window" json:"flap_window"`
+	FlapThreshold  int      `yaml:"flap_threshold" json:"flap_threshold"`
+	AlertEmail     bool     `yaml:"alert_email" json:"alert_email"`
+	AlertWebhook   bool     `yaml:"alert_webhook" json:"alert_webhook"`
+	Mailtos        []string `yaml:"mailtos" json:"mailtos"`
+}
+
+type IfStatsConfig struct {
+	Enabled     bool `yaml:"enabled" json:"enabled"`
+	Interval    int  `yaml:"interval" json:"interval"`
+	HistoryDays int  `yaml:"history_days" json:"history_days"`
+}
+
 type AppConfig struct {
 	System     SysConfig        `yaml:"system" json:"system"`
 	NBI        NBIConfig        `yaml:"nbi" json:"nbi"`
@@ -85,6 +200,19 @@ type AppConfig struct {
 	Grpc       GrpcConfig       `yaml:"grpc" json:"grpc"`
 	Radiusd    RadiusdConfig    `yaml:"radiusd" json:"radiusd"`
 	Syslogd    SyslogdConfig    `yaml:"syslogd" json:"syslogd"`
+	Cdr        CdrConfig        `yaml:"cdr" json:"cdr"`
+	Metrics    MetricsConfig    `yaml:"metrics" json:"metrics"`
+	Portald    PortaldConfig    `yaml:"portald" json:"portald"`
+	Mail       MailConfig       `yaml:"mail" json:"mail"`
+	Notify     NotifyConfig     `yaml:"notify" json:"notify"`
+	Billing    BillingConfig    `yaml:"billing" json:"billing"`
+	Tacacsd    TacacsdConfig    `yaml:"tacacsd" json:"tacacsd"`
+	Snmptrapd  SnmptrapdConfig  `yaml:"snmptrapd" json:"snmptrapd"`
+	Flowd      FlowdConfig      `yaml:"flowd" json:"flowd"`
+	Routeros   RouterosConfig   `yaml:"routeros" json:"routeros"`
+	Genieacs   GenieacsConfig   `yaml:"genieacs" json:"genieacs"`
+	CpeMonitor CpeMonitorConfig `yaml:"cpe_monitor" json:"cpe_monitor"`
+	IfStats    IfStatsConfig    `yaml:"if_stats" json:"if_stats"`
 }
 
 func (c *AppConfig) GetLogDir() string {
@@ -111,6 +239,10 @@ func (c *AppConfig) GetBackupDir() string {
 	return path.Join(c.System.Workdir, "backup")
 }
 
+func (c *AppConfig) GetCdrDir() string {
+	return path.Join(c.System.Workdir, "cdr")
+}
+
 func (c *AppConfig) InitDirs() {
 	os.MkdirAll(path.Join(c.System.Workdir, "logs"), 0700)
 	os.MkdirAll(path.Join(c.System.Workdir, "radius"), 0700)
@@ -118,7 +250,8 @@ func (c *AppConfig) InitDirs() {
 	os.MkdirAll(path.Join(c.System.Workdir, "public"), 0700)
 	os.MkdirAll(path.Join(c.System.Workdir, "private"), 0700)
 	os.MkdirAll(path.Join(c.System.Workdir, "resource"), 0700)
-	os.MkdirAll(path.Join(c.System.Workdir, "backup"), 0644)
+	os.MkdirAll(path.Join(c.System.Workdir, "backup"), 0700)
+	os.MkdirAll(path.Join(c.System.Workdir, "cdr"), 0700)
 }
 
 var DefaultAppConfig = &AppConfig{
@@ -159,6 +292,116 @@ var DefaultAppConfig = &AppConfig{
 		MaxRecodes:  100000,
 		Debug:       true,
 	},
+	Cdr: CdrConfig{
+		Enabled:  false,
+		Rotate:   "daily",
+		Interval: 5,
+		Formats:  []string{"csv"},
+		Columns:  []string{},
+	},
+	Metrics: MetricsConfig{
+		Enabled: false,
+		Host:    "127.0.0.1",
+		Port:    1982,
+	},
+	Portald: PortaldConfig{
+		Enabled:  false,
+		Host:     "0.0.0.0",
+		Port:     2000,
+		WebPort:  1983,
+		BrasPort: 2000,
+		Version:  2,
+		Secret:   "",
+		Timeout:  5,
+		Debug:    true,
+	},
+	Mail: MailConfig{
+		Server:   "",
+		Port:     25,
+		Tls:      false,
+		Username: "",
+		Alias:    "TeamsACS",
+		Password: "",
+		Mailtos:  []string{},
+	},
+	Notify: NotifyConfig{
+		Enabled:      false,
+		Interval:     60,
+		ExpireDays:   3,
+		QuotaPercent: 80,
+	},
+	Billing: BillingConfig{
+		Enabled:  false,
+		Interval: 5,
+	},
+	Tacacsd: TacacsdConfig{
+		Enabled: false,
+		Host:    "0.0.0.0",
+		Port:    49,
+		Debug:   true,
+	},
+	Snmptrapd: SnmptrapdConfig{
+		Enabled:    false,
+		Host:       "0.0.0.0",
+		Port:       162,
+		Community:  "",
+		MibDir:     "/var/teamsacs/mibs",
+		MaxRecodes: 100000,
+		Debug:      true,
+	},
+	Flowd: FlowdConfig{
+		Enabled:       false,
+		Host:          "0.0.0.0",
+		Port:          2055,
+		FlushInterval: 60,
+		HistoryDays:   30,
+		Debug:         false,
+	},
+	Routeros: RouterosConfig{
+		Timeout:     10,
+		TlsInsecure: false,
+		Allowlist: []string{
+			"/system/resource/print",
+			"/system/identity/print",
+			"/system/clock/print",
+			"/interface/print",
+			"/ip/address/print",
+			"/ip/route/print",
+			"/ip/dhcp-server/lease/print",
+			"/ppp/active/print",
+			"/ppp/active/remove",
+			"/queue/simple/print",
+			"/log/print",
+		},
+	},
+	Genieacs: GenieacsConfig{
+		Enabled:                  false,
+		NbiUrl:                   "http://127.0.0.1:7557",
+		Username:                 "",
+		Password:                 "",
+		Timeout:                  30,
+		ConnectionRequestTimeout: 10,
+		SyncInterval:             30,
+		InventoryInterval:        10,
+	},
+	CpeMonitor: CpeMonitorConfig{
+		Enabled:        false,
+		Interval:       60,
+		InformInterval: 300,
+		LateFactor:     1.5,
+		OfflineFactor:  3,
+		HoldDown:       5,
+		FlapWindow:     60,
+		FlapThreshold:  4,
+		AlertEmail:     false,
+		AlertWebhook:   true,
+		Mailtos:        []string{},
+	},
+	IfStats: IfStatsConfig{
+		Enabled:     false,
+		Interval:    5,
+		HistoryDays: 30,
+	},
 	Mongodb: MongodbConfig{
 		Url:    "mongodb://127.0.0.1:27017",
 		User:   "",
@@ -252,5 +495,90 @@ func LoadConfig(cfile string) *AppConfig {
 		cfg.Radiusd.Debug = v == "true"
 	})
 
+	setEnvValue("TEAMSACS_CDR_ENABLED", func(v string) {
+		cfg.Cdr.Enabled = v == "true"
+	})
+
+	setEnvValue("TEAMSACS_METRICS_ENABLED", func(v string) {
+		cfg.Metrics.Enabled = v == "true"
+	})
+	setEnvValue("TEAMSACS_METRICS_HOST", func(v string) {
+		cfg.Metrics.Host = v
+	})
+	setEnvInt64Value("TEAMSACS_METRICS_PORT", func(v int64) {
+		cfg.Metrics.Port = int(v)
+	})
+
+	setEnvValue("TEAMSACS_PORTALD_ENABLED", func(v string) {
+		cfg.Portald.Enabled = v == "true"
+	})
+	setEnvInt64Value("TEAMSACS_PORTALD_PORT", func(v int64) {
+		cfg.Portald.Port = int(v)
+	})
+	setEnvInt64Value("TEAMSACS_PORTALD_WEB_PORT", func(v int64) {
+		cfg.Portald.WebPort = int(v)
+	})
+	setEnvValue("TEAMSACS_PORTALD_SECRET", func(v string) {
+		cfg.Portald.Secret = v
+	})
+
+	setEnvValue("TEAMSACS_MAIL_SERVER", func(v string) {
+		cfg.Mail.Server = v
+	})
+	setEnvInt64Value("TEAMSACS_MAIL_PORT", func(v int64) {
+		cfg.Mail.Port = int(v)
+	})
+	setEnvValue("TEAMSACS_MAIL_USERNAME", func(v string) {
+		cfg.Mail.Username = v
+	})
+	setEnvValue("TEAMSACS_MAIL_PASSWORD", func(v string) {
+		cfg.Mail.Password = v
+	})
+	setEnvValue("TEAMSACS_NOTIFY_ENABLED", func(v string) {
+		cfg.Notify.Enabled = v == "true"
+	})
+	setEnvValue("TEAMSACS_BILLING_ENABLED", func(v string) {
+		cfg.Billing.Enabled = v == "true"
+	})
+
+	setEnvValue("TEAMSACS_TACACSD_ENABLED", func(v string) {
+		cfg.Tacacsd.Enabled = v == "true"
+	})
+	setEnvInt64Value("TEAMSACS_TACACSD_PORT", func(v int64) {
+		cfg.Tacacsd.Port = int(v)
+	})
+
+	setEnvValue("TEAMSACS_SNMPTRAPD_ENABLED", func(v string) {
+		cfg.Snmptrapd.Enabled = v == "true"
+	})
+	setEnvInt64Value("TEAMSACS_SNMPTRAPD_PORT", func(v int64) {
+		cfg.Snmptrapd.Port = int(v)
+	})
+	setEnvValue("TEAMSACS_SNMPTRAPD_MIB_DIR", func(v string) {
+		cfg.Snmptrapd.MibDir = v
+	})
+
+	setEnvValue("TEAMSACS_GENIEACS_ENABLED", func(v string) {
+		cfg.Genieacs.Enabled = v == "true"
+	})
+	setEnvValue("TEAMSACS_GENIEACS_NBI_URL", func(v string) {
+		cfg.Genieacs.NbiUrl = v
+	})
+
+	setEnvValue("TEAMSACS_CPE_MONITOR_ENABLED", func(v string) {
+		cfg.CpeMonitor.Enabled = v == "true"
+	})
+
+	setEnvValue("TEAMSACS_IF_STATS_ENABLED", func(v string) {
+		cfg.IfStats.Enabled = v == "true"
+	})
+
+	setEnvValue("TEAMSACS_FLOWD_ENABLED", func(v string) {
+		cfg.Flowd.Enabled = v == "true"
+	})
+	setEnvInt64Value("TEAMSACS_FLOWD_PORT", func(v int64) {
+		cfg.Flowd.Port = int(v)
+	})
+
 	return cfg
 }
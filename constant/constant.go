@@ -16,6 +16,11 @@
 
 package constant
 
+import "time"
+
+// ShutdownTimeout the max time to wait for a listener to drain
+const ShutdownTimeout = time.Second * 15
+
 const (
 	NA = "N/A"
 	ENABLED = "enabled"
@@ -24,6 +24,7 @@ const (
 	RadiusAuthlogLevel       = "RadiusAuthlogLevel"
 	RadiusRejectDelay        = "RadiusRejectDelay"
 	RadiusAuthlogHistoryDays = "RadiusAuthlogHistoryDays"
+	RadiusHistoryArchive     = "RadiusHistoryArchive"
 	FreeRadiusApiUrl         = "FreeRadiusApiUrl"
 	FreeRadiusApiToken       = "FreeRadiusApiToken"
 )
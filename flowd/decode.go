@@ -0,0 +1,403 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package flowd
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	netflowV5 = 5
+	netflowV9 = 9
+	ipfix     = 10
+
+	v5HeaderLen    = 24
+	v5RecordLen    = 48
+	v9HeaderLen    = 20
+	ipfixHeaderLen = 16
+
+	// IPFIX variable length field
+	varLength = 65535
+
+	// the template cache of all exporters
+	defaultMaxTemplates = 4096
+)
+
+// information elements used by the collector, shared by NetFlow v9 and IPFIX
+const (
+	fieldInBytes         = 1
+	fieldInPkts          = 2
+	fieldProtocol        = 4
+	fieldSrcPort         = 7
+	fieldSrcAddr         = 8
+	fieldDstPort         = 11
+	fieldDstAddr         = 12
+	fieldLastSwitched    = 21
+	fieldFirstSwitched   = 22
+	fieldSrcAddr6        = 27
+	fieldDstAddr6        = 28
+	fieldOctetTotalCount = 85
+	fieldPktTotalCount   = 86
+	fieldStartSeconds    = 150
+	fieldEndSeconds      = 151
+	fieldStartMillis     = 152
+	fieldEndMillis       = 153
+)
+
+// Flow
+// A decoded flow record
+type Flow struct {
+	Exporter string
+	SrcAddr  net.IP
+	DstAddr  net.IP
+	SrcPort  uint16
+	DstPort  uint16
+	Proto    uint8
+	Bytes    uint64
+	Packets  uint64
+	Start    time.Time
+	End      time.Time
+}
+
+type templateField struct {
+	Type   uint16
+	Length uint16
+	// enterprise specific fields are skipped
+	Enterprise bool
+}
+
+type template struct {
+	Fields  []templateField
+	Updated time.Time
+}
+
+// fixed length of the record, -1 if any field is variable length
+func (t *template) recordLength() int {
+	var n int
+	for _, f := range t.Fields {
+		if f.Length == varLength {
+			return -1
+		}
+		n += int(f.Length)
+	}
+	return n
+}
+
+// Decoder
+// NetFlow v5/v9 and IPFIX decoder, v9 and IPFIX templates are cached
+// per exporter, observation domain and template id
+type Decoder struct {
+	sync.Mutex
+	// MaxTemplates the cached templates, the oldest is replaced when full
+	MaxTemplates int
+	templates    map[string]*template
+}
+
+func NewDecoder() *Decoder {
+	return &Decoder{MaxTemplates: defaultMaxTemplates, templates: make(map[string]*template)}
+}
+
+func templateKey(version int, exporter string, domain uint32, id uint16) string {
+	return fmt.Sprintf("%d/%s/%d/%d", version, exporter, domain, id)
+}
+
+// TemplateCount
+func (d *Decoder) TemplateCount() int {
+	d.Lock()
+	defer d.Unlock()
+	return len(d.templates)
+}
+
+// Decode
+// decode the export packet from the exporter ip
+func (d *Decoder) Decode(packet []byte, exporter string) ([]Flow, error) {
+	if len(packet) < 2 {
+		return nil, fmt.Errorf("packet too short")
+	}
+	switch version := binary.BigEndian.Uint16(packet); version {
+	case netflowV5:
+		return d.decodeV5(packet, exporter)
+	case netflowV9:
+		return d.decodeV9(packet, exporter)
+	case ipfix:
+		return d.decodeIpfix(packet, exporter)
+	default:
+		return nil, fmt.Errorf("unsupported flow version %d", version)
+	}
+}
+
+func (d *Decoder) decodeV5(packet []byte, exporter string) ([]Flow, error) {
+	if len(packet) < v5HeaderLen {
+		return nil, fmt.Errorf("netflow v5 header too short")
+	}
+	count := int(binary.BigEndian.Uint16(packet[2:]))
+	if len(packet) < v5HeaderLen+count*v5RecordLen {
+		return nil, fmt.Errorf("netflow v5 packet truncated, %d records", count)
+	}
+	uptime := binary.BigEndian.Uint32(packet[4:])
+	export := time.Unix(int64(binary.BigEndian.Uint32(packet[8:])), int64(binary.BigEndian.Uint32(packet[12:])))
+	sampling := uint64(binary.BigEndian.Uint16(packet[22:]) & 0x3fff)
+	flows := make([]Flow, 0, count)
+	for i := 0; i < count; i++ {
+		r := packet[v5HeaderLen+i*v5RecordLen:]
+		f := Flow{
+			Exporter: exporter,
+			SrcAddr:  net.IP(append([]byte(nil), r[0:4]...)),
+			DstAddr:  net.IP(append([]byte(nil), r[4:8]...)),
+			Packets:  uint64(binary.BigEndian.Uint32(r[16:])),
+			Bytes:    uint64(binary.BigEndian.Uint32(r[20:])),
+			Start:    uptimeTime(export, uptime, binary.BigEndian.Uint32(r[24:])),
+			End:      uptimeTime(export, uptime, binary.BigEndian.Uint32(r[28:])),
+			SrcPort:  binary.BigEndian.Uint16(r[32:]),
+			DstPort:  binary.BigEndian.Uint16(r[34:]),
+			Proto:    r[38],
+		}
+		if sampling > 1 {
+			f.Bytes *= sampling
+			f.Packets *= sampling
+		}
+		flows = append(flows, f)
+	}
+	return flows, nil
+}
+
+// uptimeTime
+// convert the sysUptime milliseconds of the switched fields to wall clock
+func uptimeTime(export time.Time, uptime, switched uint32) time.Time {
+	return export.Add(-time.Duration(int64(uptime)-int64(switched)) * time.Millisecond)
+}
+
+func (d *Decoder) decodeV9(packet []byte, exporter string) ([]Flow, error) {
+	if len(packet) < v9HeaderLen {
+		return nil, fmt.Errorf("netflow v9 header too short")
+	}
+	uptime := binary.BigEndian.Uint32(packet[4:])
+	export := time.Unix(int64(binary.BigEndian.Uint32(packet[8:])), 0)
+	domain := binary.BigEndian.Uint32(packet[16:])
+	return d.decodeSets(netflowV9, packet[v9HeaderLen:], exporter, domain, export, uptime)
+}
+
+func (d *Decoder) decodeIpfix(packet []byte, exporter string) ([]Flow, error) {
+	if len(packet) < ipfixHeaderLen {
+		return nil, fmt.Errorf("ipfix header too short")
+	}
+	length := int(binary.BigEndian.Uint16(packet[2:]))
+	if length < ipfixHeaderLen || length > len(packet) {
+		return nil, fmt.Errorf("ipfix message length %d invalid", length)
+	}
+	export := time.Unix(int64(binary.BigEndian.Uint32(packet[4:])), 0)
+	domain := binary.BigEndian.Uint32(packet[12:])
+	return d.decodeSets(ipfix, packet[ipfixHeaderLen:length], exporter, domain, export, 0)
+}
+
+// decodeSets
+// walk the flowsets (v9) or sets (IPFIX), templates are learned before
+// the data sets that follow them in the same packet
+func (d *Decoder) decodeSets(version int, data []byte, exporter string, domain uint32, export time.Time, uptime uint32) ([]Flow, error) {
+	var templateSet, optionsSet uint16 = 0, 1
+	if version == ipfix {
+		templateSet, optionsSet = 2, 3
+	}
+	flows := make([]Flow, 0)
+	for len(data) >= 4 {
+		setId := binary.BigEndian.Uint16(data)
+		setLen := int(binary.BigEndian.Uint16(data[2:]))
+		if setLen < 4 || setLen > len(data) {
+			return flows, fmt.Errorf("flowset %d length %d invalid", setId, setLen)
+		}
+		body := data[4:setLen]
+		data = data[setLen:]
+		switch {
+		case setId == templateSet:
+			if err := d.parseTemplates(version, body, exporter, domain); err != nil {
+				return flows, err
+			}
+		case setId == optionsSet:
+			// options templates carry exporter statistics, not flows
+		case setId >= 256:
+			d.Lock()
+			tpl := d.templates[templateKey(version, exporter, domain, setId)]
+			d.Unlock()
+			if tpl == nil {
+				// the template has not been received yet
+				continue
+			}
+			recs, err := decodeRecords(tpl, body)
+			if err != nil {
+				return flows, err
+			}
+			for _, rec := range recs {
+				flows = append(flows, rec.flow(exporter, export, uptime))
+			}
+		}
+	}
+	return flows, nil
+}
+
+func (d *Decoder) parseTemplates(version int, body []byte, exporter string, domain uint32) error {
+	for len(body) >= 4 {
+		id := binary.BigEndian.Uint16(body)
+		count := int(binary.BigEndian.Uint16(body[2:]))
+		body = body[4:]
+		if id < 256 {
+			// padding
+			return nil
+		}
+		tpl := &template{Fields: make([]templateField, 0, count), Updated: time.Now()}
+		for i := 0; i < count; i++ {
+			if len(body) < 4 {
+				return fmt.Errorf("template %d truncated", id)
+			}
+			f := templateField{Type: binary.BigEndian.Uint16(body), Length: binary.BigEndian.Uint16(body[2:])}
+			body = body[4:]
+			if version == ipfix && f.Type&0x8000 != 0 {
+				if len(body) < 4 {
+					return fmt.Errorf("template %d truncated", id)
+				}
+				f.Type &= 0x7fff
+				f.Enterprise = true
+				body = body[4:]
+			}
+			tpl.Fields = append(tpl.Fields, f)
+		}
+		d.addTemplate(templateKey(version, exporter, domain, id), tpl)
+	}
+	return nil
+}
+
+type record map[uint16][]byte
+
+func decodeRecords(tpl *template, body []byte) ([]record, error) {
+	fixed := tpl.recordLength()
+	if fixed == 0 {
+		return nil, fmt.Errorf("empty template")
+	}
+	recs := make([]record, 0)
+records:
+	for len(body) > 0 {
+		if fixed > 0 && len(body) < fixed {
+			// set padding
+			break
+		}
+		rec := make(record, len(tpl.Fields))
+		for i, f := range tpl.Fields {
+			n := int(f.Length)
+			if f.Length == varLength && len(body) > 0 {
+				n, body = int(body[0]), body[1:]
+				if n == 255 && len(body) >= 2 {
+					n, body = int(binary.BigEndian.Uint16(body)), body[2:]
+				}
+			}
+			if len(body) < n {
+				if fixed < 0 && i == 0 {
+					// set padding
+					break records
+				}
+				return recs, fmt.Errorf("flow record truncated")
+			}
+			if !f.Enterprise {
+				rec[f.Type] = body[:n]
+			}
+			body = body[n:]
+		}
+		recs = append(recs, rec)
+	}
+	return recs, nil
+}
+
+func (r record) uint(field uint16) (uint64, bool) {
+	b, ok := r[field]
+	if !ok || len(b) == 0 || len(b) > 8 {
+		return 0, false
+	}
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v, true
+}
+
+func (r record) ip(v4, v6 uint16) net.IP {
+	if b, ok := r[v4]; ok && len(b) == net.IPv4len {
+		return net.IP(append([]byte(nil), b...))
+	}
+	if b, ok := r[v6]; ok && len(b) == net.IPv6len {
+		return net.IP(append([]byte(nil), b...))
+	}
+	return nil
+}
+
+func (r record) flow(exporter string, export time.Time, uptime uint32) Flow {
+	f := Flow{Exporter: exporter, Start: export, End: export}
+	f.SrcAddr = r.ip(fieldSrcAddr, fieldSrcAddr6)
+	f.DstAddr = r.ip(fieldDstAddr, fieldDstAddr6)
+	if v, ok := r.uint(fieldSrcPort); ok {
+		f.SrcPort = uint16(v)
+	}
+	if v, ok := r.uint(fieldDstPort); ok {
+		f.DstPort = uint16(v)
+	}
+	if v, ok := r.uint(fieldProtocol); ok {
+		f.Proto = uint8(v)
+	}
+	if v, ok := r.uint(fieldInBytes); ok {
+		f.Bytes = v
+	} else if v, ok := r.uint(fieldOctetTotalCount); ok {
+		f.Bytes = v
+	}
+	if v, ok := r.uint(fieldInPkts); ok {
+		f.Packets = v
+	} else if v, ok := r.uint(fieldPktTotalCount); ok {
+		f.Packets = v
+	}
+	if v, ok := r.uint(fieldStartMillis); ok {
+		f.Start = time.Unix(0, int64(v)*int64(time.Millisecond))
+	} else if v, ok := r.uint(fieldStartSeconds); ok {
+		f.Start = time.Unix(int64(v), 0)
+	} else if v, ok := r.uint(fieldFirstSwitched); ok && uptime > 0 {
+		f.Start = uptimeTime(export, uptime, uint32(v))
+	}
+	if v, ok := r.uint(fieldEndMillis); ok {
+		f.End = time.Unix(0, int64(v)*int64(time.Millisecond))
+	} else if v, ok := r.uint(fieldEndSeconds); ok {
+		f.End = time.Unix(int64(v), 0)
+	} else if v, ok := r.uint(fieldLastSwitched); ok && uptime > 0 {
+		f.End = uptimeTime(export, uptime, uint32(v))
+	}
+	return f
+}
+
+// addTemplate
+// cache the template, the oldest template is evicted when the cache is full
+func (d *Decoder) addTemplate(key string, tpl *template) {
+	d.Lock()
+	defer d.Unlock()
+	if _, ok := d.templates[key]; !ok && d.MaxTemplates > 0 && len(d.templates) >= d.MaxTemplates {
+		var oldest string
+		for k, t := range d.templates {
+			if oldest == "" || t.Updated.Before(d.templates[oldest].Updated) {
+				oldest = k
+			}
+		}
+		delete(d.templates, oldest)
+	}
+	d.templates[key] = tpl
+}
@@ -0,0 +1,372 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package flowd
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ca17/teamsacs/models"
+)
+
+type packetWriter struct{ bytes.Buffer }
+
+func (w *packetWriter) u8(v uint8)   { w.WriteByte(v) }
+func (w *packetWriter) u16(v uint16) { _ = binary.Write(w, binary.BigEndian, v) }
+func (w *packetWriter) u32(v uint32) { _ = binary.Write(w, binary.BigEndian, v) }
+func (w *packetWriter) ip(s string)  { w.Write(net.ParseIP(s).To4()) }
+
+var exportTime = time.Date(2020, 11, 1, 8, 0, 30, 0, time.UTC)
+
+func v5Packet() []byte {
+	w := &packetWriter{}
+	w.u16(5)
+	w.u16(1)
+	w.u32(100000) // sysUptime
+	w.u32(uint32(exportTime.Unix()))
+	w.u32(0)
+	w.u32(1)
+	w.u8(0)
+	w.u8(0)
+	w.u16(0)
+	// record
+	w.ip("10.10.0.2")
+	w.ip("93.184.216.34")
+	w.ip("0.0.0.0")
+	w.u16(1)
+	w.u16(2)
+	w.u32(10)    // packets
+	w.u32(4000)  // bytes
+	w.u32(90000) // first
+	w.u32(99000) // last
+	w.u16(51000)
+	w.u16(443)
+	w.u8(0)
+	w.u8(0x18)
+	w.u8(6)
+	w.u8(0)
+	w.Write(make([]byte, 8))
+	return w.Bytes()
+}
+
+func v9Packet(withTemplate bool) []byte {
+	w := &packetWriter{}
+	count := uint16(1)
+	if withTemplate {
+		count = 2
+	}
+	w.u16(9)
+	w.u16(count)
+	w.u32(100000)
+	w.u32(uint32(exportTime.Unix()))
+	w.u32(1)
+	w.u32(7) // source id
+	if withTemplate {
+		w.u16(0)
+		w.u16(4 + 4 + 7*4)
+		w.u16(260)
+		w.u16(7)
+		for _, f := range [][2]uint16{{8, 4}, {12, 4}, {7, 2}, {11, 2}, {4, 1}, {1, 4}, {2, 4}} {
+			w.u16(f[0])
+			w.u16(f[1])
+		}
+	}
+	// data flowset with 2 records and padding
+	w.u16(260)
+	w.u16(4 + 2*21 + 2)
+	for _, rec := range []struct {
+		src, dst string
+		sport    uint16
+		dport    uint16
+		proto    uint8
+		bytes    uint32
+	}{
+		{"93.184.216.34", "10.10.0.2", 443, 51000, 6, 60000},
+		{"10.10.0.3", "8.8.8.8", 53000, 53, 17, 120},
+	} {
+		w.ip(rec.src)
+		w.ip(rec.dst)
+		w.u16(rec.sport)
+		w.u16(rec.dport)
+		w.u8(rec.proto)
+		w.u32(rec.bytes)
+		w.u32(2)
+	}
+	w.u16(0)
+	return w.Bytes()
+}
+
+func ipfixPacket() []byte {
+	body := &packetWriter{}
+	// template set with an enterprise field and a variable length field
+	body.u16(2)
+	body.u16(4 + 4 + 6*4 + 4 + 4)
+	body.u16(300)
+	body.u16(7)
+	body.u16(8)
+	body.u16(4)
+	body.u16(12)
+	body.u16(4)
+	body.u16(7)
+	body.u16(2)
+	body.u16(11)
+	body.u16(2)
+	body.u16(0x8000 | 100)
+	body.u16(65535)
+	body.u32(14988) // enterprise number
+	body.u16(85)
+	body.u16(8)
+	body.u16(153)
+	body.u16(8)
+	// data set
+	body.u16(300)
+	body.u16(4 + 4 + 4 + 2 + 2 + 1 + 3 + 8 + 8)
+	body.ip("10.10.0.2")
+	body.ip("1.1.1.1")
+	body.u16(40000)
+	body.u16(853)
+	body.u8(3)
+	body.Write([]byte("dot"))
+	_ = binary.Write(body, binary.BigEndian, uint64(1500))
+	_ = binary.Write(body, binary.BigEndian, uint64(exportTime.Add(-time.Second).UnixNano()/int64(time.Millisecond)))
+
+	w := &packetWriter{}
+	w.u16(10)
+	w.u16(uint16(16 + body.Len()))
+	w.u32(uint32(exportTime.Unix()))
+	w.u32(1)
+	w.u32(1)
+	w.Write(body.Bytes())
+	return w.Bytes()
+}
+
+func TestDecodeV5(t *testing.T) {
+	flows, err := NewDecoder().Decode(v5Packet(), "192.168.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(flows) != 1 {
+		t.Fatalf("expected 1 flow, got %d", len(flows))
+	}
+	f := flows[0]
+	if f.SrcAddr.String() != "10.10.0.2" || f.DstPort != 443 || f.Proto != 6 || f.Bytes != 4000 || f.Packets != 10 {
+		t.Fatalf("flow error %+v", f)
+	}
+	if !f.End.Equal(exportTime.Add(-time.Second)) {
+		t.Fatalf("flow end %s", f.End)
+	}
+}
+
+func TestDecodeV9(t *testing.T) {
+	d := NewDecoder()
+	flows, err := d.Decode(v9Packet(false), "192.168.0.1")
+	if err != nil || len(flows) != 0 {
+		t.Fatalf("data before template, %d flows, %v", len(flows), err)
+	}
+	flows, err = d.Decode(v9Packet(true), "192.168.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(flows) != 2 || d.TemplateCount() != 1 {
+		t.Fatalf("expected 2 flows, got %d", len(flows))
+	}
+	if flows[0].DstAddr.String() != "10.10.0.2" || flows[0].Bytes != 60000 || flows[1].DstPort != 53 {
+		t.Fatalf("flows error %+v", flows)
+	}
+	// the cached template is used by the later packets
+	if flows, _ = d.Decode(v9Packet(false), "192.168.0.1"); len(flows) != 2 {
+		t.Fatalf("expected 2 flows, got %d", len(flows))
+	}
+	// templates are per exporter
+	if flows, _ = d.Decode(v9Packet(false), "192.168.0.2"); len(flows) != 0 {
+		t.Fatalf("expected 0 flows, got %d", len(flows))
+	}
+	// the oldest template is evicted when the cache is full
+	d.MaxTemplates = 1
+	if flows, _ = d.Decode(v9Packet(true), "192.168.0.2"); len(flows) != 2 || d.TemplateCount() != 1 {
+		t.Fatalf("expected 2 flows and 1 template, got %d %d", len(flows), d.TemplateCount())
+	}
+	if flows, _ = d.Decode(v9Packet(false), "192.168.0.1"); len(flows) != 0 {
+		t.Fatalf("expected 0 flows, got %d", len(flows))
+	}
+}
+
+func TestDecodeIpfix(t *testing.T) {
+	flows, err := NewDecoder().Decode(ipfixPacket(), "192.168.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(flows) != 1 {
+		t.Fatalf("expected 1 flow, got %d", len(flows))
+	}
+	f := flows[0]
+	if f.DstAddr.String() != "1.1.1.1" || f.DstPort != 853 || f.Bytes != 1500 {
+		t.Fatalf("flow error %+v", f)
+	}
+	if !f.End.Equal(exportTime.Add(-time.Second)) {
+		t.Fatalf("flow end %s", f.End)
+	}
+}
+
+func TestAppPort(t *testing.T) {
+	if p := appPort(Flow{SrcPort: 51000, DstPort: 443}, true); p != 443 {
+		t.Fatalf("client port %d", p)
+	}
+	if p := appPort(Flow{SrcPort: 51000, DstPort: 22}, false); p != 22 {
+		t.Fatalf("server port %d", p)
+	}
+}
+
+func TestFlowServer(t *testing.T) {
+	var lock sync.Mutex
+	stats := make([]models.FlowStat, 0)
+	s := &FlowServer{
+		Decoder: NewDecoder(),
+		IsExporter: func(ip string) bool {
+			return ip == "127.0.0.1"
+		},
+		GetOnlines: func() ([]models.OnlineIpaddr, error) {
+			return []models.OnlineIpaddr{
+				{Username: "old", FramedIpaddr: "10.10.0.2", AcctStartTime: exportTime.Add(-time.Hour * 2)},
+				{Username: "alice", FramedIpaddr: "10.10.0.2", AcctStartTime: exportTime.Add(-time.Hour)},
+				// the session started after the flows
+				{Username: "bob", FramedIpaddr: "10.10.0.3", AcctStartTime: exportTime.Add(time.Hour)},
+			}, nil
+		},
+		OnFlush: func(items []models.FlowStat) error {
+			lock.Lock()
+			defer lock.Unlock()
+			stats = append(stats, items...)
+			return nil
+		},
+		stats: make(map[statKey]*models.FlowStat),
+	}
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		_ = s.Serve(ctx, conn)
+		close(done)
+	}()
+	client, err := net.DialUDP("udp", nil, conn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	for _, p := range [][]byte{v5Packet(), v9Packet(true), ipfixPacket()} {
+		if _, err = client.Write(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(time.Millisecond * 200)
+	cancel()
+	<-done
+
+	lock.Lock()
+	defer lock.Unlock()
+	byPort := make(map[int]models.FlowStat)
+	for _, st := range stats {
+		if st.Username != "alice" {
+			t.Fatalf("flow attributed to %s", st.Username)
+		}
+		byPort[st.Port] = st
+	}
+	if len(byPort) != 2 {
+		t.Fatalf("expected 2 ports, got %+v", stats)
+	}
+	// v5 upload and v9 download of the same port and minute are merged
+	https := byPort[443]
+	if https.UpBytes != 4000 || https.DownBytes != 60000 || https.Flows != 2 || https.Proto != 6 {
+		t.Fatalf("https stat error %+v", https)
+	}
+	if !https.Minute.Equal(time.Date(2020, 11, 1, 8, 0, 0, 0, time.UTC)) {
+		t.Fatalf("minute error %s", https.Minute)
+	}
+	if dot := byPort[853]; dot.UpBytes != 1500 {
+		t.Fatalf("dot stat error %+v", dot)
+	}
+}
+
+func TestFlowServerUnknownExporter(t *testing.T) {
+	s := &FlowServer{
+		Decoder: NewDecoder(),
+		IsExporter: func(ip string) bool {
+			return false
+		},
+		GetOnlines: func() ([]models.OnlineIpaddr, error) {
+			return []models.OnlineIpaddr{{Username: "alice", FramedIpaddr: "10.10.0.2", AcctStartTime: exportTime.Add(-time.Hour)}}, nil
+		},
+		OnFlush: func(items []models.FlowStat) error {
+			t.Errorf("flows of an unknown exporter %+v", items)
+			return nil
+		},
+		stats: make(map[statKey]*models.FlowStat),
+	}
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		_ = s.Serve(ctx, conn)
+		close(done)
+	}()
+	client, err := net.DialUDP("udp", nil, conn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if _, err = client.Write(v9Packet(true)); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 200)
+	cancel()
+	<-done
+	if s.Decoder.TemplateCount() != 0 {
+		t.Fatal("template of an unknown exporter cached")
+	}
+}
+
+func TestStoppedSessionFlows(t *testing.T) {
+	now := time.Now()
+	s := &FlowServer{
+		GetOnlines: func() ([]models.OnlineIpaddr, error) {
+			return []models.OnlineIpaddr{}, nil
+		},
+		GetStopped: func(since time.Time) ([]models.OnlineIpaddr, error) {
+			return []models.OnlineIpaddr{
+				{Username: "carol", FramedIpaddr: "10.10.0.5", AcctStartTime: now.Add(-time.Hour), AcctStopTime: now.Add(-time.Minute * 5)},
+			}, nil
+		},
+	}
+	ip := net.ParseIP("10.10.0.5")
+	if o := s.getOnline(ip, now.Add(-time.Minute*10)); o == nil || o.Username != "carol" {
+		t.Fatalf("flow of the stopped session %+v", o)
+	}
+	if o := s.getOnline(ip, now); o != nil {
+		t.Fatalf("flow after the session stopped %+v", o)
+	}
+}
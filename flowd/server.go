@@ -0,0 +1,272 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package flowd
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/ca17/teamsacs/common"
+	"github.com/ca17/teamsacs/common/log"
+	"github.com/ca17/teamsacs/config"
+	"github.com/ca17/teamsacs/models"
+)
+
+const (
+	onlineCacheTTL = time.Second * 30
+	// the flows of the sessions stopped in the window are still attributed
+	stoppedWindow = time.Minute * 30
+	maxPacketSize = 65535
+)
+
+type statKey struct {
+	Username string
+	Minute   int64
+	Proto    uint8
+	Port     uint16
+}
+
+// FlowServer
+// NetFlow v5/v9 and IPFIX collector, the flows are attributed to the online
+// subscribers by framed ip and merged into minute aggregates
+type FlowServer struct {
+	Config  config.FlowdConfig
+	Decoder *Decoder
+
+	// IsExporter the known exporters, the packets of other addresses are dropped
+	IsExporter func(ip string) bool
+	// GetOnlines the framed ip of the online sessions
+	GetOnlines func() ([]models.OnlineIpaddr, error)
+	// GetStopped the framed ip of the sessions stopped since the time
+	GetStopped func(since time.Time) ([]models.OnlineIpaddr, error)
+	// OnFlush store the aggregates
+	OnFlush func(stats []models.FlowStat) error
+
+	onlineLock    sync.Mutex
+	onlines       map[string][]models.OnlineIpaddr
+	onlineExpires time.Time
+
+	statLock sync.Mutex
+	stats    map[statKey]*models.FlowStat
+}
+
+func NewFlowServer(manager *models.ModelManager) *FlowServer {
+	fm := manager.GetFlowManager()
+	return &FlowServer{
+		Config:  manager.Config.Flowd,
+		Decoder: NewDecoder(),
+		IsExporter: func(ip string) bool {
+			if common.InSlice(ip, manager.Config.Flowd.Exporters) {
+				return true
+			}
+			_, err := manager.GetVpeManager().GetVpeByIpaddr(ip)
+			return err == nil
+		},
+		GetOnlines: fm.GetOnlineIpaddrs,
+		GetStopped: fm.GetStoppedIpaddrs,
+		OnFlush:    fm.AddFlowStats,
+		stats:      make(map[statKey]*models.FlowStat),
+	}
+}
+
+// ListenAndServe
+func (s *FlowServer) ListenAndServe(ctx context.Context) error {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP(s.Config.Host), Port: s.Config.Port})
+	if err != nil {
+		return err
+	}
+	log.Infof("Starting flow collector on %s", conn.LocalAddr())
+	return s.Serve(ctx, conn)
+}
+
+// Serve
+// Read export packets until the context is done, the aggregates are
+// flushed every flush interval and on exit
+func (s *FlowServer) Serve(ctx context.Context, conn *net.UDPConn) error {
+	interval := time.Duration(s.Config.FlushInterval) * time.Second
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	done := make(chan struct{})
+	defer func() {
+		close(done)
+		s.Flush()
+	}()
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.Flush()
+			case <-ctx.Done():
+				_ = conn.Close()
+				return
+			case <-done:
+				return
+			}
+		}
+	}()
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, addr, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		exporter := addr.IP.String()
+		if s.IsExporter == nil || !s.IsExporter(exporter) {
+			if s.Config.Debug {
+				log.Debugf("drop flow packet from unknown exporter %s", addr)
+			}
+			continue
+		}
+		flows, err := s.Decoder.Decode(buf[:n], exporter)
+		if err != nil {
+			log.Errorf("decode flow packet from %s error, %s", addr, err.Error())
+		}
+		if s.Config.Debug {
+			log.Debugf("flow packet from %s, %d flows", addr, len(flows))
+		}
+		s.HandleFlows(flows)
+	}
+}
+
+// getOnline
+// the session that owned the ip at the flow time, the online sessions
+// and the sessions stopped in the window
+func (s *FlowServer) getOnline(ip net.IP, t time.Time) *models.OnlineIpaddr {
+	if ip == nil {
+		return nil
+	}
+	s.onlineLock.Lock()
+	defer s.onlineLock.Unlock()
+	if time.Now().After(s.onlineExpires) {
+		s.loadOnlines()
+	}
+	var result *models.OnlineIpaddr
+	items := s.onlines[ip.String()]
+	for i := range items {
+		item := &items[i]
+		if !item.Covers(t) {
+			continue
+		}
+		if result == nil || item.AcctStartTime.After(result.AcctStartTime) {
+			result = item
+		}
+	}
+	return result
+}
+
+func (s *FlowServer) loadOnlines() {
+	items, err := s.GetOnlines()
+	if err != nil {
+		log.Errorf("query online ipaddrs error, %s", err.Error())
+		return
+	}
+	if s.GetStopped != nil {
+		stopped, err := s.GetStopped(time.Now().Add(-stoppedWindow))
+		if err != nil {
+			log.Errorf("query stopped ipaddrs error, %s", err.Error())
+			return
+		}
+		items = append(items, stopped...)
+	}
+	s.onlines = make(map[string][]models.OnlineIpaddr)
+	for _, item := range items {
+		s.onlines[item.FramedIpaddr] = append(s.onlines[item.FramedIpaddr], item)
+	}
+	s.onlineExpires = time.Now().Add(onlineCacheTTL)
+}
+
+// HandleFlows
+// attribute the flows to subscribers and add them to the aggregates,
+// flows of unknown addresses are dropped
+func (s *FlowServer) HandleFlows(flows []Flow) {
+	for _, f := range flows {
+		t := f.End
+		if t.IsZero() {
+			t = time.Now()
+		}
+		up := true
+		online := s.getOnline(f.SrcAddr, t)
+		if online == nil {
+			up = false
+			if online = s.getOnline(f.DstAddr, t); online == nil {
+				continue
+			}
+		}
+		s.add(online.Username, t, f, up)
+	}
+}
+
+// appPort
+// the remote port of the subscriber, or the local port when the subscriber
+// serves a well known port
+func appPort(f Flow, up bool) uint16 {
+	local, remote := f.SrcPort, f.DstPort
+	if !up {
+		local, remote = f.DstPort, f.SrcPort
+	}
+	if remote >= 1024 && local > 0 && local < 1024 {
+		return local
+	}
+	return remote
+}
+
+func (s *FlowServer) add(username string, t time.Time, f Flow, up bool) {
+	minute := t.Truncate(time.Minute)
+	key := statKey{Username: username, Minute: minute.Unix(), Proto: f.Proto, Port: appPort(f, up)}
+	s.statLock.Lock()
+	defer s.statLock.Unlock()
+	st, ok := s.stats[key]
+	if !ok {
+		st = &models.FlowStat{Username: username, Minute: minute, Proto: int(key.Proto), Port: int(key.Port)}
+		s.stats[key] = st
+	}
+	if up {
+		st.UpBytes += int64(f.Bytes)
+		st.UpPackets += int64(f.Packets)
+	} else {
+		st.DownBytes += int64(f.Bytes)
+		st.DownPackets += int64(f.Packets)
+	}
+	st.Flows++
+}
+
+// Flush
+// store and reset the aggregates, the stored minutes are merged
+// so late flows of a flushed minute are not lost
+func (s *FlowServer) Flush() {
+	s.statLock.Lock()
+	stats := make([]models.FlowStat, 0, len(s.stats))
+	for _, st := range s.stats {
+		stats = append(stats, *st)
+	}
+	s.stats = make(map[statKey]*models.FlowStat)
+	s.statLock.Unlock()
+	if len(stats) == 0 {
+		return
+	}
+	if err := s.OnFlush(stats); err != nil {
+		log.Errorf("store %d flow stats error, %s", len(stats), err.Error())
+	}
+}
@@ -27,6 +27,7 @@ import (
 	"github.com/ca17/teamsacs/common/validutil"
 	"github.com/ca17/teamsacs/common/web"
 	"github.com/ca17/teamsacs/constant"
+	"github.com/ca17/teamsacs/models"
 
 	"github.com/labstack/echo/v4"
 )
@@ -48,6 +49,11 @@ func (h *HttpHandler) InitAllRouter(e *echo.Echo) {
 
 // 添加认证日志
 func (h *HttpHandler) AddAuthlog(username string, nasip string, result string, reason string, level string, cast int64) {
+	if result == RadiusAuthSucces {
+		h.GetManager().Events.Publish(models.NewAuthEvent(models.EventAuthAccept, username, nasip, ""))
+	} else {
+		h.GetManager().Events.Publish(models.NewAuthEvent(models.EventAuthReject, username, nasip, reason))
+	}
 	if level != "all" || result != level {
 		err := h.GetManager().GetRadiusManager().AddRadiusAuthLog(username, nasip, result, reason, cast)
 		if err != nil {
@@ -92,6 +98,18 @@ func (h *HttpHandler) FreeradiusAuthorize(c echo.Context) error {
 		return c.JSON(501, echo.Map{"Reply-Message": "user expire, reject auth"})
 	}
 
+	// Check user start time, such as fixed date vouchers
+	if user.GetStartTime().After(time.Now()) {
+		h.AddAuthlog(username, nasip, RadiusAuthFailure, "user not yet valid", RadiusAuthlogLevel, time.Since(start).Milliseconds())
+		return c.JSON(501, echo.Map{"Reply-Message": "user not yet valid, reject auth"})
+	}
+
+	// Check prepaid balance
+	if err := h.GetManager().GetBillingManager().CheckBalance(username); err != nil {
+		h.AddAuthlog(username, nasip, RadiusAuthFailure, "user balance exhausted", RadiusAuthlogLevel, time.Since(start).Milliseconds())
+		return c.JSON(501, echo.Map{"Reply-Message": "user balance exhausted, reject auth"})
+	}
+
 	// Evaluation of online limit
 	// Current number online
 	count, err := h.GetManager().GetRadiusManager().GetOnlineCount(username)
@@ -155,5 +173,24 @@ func (h *HttpHandler) FreeradiusAccounting(c echo.Context) error {
 	if err != nil {
 		log.Error(err)
 	}
+	switch webform.GetVal("acctStatusType") {
+	case "Start":
+		online := models.NewRadiusOnlineFromForm(webform)
+		if err = h.GetManager().GetVoucherManager().ActivateVoucher(online.Username); err != nil {
+			log.Error(err)
+		}
+		h.GetManager().Events.Publish(models.NewSessionEvent(models.EventAcctStart, &online))
+	case "Update", "Alive", "Interim-Update":
+		if err = h.GetManager().GetVoucherManager().CheckVoucherQuota(webform.GetVal("username")); err != nil {
+			log.Error(err)
+		}
+	case "Stop":
+		online := models.NewRadiusOnlineFromForm(webform)
+		if err = h.GetManager().GetVoucherManager().CheckVoucherQuota(online.Username); err != nil {
+			log.Error(err)
+		}
+		online.AcctStopTime = time.Now()
+		h.GetManager().Events.Publish(models.NewSessionEvent(models.EventAcctStop, &online))
+	}
 	return c.JSON(http.StatusOK, map[string]interface{}{})
 }
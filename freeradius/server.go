@@ -17,6 +17,7 @@
 package freeradius
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"os"
@@ -29,11 +30,12 @@ import (
 
 	"github.com/ca17/teamsacs/common"
 	"github.com/ca17/teamsacs/common/log"
+	"github.com/ca17/teamsacs/constant"
 	"github.com/ca17/teamsacs/models"
 )
 
 // 运行管理系统
-func ListenFreeRADIUSServer(manager *models.ModelManager) error {
+func ListenFreeRADIUSServer(ctx context.Context, manager *models.ModelManager) error {
 	e := echo.New()
 	e.Pre(middleware.RemoveTrailingSlash())
 	if os.Getenv("ELASTIC_APM_SERVER_URL") != "" {
@@ -56,17 +58,32 @@ func ListenFreeRADIUSServer(manager *models.ModelManager) error {
 	e.Logger.SetLevel(common.If(manager.Config.Freeradius.Debug, elog.DEBUG, elog.INFO).(elog.Lvl))
 	e.Debug = manager.Config.Freeradius.Debug
 
+	go shutdownOnDone(ctx, e)
 	var servaddr = fmt.Sprintf("%s:%d", manager.Config.Freeradius.Host, manager.Config.Freeradius.Port)
 	log.Info("try start tls web server")
 	err := e.StartTLS(servaddr,path.Join(manager.Config.GetPrivateDir(), "freeradius-api.tls.crt"), path.Join(manager.Config.GetPrivateDir(), "freeradius-api.tls.key"))
-	if err != nil {
+	if err != nil && err != http.ErrServerClosed && ctx.Err() == nil {
 		log.Warningf("start tls server error %s", err)
 		log.Infof("start web server %s", servaddr)
 		err = e.Start(servaddr)
 	}
+	if err == http.ErrServerClosed {
+		return nil
+	}
 	return err
 }
 
+// shutdownOnDone
+// Stop the echo server gracefully when the context is done
+func shutdownOnDone(ctx context.Context, e *echo.Echo) {
+	<-ctx.Done()
+	sctx, cancel := context.WithTimeout(context.Background(), constant.ShutdownTimeout)
+	defer cancel()
+	if err := e.Shutdown(sctx); err != nil {
+		log.Errorf("shutdown web server error, %s", err.Error())
+	}
+}
+
 func ServerRecover(debug bool) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
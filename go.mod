@@ -8,14 +8,17 @@ require (
 	github.com/bwmarrin/snowflake v0.3.0
 	github.com/dgrijalva/jwt-go v3.2.0+incompatible
 	github.com/go-co-op/gocron v0.1.1
-	github.com/golang/protobuf v1.4.2
+	github.com/golang/protobuf v1.4.3
+	github.com/gosnmp/gosnmp v1.29.0
 	github.com/influxdata/go-syslog/v3 v3.0.0
+	github.com/jung-kurt/gofpdf v1.16.2
 	github.com/labstack/echo/v4 v4.1.15
 	github.com/labstack/gommon v0.3.0
 	github.com/mitchellh/mapstructure v1.2.2
 	github.com/op/go-logging v0.0.0-20160315200505-970db520ece7
 	github.com/orcaman/concurrent-map v0.0.0-20190826125027-8c72a8bb44f6
 	github.com/pkg/errors v0.9.1
+	github.com/prometheus/client_golang v1.8.0
 	go.elastic.co/apm/module/apmechov4 v1.8.0
 	go.mongodb.org/mongo-driver v1.4.2
 	golang.org/x/sync v0.0.0-20190911185100-cd5d95a43a6e
@@ -23,6 +26,6 @@ require (
 	google.golang.org/protobuf v1.23.0
 	gopkg.in/alexcesaro/quotedprintable.v3 v3.0.0-20150716171945-2caba252f4dc // indirect
 	gopkg.in/gomail.v2 v2.0.0-20160411212932-81ebce5c23df
-	gopkg.in/yaml.v2 v2.2.8
+	gopkg.in/yaml.v2 v2.3.0
 	layeh.com/radius v0.0.0-20200828214147-d62a6e0a1819
 )
@@ -21,11 +21,13 @@ import (
 	"fmt"
 	"net"
 	"path"
+	"time"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/reflection"
 
+	"github.com/ca17/teamsacs/constant"
 	"github.com/ca17/teamsacs/models"
 )
 
@@ -40,7 +42,7 @@ func (s *server) GetConfig(ctx context.Context, request *GetConfigRequest) (*Get
 
 
 
-func StartGrpcServer(manager *models.ModelManager) error {
+func StartGrpcServer(ctx context.Context, manager *models.ModelManager) error {
 	appconfig := manager.Config
 	certfile := path.Join(appconfig.GetPrivateDir(), "teamsacs-grpc.tls.crt")
 	keyfile := path.Join(appconfig.GetPrivateDir(), "teamsacs-grpc.tls.key")
@@ -55,5 +57,18 @@ func StartGrpcServer(manager *models.ModelManager) error {
 	s := grpc.NewServer(grpc.Creds(creds))
 	RegisterTeamsacsServiceServer(s, &server{manager: manager})
 	reflection.Register(s)
+	go func() {
+		<-ctx.Done()
+		stopped := make(chan struct{})
+		go func() {
+			s.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-time.After(constant.ShutdownTimeout):
+			s.Stop()
+		}
+	}()
 	return s.Serve(lis)
 }
@@ -0,0 +1,98 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+
+	"github.com/ca17/teamsacs/common/log"
+	"github.com/ca17/teamsacs/constant"
+)
+
+// Lifecycle
+// Run all listeners with a shared context, stop them all on SIGTERM/SIGINT
+// or when any one of them fails, call the reload hook on SIGHUP
+type Lifecycle struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	group  *errgroup.Group
+	gctx   context.Context
+	// the max time to wait for the listeners after the stop
+	timeout time.Duration
+}
+
+func NewLifecycle() *Lifecycle {
+	ctx, cancel := context.WithCancel(context.Background())
+	group, gctx := errgroup.WithContext(ctx)
+	return &Lifecycle{ctx: ctx, cancel: cancel, group: group, gctx: gctx, timeout: constant.ShutdownTimeout}
+}
+
+// Go
+// start a named listener, the listener must return when ctx is done
+func (l *Lifecycle) Go(name string, fn func(ctx context.Context) error) {
+	l.group.Go(func() error {
+		log.Infof("Start %s ...", name)
+		err := fn(l.gctx)
+		if err != nil {
+			log.Errorf("%s failure, all services will be stopped, %s", name, err.Error())
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		log.Infof("%s stopped", name)
+		return nil
+	})
+}
+
+// Wait
+// Block until a stop signal is received or a listener fails,
+// then wait for all listeners to drain, at most the shutdown timeout
+func (l *Lifecycle) Wait(reload func()) error {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(sigs)
+	for running := true; running; {
+		select {
+		case sig := <-sigs:
+			if sig == syscall.SIGHUP {
+				log.Info("Received SIGHUP, reload config")
+				reload()
+				continue
+			}
+			log.Infof("Received %s, shutting down ...", sig)
+			running = false
+		case <-l.gctx.Done():
+			running = false
+		}
+	}
+	l.cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- l.group.Wait()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(l.timeout):
+		return fmt.Errorf("listeners not stopped in %s", l.timeout)
+	}
+}
@@ -0,0 +1,65 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLifecycleListenerFailure(t *testing.T) {
+	lc := NewLifecycle()
+	stopped := make(chan struct{})
+	lc.Go("Test Server", func(ctx context.Context) error {
+		<-ctx.Done()
+		close(stopped)
+		return nil
+	})
+	lc.Go("Broken Server", func(ctx context.Context) error {
+		return errors.New("address already in use")
+	})
+	err := lc.Wait(func() {})
+	if err == nil || !strings.HasPrefix(err.Error(), "Broken Server") {
+		t.Fatalf("unexpected error %v", err)
+	}
+	select {
+	case <-stopped:
+	default:
+		t.Fatal("listener not stopped")
+	}
+}
+
+func TestLifecycleShutdownTimeout(t *testing.T) {
+	lc := NewLifecycle()
+	lc.timeout = time.Millisecond * 100
+	block := make(chan struct{})
+	defer close(block)
+	lc.Go("Stuck Server", func(ctx context.Context) error {
+		<-block
+		return nil
+	})
+	lc.Go("Broken Server", func(ctx context.Context) error {
+		return errors.New("address already in use")
+	})
+	err := lc.Wait(func() {})
+	if err == nil || !strings.HasPrefix(err.Error(), "listeners not stopped") {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
@@ -17,31 +17,34 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"os"
 	"runtime"
-	"time"
 	_ "time/tzdata"
 
 	"github.com/op/go-logging"
-	"golang.org/x/sync/errgroup"
-
+	"github.com/ca17/teamsacs/common"
 	"github.com/ca17/teamsacs/common/installer"
 	"github.com/ca17/teamsacs/common/log"
+	"github.com/ca17/teamsacs/common/metrics"
 	"github.com/ca17/teamsacs/config"
+	"github.com/ca17/teamsacs/constant"
+	"github.com/ca17/teamsacs/flowd"
 	"github.com/ca17/teamsacs/freeradius"
 	"github.com/ca17/teamsacs/grpcservice"
 	"github.com/ca17/teamsacs/models"
 	"github.com/ca17/teamsacs/nbi"
+	"github.com/ca17/teamsacs/portald"
 	"github.com/ca17/teamsacs/radiusd"
 	"github.com/ca17/teamsacs/radiusd/radlog"
+	"github.com/ca17/teamsacs/snmptrapd"
 	"github.com/ca17/teamsacs/syslogd"
+	"github.com/ca17/teamsacs/tacacsd"
 )
 
 var (
-	g errgroup.Group
-
 	BuildVersion   string
 	ReleaseVersion string
 	BuildTime      string
@@ -114,21 +117,36 @@ func setupAppconfig() *config.AppConfig {
 	return appconfig
 }
 
-func setupLogging(appconfig *config.AppConfig) {
-	// system logging
-	level := logging.INFO
+func logLevels(appconfig *config.AppConfig) (level, radlevel logging.Level) {
+	level, radlevel = logging.INFO, logging.INFO
 	if appconfig.NBI.Debug {
 		level = logging.DEBUG
 	}
-	log.SetupLog(level, appconfig.System.SyslogAddr, appconfig.GetLogDir(), appconfig.System.Appid)
-
-	// radius logging
-	radlevel := logging.INFO
 	if appconfig.Radiusd.Debug {
 		radlevel = logging.DEBUG
 	}
+	return
+}
+
+func setupLogging(appconfig *config.AppConfig) {
+	level, radlevel := logLevels(appconfig)
+	// system logging
+	log.SetupLog(level, appconfig.System.SyslogAddr, appconfig.GetLogDir(), appconfig.System.Appid)
+	// radius logging
 	radlog.SetupLog(radlevel, appconfig.System.SyslogAddr, appconfig.GetLogDir(), "Radiusd")
+}
 
+// reloadLogging
+// reload debug options from the config file and apply the log level
+func reloadLogging(appconfig *config.AppConfig) {
+	newcfg := config.LoadConfig(*conffile)
+	appconfig.NBI.Debug = newcfg.NBI.Debug || *debug
+	appconfig.Radiusd.Debug = newcfg.Radiusd.Debug || *debug
+	appconfig.Grpc.Debug = newcfg.Grpc.Debug || *debug
+	level, radlevel := logLevels(appconfig)
+	log.SetLevel(level, appconfig.System.Appid)
+	radlog.SetLevel(radlevel, "Radiusd")
+	log.Infof("log level reloaded, system=%s radius=%s", level, radlevel)
 }
 
 func installService(appconfig *config.AppConfig) bool {
@@ -194,62 +212,82 @@ func main() {
 		log.Debug("Running for Dev Mode")
 	}
 
-	g.Go(func() error {
-		log.Info("Start Radius auth Server ...")
-		return radiusd.ListenRadiusAuthServer(manager)
-	})
+	lc := NewLifecycle()
 
-	g.Go(func() error {
-		log.Info("Start Radius acct Server ...")
-		return radiusd.ListenRadiusAcctServer(manager)
+	lc.Go("Radius auth Server", func(ctx context.Context) error {
+		return radiusd.ListenRadiusAuthServer(ctx, manager)
 	})
 
-	time.Sleep(time.Millisecond * 50)
+	lc.Go("Radius acct Server", func(ctx context.Context) error {
+		return radiusd.ListenRadiusAcctServer(ctx, manager)
+	})
 
-	g.Go(func() error {
-		log.Info("Start Grpc Server ...")
-		return grpcservice.StartGrpcServer(manager)
+	lc.Go("Grpc Server", func(ctx context.Context) error {
+		return grpcservice.StartGrpcServer(ctx, manager)
 	})
 
 	if *startFreeradius {
-		g.Go(func() error {
-			log.Info("Start FreeRADIUS API Server ...")
-			return freeradius.ListenFreeRADIUSServer(manager)
+		lc.Go("FreeRADIUS API Server", func(ctx context.Context) error {
+			return freeradius.ListenFreeRADIUSServer(ctx, manager)
 		})
 	}
 
-	time.Sleep(time.Millisecond * 50)
-
 	if *startNbi {
-		g.Go(func() error {
-			log.Info("Start NBI Server ...")
-			return nbi.ListenNBIServer(manager)
+		lc.Go("NBI Server", func(ctx context.Context) error {
+			return nbi.ListenNBIServer(ctx, manager)
 		})
 	}
 
 	syslogserv := syslogd.NewSyslogServer(manager)
 	if *startRfc3164 || os.Getenv("TEAMSACS_RFC3164") == "true" {
-		g.Go(func() error {
-			log.Info("Start rfc3164 Syslog Server ...")
-			return syslogserv.StartRfc3164()
-		})
+		lc.Go("rfc3164 Syslog Server", syslogserv.StartRfc3164)
+	}
+
+	if *startRfc5424 || os.Getenv("TEAMSACS_RFC5424") == "true" {
+		lc.Go("rfc5424 Syslog Server", syslogserv.StartRfc5424)
+	}
+
+	lc.Go("Syslog Server", syslogserv.StartTextlog)
+
+	if appconfig.Portald.Enabled {
+		portalserv := portald.NewPortalServer(manager)
+		lc.Go("Portal Server", portalserv.ListenAndServe)
+		lc.Go("Portal Web Server", portalserv.ListenWebServer)
 	}
 
-	if *startRfc5424 || os.Getenv("TEAMSACS_RFC5424") == "true"{
-		g.Go(func() error {
-			log.Info("Start rfc5424 Syslog Server ...")
-			return syslogserv.StartRfc5424()
+	if appconfig.Tacacsd.Enabled {
+		lc.Go("Tacacs+ Server", tacacsd.NewTacacsServer(manager).ListenAndServe)
+	}
+
+	if appconfig.Snmptrapd.Enabled {
+		lc.Go("SNMP Trap Server", snmptrapd.NewTrapServer(manager).ListenAndServe)
+	}
+
+	if appconfig.Flowd.Enabled {
+		lc.Go("Flow Collector", flowd.NewFlowServer(manager).ListenAndServe)
+	}
+
+	if appconfig.Metrics.Enabled {
+		common.Must(metrics.Register(models.NewOnlineSessionCollector(manager)))
+		lc.Go("Metrics Server", func(ctx context.Context) error {
+			return metrics.ListenMetricsServer(ctx, appconfig.Metrics.Host, appconfig.Metrics.Port)
 		})
 	}
 
-	g.Go(func() error {
-		log.Info("Start Syslog Server ...")
-		return syslogserv.StartTextlog()
+	err := lc.Wait(func() {
+		reloadLogging(appconfig)
+		manager.GetConfigManager().ReloadRadiusConfig()
 	})
 
-	time.Sleep(time.Millisecond * 50)
+	// flush pending writes and disconnect mongodb
+	ctx, cancel := context.WithTimeout(context.Background(), constant.ShutdownTimeout)
+	defer cancel()
+	if cerr := manager.Close(ctx); cerr != nil {
+		log.Errorf("close manager error, %s", cerr.Error())
+	}
 
-	if err := g.Wait(); err != nil {
+	if err != nil {
 		log.Fatal(err)
 	}
+	log.Info("TeamsACS stopped")
 }
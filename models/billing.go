@@ -0,0 +1,505 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/ca17/teamsacs/common"
+	"github.com/ca17/teamsacs/common/log"
+	"github.com/ca17/teamsacs/common/timeutil"
+	"github.com/ca17/teamsacs/common/web"
+	"github.com/ca17/teamsacs/constant"
+)
+
+// Tariff types, the price is the amount per unit
+const (
+	TariffPerMB     = "per_mb"
+	TariffPerMinute = "per_minute"
+	TariffPerDay    = "per_day"
+
+	LedgerRecharge = "recharge"
+	LedgerCharge   = "charge"
+
+	// max accounting records charged per user in one run
+	billingBatchSize = 1000
+)
+
+var tariffTypes = []string{TariffPerMB, TariffPerMinute, TariffPerDay}
+
+// Tariff
+// A charging rule of a plan, subscribers select the plan by the plan attribute.
+// Amounts are integers in the smallest currency unit, such as cents.
+type Tariff struct {
+	ID         string    `bson:"_id,omitempty" json:"id,omitempty"`
+	Name       string    `bson:"name" json:"name"`
+	Plan       string    `bson:"plan" json:"plan"`
+	Type       string    `bson:"type" json:"type"`
+	Price      int64     `bson:"price" json:"price"`
+	Status     string    `bson:"status" json:"status"`
+	Remark     string    `bson:"remark" json:"remark"`
+	UpdateTime time.Time `bson:"update_time" json:"update_time"`
+}
+
+func (t *Tariff) Validate() error {
+	if t.Name == "" || t.Plan == "" {
+		return fmt.Errorf("tariff name and plan can not be empty")
+	}
+	if !common.InSlice(t.Type, tariffTypes) {
+		return fmt.Errorf("tariff type must be one of %v", tariffTypes)
+	}
+	if t.Price < 0 {
+		return fmt.Errorf("tariff price can not be negative")
+	}
+	return nil
+}
+
+// BillingAccount
+// The prepaid account of a subscriber, opened by the first recharge
+type BillingAccount struct {
+	Username   string    `bson:"_id" json:"username"`
+	Balance    int64     `bson:"balance" json:"balance"`
+	CreateTime time.Time `bson:"create_time" json:"create_time"`
+	UpdateTime time.Time `bson:"update_time" json:"update_time"`
+}
+
+// LedgerEntry
+// Every balance change, Amount is negative for charges, Ref is the session id or the charged day
+type LedgerEntry struct {
+	ID         string    `bson:"_id" json:"id"`
+	Username   string    `bson:"username" json:"username"`
+	Type       string    `bson:"type" json:"type"`
+	Amount     int64     `bson:"amount" json:"amount"`
+	Balance    int64     `bson:"balance" json:"balance"`
+	TariffId   string    `bson:"tariff_id,omitempty" json:"tariff_id,omitempty"`
+	Ref        string    `bson:"ref,omitempty" json:"ref,omitempty"`
+	Operator   string    `bson:"operator,omitempty" json:"operator,omitempty"`
+	Remark     string    `bson:"remark" json:"remark"`
+	CreateTime time.Time `bson:"create_time" json:"create_time"`
+}
+
+// Statement
+// The ledger of a period with the opening and closing balance
+type Statement struct {
+	Username string        `json:"username"`
+	Start    time.Time     `json:"start"`
+	End      time.Time     `json:"end"`
+	Opening  int64         `json:"opening"`
+	Closing  int64         `json:"closing"`
+	Recharge int64         `json:"recharge"`
+	Charge   int64         `json:"charge"`
+	Items    []LedgerEntry `json:"items"`
+}
+
+// ComputeSessionCharge
+// the usage charge of a session by the totals, partial units are rounded up
+func ComputeSessionCharge(tariff Tariff, acct Accounting) int64 {
+	switch tariff.Type {
+	case TariffPerMB:
+		bytes := acct.AcctInputTotal + acct.AcctOutputTotal
+		return ceilDiv(bytes*tariff.Price, 1024*1024)
+	case TariffPerMinute:
+		return ceilDiv(int64(acct.AcctSessionTime), 60) * tariff.Price
+	default:
+		return 0
+	}
+}
+
+func ceilDiv(a, b int64) int64 {
+	if a <= 0 {
+		return 0
+	}
+	return (a + b - 1) / b
+}
+
+// SetupBillingDB
+// the charged amounts of the sessions are summed by the ledger refs
+func (m *ModelManager) SetupBillingDB() {
+	_, err := m.GetTeamsAcsCollection(TeamsacsBillingLedger).Indexes().CreateMany(context.TODO(), []mongo.IndexModel{
+		{Keys: bson.D{{"username", 1}, {"ref", 1}}},
+		{Keys: bson.D{{"username", 1}, {"create_time", 1}}},
+	})
+	if err != nil {
+		log.Errorf("create billing ledger indexes error, %s", err.Error())
+	}
+}
+
+// BillingManager
+type BillingManager struct{ *ModelManager }
+
+func (m *ModelManager) GetBillingManager() *BillingManager {
+	store, _ := m.ManagerMap.Get("BillingManager")
+	return store.(*BillingManager)
+}
+
+// QueryTariffs
+func (m *BillingManager) QueryTariffs(params web.RequestParams) (*web.PageResult, error) {
+	return m.QueryPagerItems(params, TeamsacsTariff)
+}
+
+// AddTariff
+func (m *BillingManager) AddTariff(t *Tariff) (string, error) {
+	if err := t.Validate(); err != nil {
+		return "", err
+	}
+	t.ID = common.UUID()
+	if t.Status == "" {
+		t.Status = constant.ENABLED
+	}
+	t.UpdateTime = time.Now()
+	_, err := m.GetTeamsAcsCollection(TeamsacsTariff).InsertOne(context.TODO(), t)
+	return t.ID, err
+}
+
+// UpdateTariff
+func (m *BillingManager) UpdateTariff(t *Tariff) error {
+	if err := t.Validate(); err != nil {
+		return err
+	}
+	t.UpdateTime = time.Now()
+	result, err := m.GetTeamsAcsCollection(TeamsacsTariff).ReplaceOne(context.TODO(), bson.M{"_id": t.ID}, t)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("tariff %s not exists", t.ID)
+	}
+	return nil
+}
+
+// DeleteTariff
+func (m *BillingManager) DeleteTariff(id string) error {
+	_, err := m.GetTeamsAcsCollection(TeamsacsTariff).DeleteOne(context.TODO(), bson.M{"_id": id})
+	return err
+}
+
+// GetPlanTariffs
+// enabled tariffs of a plan
+func (m *BillingManager) GetPlanTariffs(plan string) ([]Tariff, error) {
+	var result = make([]Tariff, 0)
+	if plan == "" {
+		return result, nil
+	}
+	cur, err := m.GetTeamsAcsCollection(TeamsacsTariff).Find(context.TODO(), bson.M{"plan": plan, "status": constant.ENABLED})
+	if err != nil {
+		return nil, err
+	}
+	err = cur.All(context.TODO(), &result)
+	return result, err
+}
+
+// GetAccount
+func (m *BillingManager) GetAccount(username string) (*BillingAccount, error) {
+	var account BillingAccount
+	err := m.GetTeamsAcsCollection(TeamsacsBillingAccount).FindOne(context.TODO(), bson.M{"_id": username}).Decode(&account)
+	if err != nil {
+		return nil, err
+	}
+	return &account, nil
+}
+
+// CheckBalance
+// users without a prepaid account are not restricted
+func (m *BillingManager) CheckBalance(username string) error {
+	account, err := m.GetAccount(username)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if account.Balance <= 0 {
+		return fmt.Errorf("user:%s balance exhausted", username)
+	}
+	return nil
+}
+
+// IsBalanceExhausted
+// the prepaid account exists and the balance runs out
+func (m *BillingManager) IsBalanceExhausted(username string) bool {
+	account, err := m.GetAccount(username)
+	return err == nil && account.Balance <= 0
+}
+
+// updateBalance
+// change the balance and write the ledger entry, the recharge opens the account
+func (m *BillingManager) updateBalance(entry *LedgerEntry) (*BillingAccount, error) {
+	now := time.Now()
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After).SetUpsert(entry.Type == LedgerRecharge)
+	var account BillingAccount
+	err := m.GetTeamsAcsCollection(TeamsacsBillingAccount).FindOneAndUpdate(context.TODO(),
+		bson.M{"_id": entry.Username},
+		bson.M{"$inc": bson.M{"balance": entry.Amount}, "$set": bson.M{"update_time": now}, "$setOnInsert": bson.M{"create_time": now}},
+		opts).Decode(&account)
+	if err != nil {
+		return nil, err
+	}
+	entry.ID = common.UUID()
+	entry.Balance = account.Balance
+	entry.CreateTime = now
+	if _, err = m.GetTeamsAcsCollection(TeamsacsBillingLedger).InsertOne(context.TODO(), entry); err != nil {
+		// the charged sessions are found by the ledger, undo the balance change
+		// so that the charge is retried without a double deduction
+		_, uerr := m.GetTeamsAcsCollection(TeamsacsBillingAccount).UpdateOne(context.TODO(),
+			bson.M{"_id": entry.Username}, bson.M{"$inc": bson.M{"balance": -entry.Amount}})
+		if uerr != nil {
+			log.Errorf("undo the balance of %s error, %s", entry.Username, uerr.Error())
+		}
+		return nil, err
+	}
+	return &account, nil
+}
+
+// Recharge
+func (m *BillingManager) Recharge(username string, amount int64, operator, remark string) (*LedgerEntry, error) {
+	if amount <= 0 {
+		return nil, fmt.Errorf("recharge amount must be positive")
+	}
+	if _, err := m.GetSubscribeManager().GetSubscribeByUser(username); err != nil {
+		return nil, fmt.Errorf("user %s not exists", username)
+	}
+	entry := &LedgerEntry{Username: username, Type: LedgerRecharge, Amount: amount, Operator: operator, Remark: remark}
+	if _, err := m.updateBalance(entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// charge
+// deduct the balance, live sessions are disconnected when the balance runs out
+func (m *BillingManager) charge(username string, amount int64, tariff Tariff, ref, remark string) error {
+	if amount <= 0 {
+		return nil
+	}
+	entry := &LedgerEntry{Username: username, Type: LedgerCharge, Amount: -amount, TariffId: tariff.ID, Ref: ref, Remark: remark}
+	account, err := m.updateBalance(entry)
+	if err != nil {
+		return err
+	}
+	if account.Balance <= 0 {
+		m.GetRadiusManager().DisconnectUser(username, "balance exhausted")
+	}
+	return nil
+}
+
+// QueryLedger
+func (m *BillingManager) QueryLedger(params web.RequestParams) (*web.PageResult, error) {
+	return m.QueryPagerItems(params, TeamsacsBillingLedger)
+}
+
+// GetStatement
+func (m *BillingManager) GetStatement(username string, start, end time.Time) (*Statement, error) {
+	coll := m.GetTeamsAcsCollection(TeamsacsBillingLedger)
+	st := &Statement{Username: username, Start: start, End: end, Items: make([]LedgerEntry, 0)}
+	var last LedgerEntry
+	err := coll.FindOne(context.TODO(), bson.M{"username": username, "create_time": bson.M{"$lt": start}},
+		options.FindOne().SetSort(bson.M{"create_time": -1})).Decode(&last)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, err
+	}
+	st.Opening = last.Balance
+	st.Closing = last.Balance
+	cur, err := coll.Find(context.TODO(), bson.M{"username": username, "create_time": bson.M{"$gte": start, "$lt": end}},
+		options.Find().SetSort(bson.M{"create_time": 1}))
+	if err != nil {
+		return nil, err
+	}
+	if err = cur.All(context.TODO(), &st.Items); err != nil {
+		return nil, err
+	}
+	for _, item := range st.Items {
+		if item.Type == LedgerRecharge {
+			st.Recharge += item.Amount
+		} else {
+			st.Charge -= item.Amount
+		}
+		st.Closing = item.Balance
+	}
+	return st, nil
+}
+
+// RunBilling
+// charge the sessions and the daily fee of prepaid accounts
+func (m *BillingManager) RunBilling() {
+	cur, err := m.GetTeamsAcsCollection(TeamsacsBillingAccount).Find(context.TODO(), bson.M{})
+	if err != nil {
+		log.Errorf("query billing accounts error, %s", err.Error())
+		return
+	}
+	var accounts []BillingAccount
+	if err = cur.All(context.TODO(), &accounts); err != nil {
+		log.Errorf("query billing accounts error, %s", err.Error())
+		return
+	}
+	for _, account := range accounts {
+		user, err := m.GetSubscribeManager().GetSubscribeByUser(account.Username)
+		if err != nil {
+			continue
+		}
+		tariffs, err := m.GetPlanTariffs(user.GetStringValue("plan", ""))
+		if err != nil {
+			log.Errorf("query tariffs of %s error, %s", account.Username, err.Error())
+			continue
+		}
+		for _, tariff := range tariffs {
+			if tariff.Type == TariffPerDay {
+				m.chargeDaily(account, tariff)
+			}
+		}
+		m.chargeSessions(account, tariffs)
+	}
+}
+
+// chargeDaily
+// once per day, the ref is the charged date
+func (m *BillingManager) chargeDaily(account BillingAccount, tariff Tariff) {
+	day := timeutil.FmtDateString(time.Now())
+	count, err := m.GetTeamsAcsCollection(TeamsacsBillingLedger).CountDocuments(context.TODO(),
+		bson.M{"username": account.Username, "tariff_id": tariff.ID, "ref": day})
+	if err != nil || count > 0 {
+		return
+	}
+	if err = m.charge(account.Username, tariff.Price, tariff, day, tariff.Name); err != nil {
+		log.Errorf("charge %s daily fee error, %s", account.Username, err.Error())
+	}
+}
+
+// chargeSessions
+// the live sessions are charged by the usage since the last run, the stopped
+// records by the rest of the usage, then bill_time is set. The charged amount
+// of a session is the sum of the ledger entries of which the ref is the session id,
+// so a record failed to charge is charged again by the next run
+func (m *BillingManager) chargeSessions(account BillingAccount, tariffs []Tariff) {
+	var onlines []Accounting
+	cur, err := m.GetTeamsAcsCollection(TeamsacsOnline).Find(context.TODO(), bson.M{"username": account.Username})
+	if err == nil {
+		err = cur.All(context.TODO(), &onlines)
+	}
+	if err != nil {
+		log.Errorf("query online sessions of %s error, %s", account.Username, err.Error())
+		return
+	}
+	coll := m.GetTeamsAcsCollection(TeamsacsAccounting)
+	cur, err = coll.Find(context.TODO(), bson.M{
+		"username":       account.Username,
+		"bill_time":      bson.M{"$exists": false},
+		"acct_stop_time": bson.M{"$gte": account.CreateTime},
+	}, options.Find().SetLimit(billingBatchSize))
+	if err != nil {
+		log.Errorf("query accounting of %s error, %s", account.Username, err.Error())
+		return
+	}
+	defer cur.Close(context.TODO())
+	var stopped []Accounting
+	var ids []bson.RawValue
+	for cur.Next(context.TODO()) {
+		var acct Accounting
+		if err = cur.Decode(&acct); err != nil {
+			log.Error(err)
+			continue
+		}
+		stopped = append(stopped, acct)
+		ids = append(ids, cur.Current.Lookup("_id"))
+	}
+	refs := make([]string, 0, len(onlines)+len(stopped))
+	for _, acct := range append(append([]Accounting{}, onlines...), stopped...) {
+		refs = append(refs, acct.AcctSessionId)
+	}
+	charged, err := m.chargedSessions(account.Username, refs)
+	if err != nil {
+		log.Errorf("query charged sessions of %s error, %s", account.Username, err.Error())
+		return
+	}
+	for _, acct := range onlines {
+		if err = m.chargeSession(acct, tariffs, charged); err != nil {
+			log.Errorf("charge %s session %s error, %s", acct.Username, acct.AcctSessionId, err.Error())
+		}
+	}
+	for i, acct := range stopped {
+		if err = m.chargeSession(acct, tariffs, charged); err != nil {
+			log.Errorf("charge %s session %s error, %s", acct.Username, acct.AcctSessionId, err.Error())
+			continue
+		}
+		_, err = coll.UpdateOne(context.TODO(), bson.M{"_id": ids[i]}, bson.M{"$set": bson.M{"bill_time": time.Now()}})
+		if err != nil {
+			log.Errorf("update bill time of %s session %s error, %s", acct.Username, acct.AcctSessionId, err.Error())
+		}
+	}
+}
+
+// chargeSession
+// charge the usage of the session not charged yet by each tariff
+func (m *BillingManager) chargeSession(acct Accounting, tariffs []Tariff, charged map[string]int64) error {
+	for _, tariff := range tariffs {
+		key := acct.AcctSessionId + "/" + tariff.ID
+		amount := SessionChargeDelta(tariff, acct, charged[key])
+		if amount <= 0 {
+			continue
+		}
+		if err := m.charge(acct.Username, amount, tariff, acct.AcctSessionId, tariff.Name); err != nil {
+			return err
+		}
+		charged[key] += amount
+	}
+	return nil
+}
+
+// SessionChargeDelta
+// the usage charge of the session minus the charged amount
+func SessionChargeDelta(tariff Tariff, acct Accounting, charged int64) int64 {
+	if amount := ComputeSessionCharge(tariff, acct) - charged; amount > 0 {
+		return amount
+	}
+	return 0
+}
+
+// chargedSessions
+// the charged amounts by the session id and the tariff id, the key is ref/tariff_id
+func (m *BillingManager) chargedSessions(username string, refs []string) (map[string]int64, error) {
+	charged := make(map[string]int64)
+	if len(refs) == 0 {
+		return charged, nil
+	}
+	cur, err := m.GetTeamsAcsCollection(TeamsacsBillingLedger).Aggregate(context.TODO(), []bson.M{
+		{"$match": bson.M{"username": username, "type": LedgerCharge, "ref": bson.M{"$in": refs}}},
+		{"$group": bson.M{"_id": bson.M{"ref": "$ref", "tariff_id": "$tariff_id"}, "amount": bson.M{"$sum": "$amount"}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var items []struct {
+		ID struct {
+			Ref      string `bson:"ref"`
+			TariffId string `bson:"tariff_id"`
+		} `bson:"_id"`
+		Amount int64 `bson:"amount"`
+	}
+	if err = cur.All(context.TODO(), &items); err != nil {
+		return nil, err
+	}
+	for _, item := range items {
+		charged[item.ID.Ref+"/"+item.ID.TariffId] = -item.Amount
+	}
+	return charged, nil
+}
@@ -0,0 +1,73 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package models
+
+import (
+	"testing"
+)
+
+func TestComputeSessionCharge(t *testing.T) {
+	acct := Accounting{AcctInputTotal: 1024 * 1024, AcctOutputTotal: 512 * 1024, AcctSessionTime: 61}
+	tests := []struct {
+		tariff Tariff
+		want   int64
+	}{
+		{Tariff{Type: TariffPerMB, Price: 10}, 15},
+		{Tariff{Type: TariffPerMB, Price: 3}, 5},
+		{Tariff{Type: TariffPerMinute, Price: 5}, 10},
+		{Tariff{Type: TariffPerDay, Price: 100}, 0},
+	}
+	for _, tt := range tests {
+		if got := ComputeSessionCharge(tt.tariff, acct); got != tt.want {
+			t.Errorf("%s price %d charge %d != %d", tt.tariff.Type, tt.tariff.Price, got, tt.want)
+		}
+	}
+	if got := ComputeSessionCharge(Tariff{Type: TariffPerMinute, Price: 5}, Accounting{}); got != 0 {
+		t.Errorf("empty session charge %d != 0", got)
+	}
+}
+
+func TestSessionChargeDelta(t *testing.T) {
+	tariff := Tariff{ID: "t1", Type: TariffPerMinute, Price: 5}
+	// the interim charges of a live session, then the rest on the stop
+	var charged int64
+	for _, tt := range []struct {
+		sessionTime int
+		want        int64
+	}{{30, 5}, {60, 0}, {61, 5}, {300, 15}, {290, 0}} {
+		delta := SessionChargeDelta(tariff, Accounting{AcctSessionTime: tt.sessionTime}, charged)
+		if delta != tt.want {
+			t.Errorf("session time %d delta %d != %d", tt.sessionTime, delta, tt.want)
+		}
+		charged += delta
+	}
+	if charged != 25 {
+		t.Errorf("charged %d != 25", charged)
+	}
+}
+
+func TestTariffValidate(t *testing.T) {
+	if err := (&Tariff{Name: "t", Plan: "basic", Type: TariffPerMB, Price: 1}).Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&Tariff{Name: "t", Plan: "basic", Type: "per_hour", Price: 1}).Validate(); err == nil {
+		t.Fatal("invalid type must fail")
+	}
+	if err := (&Tariff{Name: "t", Plan: "basic", Type: TariffPerDay, Price: -1}).Validate(); err == nil {
+		t.Fatal("negative price must fail")
+	}
+}
@@ -0,0 +1,475 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package models
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/ca17/teamsacs/common"
+	"github.com/ca17/teamsacs/common/log"
+	"github.com/ca17/teamsacs/common/log/dailyrotate"
+	"github.com/ca17/teamsacs/common/timeutil"
+)
+
+const (
+	cdrFilePrefix   = "cdr-"
+	cdrChecksumExt  = ".sha256"
+	cdrWatermarkId  = "watermark"
+	cdrExportDelay  = time.Second * 5
+	cdrRotateHourly = "hourly"
+)
+
+// CdrFile
+type CdrFile struct {
+	Name      string    `json:"name"`
+	Format    string    `json:"format"`
+	Size      int64     `json:"size"`
+	ModTime   time.Time `json:"mod_time"`
+	Sha256    string    `json:"sha256"`
+	Finalized bool      `json:"finalized"`
+}
+
+// cdrState
+// the export watermark of a format, the stop time of the last exported record
+// and the ids of the exported records stopped at that time
+type cdrState struct {
+	ID    string          `bson:"_id"`
+	Value time.Time       `bson:"value"`
+	Ids   []bson.RawValue `bson:"ids"`
+}
+
+// after
+// the record is not exported yet
+func (s *cdrState) after(stop time.Time, id bson.RawValue) bool {
+	if !stop.Equal(s.Value) {
+		return stop.After(s.Value)
+	}
+	for _, v := range s.Ids {
+		if v.Equal(id) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *cdrState) advance(stop time.Time, id bson.RawValue) {
+	if !stop.Equal(s.Value) {
+		s.Value = stop
+		s.Ids = nil
+	}
+	s.Ids = append(s.Ids, id)
+}
+
+// cdrWriter
+// A rotating cdr file of one format
+type cdrWriter struct {
+	format  string
+	encoder CdrEncoder
+	file    *dailyrotate.File
+	pathGen func(t time.Time) string
+	current string
+}
+
+// CdrManager
+// Export the accounting records as cdr files
+type CdrManager struct {
+	*ModelManager
+	sync.Mutex
+	writers []*cdrWriter
+}
+
+func (m *ModelManager) GetCdrManager() *CdrManager {
+	store, _ := m.ManagerMap.Get("CdrManager")
+	return store.(*CdrManager)
+}
+
+func (m *CdrManager) cdrFilename(format, ext string, t time.Time) string {
+	layout := timeutil.Datetime8Layout
+	if m.Config.Cdr.Rotate == cdrRotateHourly {
+		layout = "2006010215"
+	}
+	return path.Join(m.Config.GetCdrDir(), fmt.Sprintf("%s%s-%s.%s", cdrFilePrefix, format, t.Format(layout), ext))
+}
+
+func (m *CdrManager) openWriters() error {
+	if m.writers != nil {
+		return nil
+	}
+	writers := make([]*cdrWriter, 0)
+	for _, format := range m.Config.Cdr.Formats {
+		enc, err := NewCdrEncoder(format, m.Config.Cdr.Columns)
+		if err != nil {
+			return err
+		}
+		w := &cdrWriter{format: format, encoder: enc}
+		w.pathGen = func(t time.Time) string {
+			return m.cdrFilename(w.format, w.encoder.Ext(), t)
+		}
+		onClose := func(p string, didRotate bool) {
+			if p != w.pathGen(time.Now().In(m.Location)) {
+				m.finalizeCdrFile(p, w.encoder)
+			}
+		}
+		if m.Config.Cdr.Rotate == cdrRotateHourly {
+			w.file, err = dailyrotate.NewHourlyFileWithPathGenerator(w.pathGen, onClose)
+		} else {
+			w.file, err = dailyrotate.NewFileWithPathGenerator(w.pathGen, onClose)
+		}
+		if err != nil {
+			return err
+		}
+		w.file.Location = m.Location
+		writers = append(writers, w)
+	}
+	m.writers = writers
+	m.finalizeStaleFiles()
+	return nil
+}
+
+// finalizeStaleFiles
+// finalize the files left by the last run that are no longer being written
+func (m *CdrManager) finalizeStaleFiles() {
+	now := time.Now().In(m.Location)
+	for _, w := range m.writers {
+		pattern := path.Join(m.Config.GetCdrDir(), fmt.Sprintf("%s%s-*.%s", cdrFilePrefix, w.format, w.encoder.Ext()))
+		files, _ := filepath.Glob(pattern)
+		for _, f := range files {
+			if f != w.pathGen(now) && !common.FileExists(f+cdrChecksumExt) {
+				m.finalizeCdrFile(f, w.encoder)
+			}
+		}
+	}
+}
+
+// finalizeCdrFile
+// Write the file footer and the sha256 checksum file
+func (m *CdrManager) finalizeCdrFile(filename string, enc CdrEncoder) {
+	if common.FileExists(filename + cdrChecksumExt) {
+		return
+	}
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		log.Errorf("finalize cdr file %s error, %s", filename, err.Error())
+		return
+	}
+	footer := enc.Footer(content)
+	if len(footer) > 0 {
+		f, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			log.Errorf("finalize cdr file %s error, %s", filename, err.Error())
+			return
+		}
+		_, err = f.Write(footer)
+		f.Close()
+		if err != nil {
+			log.Errorf("finalize cdr file %s error, %s", filename, err.Error())
+			return
+		}
+		content = append(content, footer...)
+	}
+	sum := sha256.Sum256(content)
+	checksum := fmt.Sprintf("%s  %s\n", hex.EncodeToString(sum[:]), path.Base(filename))
+	if err = ioutil.WriteFile(filename+cdrChecksumExt, []byte(checksum), 0644); err != nil {
+		log.Errorf("write cdr checksum %s error, %s", filename, err.Error())
+	}
+}
+
+func (w *cdrWriter) write(acct *Accounting, now time.Time) error {
+	p := w.pathGen(now)
+	if p != w.current {
+		w.current = p
+		info, err := os.Stat(p)
+		if (err != nil || info.Size() == 0) && len(w.encoder.Header()) > 0 {
+			if _, err = w.file.Write(w.encoder.Header()); err != nil {
+				return err
+			}
+		}
+	}
+	bs, err := w.encoder.Encode(acct)
+	if err != nil {
+		return err
+	}
+	_, err = w.file.Write(bs)
+	return err
+}
+
+// SetupCdrDB
+// the incremental export reads the accounting records by the stop time
+func (m *ModelManager) SetupCdrDB() {
+	_, err := m.GetTeamsAcsCollection(TeamsacsAccounting).Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+		Keys: bson.D{{Key: "acct_stop_time", Value: 1}, {Key: "_id", Value: 1}},
+	})
+	if err != nil {
+		log.Errorf("create cdr export index error, %s", err.Error())
+	}
+}
+
+// getWatermark
+// the watermark of the format, a format without it starts from the
+// watermark shared by all formats before the per format watermarks
+func (m *CdrManager) getWatermark(format string) *cdrState {
+	coll := m.GetTeamsAcsCollection(TeamsacsCdrState)
+	var state = new(cdrState)
+	if err := coll.FindOne(context.TODO(), bson.M{"_id": cdrWatermarkId + ":" + format}).Decode(state); err == nil {
+		return state
+	}
+	state = new(cdrState)
+	_ = coll.FindOne(context.TODO(), bson.M{"_id": cdrWatermarkId}).Decode(state)
+	return &cdrState{ID: cdrWatermarkId + ":" + format, Value: state.Value}
+}
+
+func (m *CdrManager) saveWatermark(state *cdrState) error {
+	_, err := m.GetTeamsAcsCollection(TeamsacsCdrState).ReplaceOne(context.TODO(),
+		bson.M{"_id": state.ID}, state, options.Replace().SetUpsert(true))
+	return err
+}
+
+// ExportIncremental
+// Scheduler entry, append the accounting records stopped since the last run to the rotating files
+func (m *CdrManager) ExportIncremental() {
+	total, err := m.ExportNewRecords()
+	if err != nil {
+		log.Errorf("cdr export error, %s", err.Error())
+		return
+	}
+	if total > 0 {
+		log.Infof("cdr export %d records", total)
+	}
+}
+
+// ExportNewRecords
+// export the records stopped after the watermarks, each format has its own watermark
+// so a failed writer does not duplicate the record in the files of the other writers
+func (m *CdrManager) ExportNewRecords() (int64, error) {
+	m.Lock()
+	defer m.Unlock()
+	if err := m.openWriters(); err != nil {
+		return 0, err
+	}
+	if len(m.writers) == 0 {
+		return 0, nil
+	}
+	states := make(map[string]*cdrState)
+	var since time.Time
+	for i, w := range m.writers {
+		states[w.format] = m.getWatermark(w.format)
+		if i == 0 || states[w.format].Value.Before(since) {
+			since = states[w.format].Value
+		}
+	}
+	filter := bson.M{"acct_stop_time": bson.M{"$gte": since, "$lte": time.Now().Add(-cdrExportDelay)}}
+	cur, err := m.GetTeamsAcsCollection(TeamsacsAccounting).Find(context.TODO(), filter,
+		options.Find().SetSort(bson.D{{Key: "acct_stop_time", Value: 1}, {Key: "_id", Value: 1}}))
+	if err != nil {
+		return 0, err
+	}
+	defer cur.Close(context.TODO())
+
+	var total int64
+	changed := make(map[string]bool)
+	for err == nil && cur.Next(context.TODO()) {
+		var acct = new(Accounting)
+		if derr := cur.Decode(acct); derr != nil {
+			log.Error(derr)
+			continue
+		}
+		id := cur.Current.Lookup("_id")
+		now := time.Now().In(m.Location)
+		var written bool
+		for _, w := range m.writers {
+			state := states[w.format]
+			if !state.after(acct.AcctStopTime, id) {
+				continue
+			}
+			if err = w.write(acct, now); err != nil {
+				break
+			}
+			state.advance(acct.AcctStopTime, id)
+			changed[w.format] = true
+			written = true
+		}
+		if written {
+			total++
+		}
+	}
+	if err == nil {
+		err = cur.Err()
+	}
+	for format := range changed {
+		if serr := m.saveWatermark(states[format]); serr != nil {
+			log.Error(serr)
+		}
+	}
+	m.closeStaleWriters()
+	return total, err
+}
+
+// closeStaleWriters
+// rotation only happens on write, close the files of the past period
+func (m *CdrManager) closeStaleWriters() {
+	now := time.Now().In(m.Location)
+	for _, w := range m.writers {
+		if w.current != "" && w.current != w.pathGen(now) {
+			if err := w.file.Close(); err != nil {
+				log.Error(err)
+			}
+			w.current = ""
+		}
+	}
+}
+
+// ExportRange
+// Re-export the accounting records of a time range to a finalized file
+func (m *CdrManager) ExportRange(start, end time.Time, format string) (*CdrFile, error) {
+	enc, err := NewCdrEncoder(format, m.Config.Cdr.Columns)
+	if err != nil {
+		return nil, err
+	}
+	if !end.After(start) {
+		return nil, fmt.Errorf("invalid time range")
+	}
+	filename := path.Join(m.Config.GetCdrDir(), fmt.Sprintf("%s%s-range-%s-%s.%s", cdrFilePrefix, format,
+		timeutil.FmtDatetime14String(start), timeutil.FmtDatetime14String(end), enc.Ext()))
+	f, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	_ = os.Remove(filename + cdrChecksumExt)
+
+	filter := bson.M{"acct_stop_time": bson.M{"$gte": start, "$lt": end}}
+	cur, err := m.GetTeamsAcsCollection(TeamsacsAccounting).Find(context.TODO(), filter,
+		options.Find().SetSort(bson.M{"acct_stop_time": 1}))
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	defer cur.Close(context.TODO())
+	if _, err = f.Write(enc.Header()); err != nil {
+		f.Close()
+		return nil, err
+	}
+	for cur.Next(context.TODO()) {
+		var acct = new(Accounting)
+		if derr := cur.Decode(acct); derr != nil {
+			log.Error(derr)
+			continue
+		}
+		bs, err := enc.Encode(acct)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if _, err = f.Write(bs); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	if err = f.Close(); err != nil {
+		return nil, err
+	}
+	m.finalizeCdrFile(filename, enc)
+	return m.getCdrFile(path.Base(filename))
+}
+
+func (m *CdrManager) getCdrFile(name string) (*CdrFile, error) {
+	filename, err := m.GetCdrFilePath(name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(filename)
+	if err != nil {
+		return nil, err
+	}
+	item := &CdrFile{
+		Name:    name,
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+	}
+	if attrs := strings.SplitN(strings.TrimPrefix(name, cdrFilePrefix), "-", 2); len(attrs) == 2 {
+		item.Format = attrs[0]
+	}
+	if bs, err := ioutil.ReadFile(filename + cdrChecksumExt); err == nil {
+		item.Sha256 = strings.Fields(string(bs))[0]
+		item.Finalized = true
+	}
+	return item, nil
+}
+
+// ListCdrFiles
+// List the cdr files order by modify time desc
+func (m *CdrManager) ListCdrFiles() ([]CdrFile, error) {
+	files, err := ioutil.ReadDir(m.Config.GetCdrDir())
+	if err != nil {
+		return nil, err
+	}
+	result := make([]CdrFile, 0)
+	for _, f := range files {
+		if f.IsDir() || !strings.HasPrefix(f.Name(), cdrFilePrefix) || strings.HasSuffix(f.Name(), cdrChecksumExt) {
+			continue
+		}
+		item, err := m.getCdrFile(f.Name())
+		if err != nil {
+			continue
+		}
+		result = append(result, *item)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ModTime.After(result[j].ModTime)
+	})
+	return result, nil
+}
+
+// GetCdrFilePath
+// Get the full path of a cdr or checksum file by name
+func (m *CdrManager) GetCdrFilePath(name string) (string, error) {
+	if name == "" || path.Base(name) != name || !strings.HasPrefix(name, cdrFilePrefix) {
+		return "", fmt.Errorf("invalid cdr file name %s", name)
+	}
+	filename := path.Join(m.Config.GetCdrDir(), name)
+	if !common.FileExists(filename) {
+		return "", fmt.Errorf("cdr file %s not exists", name)
+	}
+	return filename, nil
+}
+
+// Close
+// close the rotating files, they will be continued on next start
+func (m *CdrManager) Close() {
+	m.Lock()
+	defer m.Unlock()
+	for _, w := range m.writers {
+		if err := w.file.Close(); err != nil {
+			log.Error(err)
+		}
+		w.current = ""
+	}
+}
@@ -0,0 +1,225 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package models
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const (
+	CdrFormatCsv   = "csv"
+	CdrFormatJsonl = "jsonl"
+	CdrFormatIpdr  = "ipdr"
+)
+
+// DefaultCdrColumns
+// The default csv column set, names are the accounting field names
+var DefaultCdrColumns = []string{
+	"username", "nas_id", "nas_addr", "framed_ipaddr", "mac_addr", "nas_port_id",
+	"acct_session_id", "acct_session_time", "acct_input_total", "acct_output_total",
+	"acct_input_packets", "acct_output_packets", "acct_start_time", "acct_stop_time",
+}
+
+func cdrTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+var cdrColumnValues = map[string]func(a *Accounting) string{
+	"id":                  func(a *Accounting) string { return a.ID },
+	"username":            func(a *Accounting) string { return a.Username },
+	"nas_id":              func(a *Accounting) string { return a.NasId },
+	"nas_addr":            func(a *Accounting) string { return a.NasAddr },
+	"nas_paddr":           func(a *Accounting) string { return a.NasPaddr },
+	"session_timeout":     func(a *Accounting) string { return strconv.Itoa(a.SessionTimeout) },
+	"framed_ipaddr":       func(a *Accounting) string { return a.FramedIpaddr },
+	"framed_netmask":      func(a *Accounting) string { return a.FramedNetmask },
+	"mac_addr":            func(a *Accounting) string { return a.MacAddr },
+	"nas_port":            func(a *Accounting) string { return strconv.FormatInt(a.NasPort, 10) },
+	"nas_class":           func(a *Accounting) string { return a.NasClass },
+	"nas_port_id":         func(a *Accounting) string { return a.NasPortId },
+	"nas_port_type":       func(a *Accounting) string { return strconv.Itoa(a.NasPortType) },
+	"service_type":        func(a *Accounting) string { return strconv.Itoa(a.ServiceType) },
+	"acct_session_id":     func(a *Accounting) string { return a.AcctSessionId },
+	"acct_session_time":   func(a *Accounting) string { return strconv.Itoa(a.AcctSessionTime) },
+	"acct_input_total":    func(a *Accounting) string { return strconv.FormatInt(a.AcctInputTotal, 10) },
+	"acct_output_total":   func(a *Accounting) string { return strconv.FormatInt(a.AcctOutputTotal, 10) },
+	"acct_input_packets":  func(a *Accounting) string { return strconv.Itoa(a.AcctInputPackets) },
+	"acct_output_packets": func(a *Accounting) string { return strconv.Itoa(a.AcctOutputPackets) },
+	"acct_start_time":     func(a *Accounting) string { return cdrTime(a.AcctStartTime) },
+	"last_update":         func(a *Accounting) string { return cdrTime(a.LastUpdate) },
+	"acct_stop_time":      func(a *Accounting) string { return cdrTime(a.AcctStopTime) },
+}
+
+// CdrEncoder
+// Encode accounting records to a cdr file format
+type CdrEncoder interface {
+	// file extension
+	Ext() string
+	// written when a new file is created
+	Header() []byte
+	Encode(acct *Accounting) ([]byte, error)
+	// written when a file is finalized, content is the current file content
+	Footer(content []byte) []byte
+}
+
+func NewCdrEncoder(format string, columns []string) (CdrEncoder, error) {
+	switch format {
+	case CdrFormatCsv:
+		if len(columns) == 0 {
+			columns = DefaultCdrColumns
+		}
+		for _, col := range columns {
+			if _, ok := cdrColumnValues[col]; !ok {
+				return nil, fmt.Errorf("cdr column %s not support", col)
+			}
+		}
+		return &csvCdrEncoder{columns: columns}, nil
+	case CdrFormatJsonl:
+		return &jsonlCdrEncoder{}, nil
+	case CdrFormatIpdr:
+		return &ipdrCdrEncoder{}, nil
+	}
+	return nil, fmt.Errorf("cdr format %s not support", format)
+}
+
+// csvCdrEncoder
+type csvCdrEncoder struct {
+	columns []string
+}
+
+func (e *csvCdrEncoder) Ext() string {
+	return "csv"
+}
+
+func (e *csvCdrEncoder) writeRow(row []string) []byte {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	_ = w.Write(row)
+	w.Flush()
+	return buf.Bytes()
+}
+
+func (e *csvCdrEncoder) Header() []byte {
+	return e.writeRow(e.columns)
+}
+
+func (e *csvCdrEncoder) Encode(acct *Accounting) ([]byte, error) {
+	row := make([]string, 0, len(e.columns))
+	for _, col := range e.columns {
+		row = append(row, cdrColumnValues[col](acct))
+	}
+	return e.writeRow(row), nil
+}
+
+func (e *csvCdrEncoder) Footer(content []byte) []byte {
+	return nil
+}
+
+// jsonlCdrEncoder
+type jsonlCdrEncoder struct{}
+
+func (e *jsonlCdrEncoder) Ext() string {
+	return "jsonl"
+}
+
+func (e *jsonlCdrEncoder) Header() []byte {
+	return nil
+}
+
+func (e *jsonlCdrEncoder) Encode(acct *Accounting) ([]byte, error) {
+	bs, err := json.Marshal(acct)
+	if err != nil {
+		return nil, err
+	}
+	return append(bs, '\n'), nil
+}
+
+func (e *jsonlCdrEncoder) Footer(content []byte) []byte {
+	return nil
+}
+
+// IpdrRecord
+// An IPDR like usage record
+type IpdrRecord struct {
+	XMLName          xml.Name `xml:"IPDR"`
+	IPDRCreationTime string   `xml:"IPDRCreationTime"`
+	SubscriberId     string   `xml:"subscriberId"`
+	SessionId        string   `xml:"sessionId"`
+	NasId            string   `xml:"nasId"`
+	NasIpAddress     string   `xml:"nasIpAddress"`
+	NasPortId        string   `xml:"nasPortId"`
+	HostIpAddress    string   `xml:"hostIpAddress"`
+	HostMacAddress   string   `xml:"hostMacAddress"`
+	StartTime        string   `xml:"startTime"`
+	EndTime          string   `xml:"endTime"`
+	Duration         int      `xml:"duration"`
+	InputOctets      int64    `xml:"inputOctets"`
+	OutputOctets     int64    `xml:"outputOctets"`
+	InputPackets     int      `xml:"inputPackets"`
+	OutputPackets    int      `xml:"outputPackets"`
+}
+
+// ipdrCdrEncoder
+type ipdrCdrEncoder struct{}
+
+func (e *ipdrCdrEncoder) Ext() string {
+	return "xml"
+}
+
+func (e *ipdrCdrEncoder) Header() []byte {
+	return []byte(fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<IPDRDoc xmlns="http://www.ipdr.org/namespaces/ipdr" version="3.5-A.0" creationTime="%s" IPDRRecorderInfo="TeamsACS">
+`, cdrTime(time.Now())))
+}
+
+func (e *ipdrCdrEncoder) Encode(acct *Accounting) ([]byte, error) {
+	bs, err := xml.MarshalIndent(IpdrRecord{
+		IPDRCreationTime: cdrTime(time.Now()),
+		SubscriberId:     acct.Username,
+		SessionId:        acct.AcctSessionId,
+		NasId:            acct.NasId,
+		NasIpAddress:     acct.NasAddr,
+		NasPortId:        acct.NasPortId,
+		HostIpAddress:    acct.FramedIpaddr,
+		HostMacAddress:   acct.MacAddr,
+		StartTime:        cdrTime(acct.AcctStartTime),
+		EndTime:          cdrTime(acct.AcctStopTime),
+		Duration:         acct.AcctSessionTime,
+		InputOctets:      acct.AcctInputTotal,
+		OutputOctets:     acct.AcctOutputTotal,
+		InputPackets:     acct.AcctInputPackets,
+		OutputPackets:    acct.AcctOutputPackets,
+	}, "  ", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(bs, '\n'), nil
+}
+
+func (e *ipdrCdrEncoder) Footer(content []byte) []byte {
+	count := bytes.Count(content, []byte("<IPDR>"))
+	return []byte(fmt.Sprintf("  <IPDRDoc.End count=\"%d\" endTime=\"%s\"/>\n</IPDRDoc>\n", count, cdrTime(time.Now())))
+}
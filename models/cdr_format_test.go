@@ -0,0 +1,115 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package models
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func testCdrAccounting() *Accounting {
+	return &Accounting{
+		Username:        "test01",
+		NasAddr:         "10.0.0.1",
+		AcctSessionId:   "session-01",
+		AcctSessionTime: 3600,
+		AcctInputTotal:  1024,
+		AcctOutputTotal: 2048,
+		AcctStartTime:   time.Date(2020, 11, 1, 10, 0, 0, 0, time.UTC),
+		AcctStopTime:    time.Date(2020, 11, 1, 11, 0, 0, 0, time.UTC),
+	}
+}
+
+func TestCsvCdrEncoder(t *testing.T) {
+	enc, err := NewCdrEncoder(CdrFormatCsv, []string{"username", "acct_input_total", "acct_stop_time"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(enc.Header()) != "username,acct_input_total,acct_stop_time\n" {
+		t.Fatalf("csv header error %s", enc.Header())
+	}
+	bs, err := enc.Encode(testCdrAccounting())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(bs) != "test01,1024,2020-11-01T11:00:00Z\n" {
+		t.Fatalf("csv row error %s", bs)
+	}
+	if _, err = NewCdrEncoder(CdrFormatCsv, []string{"nocolumn"}); err == nil {
+		t.Fatal("unknown column must be rejected")
+	}
+}
+
+func TestIpdrCdrEncoder(t *testing.T) {
+	enc, err := NewCdrEncoder(CdrFormatIpdr, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var content = enc.Header()
+	for i := 0; i < 2; i++ {
+		bs, err := enc.Encode(testCdrAccounting())
+		if err != nil {
+			t.Fatal(err)
+		}
+		content = append(content, bs...)
+	}
+	content = append(content, enc.Footer(content)...)
+	if !strings.Contains(string(content), `count="2"`) {
+		t.Fatal("ipdr footer count error")
+	}
+	var doc struct {
+		Records []IpdrRecord `xml:"IPDR"`
+	}
+	if err = xml.Unmarshal(content, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Records) != 2 || doc.Records[0].SubscriberId != "test01" {
+		t.Fatalf("ipdr records error %+v", doc.Records)
+	}
+}
+
+func TestCdrWatermark(t *testing.T) {
+	rawId := func(id string) bson.RawValue {
+		bs, err := bson.Marshal(bson.M{"_id": id})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return bson.Raw(bs).Lookup("_id")
+	}
+	stop := time.Date(2020, 11, 1, 8, 0, 0, 0, time.UTC)
+	state := &cdrState{ID: cdrWatermarkId + ":" + CdrFormatCsv}
+	if !state.after(stop, rawId("a1")) {
+		t.Fatal("record before the first export")
+	}
+	state.advance(stop, rawId("a1"))
+	// the records stopped at the same time are exported once
+	if state.after(stop, rawId("a1")) || !state.after(stop, rawId("a2")) {
+		t.Fatalf("records of the watermark %+v", state)
+	}
+	state.advance(stop, rawId("a2"))
+	if len(state.Ids) != 2 || state.after(stop.Add(-time.Second), rawId("a0")) {
+		t.Fatalf("watermark %+v", state)
+	}
+	state.advance(stop.Add(time.Second), rawId("a3"))
+	if !state.Value.Equal(stop.Add(time.Second)) || len(state.Ids) != 1 {
+		t.Fatalf("watermark %+v", state)
+	}
+}
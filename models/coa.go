@@ -0,0 +1,78 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+
+package models
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+	"layeh.com/radius/rfc2866"
+
+	"github.com/ca17/teamsacs/common/log"
+)
+
+// DisconnectOnline
+// send a radius disconnect request of the online session to the vpe coa port
+func (m *RadiusManager) DisconnectOnline(online Accounting, reason string) error {
+	vpe, err := m.GetVpeManager().GetVpeByIpaddr(online.NasAddr)
+	if err != nil {
+		return fmt.Errorf("vpe %s not exists", online.NasAddr)
+	}
+	nasip := online.NasPaddr
+	if nasip == "" {
+		nasip = online.NasAddr
+	}
+	packet := radius.New(radius.CodeDisconnectRequest, []byte(vpe.GetSecret()))
+	_ = rfc2865.UserName_SetString(packet, online.Username)
+	_ = rfc2866.AcctSessionID_SetString(packet, online.AcctSessionId)
+	var coaPort = vpe.GetIntValue("coa_port", 3799)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	response, err := radius.Exchange(ctx, packet, fmt.Sprintf("%s:%d", nasip, coaPort))
+	if err == nil && response.Code != radius.CodeDisconnectACK {
+		err = fmt.Errorf("disconnect user:%s response %s", online.Username, response.Code.String())
+	}
+	event := NewAuthEvent(EventSessionDisconnect, online.Username, nasip, reason)
+	event.Data = map[string]interface{}{"acct_session_id": online.AcctSessionId, "coa_port": coaPort, "success": err == nil}
+	m.Events.Publish(event)
+	return err
+}
+
+// DisconnectUser
+// disconnect all online sessions of the user
+func (m *RadiusManager) DisconnectUser(username string, reason string) {
+	cur, err := m.GetTeamsAcsCollection(TeamsacsOnline).Find(context.TODO(), bson.M{"username": username})
+	if err != nil {
+		log.Errorf("query user %s online error, %s", username, err.Error())
+		return
+	}
+	var onlines []Accounting
+	if err = cur.All(context.TODO(), &onlines); err != nil {
+		log.Errorf("query user %s online error, %s", username, err.Error())
+		return
+	}
+	for _, online := range onlines {
+		if err := m.DisconnectOnline(online, reason); err != nil {
+			log.Errorf("disconnect user %s session %s error, %s", username, online.AcctSessionId, err.Error())
+		}
+	}
+}
@@ -19,9 +19,11 @@ package models
 import (
 	"context"
 	"strconv"
+	"sync"
 
 	"go.mongodb.org/mongo-driver/bson"
 
+	"github.com/ca17/teamsacs/common/log"
 	"github.com/ca17/teamsacs/common/web"
 )
 
@@ -34,7 +36,11 @@ type Config struct {
 }
 
 
-type ConfigManager struct{ *ModelManager }
+type ConfigManager struct {
+	*ModelManager
+	radiusLock  sync.RWMutex
+	radiusCache map[string]string
+}
 
 func (m *ModelManager) GetConfigManager() *ConfigManager {
 	store, _ := m.ManagerMap.Get("ConfigManager")
@@ -57,16 +63,40 @@ func (m *ConfigManager) GetConfigValue(ctype, name string) string {
 	return result.Value
 }
 
+// GetRadiusConfigValue
+// radius config values are cached, reload by ReloadRadiusConfig
 func (m *ConfigManager) GetRadiusConfigValue(name string) string {
+	m.radiusLock.RLock()
+	cache := m.radiusCache
+	m.radiusLock.RUnlock()
+	if cache == nil {
+		cache = m.ReloadRadiusConfig()
+	}
+	return cache[name]
+}
+
+// ReloadRadiusConfig
+// load all radius config values from the database into the cache
+func (m *ConfigManager) ReloadRadiusConfig() map[string]string {
+	var cache = make(map[string]string)
 	coll := m.GetTeamsAcsCollection(TeamsacsConfig)
-	doc := coll.FindOne(context.TODO(), bson.M{"type": "radius", "name": name})
-	err := doc.Err()
+	cur, err := coll.Find(context.TODO(), bson.M{"type": "radius"})
 	if err != nil {
-		return ""
+		log.Errorf("load radius config error, %s", err.Error())
+		return cache
 	}
-	var result = ""
-	err = doc.Decode(&result)
-	return result
+	var items []Config
+	if err = cur.All(context.TODO(), &items); err != nil {
+		log.Errorf("load radius config error, %s", err.Error())
+		return cache
+	}
+	for _, item := range items {
+		cache[item.Name] = item.Value
+	}
+	m.radiusLock.Lock()
+	m.radiusCache = cache
+	m.radiusLock.Unlock()
+	return cache
 }
 
 func (m *ConfigManager) GetRadiusConfigStringValue(name string, defval string) string {
@@ -94,5 +124,8 @@ func (m *ConfigManager) UpdateConfigValue(ctype, name, value string) error {
 	query := bson.M{"type": ctype, "name": name}
 	update := bson.M{"$set": bson.M{"value": value}}
 	_, err := coll.UpdateOne(context.TODO(), query, update)
+	if err == nil && ctype == "radius" {
+		m.ReloadRadiusConfig()
+	}
 	return err
 }
@@ -18,6 +18,8 @@ package models
 
 import (
 	"context"
+	"fmt"
+	"regexp"
 	"strings"
 
 	"go.mongodb.org/mongo-driver/bson"
@@ -26,7 +28,10 @@ import (
 	"github.com/ca17/teamsacs/common/web"
 )
 
-// A generic data CRUD management API with no predefined schema,
+// A generic data CRUD management API, the data is validated by the JSON Schema
+// of the collection in the schema registry when defined
+
+var collectionNameRegexp = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_]{0,63}$`)
 
 // DataManager
 type DataManager struct{ *ModelManager }
@@ -37,6 +42,14 @@ func (m *ModelManager) GetDataManager() *DataManager {
 }
 
 
+// onDataChanged
+// invalidate the cache of the collection
+func (m *DataManager) onDataChanged(collname string) {
+	if collname == TeamsacsVpe {
+		m.GetVpeManager().InvalidateCache()
+	}
+}
+
 // GetDataById
 func (m *DataManager) GetData(params web.RequestParams) (*Attributes, error) {
 	_id := params.GetParamMap("querymap").GetMustString("_id")
@@ -76,31 +89,79 @@ func (m *DataManager) GetDataNameValues(params web.RequestParams) ([]NameValue,
 
 
 // AddData
+// the data is validated by the schema of the collection
 func (m *DataManager) AddData(params web.RequestParams) error {
-	data := params.GetParamMap("data")
-	_id := data.GetString("_id")
-	if common.IsEmptyOrNA(_id) {
+	collname := params.GetMustString("collname")
+	if err := m.CheckDataCollection(collname); err != nil {
+		return err
+	}
+	data, err := m.ValidateData(collname, params.GetParamMap("data"), false, "")
+	if err != nil {
+		return err
+	}
+	if common.IsEmptyOrNA(web.RequestParams(data).GetString("_id")) {
 		data["_id"] = common.UUID()
 	}
-	coll := m.GetTeamsAcsCollection(params.GetMustString("collname"))
-	_, err := coll.InsertOne(context.TODO(), data)
+	_, err = m.GetTeamsAcsCollection(collname).InsertOne(context.TODO(), data)
+	m.onDataChanged(collname)
 	return err
 }
 
-// AddData
-func (m *DataManager) AddBatchData(collname string, datas []interface{}) error {
-	coll := m.GetTeamsAcsCollection(collname)
-	_, err := coll.InsertMany(context.TODO(), datas)
+// AddBatchData
+// all items are validated by the schema before the insert,
+// the error fields are prefixed by the item index like [2].name
+func (m *DataManager) AddBatchData(collname string, items []map[string]interface{}) error {
+	if err := m.CheckDataCollection(collname); err != nil {
+		return err
+	}
+	schema, err := m.getWriteSchema(collname)
+	if err != nil {
+		return err
+	}
+	var ferrs FieldErrors
+	datas := make([]interface{}, 0, len(items))
+	for i, item := range items {
+		if err = checkSecretFields(collname, item, fmt.Sprintf("[%d].", i)); err != nil {
+			ferrs = append(ferrs, err.(FieldErrors)...)
+			continue
+		}
+		if schema != nil {
+			if item, err = validateBySchema(schema, item, false, fmt.Sprintf("[%d].", i)); err != nil {
+				ferrs = append(ferrs, err.(FieldErrors)...)
+				continue
+			}
+		}
+		markCpeIpaddrSource(collname, item)
+		datas = append(datas, item)
+	}
+	if len(ferrs) > 0 {
+		return ferrs
+	}
+	if len(datas) == 0 {
+		return nil
+	}
+	_, err = m.GetTeamsAcsCollection(collname).InsertMany(context.TODO(), datas)
+	m.onDataChanged(collname)
 	return err
 }
 
 // UpdateData
+// the fields of the data are validated by the schema of the collection
 func (m *DataManager) UpdateData(params web.RequestParams) error {
+	collname := params.GetMustString("collname")
+	if err := m.CheckDataCollection(collname); err != nil {
+		return err
+	}
 	data := params.GetParamMap("data")
 	_id := data.GetMustString("_id")
+	valid, err := m.ValidateData(collname, data, true, "")
+	if err != nil {
+		return err
+	}
 	query := bson.M{"_id": _id}
-	update := bson.M{"$set": data}
-	_, err := m.GetTeamsAcsCollection(params.GetMustString("collname")).UpdateOne(context.TODO(), query, update)
+	update := bson.M{"$set": valid}
+	_, err = m.GetTeamsAcsCollection(collname).UpdateOne(context.TODO(), query, update)
+	m.onDataChanged(collname)
 	return err
 }
 
@@ -112,8 +173,12 @@ func (m *DataManager) DeleteData(params web.RequestParams) error {
 		idarray = append(idarray, id)
 	}
 	collname := params.GetMustString("collname")
+	if err := m.CheckDataCollection(collname); err != nil {
+		return err
+	}
 	filter := bson.M{"_id": bson.M{"$in":idarray}}
 	_, err := m.GetTeamsAcsCollection(collname).DeleteMany(context.TODO(), filter)
+	m.onDataChanged(collname)
 	return err
 }
 
@@ -0,0 +1,163 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package models
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ca17/teamsacs/common"
+	"github.com/ca17/teamsacs/common/log"
+)
+
+// Session lifecycle events
+const (
+	EventAll               = "*"
+	EventAuthAccept        = "auth.accept"
+	EventAuthReject        = "auth.reject"
+	EventAcctStart         = "acct.start"
+	EventAcctStop          = "acct.stop"
+	EventSessionDisconnect = "session.disconnect"
+	EventWebhookPing       = "webhook.ping"
+	EventCpeStatus         = "cpe.status"
+
+	eventQueueSize = 4096
+)
+
+// Event
+type Event struct {
+	ID        string      `bson:"_id" json:"id"`
+	Type      string      `bson:"type" json:"type"`
+	Timestamp time.Time   `bson:"timestamp" json:"timestamp"`
+	Username  string      `bson:"username,omitempty" json:"username,omitempty"`
+	NasAddr   string      `bson:"nas_addr,omitempty" json:"nas_addr,omitempty"`
+	Reason    string      `bson:"reason,omitempty" json:"reason,omitempty"`
+	Data      interface{} `bson:"data,omitempty" json:"data,omitempty"`
+}
+
+func NewEvent(etype, username, nasaddr string) *Event {
+	return &Event{
+		ID:        common.UUID(),
+		Type:      etype,
+		Timestamp: time.Now(),
+		Username:  username,
+		NasAddr:   nasaddr,
+	}
+}
+
+// NewAuthEvent
+// auth accept or reject event, reason is the reject message
+func NewAuthEvent(etype, username, nasaddr, reason string) *Event {
+	e := NewEvent(etype, username, nasaddr)
+	e.Reason = reason
+	return e
+}
+
+// NewSessionEvent
+// session start, stop or disconnect event with the session data
+func NewSessionEvent(etype string, session *Accounting) *Event {
+	e := NewEvent(etype, session.Username, session.NasAddr)
+	e.Data = session
+	return e
+}
+
+type EventHandler func(event *Event)
+
+// EventBus
+// Asynchronous publish/subscribe of the session lifecycle events,
+// publishing never blocks the radius processing, events are dropped when the queue is full.
+type EventBus struct {
+	sync.RWMutex
+	handlers map[string][]EventHandler
+	queue    chan *Event
+	done     chan struct{}
+	closed   bool
+}
+
+func NewEventBus() *EventBus {
+	b := &EventBus{
+		handlers: make(map[string][]EventHandler),
+		queue:    make(chan *Event, eventQueueSize),
+		done:     make(chan struct{}),
+	}
+	go b.dispatch()
+	return b
+}
+
+// Subscribe
+// subscribe an event type, EventAll for all events
+func (b *EventBus) Subscribe(etype string, handler EventHandler) {
+	b.Lock()
+	defer b.Unlock()
+	b.handlers[etype] = append(b.handlers[etype], handler)
+}
+
+// Publish
+func (b *EventBus) Publish(event *Event) {
+	b.RLock()
+	defer b.RUnlock()
+	if b.closed {
+		return
+	}
+	select {
+	case b.queue <- event:
+	default:
+		log.Warningf("event queue is full, drop event %s %s", event.Type, event.Username)
+	}
+}
+
+func (b *EventBus) dispatch() {
+	defer close(b.done)
+	for event := range b.queue {
+		b.RLock()
+		handlers := make([]EventHandler, 0)
+		handlers = append(handlers, b.handlers[event.Type]...)
+		handlers = append(handlers, b.handlers[EventAll]...)
+		b.RUnlock()
+		for _, handler := range handlers {
+			b.call(handler, event)
+		}
+	}
+}
+
+func (b *EventBus) call(handler EventHandler, event *Event) {
+	defer func() {
+		if ret := recover(); ret != nil {
+			log.Errorf("event %s handler error, %v", event.Type, ret)
+		}
+	}()
+	handler(event)
+}
+
+// Close
+// stop accepting events and wait for the queued events to be dispatched
+// until the context is done
+func (b *EventBus) Close(ctx context.Context) error {
+	b.Lock()
+	if !b.closed {
+		b.closed = true
+		close(b.queue)
+	}
+	b.Unlock()
+	select {
+	case <-b.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
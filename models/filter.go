@@ -0,0 +1,489 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/ca17/teamsacs/common"
+	"github.com/ca17/teamsacs/common/jsonschema"
+	"github.com/ca17/teamsacs/common/web"
+)
+
+// The filter operators
+//
+// query string: filter[expire_time][gte]=2021-01-01&filter[status][in]=enabled,pause
+// or[0][node_id]=n1&or[1][remark][contains]=vip&sort=-expire_time,username&fields=username,status
+//
+// json: {"filter": {"and": [{"field": "expire_time", "op": "gte", "value": "2021-01-01"}],
+// "or": [[{"field": "node_id", "value": "n1"}]]}, "sort": ["-expire_time"], "fields": ["username"]}
+const (
+	FilterOpEq       = "eq"
+	FilterOpNe       = "ne"
+	FilterOpGt       = "gt"
+	FilterOpGte      = "gte"
+	FilterOpLt       = "lt"
+	FilterOpLte      = "lte"
+	FilterOpIn       = "in"
+	FilterOpNin      = "nin"
+	FilterOpPrefix   = "prefix"
+	FilterOpContains = "contains"
+	FilterOpExists   = "exists"
+
+	filterMaxConds  = 50
+	filterMaxGroups = 10
+	filterMaxValues = 100
+	filterMaxLength = 128
+)
+
+var (
+	filterOperators = map[string]string{
+		FilterOpEq:  "$eq",
+		FilterOpNe:  "$ne",
+		FilterOpGt:  "$gt",
+		FilterOpGte: "$gte",
+		FilterOpLt:  "$lt",
+		FilterOpLte: "$lte",
+		FilterOpIn:  "$in",
+		FilterOpNin: "$nin",
+	}
+	filterTypes       = []string{"string", "int", "number", "bool", "date"}
+	filterFieldRegexp = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_\-]*(\.[a-zA-Z0-9_\-]+)*$`)
+	filterTimeLayouts = []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02"}
+
+	// the date fields of the collections saved by the models, the dates of
+	// the generic data documents like subscribe are strings, see FilterCond.Type
+	filterDateFields = map[string][]string{
+		TeamsacsOnline:            {"acct_start_time", "last_update", "acct_stop_time"},
+		TeamsacsAccounting:        {"acct_start_time", "last_update", "acct_stop_time"},
+		TeamsacsAuthlog:           {"timestamp"},
+		TeamsacsSyslog:            {"timestamp"},
+		TeamsacsSnmpTrap:          {"timestamp"},
+		TeamsacsSnmpUsmUser:       {"update_time"},
+		TeamsacsFlowStat:          {"minute"},
+		TeamsacsWebhookDeadletter: {"timestamp"},
+		TeamsacsNotifyLog:         {"create_time"},
+		TeamsacsRenewalHistory:    {"old_expire", "new_expire", "create_time"},
+		TeamsacsTariff:            {"update_time"},
+		TeamsacsBillingLedger:     {"create_time"},
+		TeamsacsVoucherBatch:      {"start_time", "end_time", "create_time"},
+		TeamsacsVoucher:           {"active_time", "expire_time"},
+		TeamsacsTacacsUser:        {"update_time"},
+		TeamsacsTacacsAccounting:  {"create_time"},
+		TeamsacsCpeTask:           {"create_time", "update_time"},
+		TeamsacsCpeSyncHistory:    {"create_time"},
+		TeamsacsCpeStatus:         {"since", "last_inform", "update_time"},
+		TeamsacsCpeStatusEvent:    {"last_inform", "timestamp"},
+		TeamsacsCpeIfStat:         {"timestamp"},
+		TeamsacsCpeProfile:        {"update_time"},
+		TeamsacsFirmwareCampaign:  {"create_time", "update_time"},
+		TeamsacsCampaignDevice:    {"start_time", "update_time"},
+	}
+
+	// the secret fields of the collections can not be filtered, sorted or projected,
+	// a prefix or range condition would reveal the value one character at a time
+	filterSecretFields = map[string][]string{
+		TeamsacsOperator:    {"password", "api_secret"},
+		TeamsacsSubscribe:   {"password"},
+		TeamsacsVpe:         {"secret", "tacacs_secret", "portal_secret", "api_password"},
+		TeamsacsCpe:         {"api_password"},
+		TeamsacsWebhook:     {"secret"},
+		TeamsacsTacacsUser:  {"password"},
+		TeamsacsSnmpUsmUser: {"auth_passphrase", "priv_passphrase"},
+		TeamsacsCpeProfile:  {"spec", "wifi.key"},
+	}
+)
+
+// FilterCond
+// A condition of the filter, the operator is eq by default, the type
+// overrides the coercion of the schema, e.g. date for a date field
+type FilterCond struct {
+	Field string      `json:"field"`
+	Op    string      `json:"op,omitempty"`
+	Value interface{} `json:"value"`
+	Type  string      `json:"type,omitempty"`
+}
+
+// QueryFilter
+// The conditions of And are all matched, the groups of Or are any matched,
+// the sort fields are descending with a - prefix
+type QueryFilter struct {
+	And    []FilterCond   `json:"and,omitempty"`
+	Or     [][]FilterCond `json:"or,omitempty"`
+	Sort   []string       `json:"sort,omitempty"`
+	Fields []string       `json:"fields,omitempty"`
+}
+
+// filterContext
+// how a filter of a collection is translated
+type filterContext struct {
+	Schema     *jsonschema.Schema
+	Location   *time.Location
+	DateFields []string
+	// the fields of which the default operator is contains
+	Contains []string
+	// the fields refused in the conditions, the sort and the projection
+	Secrets []string
+	// map the field name to the document path
+	Path func(field string) string
+}
+
+// ParseQueryFilter
+// parse the filter of the query string and the json body, the legacy
+// filtermap and sortmap are supported
+func ParseQueryFilter(params web.RequestParams) (*QueryFilter, error) {
+	f := &QueryFilter{}
+	var errs FieldErrors
+	for _, key := range sortedKeys(params.GetParamMap("filtermap")) {
+		parts := strings.Split(key, "][")
+		if len(parts) > 3 {
+			errs.Add("filter["+key+"]", "invalid filter")
+			continue
+		}
+		f.And = append(f.And, newFilterCond(parts, params.GetParamMap("filtermap")[key]))
+	}
+	groups := make(map[int][]FilterCond)
+	for _, key := range sortedKeys(params.GetParamMap("ormap")) {
+		parts := strings.Split(key, "][")
+		idx, err := strconv.Atoi(parts[0])
+		if err != nil || idx < 0 || len(parts) < 2 || len(parts) > 4 {
+			errs.Add("or["+key+"]", "invalid filter")
+			continue
+		}
+		groups[idx] = append(groups[idx], newFilterCond(parts[1:], params.GetParamMap("ormap")[key]))
+	}
+	idxs := make([]int, 0, len(groups))
+	for idx := range groups {
+		idxs = append(idxs, idx)
+	}
+	sort.Ints(idxs)
+	for _, idx := range idxs {
+		f.Or = append(f.Or, groups[idx])
+	}
+
+	if v, ok := params["filter"]; ok && v != nil {
+		bs, err := json.Marshal(v)
+		var jf QueryFilter
+		if err == nil {
+			err = json.Unmarshal(bs, &jf)
+		}
+		if err != nil {
+			errs.Add("filter", "invalid filter")
+		}
+		f.And = append(f.And, jf.And...)
+		f.Or = append(f.Or, jf.Or...)
+		f.Sort = append(f.Sort, jf.Sort...)
+		f.Fields = append(f.Fields, jf.Fields...)
+	}
+
+	querymap := params.GetParamMap("querymap")
+	f.Sort = append(f.Sort, filterStrings(querymap["sort"])...)
+	f.Sort = append(f.Sort, filterStrings(params["sort"])...)
+	sortmap := params.GetParamMap("sortmap")
+	for _, name := range sortedKeys(sortmap) {
+		switch sortmap[name] {
+		case "asc":
+			f.Sort = append(f.Sort, name)
+		case "desc":
+			f.Sort = append(f.Sort, "-"+name)
+		}
+	}
+	f.Fields = append(f.Fields, filterStrings(querymap["fields"])...)
+	f.Fields = append(f.Fields, filterStrings(params["fields"])...)
+	return f, errs.Err()
+}
+
+// newFilterCond
+// a condition of the query string key parts, field[op][type]
+func newFilterCond(parts []string, value interface{}) FilterCond {
+	cond := FilterCond{Field: parts[0], Value: value}
+	if len(parts) > 1 {
+		cond.Op = parts[1]
+	}
+	if len(parts) > 2 {
+		cond.Type = parts[2]
+	}
+	return cond
+}
+
+// apply
+// translate the filter to the query, the sort and the projection of the find options
+func (f *QueryFilter) apply(ctx *filterContext, findOptions *options.FindOptions) (bson.M, error) {
+	var errs FieldErrors
+	if len(f.And) > filterMaxConds {
+		errs.Add("filter", "too many conditions, max %d", filterMaxConds)
+	}
+	if len(f.Or) > filterMaxGroups {
+		errs.Add("or", "too many groups, max %d", filterMaxGroups)
+	}
+	if err := errs.Err(); err != nil {
+		return nil, err
+	}
+	q := bson.M{}
+	if conds := ctx.conds("filter", f.And, &errs); len(conds) > 0 {
+		q["$and"] = conds
+	}
+	groups := make(bson.A, 0, len(f.Or))
+	for i, group := range f.Or {
+		if len(group) == 0 || len(group) > filterMaxConds {
+			errs.Add(fmt.Sprintf("or[%d]", i), "must have 1 to %d conditions", filterMaxConds)
+			continue
+		}
+		groups = append(groups, bson.M{"$and": ctx.conds(fmt.Sprintf("or[%d]", i), group, &errs)})
+	}
+	if len(groups) > 0 {
+		q["$or"] = groups
+	}
+
+	if len(f.Sort) > 0 {
+		sorts := bson.D{}
+		for _, s := range f.Sort {
+			order := 1
+			name := strings.TrimPrefix(s, "+")
+			if strings.HasPrefix(s, "-") {
+				order, name = -1, s[1:]
+			}
+			if !filterFieldRegexp.MatchString(name) || ctx.secret(name) {
+				errs.Add("sort", "invalid field %s", s)
+				continue
+			}
+			sorts = append(sorts, bson.E{Key: ctx.path(name), Value: order})
+		}
+		findOptions.SetSort(sorts)
+	}
+	if len(f.Fields) > 0 {
+		projection := bson.M{}
+		for _, name := range f.Fields {
+			if !filterFieldRegexp.MatchString(name) || ctx.secret(name) {
+				errs.Add("fields", "invalid field %s", name)
+				continue
+			}
+			projection[ctx.path(name)] = 1
+		}
+		findOptions.SetProjection(projection)
+	}
+	return q, errs.Err()
+}
+
+func (ctx *filterContext) secret(field string) bool {
+	return isSecretField(ctx.Secrets, field)
+}
+
+// isSecretField
+// the field or a sub field of it is a secret
+func isSecretField(secrets []string, field string) bool {
+	for _, name := range secrets {
+		if field == name || strings.HasPrefix(field, name+".") {
+			return true
+		}
+	}
+	return false
+}
+
+func (ctx *filterContext) path(field string) string {
+	if ctx.Path == nil {
+		return field
+	}
+	return ctx.Path(field)
+}
+
+func (ctx *filterContext) conds(name string, conds []FilterCond, errs *FieldErrors) bson.A {
+	result := make(bson.A, 0, len(conds))
+	for _, cond := range conds {
+		expr, err := ctx.expr(cond)
+		if err != nil {
+			errs.Add(name+"."+cond.Field, err.Error())
+			continue
+		}
+		result = append(result, bson.M{ctx.path(cond.Field): expr})
+	}
+	return result
+}
+
+// expr
+// the bson expression of a condition, the values must be scalars,
+// so an operator can not be injected by a value
+func (ctx *filterContext) expr(cond FilterCond) (interface{}, error) {
+	if !filterFieldRegexp.MatchString(cond.Field) {
+		return nil, fmt.Errorf("invalid field")
+	}
+	if ctx.secret(cond.Field) {
+		return nil, fmt.Errorf("secret field can not be filtered")
+	}
+	if cond.Type != "" && !common.InSlice(cond.Type, filterTypes) {
+		return nil, fmt.Errorf("invalid type %s, must be one of %s", cond.Type, strings.Join(filterTypes, ","))
+	}
+	op := cond.Op
+	if op == "" {
+		op = FilterOpEq
+		if common.InSlice(cond.Field, ctx.Contains) {
+			op = FilterOpContains
+		}
+	}
+	switch op {
+	case FilterOpEq, FilterOpNe, FilterOpGt, FilterOpGte, FilterOpLt, FilterOpLte:
+		v, err := ctx.coerce(cond, cond.Value)
+		if err != nil {
+			return nil, err
+		}
+		return bson.M{filterOperators[op]: v}, nil
+	case FilterOpIn, FilterOpNin:
+		var values []interface{}
+		switch vs := cond.Value.(type) {
+		case string:
+			for _, s := range strings.Split(vs, ",") {
+				values = append(values, strings.TrimSpace(s))
+			}
+		case []interface{}:
+			values = vs
+		default:
+			return nil, fmt.Errorf("must be a list")
+		}
+		if len(values) > filterMaxValues {
+			return nil, fmt.Errorf("too many values, max %d", filterMaxValues)
+		}
+		list := make(bson.A, 0, len(values))
+		for _, value := range values {
+			v, err := ctx.coerce(cond, value)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+		return bson.M{filterOperators[op]: list}, nil
+	case FilterOpPrefix, FilterOpContains:
+		s, ok := cond.Value.(string)
+		if !ok || s == "" || len(s) > filterMaxLength {
+			return nil, fmt.Errorf("must be a string of 1 to %d characters", filterMaxLength)
+		}
+		if op == FilterOpPrefix {
+			return bson.M{"$regex": primitive.Regex{Pattern: "^" + regexp.QuoteMeta(s)}}, nil
+		}
+		return bson.M{"$regex": primitive.Regex{Pattern: regexp.QuoteMeta(s), Options: "i"}}, nil
+	case FilterOpExists:
+		b, err := strconv.ParseBool(fmt.Sprint(cond.Value))
+		if err != nil {
+			return nil, fmt.Errorf("must be a boolean")
+		}
+		return bson.M{"$exists": b}, nil
+	}
+	return nil, fmt.Errorf("invalid operator %s", op)
+}
+
+// coerce
+// convert the value by the type of the condition, the schema property
+// or the date fields
+func (ctx *filterContext) coerce(cond FilterCond, v interface{}) (interface{}, error) {
+	switch v.(type) {
+	case string, bool, float64, int, int64, nil:
+	default:
+		return nil, fmt.Errorf("must be a scalar value")
+	}
+	typ := cond.Type
+	if typ == "" {
+		if prop := ctx.property(cond.Field); prop != nil {
+			return prop.Coerce(v)
+		}
+		if common.InSlice(cond.Field, ctx.DateFields) {
+			typ = "date"
+		}
+	}
+	s, ok := v.(string)
+	if !ok {
+		return v, nil
+	}
+	s = strings.TrimSpace(s)
+	switch typ {
+	case "int":
+		i, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("must be an integer")
+		}
+		return i, nil
+	case "number":
+		n, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, fmt.Errorf("must be a number")
+		}
+		return n, nil
+	case "bool":
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return nil, fmt.Errorf("must be a boolean")
+		}
+		return b, nil
+	case "date":
+		loc := ctx.Location
+		if loc == nil {
+			loc = time.Local
+		}
+		for _, layout := range filterTimeLayouts {
+			if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+				return t, nil
+			}
+		}
+		return nil, fmt.Errorf("must be a date of %s", strings.Join(filterTimeLayouts, ", "))
+	}
+	return v, nil
+}
+
+func (ctx *filterContext) property(field string) *jsonschema.Schema {
+	if ctx.Schema == nil {
+		return nil
+	}
+	return ctx.Schema.Property(field)
+}
+
+// filterStrings
+// a comma separated string or a list of strings
+func filterStrings(v interface{}) []string {
+	result := make([]string, 0)
+	switch vs := v.(type) {
+	case string:
+		for _, s := range strings.Split(vs, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				result = append(result, s)
+			}
+		}
+	case []interface{}:
+		for _, s := range vs {
+			result = append(result, fmt.Sprint(s))
+		}
+	case []string:
+		result = append(result, vs...)
+	}
+	return result
+}
+
+func sortedKeys(m web.RequestParams) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,191 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package models
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/ca17/teamsacs/common/jsonschema"
+	"github.com/ca17/teamsacs/common/web"
+)
+
+func TestQueryFilter(t *testing.T) {
+	params := web.RequestParams{
+		"filtermap": map[string]interface{}{
+			"ports][gte":            "8",
+			"remark":                "a.b",
+			"status][in":            "enabled, pause",
+			"create_time][lt":       "2021-01-02",
+			"name][prefix":          "olt",
+			"mac][exists":           "false",
+			"expire_time][gt][date": "2021-01-01 00:00:00",
+		},
+		"ormap": map[string]interface{}{
+			"0][node_id":    "n1",
+			"1][site][ne":   "s1",
+			"1][ports][lte": "4",
+		},
+		"querymap": map[string]interface{}{"sort": "-create_time,name", "fields": "name,ports"},
+	}
+	f, err := ParseQueryFilter(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, _ := jsonschema.Parse([]byte(`{"type": "object", "properties": {"ports": {"type": "integer"}}}`))
+	findOptions := options.Find()
+	q, err := f.apply(&filterContext{Schema: s, Location: time.UTC, DateFields: filterDateFields[TeamsacsVoucherBatch], Contains: []string{"remark"}}, findOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := bson.M{
+		"$and": bson.A{
+			bson.M{"create_time": bson.M{"$lt": time.Date(2021, 1, 2, 0, 0, 0, 0, time.UTC)}},
+			bson.M{"expire_time": bson.M{"$gt": time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)}},
+			bson.M{"mac": bson.M{"$exists": false}},
+			bson.M{"name": bson.M{"$regex": primitive.Regex{Pattern: "^olt"}}},
+			bson.M{"ports": bson.M{"$gte": int64(8)}},
+			bson.M{"remark": bson.M{"$regex": primitive.Regex{Pattern: `a\.b`, Options: "i"}}},
+			bson.M{"status": bson.M{"$in": bson.A{"enabled", "pause"}}},
+		},
+		"$or": bson.A{
+			bson.M{"$and": bson.A{bson.M{"node_id": bson.M{"$eq": "n1"}}}},
+			bson.M{"$and": bson.A{bson.M{"ports": bson.M{"$lte": int64(4)}}, bson.M{"site": bson.M{"$ne": "s1"}}}},
+		},
+	}
+	if !reflect.DeepEqual(q, expect) {
+		t.Fatalf("%v\n%v", q, expect)
+	}
+	if !reflect.DeepEqual(findOptions.Sort, bson.D{{Key: "create_time", Value: -1}, {Key: "name", Value: 1}}) {
+		t.Fatal(findOptions.Sort)
+	}
+	if !reflect.DeepEqual(findOptions.Projection, bson.M{"name": 1, "ports": 1}) {
+		t.Fatal(findOptions.Projection)
+	}
+}
+
+func TestQueryFilterStringDate(t *testing.T) {
+	f := &QueryFilter{And: []FilterCond{{Field: "start_time", Op: "gte", Value: "2021-01-01"}}}
+	q, err := f.apply(&filterContext{DateFields: filterDateFields[TeamsacsSubscribe]}, options.Find())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := bson.M{"$and": bson.A{bson.M{"start_time": bson.M{"$gte": "2021-01-01"}}}}
+	if !reflect.DeepEqual(q, expect) {
+		t.Fatalf("%v\n%v", q, expect)
+	}
+}
+
+func TestQueryFilterSecret(t *testing.T) {
+	ctx := &filterContext{Secrets: filterSecretFields[TeamsacsWebhook]}
+	for _, f := range []*QueryFilter{
+		{And: []FilterCond{{Field: "secret", Op: "prefix", Value: "a"}}},
+		{Or: [][]FilterCond{{{Field: "secret", Op: "gt", Value: "m"}}}},
+		{Sort: []string{"-secret"}},
+		{Fields: []string{"name", "secret"}},
+	} {
+		if _, err := f.apply(ctx, options.Find()); err == nil {
+			t.Fatalf("secret field accepted %+v", f)
+		}
+	}
+	ctx = &filterContext{Secrets: filterSecretFields[TeamsacsCpeProfile]}
+	f := &QueryFilter{And: []FilterCond{{Field: "wifi.key", Op: "prefix", Value: "a"}}}
+	if _, err := f.apply(ctx, options.Find()); err == nil {
+		t.Fatal("wifi key accepted")
+	}
+	f = &QueryFilter{And: []FilterCond{{Field: "wifi.ssid", Value: "home"}}, Sort: []string{"name"}}
+	if _, err := f.apply(ctx, options.Find()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestQueryFilterJson(t *testing.T) {
+	params := web.RequestParams{
+		"filter": map[string]interface{}{
+			"and": []interface{}{
+				map[string]interface{}{"field": "Message", "value": "login"},
+				map[string]interface{}{"field": "timestamp", "op": "gte", "value": "2021-01-01T00:00:00Z"},
+			},
+		},
+		"sort": []interface{}{"-timestamp"},
+	}
+	f, err := ParseQueryFilter(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	findOptions := options.Find()
+	q, err := f.apply(&filterContext{DateFields: []string{"timestamp"}, Contains: []string{"Message"}, Path: syslogFieldPath}, findOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := bson.M{"$and": bson.A{
+		bson.M{"attrs.Message": bson.M{"$regex": primitive.Regex{Pattern: "login", Options: "i"}}},
+		bson.M{"timestamp": bson.M{"$gte": time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)}},
+	}}
+	if !reflect.DeepEqual(q, expect) {
+		t.Fatalf("%v\n%v", q, expect)
+	}
+	if !reflect.DeepEqual(findOptions.Sort, bson.D{{Key: "timestamp", Value: -1}}) {
+		t.Fatal(findOptions.Sort)
+	}
+}
+
+func TestQuerySnmpTrapFilter(t *testing.T) {
+	m := &SnmpTrapManager{&ModelManager{Location: time.UTC}}
+	params := web.RequestParams{"filtermap": map[string]interface{}{"trap_name": "linkDown.*("}}
+	q, err := m.snmpTrapQuery(params, options.Find())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := bson.M{"$and": bson.A{
+		bson.M{"trap_name": bson.M{"$regex": primitive.Regex{Pattern: `linkDown\.\*\(`, Options: "i"}}},
+	}}
+	if !reflect.DeepEqual(q, expect) {
+		t.Fatalf("%v\n%v", q, expect)
+	}
+	params = web.RequestParams{"filtermap": map[string]interface{}{"trap_name": []interface{}{"a"}}}
+	if _, err = m.snmpTrapQuery(params, options.Find()); err == nil {
+		t.Fatal("non-string trap_name must fail")
+	}
+}
+
+func TestQueryFilterInvalid(t *testing.T) {
+	for _, cond := range []FilterCond{
+		{Field: "$where", Value: "1"},
+		{Field: "name", Op: "regex", Value: ".*"},
+		{Field: "name", Value: map[string]interface{}{"$ne": ""}},
+		{Field: "name", Op: "in", Value: 1},
+		{Field: "ports", Type: "int", Value: "x"},
+		{Field: "ports", Type: "long", Value: "1"},
+		{Field: "timestamp", Op: "gt", Value: "yesterday"},
+	} {
+		f := &QueryFilter{And: []FilterCond{cond}}
+		_, err := f.apply(&filterContext{DateFields: filterDateFields[TeamsacsSyslog]}, options.Find())
+		if _, ok := err.(FieldErrors); !ok {
+			t.Fatal(cond, err)
+		}
+	}
+	f := &QueryFilter{Sort: []string{"-$natural"}}
+	if _, err := f.apply(&filterContext{}, options.Find()); err == nil {
+		t.Fatal("invalid sort field")
+	}
+}
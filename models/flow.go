@@ -0,0 +1,223 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package models
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/ca17/teamsacs/common/log"
+	"github.com/ca17/teamsacs/common/web"
+)
+
+const (
+	FlowTopBySubscriber     = "subscriber"
+	FlowTopByPort           = "port"
+	FlowTopBySubscriberPort = "subscriber_port"
+)
+
+// FlowStat
+// Minute level traffic of a subscriber and application port,
+// up is the subscriber sending, down is the subscriber receiving
+type FlowStat struct {
+	ID          string    `bson:"_id,omitempty" json:"id,omitempty"`
+	Username    string    `bson:"username" json:"username"`
+	Minute      time.Time `bson:"minute" json:"minute"`
+	Proto       int       `bson:"proto" json:"proto"`
+	Port        int       `bson:"port" json:"port"`
+	UpBytes     int64     `bson:"up_bytes" json:"up_bytes"`
+	DownBytes   int64     `bson:"down_bytes" json:"down_bytes"`
+	UpPackets   int64     `bson:"up_packets" json:"up_packets"`
+	DownPackets int64     `bson:"down_packets" json:"down_packets"`
+	Flows       int64     `bson:"flows" json:"flows"`
+}
+
+// FlowStatId
+// the aggregate key, the same subscriber port and minute merges into one document
+func FlowStatId(username string, minute time.Time, proto, port int) string {
+	return fmt.Sprintf("%s/%d/%d/%d", username, minute.Unix(), proto, port)
+}
+
+// FlowTop
+// Top talker item
+type FlowTop struct {
+	Username   string `bson:"username,omitempty" json:"username,omitempty"`
+	Proto      int    `bson:"proto,omitempty" json:"proto,omitempty"`
+	Port       int    `bson:"port,omitempty" json:"port,omitempty"`
+	UpBytes    int64  `bson:"up_bytes" json:"up_bytes"`
+	DownBytes  int64  `bson:"down_bytes" json:"down_bytes"`
+	TotalBytes int64  `bson:"total_bytes" json:"total_bytes"`
+	Flows      int64  `bson:"flows" json:"flows"`
+}
+
+// OnlineIpaddr
+// The framed ip of a session, the stop time is zero while online
+type OnlineIpaddr struct {
+	Username      string    `bson:"username"`
+	FramedIpaddr  string    `bson:"framed_ipaddr"`
+	AcctStartTime time.Time `bson:"acct_start_time"`
+	AcctStopTime  time.Time `bson:"acct_stop_time,omitempty"`
+}
+
+// Covers
+// the session owned the ip at the time
+func (o *OnlineIpaddr) Covers(t time.Time) bool {
+	if o.AcctStartTime.After(t) {
+		return false
+	}
+	return o.AcctStopTime.IsZero() || !o.AcctStopTime.Before(t)
+}
+
+// SetupFlowDB
+// The aggregates expire after the history days
+func (m *ModelManager) SetupFlowDB() {
+	days := m.Config.Flowd.HistoryDays
+	if days <= 0 {
+		days = 30
+	}
+	_, err := m.GetTeamsAcsCollection(TeamsacsFlowStat).Indexes().CreateMany(context.TODO(), []mongo.IndexModel{
+		{Keys: bson.D{{"username", 1}, {"minute", -1}}},
+		{Keys: bson.D{{"minute", 1}}, Options: options.Index().SetExpireAfterSeconds(int32(days * 86400))},
+	})
+	if err != nil {
+		log.Errorf("create flow stat indexes error, %s", err.Error())
+	}
+}
+
+// FlowManager
+type FlowManager struct{ *ModelManager }
+
+func (m *ModelManager) GetFlowManager() *FlowManager {
+	store, _ := m.ManagerMap.Get("FlowManager")
+	return store.(*FlowManager)
+}
+
+// GetOnlineIpaddrs
+// The framed ip of all online sessions
+func (m *FlowManager) GetOnlineIpaddrs() ([]OnlineIpaddr, error) {
+	cur, err := m.GetTeamsAcsCollection(TeamsacsOnline).Find(context.TODO(),
+		bson.M{"framed_ipaddr": bson.M{"$nin": []string{"", "0.0.0.0"}}},
+		options.Find().SetProjection(bson.M{"username": 1, "framed_ipaddr": 1, "acct_start_time": 1}))
+	if err != nil {
+		return nil, err
+	}
+	items := make([]OnlineIpaddr, 0)
+	err = cur.All(context.TODO(), &items)
+	return items, err
+}
+
+// GetStoppedIpaddrs
+// The framed ip of the sessions stopped since the time, the flows are
+// exported after the active or inactive timeout of the exporter
+func (m *FlowManager) GetStoppedIpaddrs(since time.Time) ([]OnlineIpaddr, error) {
+	cur, err := m.GetTeamsAcsCollection(TeamsacsAccounting).Find(context.TODO(),
+		bson.M{"acct_stop_time": bson.M{"$gte": since}, "framed_ipaddr": bson.M{"$nin": []string{"", "0.0.0.0"}}},
+		options.Find().SetProjection(bson.M{"username": 1, "framed_ipaddr": 1, "acct_start_time": 1, "acct_stop_time": 1}))
+	if err != nil {
+		return nil, err
+	}
+	items := make([]OnlineIpaddr, 0)
+	err = cur.All(context.TODO(), &items)
+	return items, err
+}
+
+// AddFlowStats
+// Merge the aggregates into the stored minutes
+func (m *FlowManager) AddFlowStats(stats []FlowStat) error {
+	if len(stats) == 0 {
+		return nil
+	}
+	writes := make([]mongo.WriteModel, 0, len(stats))
+	for _, st := range stats {
+		writes = append(writes, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": FlowStatId(st.Username, st.Minute, st.Proto, st.Port)}).
+			SetUpdate(bson.M{
+				"$setOnInsert": bson.M{"username": st.Username, "minute": st.Minute, "proto": st.Proto, "port": st.Port},
+				"$inc": bson.M{
+					"up_bytes":     st.UpBytes,
+					"down_bytes":   st.DownBytes,
+					"up_packets":   st.UpPackets,
+					"down_packets": st.DownPackets,
+					"flows":        st.Flows,
+				},
+			}).
+			SetUpsert(true))
+	}
+	_, err := m.GetTeamsAcsCollection(TeamsacsFlowStat).BulkWrite(context.TODO(), writes, options.BulkWrite().SetOrdered(false))
+	return err
+}
+
+// QueryFlowStats
+func (m *FlowManager) QueryFlowStats(params web.RequestParams) (*web.PageResult, error) {
+	return m.QueryPagerItems(params, TeamsacsFlowStat)
+}
+
+// QueryFlowTop
+// The top talkers between start and end, grouped by subscriber, port or both,
+// username limits the result to one subscriber
+func (m *FlowManager) QueryFlowTop(by, username string, start, end time.Time, limit int64) ([]FlowTop, error) {
+	var group bson.M
+	switch by {
+	case FlowTopBySubscriber, "":
+		group = bson.M{"username": "$username"}
+	case FlowTopByPort:
+		group = bson.M{"proto": "$proto", "port": "$port"}
+	case FlowTopBySubscriberPort:
+		group = bson.M{"username": "$username", "proto": "$proto", "port": "$port"}
+	default:
+		return nil, fmt.Errorf("unsupported top talker group %s", by)
+	}
+	if limit <= 0 {
+		limit = 10
+	}
+	match := bson.M{"minute": bson.M{"$gte": start, "$lt": end}}
+	if username != "" {
+		match["username"] = username
+	}
+	cur, err := m.GetTeamsAcsCollection(TeamsacsFlowStat).Aggregate(context.TODO(), []bson.M{
+		{"$match": match},
+		{"$group": bson.M{
+			"_id":        group,
+			"up_bytes":   bson.M{"$sum": "$up_bytes"},
+			"down_bytes": bson.M{"$sum": "$down_bytes"},
+			"flows":      bson.M{"$sum": "$flows"},
+		}},
+		{"$project": bson.M{
+			"_id":         0,
+			"username":    "$_id.username",
+			"proto":       "$_id.proto",
+			"port":        "$_id.port",
+			"up_bytes":    1,
+			"down_bytes":  1,
+			"flows":       1,
+			"total_bytes": bson.M{"$add": bson.A{"$up_bytes", "$down_bytes"}},
+		}},
+		{"$sort": bson.M{"total_bytes": -1}},
+		{"$limit": limit},
+	})
+	if err != nil {
+		return nil, err
+	}
+	items := make([]FlowTop, 0)
+	err = cur.All(context.TODO(), &items)
+	return items, err
+}
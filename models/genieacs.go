@@ -24,6 +24,7 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo/options"
 
+	"github.com/ca17/teamsacs/common/log"
 	"github.com/ca17/teamsacs/common/validutil"
 	"github.com/ca17/teamsacs/models/mikrotik"
 )
@@ -50,6 +51,10 @@ func (m *GenieacsManager) QueryMikrotikDeviceInfo() ([]mikrotik.DeviceInfo, erro
 				Value: mikrotik.GetObject(c, "Device.DeviceInfo"),
 			}
 		}).
+		WhereT(func(i linq.KeyValue) bool {
+			_, ok := i.Value.(mikrotik.TMap)
+			return ok
+		}).
 		ForEachT(func(i linq.KeyValue) {
 			var info = new(mikrotik.DeviceInfo)
 			info.ParseBson(i.Value.(mikrotik.TMap))
@@ -254,3 +259,29 @@ func (m *GenieacsManager) QueryMikrotikSourceData(sn string) ([]map[string]inter
 	}
 	return items, nil
 }
+
+// EachDevice
+// iterate the GenieACS devices in batches, projection limits the fields
+func (m *GenieacsManager) EachDevice(q bson.M, projection bson.M, fn func(map[string]interface{}) error) error {
+	findOptions := options.Find().SetBatchSize(200).SetSort(bson.M{"_id": 1})
+	if projection != nil {
+		findOptions.SetProjection(projection)
+	}
+	coll := m.GetGenieAcsCollection(GenieacsDevices)
+	cur, err := coll.Find(context.TODO(), q, findOptions)
+	if err != nil {
+		return err
+	}
+	defer cur.Close(context.TODO())
+	for cur.Next(context.TODO()) {
+		var elem map[string]interface{}
+		if err := cur.Decode(&elem); err != nil {
+			log.Error(err)
+			continue
+		}
+		if err := fn(elem); err != nil {
+			return err
+		}
+	}
+	return cur.Err()
+}
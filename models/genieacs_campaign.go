@@ -0,0 +1,595 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package models
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/ca17/teamsacs/common"
+	"github.com/ca17/teamsacs/common/genieacs"
+	"github.com/ca17/teamsacs/common/log"
+	"github.com/ca17/teamsacs/common/web"
+)
+
+const (
+	CampaignDraft     = "draft"
+	CampaignRunning   = "running"
+	CampaignPaused    = "paused"
+	CampaignStopped   = "stopped"
+	CampaignCompleted = "completed"
+
+	CampaignDevicePending   = "pending"
+	CampaignDeviceUpgrading = "upgrading"
+	CampaignDeviceSucceeded = "succeeded"
+	CampaignDeviceFailed    = "failed"
+	CampaignDeviceSkipped   = "skipped"
+
+	campaignDefaultBatchSize = 10
+	campaignDefaultTimeout   = 30
+)
+
+var campaignFileNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.\-]*$`)
+
+// CampaignSelector
+// The devices of a campaign, empty fields match all devices
+type CampaignSelector struct {
+	Model    string   `bson:"model" json:"model"`
+	Versions []string `bson:"versions" json:"versions"`
+	Tag      string   `bson:"tag" json:"tag"`
+}
+
+// Match
+func (s CampaignSelector) Match(inv CpeInventory) bool {
+	if s.Model != "" && !strings.EqualFold(s.Model, inv.Model) {
+		return false
+	}
+	if len(s.Versions) > 0 && !common.InSlice(inv.Firmware, s.Versions) {
+		return false
+	}
+	return true
+}
+
+// CampaignPolicy
+// The rollout policy, the window is HH:MM in the local time and may cross midnight,
+// VerifyTimeout is the minutes to wait for the new version after the task is issued
+type CampaignPolicy struct {
+	BatchSize          int     `bson:"batch_size" json:"batch_size"`
+	WindowStart        string  `bson:"window_start" json:"window_start"`
+	WindowEnd          string  `bson:"window_end" json:"window_end"`
+	StopFailurePercent float64 `bson:"stop_failure_percent" json:"stop_failure_percent"`
+	VerifyTimeout      int     `bson:"verify_timeout" json:"verify_timeout"`
+}
+
+func parseClock(v string) (int, error) {
+	t, err := time.Parse("15:04", v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid window time %s", v)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// InWindow
+// whether a new batch can start at the time, no window means any time
+func (p CampaignPolicy) InWindow(now time.Time) bool {
+	if p.WindowStart == "" || p.WindowEnd == "" {
+		return true
+	}
+	start, err1 := parseClock(p.WindowStart)
+	end, err2 := parseClock(p.WindowEnd)
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	minute := now.Hour()*60 + now.Minute()
+	if start <= end {
+		return minute >= start && minute < end
+	}
+	return minute >= start || minute < end
+}
+
+// FirmwareCampaign
+// A firmware upgrade of the selected devices, rolled out batch by batch. FileName is
+// a file uploaded to the GenieACS file server, FailedBase and DoneBase are the counters
+// when a stopped campaign resumes, the failure percent counts the devices since then
+type FirmwareCampaign struct {
+	ID            string           `bson:"_id,omitempty" json:"id,omitempty"`
+	Name          string           `bson:"name" json:"name"`
+	FileName      string           `bson:"file_name" json:"file_name"`
+	TargetVersion string           `bson:"target_version" json:"target_version"`
+	Selector      CampaignSelector `bson:"selector" json:"selector"`
+	Policy        CampaignPolicy   `bson:"policy" json:"policy"`
+	Status        string           `bson:"status" json:"status"`
+	Batch         int              `bson:"batch" json:"batch"`
+	Total         int              `bson:"total" json:"total"`
+	Pending       int              `bson:"pending" json:"pending"`
+	Upgrading     int              `bson:"upgrading" json:"upgrading"`
+	Succeeded     int              `bson:"succeeded" json:"succeeded"`
+	Failed        int              `bson:"failed" json:"failed"`
+	Skipped       int              `bson:"skipped" json:"skipped"`
+	FailedBase    int              `bson:"failed_base" json:"failed_base"`
+	DoneBase      int              `bson:"done_base" json:"done_base"`
+	StopReason    string           `bson:"stop_reason" json:"stop_reason"`
+	Operator      string           `bson:"operator" json:"operator"`
+	Remark        string           `bson:"remark" json:"remark"`
+	CreateTime    time.Time        `bson:"create_time" json:"create_time"`
+	UpdateTime    time.Time        `bson:"update_time" json:"update_time"`
+}
+
+// Validate
+// check the campaign and set the policy defaults
+func (c *FirmwareCampaign) Validate() error {
+	switch {
+	case common.IsEmptyOrNA(c.Name):
+		return fmt.Errorf("invalid campaign name")
+	case c.TargetVersion == "":
+		return fmt.Errorf("target version is empty")
+	}
+	if !campaignFileNameRegexp.MatchString(c.FileName) {
+		return fmt.Errorf("invalid firmware file name")
+	}
+	if (c.Policy.WindowStart == "") != (c.Policy.WindowEnd == "") {
+		return fmt.Errorf("maintenance window requires start and end")
+	}
+	for _, v := range []string{c.Policy.WindowStart, c.Policy.WindowEnd} {
+		if v == "" {
+			continue
+		}
+		if _, err := parseClock(v); err != nil {
+			return err
+		}
+	}
+	if c.Policy.StopFailurePercent < 0 || c.Policy.StopFailurePercent > 100 {
+		return fmt.Errorf("stop failure percent must be 0-100")
+	}
+	if c.Policy.BatchSize <= 0 {
+		c.Policy.BatchSize = campaignDefaultBatchSize
+	}
+	if c.Policy.VerifyTimeout <= 0 {
+		c.Policy.VerifyTimeout = campaignDefaultTimeout
+	}
+	return nil
+}
+
+// resume
+// a stopped campaign counts the failures since the resume
+func (c *FirmwareCampaign) resume() {
+	if c.Status == CampaignStopped {
+		c.FailedBase, c.DoneBase = c.Failed, c.Succeeded+c.Failed
+	}
+	c.Status = CampaignRunning
+	c.StopReason = ""
+}
+
+// Count
+// update the progress counters from the devices
+func (c *FirmwareCampaign) Count(devices []CampaignDevice) {
+	c.Total, c.Pending, c.Upgrading, c.Succeeded, c.Failed, c.Skipped = len(devices), 0, 0, 0, 0, 0
+	for _, d := range devices {
+		switch d.Status {
+		case CampaignDevicePending:
+			c.Pending++
+		case CampaignDeviceUpgrading:
+			c.Upgrading++
+		case CampaignDeviceSucceeded:
+			c.Succeeded++
+		case CampaignDeviceFailed:
+			c.Failed++
+		case CampaignDeviceSkipped:
+			c.Skipped++
+		}
+	}
+}
+
+// CampaignDevice
+// The upgrade progress of a device in a campaign
+type CampaignDevice struct {
+	ID          string    `bson:"_id,omitempty" json:"id,omitempty"`
+	CampaignId  string    `bson:"campaign_id" json:"campaign_id"`
+	DeviceId    string    `bson:"device_id" json:"device_id"`
+	Sn          string    `bson:"sn" json:"sn"`
+	Model       string    `bson:"model" json:"model"`
+	FromVersion string    `bson:"from_version" json:"from_version"`
+	Version     string    `bson:"version" json:"version"`
+	Batch       int       `bson:"batch" json:"batch"`
+	Status      string    `bson:"status" json:"status"`
+	TaskId      string    `bson:"task_id" json:"task_id"`
+	Error       string    `bson:"error" json:"error"`
+	StartTime   time.Time `bson:"start_time" json:"start_time"`
+	UpdateTime  time.Time `bson:"update_time" json:"update_time"`
+}
+
+var campaignDeviceProjection = []string{
+	"_id", "_deviceId", "_lastInform",
+	"Device.DeviceInfo", "InternetGatewayDevice.DeviceInfo",
+}
+
+// FirmwareOrchestrator
+// Issues the download tasks of a campaign batch by batch through the
+// GenieACS NBI and verifies the SoftwareVersion reported after the reboot
+type FirmwareOrchestrator struct {
+	Client                   *genieacs.Client
+	Location                 *time.Location
+	ConnectionRequestTimeout time.Duration
+}
+
+// SelectDevices
+// the devices matching the selector, devices already at the target version are skipped
+func (o *FirmwareOrchestrator) SelectDevices(c *FirmwareCampaign) ([]CampaignDevice, error) {
+	var query = map[string]interface{}{}
+	if c.Selector.Tag != "" {
+		query["_tags"] = c.Selector.Tag
+	}
+	docs, err := o.Client.GetDevices(query, campaignDeviceProjection)
+	if err != nil {
+		return nil, err
+	}
+	devices := make([]CampaignDevice, 0)
+	for _, doc := range docs {
+		inv := ParseCpeInventory(doc)
+		if inv.DeviceId == "" || !c.Selector.Match(inv) {
+			continue
+		}
+		d := CampaignDevice{
+			ID:          common.UUID(),
+			CampaignId:  c.ID,
+			DeviceId:    inv.DeviceId,
+			Sn:          inv.Sn,
+			Model:       inv.Model,
+			FromVersion: inv.Firmware,
+			Version:     inv.Firmware,
+			Status:      CampaignDevicePending,
+		}
+		if inv.Firmware == c.TargetVersion {
+			d.Status = CampaignDeviceSkipped
+		}
+		devices = append(devices, d)
+	}
+	sort.Slice(devices, func(i, j int) bool { return devices[i].Sn < devices[j].Sn })
+	return devices, nil
+}
+
+// CheckFile
+// the firmware file must be uploaded to the GenieACS file server
+func (o *FirmwareOrchestrator) CheckFile(c *FirmwareCampaign) error {
+	files, err := o.Client.GetFiles(map[string]interface{}{"_id": c.FileName})
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("firmware file %s is not uploaded to genieacs", c.FileName)
+	}
+	return nil
+}
+
+// Step
+// verify the upgrading devices, after the current batch is finished stop the campaign
+// when the failure percent is reached, otherwise start the next batch. Returns the
+// indexes of the changed devices.
+func (o *FirmwareOrchestrator) Step(c *FirmwareCampaign, devices []CampaignDevice, now time.Time) ([]int, error) {
+	changed := make([]int, 0)
+	if c.Status != CampaignRunning {
+		return changed, nil
+	}
+	if err := o.verify(c, devices, now, &changed); err != nil {
+		return changed, err
+	}
+	c.Count(devices)
+	c.UpdateTime = now
+
+	if c.Upgrading > 0 {
+		return changed, nil
+	}
+	failed, done := c.Failed-c.FailedBase, c.Succeeded+c.Failed-c.DoneBase
+	if c.Policy.StopFailurePercent > 0 && failed > 0 &&
+		float64(failed)*100/float64(done) >= c.Policy.StopFailurePercent {
+		c.Status = CampaignStopped
+		c.StopReason = fmt.Sprintf("%d of %d upgrades failed", failed, done)
+		return changed, nil
+	}
+	if c.Pending == 0 {
+		c.Status = CampaignCompleted
+		return changed, nil
+	}
+	if !c.Policy.InWindow(now.In(o.Location)) {
+		return changed, nil
+	}
+
+	c.Batch++
+	var count = 0
+	for i := range devices {
+		d := &devices[i]
+		if d.Status != CampaignDevicePending {
+			continue
+		}
+		if count >= c.Policy.BatchSize {
+			break
+		}
+		count++
+		task := &genieacs.Task{Name: genieacs.TaskDownload, FileType: genieacs.FileTypeFirmware, FileName: c.FileName}
+		d.Batch, d.StartTime, d.UpdateTime = c.Batch, now, now
+		result, err := o.Client.AddTask(d.DeviceId, task, true, o.ConnectionRequestTimeout)
+		if err != nil {
+			d.Status, d.Error = CampaignDeviceFailed, err.Error()
+		} else {
+			d.Status, d.TaskId = CampaignDeviceUpgrading, result.Task.ID
+		}
+		changed = append(changed, i)
+	}
+	c.Count(devices)
+	return changed, nil
+}
+
+// verify
+// the upgrading devices succeed with the target version, fail by the task fault
+// or the verify timeout, the task of a failed device is removed from the queue
+func (o *FirmwareOrchestrator) verify(c *FirmwareCampaign, devices []CampaignDevice, now time.Time, changed *[]int) error {
+	ids := make([]interface{}, 0)
+	for _, d := range devices {
+		if d.Status == CampaignDeviceUpgrading {
+			ids = append(ids, d.DeviceId)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	docs, err := o.Client.GetDevices(map[string]interface{}{"_id": map[string]interface{}{"$in": ids}}, campaignDeviceProjection)
+	if err != nil {
+		return err
+	}
+	versions := make(map[string]string, len(docs))
+	for _, doc := range docs {
+		inv := ParseCpeInventory(doc)
+		versions[inv.DeviceId] = inv.Firmware
+	}
+	faults, err := o.Client.GetFaults(map[string]interface{}{"device": map[string]interface{}{"$in": ids}})
+	if err != nil {
+		return err
+	}
+	taskFaults := make(map[string]genieacs.Fault, len(faults))
+	for _, f := range faults {
+		taskFaults[f.TaskId()] = f
+	}
+
+	timeout := time.Duration(c.Policy.VerifyTimeout) * time.Minute
+	for i := range devices {
+		d := &devices[i]
+		if d.Status != CampaignDeviceUpgrading {
+			continue
+		}
+		version := versions[d.DeviceId]
+		fault, faulted := taskFaults[d.TaskId]
+		switch {
+		case version == c.TargetVersion:
+			d.Status = CampaignDeviceSucceeded
+		case faulted:
+			d.Status, d.Error = CampaignDeviceFailed, fmt.Sprintf("%s %s", fault.Code, fault.Message)
+		case now.Sub(d.StartTime) >= timeout:
+			d.Status, d.Error = CampaignDeviceFailed, fmt.Sprintf("version %s after %d minutes", version, c.Policy.VerifyTimeout)
+		default:
+			if version != d.Version {
+				d.Version, d.UpdateTime = version, now
+				*changed = append(*changed, i)
+			}
+			continue
+		}
+		d.Version, d.UpdateTime = version, now
+		if d.Status == CampaignDeviceFailed && d.TaskId != "" {
+			if err := o.Client.DeleteTask(d.TaskId); err != nil {
+				log.Errorf("delete campaign task %s error, %s", d.TaskId, err.Error())
+			}
+		}
+		*changed = append(*changed, i)
+	}
+	return nil
+}
+
+// GetFirmwareOrchestrator
+func (m *GenieacsManager) GetFirmwareOrchestrator() (*FirmwareOrchestrator, error) {
+	client, err := m.GetNbiClient()
+	if err != nil {
+		return nil, err
+	}
+	return &FirmwareOrchestrator{
+		Client:                   client,
+		Location:                 m.Location,
+		ConnectionRequestTimeout: time.Duration(m.Config.Genieacs.ConnectionRequestTimeout) * time.Second,
+	}, nil
+}
+
+// AddFirmwareCampaign
+func (m *GenieacsManager) AddFirmwareCampaign(c *FirmwareCampaign) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	c.ID = common.UUID()
+	c.Status = CampaignDraft
+	c.CreateTime = time.Now()
+	c.UpdateTime = c.CreateTime
+	_, err := m.GetTeamsAcsCollection(TeamsacsFirmwareCampaign).InsertOne(context.TODO(), c)
+	return err
+}
+
+// GetFirmwareCampaign
+func (m *GenieacsManager) GetFirmwareCampaign(id string) (*FirmwareCampaign, error) {
+	var item = new(FirmwareCampaign)
+	err := m.GetTeamsAcsCollection(TeamsacsFirmwareCampaign).FindOne(context.TODO(), bson.M{"_id": id}).Decode(item)
+	if err != nil {
+		return nil, fmt.Errorf("campaign %s not found", id)
+	}
+	return item, nil
+}
+
+// QueryFirmwareCampaigns
+func (m *GenieacsManager) QueryFirmwareCampaigns(params web.RequestParams) (*web.PageResult, error) {
+	return m.QueryPagerItems(params, TeamsacsFirmwareCampaign)
+}
+
+// QueryCampaignDevices
+// the per device progress, filter[campaign_id] selects the campaign
+func (m *GenieacsManager) QueryCampaignDevices(params web.RequestParams) (*web.PageResult, error) {
+	return m.QueryPagerItems(params, TeamsacsCampaignDevice)
+}
+
+func (m *GenieacsManager) getCampaignDevices(id string) ([]CampaignDevice, error) {
+	cur, err := m.GetTeamsAcsCollection(TeamsacsCampaignDevice).Find(context.TODO(),
+		bson.M{"campaign_id": id}, options.Find().SetSort(bson.M{"sn": 1}))
+	if err != nil {
+		return nil, err
+	}
+	items := make([]CampaignDevice, 0)
+	err = cur.All(context.TODO(), &items)
+	return items, err
+}
+
+// saveFirmwareCampaign
+// the campaign is saved only if the stored status is not changed, so a pause
+// during a step is not overwritten
+func (m *GenieacsManager) saveFirmwareCampaign(c *FirmwareCampaign, status string) error {
+	r, err := m.GetTeamsAcsCollection(TeamsacsFirmwareCampaign).ReplaceOne(context.TODO(), bson.M{"_id": c.ID, "status": status}, c)
+	if err == nil && r.MatchedCount == 0 {
+		err = fmt.Errorf("campaign %s is not %s", c.ID, status)
+	}
+	return err
+}
+
+// StartFirmwareCampaign
+// a draft campaign selects the devices and starts, a paused or stopped campaign resumes
+func (m *GenieacsManager) StartFirmwareCampaign(id string) (*FirmwareCampaign, error) {
+	c, err := m.GetFirmwareCampaign(id)
+	if err != nil {
+		return nil, err
+	}
+	status := c.Status
+	o, err := m.GetFirmwareOrchestrator()
+	if err != nil {
+		return nil, err
+	}
+	switch c.Status {
+	case CampaignDraft:
+		if err = o.CheckFile(c); err != nil {
+			return nil, err
+		}
+		devices, err := o.SelectDevices(c)
+		if err != nil {
+			return nil, err
+		}
+		if len(devices) == 0 {
+			return nil, fmt.Errorf("no device matches the campaign")
+		}
+		docs := make([]interface{}, 0, len(devices))
+		for i := range devices {
+			docs = append(docs, devices[i])
+		}
+		if _, err = m.GetTeamsAcsCollection(TeamsacsCampaignDevice).InsertMany(context.TODO(), docs); err != nil {
+			return nil, err
+		}
+		c.Count(devices)
+	case CampaignPaused, CampaignStopped:
+		if err = o.CheckFile(c); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("campaign is %s", c.Status)
+	}
+	c.resume()
+	c.UpdateTime = time.Now()
+	return c, m.saveFirmwareCampaign(c, status)
+}
+
+// PauseFirmwareCampaign
+// no new batch is started, the upgrading devices are verified after resume
+func (m *GenieacsManager) PauseFirmwareCampaign(id string) error {
+	r, err := m.GetTeamsAcsCollection(TeamsacsFirmwareCampaign).UpdateOne(context.TODO(),
+		bson.M{"_id": id, "status": CampaignRunning},
+		bson.M{"$set": bson.M{"status": CampaignPaused, "update_time": time.Now()}})
+	if err == nil && r.MatchedCount == 0 {
+		err = fmt.Errorf("campaign %s is not running", id)
+	}
+	return err
+}
+
+// DeleteFirmwareCampaign
+// running campaigns must be paused first
+func (m *GenieacsManager) DeleteFirmwareCampaign(id string) error {
+	c, err := m.GetFirmwareCampaign(id)
+	if err != nil {
+		return err
+	}
+	if c.Status == CampaignRunning {
+		return fmt.Errorf("campaign is running")
+	}
+	if _, err = m.GetTeamsAcsCollection(TeamsacsCampaignDevice).DeleteMany(context.TODO(), bson.M{"campaign_id": id}); err != nil {
+		return err
+	}
+	_, err = m.GetTeamsAcsCollection(TeamsacsFirmwareCampaign).DeleteOne(context.TODO(), bson.M{"_id": id})
+	return err
+}
+
+// StepFirmwareCampaign
+// run the orchestrator once for the campaign and save the progress
+func (m *GenieacsManager) StepFirmwareCampaign(c *FirmwareCampaign) error {
+	o, err := m.GetFirmwareOrchestrator()
+	if err != nil {
+		return err
+	}
+	devices, err := m.getCampaignDevices(c.ID)
+	if err != nil {
+		return err
+	}
+	changed, err := o.Step(c, devices, time.Now())
+	if len(changed) > 0 {
+		writes := make([]mongo.WriteModel, 0, len(changed))
+		for _, i := range changed {
+			writes = append(writes, mongo.NewReplaceOneModel().SetFilter(bson.M{"_id": devices[i].ID}).SetReplacement(devices[i]))
+		}
+		if _, werr := m.GetTeamsAcsCollection(TeamsacsCampaignDevice).BulkWrite(context.TODO(), writes); werr != nil {
+			return werr
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return m.saveFirmwareCampaign(c, CampaignRunning)
+}
+
+// RunFirmwareCampaigns
+// Scheduler entry
+func (m *GenieacsManager) RunFirmwareCampaigns() {
+	cur, err := m.GetTeamsAcsCollection(TeamsacsFirmwareCampaign).Find(context.TODO(), bson.M{"status": CampaignRunning})
+	if err != nil {
+		log.Errorf("query firmware campaigns error, %s", err.Error())
+		return
+	}
+	items := make([]FirmwareCampaign, 0)
+	if err = cur.All(context.TODO(), &items); err != nil {
+		log.Errorf("query firmware campaigns error, %s", err.Error())
+		return
+	}
+	for i := range items {
+		if err := m.StepFirmwareCampaign(&items[i]); err != nil {
+			log.Errorf("firmware campaign %s error, %s", items[i].Name, err.Error())
+		}
+	}
+}
@@ -0,0 +1,193 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ca17/teamsacs/common/genieacs"
+	"github.com/ca17/teamsacs/common/genieacs/genieacstest"
+)
+
+func newCampaignServer() *genieacstest.Server {
+	server := genieacstest.NewServer("CPE-0001", "CPE-0002", "CPE-0003", "CPE-0004", "CPE-0005", "CPE-0006")
+	for _, d := range []string{"CPE-0001", "CPE-0002", "CPE-0003", "CPE-0004", "CPE-0005", "CPE-0006"} {
+		server.SetDeviceField(d, "_deviceId", map[string]interface{}{"_SerialNumber": d, "_ProductClass": "RB750Gr3"})
+		server.SetParam(d, "Device.DeviceInfo.ModelName", "hEX")
+		server.SetParam(d, "Device.DeviceInfo.SoftwareVersion", "6.46.8")
+		server.SetOnline(d, true)
+	}
+	server.SetParam("CPE-0005", "Device.DeviceInfo.SoftwareVersion", "6.47.7")
+	server.SetParam("CPE-0006", "Device.DeviceInfo.ModelName", "hAP")
+	server.Exec = func(device string, task genieacs.Task) *genieacs.Fault {
+		if device == "CPE-0003" {
+			return &genieacs.Fault{Code: "cwmp.9010", Message: "Download failure"}
+		}
+		return nil
+	}
+	return server
+}
+
+func TestFirmwareCampaign(t *testing.T) {
+	server := newCampaignServer()
+	defer server.Close()
+	o := &FirmwareOrchestrator{
+		Client:   genieacs.NewClient(server.URL, "", "", time.Second*5),
+		Location: time.UTC,
+	}
+	c := &FirmwareCampaign{
+		ID:            "c1",
+		Name:          "hex 6.47.7",
+		FileName:      "routeros-mmips-6.47.7.npk",
+		TargetVersion: "6.47.7",
+		Selector:      CampaignSelector{Model: "hex", Versions: []string{"6.46.8", "6.47.7"}},
+		Policy:        CampaignPolicy{BatchSize: 2, StopFailurePercent: 50},
+	}
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.CheckFile(c); err == nil {
+		t.Fatal("the file is not uploaded")
+	}
+	server.AddFile(genieacs.File{ID: "routeros-mmips-6.47.7.npk", Metadata: genieacs.FileMetadata{FileType: genieacs.FileTypeFirmware}})
+	if err := o.CheckFile(c); err != nil {
+		t.Fatal(err)
+	}
+	devices, err := o.SelectDevices(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Count(devices)
+	if c.Total != 5 || c.Pending != 4 || c.Skipped != 1 || devices[0].Sn != "CPE-0001" {
+		t.Fatalf("campaign %+v devices %+v", c, devices)
+	}
+	c.Status = CampaignRunning
+	now := time.Now()
+	step := func() {
+		if _, err := o.Step(c, devices, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	step()
+	tasks := server.Executed()
+	if c.Batch != 1 || c.Upgrading != 2 || len(tasks) != 2 || tasks[0].FileName != "routeros-mmips-6.47.7.npk" || tasks[0].FileType != genieacs.FileTypeFirmware {
+		t.Fatalf("campaign %+v tasks %+v", c, tasks)
+	}
+
+	// the next batch waits for the current batch
+	server.SetParam("CPE-0001", "Device.DeviceInfo.SoftwareVersion", "6.47.7")
+	step()
+	if c.Batch != 1 || c.Succeeded != 1 || c.Upgrading != 1 {
+		t.Fatalf("campaign %+v", c)
+	}
+
+	server.SetParam("CPE-0002", "Device.DeviceInfo.SoftwareVersion", "6.47.7")
+	step()
+	if c.Batch != 2 || c.Succeeded != 2 || c.Upgrading != 2 || devices[2].Batch != 2 {
+		t.Fatalf("campaign %+v", c)
+	}
+
+	server.SetParam("CPE-0004", "Device.DeviceInfo.SoftwareVersion", "6.47.7")
+	step()
+	if c.Status != CampaignCompleted || c.Succeeded != 3 || c.Failed != 1 || devices[2].Error != "cwmp.9010 Download failure" {
+		t.Fatalf("campaign %+v devices %+v", c, devices)
+	}
+	if len(server.Tasks()) != 0 {
+		t.Fatalf("failed task is queued %+v", server.Tasks())
+	}
+}
+
+func TestFirmwareCampaignStop(t *testing.T) {
+	server := newCampaignServer()
+	defer server.Close()
+	server.SetOnline("CPE-0004", false)
+	o := &FirmwareOrchestrator{
+		Client:   genieacs.NewClient(server.URL, "", "", time.Second*5),
+		Location: time.UTC,
+	}
+	c := &FirmwareCampaign{
+		ID:            "c2",
+		Name:          "hex 6.47.7",
+		FileName:      "fw.npk",
+		TargetVersion: "6.47.7",
+		Selector:      CampaignSelector{Model: "hEX"},
+		Policy:        CampaignPolicy{BatchSize: 2, StopFailurePercent: 50, VerifyTimeout: 10},
+		Status:        CampaignRunning,
+	}
+	devices, err := o.SelectDevices(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	// CPE-0003 faults and CPE-0004 is offline
+	devices = devices[2:4]
+	if _, err = o.Step(c, devices, now); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = o.Step(c, devices, now.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if c.Status != CampaignRunning || c.Failed != 1 || c.Upgrading != 1 {
+		t.Fatalf("campaign %+v", c)
+	}
+	if _, err = o.Step(c, devices, now.Add(11*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if c.Status != CampaignStopped || c.Failed != 2 || devices[1].Error == "" || len(server.Tasks()) != 0 {
+		t.Fatalf("campaign %+v devices %+v", c, devices)
+	}
+
+	// the resumed campaign counts the failures since the resume
+	devices = append(devices, CampaignDevice{ID: "d5", CampaignId: "c2", DeviceId: "CPE-0001", Sn: "CPE-0001", Status: CampaignDevicePending})
+	c.resume()
+	if _, err = o.Step(c, devices, now.Add(12*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if c.Status != CampaignRunning || c.Upgrading != 1 || c.FailedBase != 2 {
+		t.Fatalf("resumed campaign %+v", c)
+	}
+}
+
+func TestCampaignPolicy(t *testing.T) {
+	at := func(clock string) time.Time {
+		v, _ := time.Parse("15:04", clock)
+		return v
+	}
+	night := CampaignPolicy{WindowStart: "23:00", WindowEnd: "05:00"}
+	if !night.InWindow(at("23:30")) || !night.InWindow(at("01:00")) || night.InWindow(at("05:00")) || night.InWindow(at("12:00")) {
+		t.Fatal("night window")
+	}
+	day := CampaignPolicy{WindowStart: "10:00", WindowEnd: "12:00"}
+	if !day.InWindow(at("10:00")) || day.InWindow(at("12:30")) {
+		t.Fatal("day window")
+	}
+	if !(CampaignPolicy{}).InWindow(at("12:00")) {
+		t.Fatal("no window")
+	}
+	c := &FirmwareCampaign{Name: "test", FileName: "../fw.npk", TargetVersion: "1"}
+	if c.Validate() == nil {
+		t.Fatal("expect invalid file name")
+	}
+	c.FileName = "fw.npk"
+	c.Policy.WindowStart = "25:00"
+	c.Policy.WindowEnd = "01:00"
+	if c.Validate() == nil {
+		t.Fatal("expect invalid window")
+	}
+}
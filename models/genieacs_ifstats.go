@@ -0,0 +1,345 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package models
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/ca17/teamsacs/common/log"
+	"github.com/ca17/teamsacs/common/validutil"
+	"github.com/ca17/teamsacs/models/mikrotik"
+)
+
+const (
+	// a boot time moving less than this is the jitter of the uptime readings
+	ifStatsBootJitter = 60
+	ifStatsMaxPoints  = 1000
+	// the most a CPE interface counts in a second, 100Gbps and 150Mpps
+	ifMaxBytesRate   = 100e9 / 8
+	ifMaxPacketsRate = 150e6
+)
+
+// the interface tables of the collected counters
+var ifStatsTables = []string{"Ethernet.Interface", "PPP.Interface"}
+
+// IfStatSample
+// The counters of a CPE interface read by GenieACS, Timestamp is the
+// time of the reading, BootTime is derived from the device uptime
+type IfStatSample struct {
+	ID               string    `bson:"_id" json:"id"`
+	Sn               string    `bson:"sn" json:"sn"`
+	DeviceId         string    `bson:"device_id" json:"device_id"`
+	Interface        string    `bson:"interface" json:"interface"`
+	Timestamp        time.Time `bson:"timestamp" json:"timestamp"`
+	BootTime         int64     `bson:"boot_time" json:"boot_time"`
+	BytesReceived    int64     `bson:"bytes_received" json:"bytes_received"`
+	BytesSent        int64     `bson:"bytes_sent" json:"bytes_sent"`
+	PacketsReceived  int64     `bson:"packets_received" json:"packets_received"`
+	PacketsSent      int64     `bson:"packets_sent" json:"packets_sent"`
+	ErrorsReceived   int64     `bson:"errors_received" json:"errors_received"`
+	ErrorsSent       int64     `bson:"errors_sent" json:"errors_sent"`
+	DiscardsReceived int64     `bson:"discards_received" json:"discards_received"`
+	DiscardsSent     int64     `bson:"discards_sent" json:"discards_sent"`
+}
+
+// IfRatePoint
+// The average rates of an interval, bits and packets per second
+type IfRatePoint struct {
+	Time      time.Time `json:"time"`
+	RxBps     float64   `json:"rx_bps"`
+	TxBps     float64   `json:"tx_bps"`
+	RxPps     float64   `json:"rx_pps"`
+	TxPps     float64   `json:"tx_pps"`
+	RxErrors  int64     `json:"rx_errors"`
+	TxErrors  int64     `json:"tx_errors"`
+	RxDiscard int64     `json:"rx_discards"`
+	TxDiscard int64     `json:"tx_discards"`
+	Seconds   float64   `json:"-"`
+}
+
+// IfRateSeries
+type IfRateSeries struct {
+	Interface string        `json:"interface"`
+	Points    []IfRatePoint `json:"points"`
+}
+
+// CounterDelta
+// the increase of a counter between two readings. A smaller value after a reboot
+// is counted from zero, otherwise it is a 32 or 64 bit wrap by the previous value.
+// A wrap over half of the counter range or over maxDelta, the most the interface
+// can count in the interval, is a reset like cleared counters or a reboot without
+// the uptime, it is counted from zero too.
+func CounterDelta(prev, cur int64, rebooted bool, maxDelta float64) int64 {
+	if rebooted || prev < 0 {
+		return cur
+	}
+	if cur >= prev {
+		return cur - prev
+	}
+	var wrapped, half uint64
+	if prev <= math.MaxUint32 {
+		wrapped = math.MaxUint32 - uint64(prev) + uint64(cur) + 1
+		half = math.MaxUint32 / 2
+	} else {
+		wrapped = uint64(cur) - uint64(prev)
+		half = math.MaxUint64 / 2
+	}
+	if wrapped > half || (maxDelta > 0 && float64(wrapped) > maxDelta) {
+		return cur
+	}
+	return int64(wrapped)
+}
+
+// ParseIfStatSamples
+// the interface counters of a GenieACS device document
+func ParseIfStatSamples(doc map[string]interface{}) []IfStatSample {
+	deviceId, _ := doc["_id"].(string)
+	sn := deviceIdValue(doc, "_SerialNumber")
+	var bootTime int64
+	if uptime, ok := mikrotik.GetObject(doc, "Device.DeviceInfo.UpTime").(mikrotik.TMap); ok {
+		ts := mikrotik.ParseDateTime(uptime["_timestamp"])
+		if ts != mikrotik.EmptyDate {
+			bootTime = ts.Time().Unix() - mikrotik.ParseInt64(uptime["_value"])
+		}
+	}
+	samples := make([]IfStatSample, 0)
+	for _, table := range ifStatsTables {
+		items, ok := mikrotik.GetObject(doc, "Device."+table).(mikrotik.TMap)
+		if !ok {
+			continue
+		}
+		for key, item := range items {
+			itemMap, ok := item.(mikrotik.TMap)
+			if !validutil.IsInt(key) || !ok {
+				continue
+			}
+			statsMap, ok := itemMap["Stats"].(mikrotik.TMap)
+			if !ok {
+				continue
+			}
+			ts := mikrotik.ParseDateTime(mikrotik.GetObject(statsMap, "BytesReceived._timestamp"))
+			if ts == mikrotik.EmptyDate {
+				continue
+			}
+			var stats = new(mikrotik.EthernetInterfaceItemStats)
+			stats.ParseBson(statsMap)
+			iface := fmt.Sprintf("%s.%s", table, key)
+			samples = append(samples, IfStatSample{
+				ID:               fmt.Sprintf("%s|%s|%d", deviceId, iface, ts.Time().Unix()),
+				Sn:               sn,
+				DeviceId:         deviceId,
+				Interface:        iface,
+				Timestamp:        ts.Time(),
+				BootTime:         bootTime,
+				BytesReceived:    stats.BytesReceived,
+				BytesSent:        stats.BytesSent,
+				PacketsReceived:  stats.PacketsReceived,
+				PacketsSent:      stats.PacketsSent,
+				ErrorsReceived:   stats.ErrorsReceived,
+				ErrorsSent:       stats.ErrorsSent,
+				DiscardsReceived: stats.DiscardPacketsReceived,
+				DiscardsSent:     stats.DiscardPacketsSent,
+			})
+		}
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i].Interface < samples[j].Interface })
+	return samples
+}
+
+// ComputeIfRates
+// the rates between the consecutive samples of an interface, a reboot is a boot
+// time later than the previous one, the interval then starts at the boot time
+func ComputeIfRates(samples []IfStatSample) []IfRatePoint {
+	points := make([]IfRatePoint, 0, len(samples))
+	for i := 1; i < len(samples); i++ {
+		prev, cur := samples[i-1], samples[i]
+		seconds := cur.Timestamp.Sub(prev.Timestamp).Seconds()
+		if seconds <= 0 {
+			continue
+		}
+		rebooted := prev.BootTime > 0 && cur.BootTime > prev.BootTime+ifStatsBootJitter
+		if rebooted && cur.BootTime < cur.Timestamp.Unix() {
+			if up := float64(cur.Timestamp.Unix() - cur.BootTime); up < seconds {
+				seconds = up
+			}
+		}
+		if seconds <= 0 {
+			continue
+		}
+		maxBytes, maxPackets := seconds*ifMaxBytesRate, seconds*ifMaxPacketsRate
+		p := IfRatePoint{
+			Time:      cur.Timestamp,
+			Seconds:   seconds,
+			RxBps:     float64(CounterDelta(prev.BytesReceived, cur.BytesReceived, rebooted, maxBytes)) * 8 / seconds,
+			TxBps:     float64(CounterDelta(prev.BytesSent, cur.BytesSent, rebooted, maxBytes)) * 8 / seconds,
+			RxPps:     float64(CounterDelta(prev.PacketsReceived, cur.PacketsReceived, rebooted, maxPackets)) / seconds,
+			TxPps:     float64(CounterDelta(prev.PacketsSent, cur.PacketsSent, rebooted, maxPackets)) / seconds,
+			RxErrors:  CounterDelta(prev.ErrorsReceived, cur.ErrorsReceived, rebooted, maxPackets),
+			TxErrors:  CounterDelta(prev.ErrorsSent, cur.ErrorsSent, rebooted, maxPackets),
+			RxDiscard: CounterDelta(prev.DiscardsReceived, cur.DiscardsReceived, rebooted, maxPackets),
+			TxDiscard: CounterDelta(prev.DiscardsSent, cur.DiscardsSent, rebooted, maxPackets),
+		}
+		points = append(points, p)
+	}
+	return points
+}
+
+// DownsampleIfRates
+// the time weighted average rates of each step from start, errors and discards are summed
+func DownsampleIfRates(points []IfRatePoint, start time.Time, step time.Duration) []IfRatePoint {
+	if step <= 0 {
+		return points
+	}
+	result := make([]IfRatePoint, 0)
+	var bucket *IfRatePoint
+	for _, p := range points {
+		t := start.Add(p.Time.Sub(start) / step * step)
+		if bucket == nil || !bucket.Time.Equal(t) {
+			if bucket != nil {
+				result = append(result, *bucket)
+			}
+			bucket = &IfRatePoint{Time: t}
+		}
+		total := bucket.Seconds + p.Seconds
+		avg := func(a, b float64) float64 { return (a*bucket.Seconds + b*p.Seconds) / total }
+		bucket.RxBps = avg(bucket.RxBps, p.RxBps)
+		bucket.TxBps = avg(bucket.TxBps, p.TxBps)
+		bucket.RxPps = avg(bucket.RxPps, p.RxPps)
+		bucket.TxPps = avg(bucket.TxPps, p.TxPps)
+		bucket.RxErrors += p.RxErrors
+		bucket.TxErrors += p.TxErrors
+		bucket.RxDiscard += p.RxDiscard
+		bucket.TxDiscard += p.TxDiscard
+		bucket.Seconds = total
+	}
+	if bucket != nil {
+		result = append(result, *bucket)
+	}
+	return result
+}
+
+// SetupIfStatsDB
+func (m *ModelManager) SetupIfStatsDB() {
+	days := m.Config.IfStats.HistoryDays
+	if days <= 0 {
+		days = 30
+	}
+	_, err := m.GetTeamsAcsCollection(TeamsacsCpeIfStat).Indexes().CreateMany(context.TODO(), []mongo.IndexModel{
+		{Keys: bson.D{{"sn", 1}, {"interface", 1}, {"timestamp", 1}}},
+		{Keys: bson.D{{"timestamp", 1}}, Options: options.Index().SetExpireAfterSeconds(int32(days * 86400))},
+	})
+	if err != nil {
+		log.Errorf("create cpe interface stat indexes error, %s", err.Error())
+	}
+}
+
+// CollectIfStats
+// save the interface counters of all devices, a reading already saved is ignored
+func (m *GenieacsManager) CollectIfStats() (int, error) {
+	projection := bson.M{"_id": 1, "_deviceId": 1, "Device.DeviceInfo.UpTime": 1}
+	for _, table := range ifStatsTables {
+		projection["Device."+table] = 1
+	}
+	coll := m.GetTeamsAcsCollection(TeamsacsCpeIfStat)
+	var total = 0
+	writes := make([]mongo.WriteModel, 0)
+	flush := func() error {
+		if len(writes) == 0 {
+			return nil
+		}
+		r, err := coll.BulkWrite(context.TODO(), writes, options.BulkWrite().SetOrdered(false))
+		if r != nil {
+			total += int(r.UpsertedCount)
+		}
+		writes = writes[:0]
+		return err
+	}
+	err := m.EachDevice(bson.M{}, projection, func(doc map[string]interface{}) error {
+		for _, s := range ParseIfStatSamples(doc) {
+			writes = append(writes, mongo.NewUpdateOneModel().
+				SetFilter(bson.M{"_id": s.ID}).
+				SetUpdate(bson.M{"$setOnInsert": s}).
+				SetUpsert(true))
+		}
+		if len(writes) >= 500 {
+			return flush()
+		}
+		return nil
+	})
+	if err != nil {
+		return total, err
+	}
+	return total, flush()
+}
+
+// QueryIfRates
+// the rates of the interfaces of a CPE in the time range, all interfaces when
+// iface is empty, downsampled to at most points per interface
+func (m *GenieacsManager) QueryIfRates(sn, iface string, start, end time.Time, points int) ([]IfRateSeries, error) {
+	if sn == "" {
+		return nil, fmt.Errorf("sn is empty")
+	}
+	if !end.After(start) {
+		return nil, fmt.Errorf("invalid time range")
+	}
+	if points <= 0 || points > ifStatsMaxPoints {
+		points = ifStatsMaxPoints
+	}
+	q := bson.M{"sn": sn, "timestamp": bson.M{"$gte": start, "$lte": end}}
+	if iface != "" {
+		q["interface"] = iface
+	}
+	cur, err := m.GetTeamsAcsCollection(TeamsacsCpeIfStat).Find(context.TODO(), q,
+		options.Find().SetSort(bson.D{{"interface", 1}, {"timestamp", 1}}))
+	if err != nil {
+		return nil, err
+	}
+	samples := make([]IfStatSample, 0)
+	if err = cur.All(context.TODO(), &samples); err != nil {
+		return nil, err
+	}
+	step := end.Sub(start) / time.Duration(points)
+	result := make([]IfRateSeries, 0)
+	for i := 0; i < len(samples); {
+		j := i
+		for j < len(samples) && samples[j].Interface == samples[i].Interface {
+			j++
+		}
+		result = append(result, IfRateSeries{
+			Interface: samples[i].Interface,
+			Points:    DownsampleIfRates(ComputeIfRates(samples[i:j]), start, step),
+		})
+		i = j
+	}
+	return result, nil
+}
+
+// RunIfStatsCollect
+// Scheduler entry
+func (m *GenieacsManager) RunIfStatsCollect() {
+	if _, err := m.CollectIfStats(); err != nil {
+		log.Errorf("collect cpe interface stats error, %s", err.Error())
+	}
+}
@@ -0,0 +1,120 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package models
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestParseIfStatSamples(t *testing.T) {
+	ts := time.Date(2020, 11, 2, 10, 0, 0, 0, time.UTC)
+	value := func(v interface{}) map[string]interface{} {
+		return map[string]interface{}{"_value": v, "_timestamp": primitive.NewDateTimeFromTime(ts)}
+	}
+	doc := map[string]interface{}{
+		"_id":       "001122-RB750-CPE0001",
+		"_deviceId": map[string]interface{}{"_SerialNumber": "CPE0001"},
+		"Device": map[string]interface{}{
+			"DeviceInfo": map[string]interface{}{"UpTime": value(3600)},
+			"Ethernet": map[string]interface{}{"Interface": map[string]interface{}{
+				"_object": true,
+				"1": map[string]interface{}{"Stats": map[string]interface{}{
+					"BytesReceived": value(1000), "BytesSent": value(2000), "PacketsReceived": value(10), "ErrorsSent": value(1),
+				}},
+				"2": map[string]interface{}{"Stats": map[string]interface{}{}},
+			}},
+			"PPP": map[string]interface{}{"Interface": map[string]interface{}{
+				"1": map[string]interface{}{"Stats": map[string]interface{}{"BytesReceived": value(5000)}},
+			}},
+		},
+	}
+	samples := ParseIfStatSamples(doc)
+	if len(samples) != 2 {
+		t.Fatalf("samples %+v", samples)
+	}
+	s := samples[0]
+	if s.Interface != "Ethernet.Interface.1" || s.Sn != "CPE0001" || s.BytesReceived != 1000 || s.BytesSent != 2000 ||
+		s.PacketsReceived != 10 || s.ErrorsSent != 1 || s.BootTime != ts.Unix()-3600 || !s.Timestamp.Equal(ts) {
+		t.Fatalf("sample %+v", s)
+	}
+	if samples[1].Interface != "PPP.Interface.1" || samples[1].ID != "001122-RB750-CPE0001|PPP.Interface.1|1604311200" {
+		t.Fatalf("sample %+v", samples[1])
+	}
+}
+
+func TestComputeIfRates(t *testing.T) {
+	if CounterDelta(100, 300, false, 0) != 200 {
+		t.Fatal("delta")
+	}
+	if d := CounterDelta(math.MaxUint32-99, 100, false, 0); d != 200 {
+		t.Fatalf("32 bit wrap %d", d)
+	}
+	if CounterDelta(5000, 100, true, 0) != 100 {
+		t.Fatal("reboot delta")
+	}
+	// the counters are cleared without a reboot
+	if d := CounterDelta(math.MaxUint32+1000, 100, false, 0); d != 100 {
+		t.Fatalf("64 bit reset %d", d)
+	}
+	if d := CounterDelta(1000000, 100, false, 0); d != 100 {
+		t.Fatalf("32 bit reset %d", d)
+	}
+	if d := CounterDelta(math.MaxUint32-1000000, 100, false, 1000); d != 100 {
+		t.Fatalf("reset over the line rate %d", d)
+	}
+
+	start := time.Date(2020, 11, 2, 0, 0, 0, 0, time.UTC)
+	boot := start.Add(-time.Hour).Unix()
+	sample := func(minute int, rx int64, boot int64) IfStatSample {
+		return IfStatSample{Timestamp: start.Add(time.Duration(minute) * time.Minute), BootTime: boot, BytesReceived: rx, PacketsReceived: rx / 100}
+	}
+	samples := []IfStatSample{
+		sample(0, 1000000, boot),
+		sample(5, 1000000+300*1000, boot),
+		sample(10, 1000000+600*1000, boot+10),
+		// rebooted a minute ago
+		sample(15, 60*2000, start.Add(14*time.Minute).Unix()),
+		sample(15, 60*2000, start.Add(14*time.Minute).Unix()),
+		sample(20, 60*2000+300*1000, start.Add(14*time.Minute).Unix()),
+	}
+	points := ComputeIfRates(samples)
+	if len(points) != 4 {
+		t.Fatalf("points %+v", points)
+	}
+	if points[0].RxBps != 8000 || points[0].RxPps != 10 || points[1].RxBps != 8000 {
+		t.Fatalf("points %+v", points[:2])
+	}
+	if points[2].RxBps != 16000 || points[2].Seconds != 60 {
+		t.Fatalf("reboot point %+v", points[2])
+	}
+
+	down := DownsampleIfRates(points, start, 10*time.Minute)
+	if len(down) != 3 {
+		t.Fatalf("downsample %+v", down)
+	}
+	if down[0].RxBps != 8000 || down[1].Seconds != 360 {
+		t.Fatalf("downsample %+v", down)
+	}
+	// 300s at 8000 and 60s at 16000
+	if want := (8000*300 + 16000*60) / 360.0; math.Abs(down[1].RxBps-want) > 0.001 {
+		t.Fatalf("downsample %f != %f", down[1].RxBps, want)
+	}
+}
@@ -0,0 +1,473 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package models
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/ca17/teamsacs/common"
+	"github.com/ca17/teamsacs/common/log"
+	"github.com/ca17/teamsacs/common/timeutil"
+	"github.com/ca17/teamsacs/common/web"
+)
+
+const (
+	CpeSyncAdded    = "added"
+	CpeSyncChanged  = "changed"
+	CpeSyncVanished = "vanished"
+
+	CpeAcsPresent  = "present"
+	CpeAcsVanished = "vanished"
+
+	// the ipaddr_source of a cpe record, a synchronized ipaddr follows
+	// the WAN address until an operator sets the ipaddr
+	CpeIpaddrSync   = "sync"
+	CpeIpaddrManual = "manual"
+)
+
+// the synchronized fields of the cpe records, changes of the other
+// fields like last_inform are not recorded in the history
+var cpeInventoryFields = []string{"device_id", "model", "firmware", "wan_ipaddr", "ppp_username", "subscriber", "acs_state"}
+
+// the parameters of the TR-181 and TR-098 data models
+var (
+	cpeModelPaths = []string{
+		"Device.DeviceInfo.ModelName",
+		"InternetGatewayDevice.DeviceInfo.ModelName",
+	}
+	cpeFirmwarePaths = []string{
+		"Device.DeviceInfo.SoftwareVersion",
+		"InternetGatewayDevice.DeviceInfo.SoftwareVersion",
+	}
+	cpeSnPaths = []string{
+		"Device.DeviceInfo.SerialNumber",
+		"InternetGatewayDevice.DeviceInfo.SerialNumber",
+	}
+	cpePppUserPaths = []string{
+		"Device.PPP.Interface.*.Username",
+		"InternetGatewayDevice.WANDevice.*.WANConnectionDevice.*.WANPPPConnection.*.Username",
+	}
+	cpeWanIpPaths = []string{
+		"Device.PPP.Interface.*.IPCP.LocalIPAddress",
+		"InternetGatewayDevice.WANDevice.*.WANConnectionDevice.*.WANPPPConnection.*.ExternalIPAddress",
+		"InternetGatewayDevice.WANDevice.*.WANConnectionDevice.*.WANIPConnection.*.ExternalIPAddress",
+	}
+	cpeConnReqPaths = []string{
+		"Device.ManagementServer.ConnectionRequestURL",
+		"InternetGatewayDevice.ManagementServer.ConnectionRequestURL",
+	}
+)
+
+// CpeInventory
+// The inventory fields of a GenieACS device
+type CpeInventory struct {
+	DeviceId    string
+	Sn          string
+	Model       string
+	Firmware    string
+	WanIpaddr   string
+	PppUsername string
+	LastInform  time.Time
+}
+
+// CpeFieldChange
+type CpeFieldChange struct {
+	Field string `bson:"field" json:"field"`
+	Old   string `bson:"old" json:"old"`
+	New   string `bson:"new" json:"new"`
+}
+
+// CpeSyncHistory
+// The change of a cpe record by the inventory sync
+type CpeSyncHistory struct {
+	ID         string           `bson:"_id,omitempty" json:"id,omitempty"`
+	Sn         string           `bson:"sn" json:"sn"`
+	DeviceId   string           `bson:"device_id" json:"device_id"`
+	Action     string           `bson:"action" json:"action"`
+	Changes    []CpeFieldChange `bson:"changes" json:"changes"`
+	CreateTime time.Time        `bson:"create_time" json:"create_time"`
+}
+
+// CpeSyncChange
+// A pending update of the cpe collection, ID is empty for new records
+type CpeSyncChange struct {
+	ID      string
+	Fields  map[string]string
+	History *CpeSyncHistory
+}
+
+// CpeSyncResult
+type CpeSyncResult struct {
+	Total    int `json:"total"`
+	Added    int `json:"added"`
+	Changed  int `json:"changed"`
+	Vanished int `json:"vanished"`
+}
+
+// firstParamValue
+// the first non empty value of the parameters matching a pattern exactly
+func firstParamValue(params []DeviceParam, patterns []string) string {
+	for _, pattern := range patterns {
+		depth := strings.Count(pattern, ".")
+		for _, p := range params {
+			if strings.Count(p.Path, ".") != depth || !MatchParamPath(pattern, p.Path) {
+				continue
+			}
+			if v := strings.TrimSpace(paramString(p.Value)); v != "" && v != "0.0.0.0" {
+				return v
+			}
+		}
+	}
+	return ""
+}
+
+func paramString(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return t
+	case time.Time:
+		return t.Format(time.RFC3339)
+	default:
+		return fmt.Sprint(t)
+	}
+}
+
+func deviceIdValue(doc map[string]interface{}, key string) string {
+	if did, ok := asMap(doc["_deviceId"]); ok {
+		if v, ok := did[key].(string); ok {
+			return v
+		}
+	}
+	return ""
+}
+
+// ParseCpeInventory
+// read the inventory fields from the GenieACS device document,
+// the WAN address falls back to the host of the connection request url
+func ParseCpeInventory(doc map[string]interface{}) CpeInventory {
+	params := FlattenDeviceParams(doc, "")
+	inv := CpeInventory{
+		Sn:          deviceIdValue(doc, "_SerialNumber"),
+		Model:       firstParamValue(params, cpeModelPaths),
+		Firmware:    firstParamValue(params, cpeFirmwarePaths),
+		WanIpaddr:   firstParamValue(params, cpeWanIpPaths),
+		PppUsername: firstParamValue(params, cpePppUserPaths),
+	}
+	inv.DeviceId, _ = doc["_id"].(string)
+	if inv.Sn == "" {
+		inv.Sn = firstParamValue(params, cpeSnPaths)
+	}
+	if inv.Model == "" {
+		inv.Model = deviceIdValue(doc, "_ProductClass")
+	}
+	if inv.WanIpaddr == "" {
+		if u, err := url.Parse(firstParamValue(params, cpeConnReqPaths)); err == nil {
+			if ip := net.ParseIP(u.Hostname()); ip != nil {
+				inv.WanIpaddr = ip.String()
+			}
+		}
+	}
+	switch t := doc["_lastInform"].(type) {
+	case primitive.DateTime:
+		inv.LastInform = t.Time()
+	case time.Time:
+		inv.LastInform = t
+	case string:
+		// the NBI devices api
+		inv.LastInform, _ = time.Parse(time.RFC3339, t)
+	}
+	return inv
+}
+
+// cpeInventoryProjection
+// the device fields read by the inventory sync
+func cpeInventoryProjection() bson.M {
+	projection := bson.M{"_id": 1, "_deviceId": 1, "_lastInform": 1}
+	for _, paths := range [][]string{cpeModelPaths, cpeFirmwarePaths, cpeSnPaths, cpePppUserPaths, cpeWanIpPaths, cpeConnReqPaths} {
+		for k := range paramProjection(paths) {
+			projection[k] = 1
+		}
+	}
+	// a path and its sub path can not be projected together
+	keys := make([]string, 0, len(projection))
+	for k := range projection {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for i := 1; i < len(keys); i++ {
+		for j := 0; j < i; j++ {
+			if strings.HasPrefix(keys[i], keys[j]+".") {
+				delete(projection, keys[i])
+				break
+			}
+		}
+	}
+	return projection
+}
+
+// DiffCpeInventory
+// compare the cpe records with the GenieACS devices, the records are matched by
+// device_id or sn, records of devices no longer in GenieACS are marked vanished.
+// linker returns the subscriber of a PPP username
+func DiffCpeInventory(cpes []Cpe, devices []CpeInventory, linker func(string) string, loc *time.Location) []CpeSyncChange {
+	byDeviceId := make(map[string]Cpe)
+	bySn := make(map[string]Cpe)
+	for _, cpe := range cpes {
+		if v := cpe.GetStringValue("device_id", ""); v != "" {
+			byDeviceId[v] = cpe
+		}
+		if v := cpe.GetStringValue("sn", ""); v != "" {
+			bySn[v] = cpe
+		}
+	}
+	now := time.Now().In(loc)
+	changes := make([]CpeSyncChange, 0)
+	seen := make(map[string]bool)
+	for _, dev := range devices {
+		if dev.Sn == "" {
+			continue
+		}
+		fields := map[string]string{
+			"device_id":    dev.DeviceId,
+			"sn":           dev.Sn,
+			"model":        dev.Model,
+			"firmware":     dev.Firmware,
+			"wan_ipaddr":   dev.WanIpaddr,
+			"ppp_username": dev.PppUsername,
+			"subscriber":   "",
+			"acs_state":    CpeAcsPresent,
+			"sync_time":    now.Format(timeutil.YYYYMMDDHHMMSS_LAYOUT),
+		}
+		if !dev.LastInform.IsZero() {
+			fields["last_inform"] = dev.LastInform.In(loc).Format(timeutil.YYYYMMDDHHMMSS_LAYOUT)
+		}
+		if dev.PppUsername != "" && linker != nil {
+			fields["subscriber"] = linker(dev.PppUsername)
+		}
+		cpe, ok := byDeviceId[dev.DeviceId]
+		if !ok {
+			cpe, ok = bySn[dev.Sn]
+		}
+		history := &CpeSyncHistory{Sn: dev.Sn, DeviceId: dev.DeviceId, Changes: make([]CpeFieldChange, 0), CreateTime: now}
+		if !ok {
+			if dev.WanIpaddr != "" {
+				fields["ipaddr"] = dev.WanIpaddr
+				fields["ipaddr_source"] = CpeIpaddrSync
+			}
+			history.Action = CpeSyncAdded
+			for _, f := range cpeInventoryFields {
+				if fields[f] != "" {
+					history.Changes = append(history.Changes, CpeFieldChange{Field: f, New: fields[f]})
+				}
+			}
+			changes = append(changes, CpeSyncChange{Fields: fields, History: history})
+			continue
+		}
+		id := cpe.GetStringValue("_id", "")
+		seen[id] = true
+		// the manual address of a record is kept
+		ipaddr := cpe.GetStringValue("ipaddr", "")
+		if dev.WanIpaddr != "" && (ipaddr == "" || cpe.GetStringValue("ipaddr_source", "") == CpeIpaddrSync) {
+			fields["ipaddr"] = dev.WanIpaddr
+			fields["ipaddr_source"] = CpeIpaddrSync
+		}
+		for _, f := range cpeInventoryFields {
+			if old := cpe.GetStringValue(f, ""); old != fields[f] {
+				history.Changes = append(history.Changes, CpeFieldChange{Field: f, Old: old, New: fields[f]})
+			}
+		}
+		if len(history.Changes) > 0 {
+			history.Action = CpeSyncChanged
+		} else {
+			history = nil
+		}
+		changes = append(changes, CpeSyncChange{ID: id, Fields: fields, History: history})
+	}
+	for _, cpe := range cpes {
+		id := cpe.GetStringValue("_id", "")
+		if seen[id] || cpe.GetStringValue("device_id", "") == "" || cpe.GetStringValue("acs_state", "") == CpeAcsVanished {
+			continue
+		}
+		changes = append(changes, CpeSyncChange{
+			ID:     id,
+			Fields: map[string]string{"acs_state": CpeAcsVanished},
+			History: &CpeSyncHistory{
+				Sn:         cpe.GetStringValue("sn", ""),
+				DeviceId:   cpe.GetStringValue("device_id", ""),
+				Action:     CpeSyncVanished,
+				Changes:    []CpeFieldChange{{Field: "acs_state", Old: cpe.GetStringValue("acs_state", ""), New: CpeAcsVanished}},
+				CreateTime: now,
+			},
+		})
+	}
+	return changes
+}
+
+// markCpeIpaddrSource
+// an ipaddr written by an operator is no longer synchronized,
+// clearing it hands the address back to the synchronization
+func markCpeIpaddrSource(collname string, doc map[string]interface{}) {
+	if collname != TeamsacsCpe {
+		return
+	}
+	v, ok := doc["ipaddr"]
+	if !ok {
+		return
+	}
+	if s, _ := v.(string); s == "" {
+		doc["ipaddr_source"] = CpeIpaddrSync
+	} else {
+		doc["ipaddr_source"] = CpeIpaddrManual
+	}
+}
+
+// QueryCpeInventory
+// the inventory of all GenieACS devices
+func (m *GenieacsManager) QueryCpeInventory() ([]CpeInventory, error) {
+	result := make([]CpeInventory, 0)
+	err := m.EachDevice(bson.M{}, cpeInventoryProjection(), func(doc map[string]interface{}) error {
+		result = append(result, ParseCpeInventory(doc))
+		return nil
+	})
+	return result, err
+}
+
+// SyncCpeInventory
+// upsert the cpe records from the GenieACS devices and record the changes
+func (m *GenieacsManager) SyncCpeInventory() (*CpeSyncResult, error) {
+	devices, err := m.QueryCpeInventory()
+	if err != nil {
+		return nil, err
+	}
+	coll := m.GetTeamsAcsCollection(TeamsacsCpe)
+	cur, err := coll.Find(context.TODO(), bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	cpes := make([]Cpe, 0)
+	if err = cur.All(context.TODO(), &cpes); err != nil {
+		return nil, err
+	}
+
+	subscribers := make(map[string]string)
+	linker := func(username string) string {
+		if v, ok := subscribers[username]; ok {
+			return v
+		}
+		subscribers[username] = ""
+		if sub, err := m.GetSubscribeManager().GetSubscribeByUser(username); err == nil {
+			subscribers[username] = sub.GetStringValue("username", "")
+		}
+		return subscribers[username]
+	}
+
+	result := &CpeSyncResult{Total: len(devices)}
+	changes := DiffCpeInventory(cpes, devices, linker, m.Location)
+	models := make([]mongo.WriteModel, 0, len(changes))
+	histories := make([]interface{}, 0)
+	for _, c := range changes {
+		if c.ID == "" {
+			c.Fields["_id"] = common.UUID()
+			models = append(models, mongo.NewInsertOneModel().SetDocument(c.Fields))
+		} else {
+			models = append(models, mongo.NewUpdateOneModel().
+				SetFilter(bson.M{"_id": c.ID}).
+				SetUpdate(bson.M{"$set": c.Fields}))
+		}
+		if c.History == nil {
+			continue
+		}
+		c.History.ID = common.UUID()
+		histories = append(histories, c.History)
+		switch c.History.Action {
+		case CpeSyncAdded:
+			result.Added++
+		case CpeSyncChanged:
+			result.Changed++
+		case CpeSyncVanished:
+			result.Vanished++
+		}
+	}
+	if len(models) > 0 {
+		if _, err = coll.BulkWrite(context.TODO(), models, options.BulkWrite().SetOrdered(false)); err != nil {
+			return nil, err
+		}
+	}
+	if len(histories) > 0 {
+		if _, err = m.GetTeamsAcsCollection(TeamsacsCpeSyncHistory).InsertMany(context.TODO(), histories); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// QueryCpeSyncHistory
+func (m *GenieacsManager) QueryCpeSyncHistory(params web.RequestParams) (*web.PageResult, error) {
+	var pos = params.GetInt64WithDefval("start", 0)
+	findOptions := options.Find().
+		SetSkip(pos).
+		SetLimit(params.GetInt64WithDefval("count", 40)).
+		SetSort(bson.M{"create_time": -1})
+	var q = bson.M{}
+	querymap := params.GetParamMap("querymap")
+	for _, k := range []string{"sn", "device_id", "action"} {
+		if v := querymap.GetString(k); v != "" {
+			q[k] = v
+		}
+	}
+	coll := m.GetTeamsAcsCollection(TeamsacsCpeSyncHistory)
+	cur, err := coll.Find(context.TODO(), q, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]CpeSyncHistory, 0)
+	if err = cur.All(context.TODO(), &items); err != nil {
+		return nil, err
+	}
+	total, err := coll.CountDocuments(context.TODO(), q)
+	if err != nil {
+		return nil, err
+	}
+	return &web.PageResult{TotalCount: total, Pos: pos, Data: items}, nil
+}
+
+// RunCpeInventorySync
+// Scheduler entry
+func (m *GenieacsManager) RunCpeInventorySync() {
+	result, err := m.SyncCpeInventory()
+	if err != nil {
+		log.Errorf("sync cpe inventory error, %s", err.Error())
+		return
+	}
+	if result.Added+result.Changed+result.Vanished > 0 {
+		log.Infof("sync cpe inventory, total %d, added %d, changed %d, vanished %d",
+			result.Total, result.Added, result.Changed, result.Vanished)
+	}
+}
@@ -0,0 +1,156 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package models
+
+import (
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestParseCpeInventory(t *testing.T) {
+	inform := time.Date(2020, 11, 2, 10, 0, 0, 0, time.UTC)
+	tr181 := map[string]interface{}{
+		"_id":         "001122-RB750-CPE0001",
+		"_deviceId":   primitive.M{"_SerialNumber": "CPE0001", "_ProductClass": "RB750"},
+		"_lastInform": primitive.NewDateTimeFromTime(inform),
+		"Device": primitive.M{
+			"DeviceInfo": primitive.M{
+				"ModelName":       primitive.M{"_value": "hEX"},
+				"SoftwareVersion": primitive.M{"_value": "6.47.7"},
+			},
+			"PPP": primitive.M{"Interface": primitive.M{
+				"1": primitive.M{
+					"Username": primitive.M{"_value": "user01"},
+					"IPCP":     primitive.M{"LocalIPAddress": primitive.M{"_value": "100.64.0.10"}},
+				},
+			}},
+		},
+	}
+	inv := ParseCpeInventory(tr181)
+	if inv.DeviceId != "001122-RB750-CPE0001" || inv.Sn != "CPE0001" || inv.Model != "hEX" || inv.Firmware != "6.47.7" ||
+		inv.PppUsername != "user01" || inv.WanIpaddr != "100.64.0.10" || !inv.LastInform.Equal(inform) {
+		t.Fatalf("inventory %+v", inv)
+	}
+
+	tr098 := map[string]interface{}{
+		"_id":       "334455-HG8245-CPE0002",
+		"_deviceId": primitive.M{"_SerialNumber": "CPE0002", "_ProductClass": "HG8245"},
+		"InternetGatewayDevice": primitive.M{
+			"DeviceInfo": primitive.M{"SoftwareVersion": primitive.M{"_value": "V3R017"}},
+			"ManagementServer": primitive.M{
+				"ConnectionRequestURL": primitive.M{"_value": "http://10.0.0.2:7547/abc"},
+			},
+			"WANDevice": primitive.M{"1": primitive.M{"WANConnectionDevice": primitive.M{"1": primitive.M{
+				"WANPPPConnection": primitive.M{"1": primitive.M{
+					"Username":          primitive.M{"_value": "user02"},
+					"ExternalIPAddress": primitive.M{"_value": "0.0.0.0"},
+				}},
+			}}}},
+		},
+	}
+	inv = ParseCpeInventory(tr098)
+	if inv.Sn != "CPE0002" || inv.Model != "HG8245" || inv.Firmware != "V3R017" ||
+		inv.PppUsername != "user02" || inv.WanIpaddr != "10.0.0.2" {
+		t.Fatalf("inventory %+v", inv)
+	}
+
+	projection := cpeInventoryProjection()
+	if projection["Device.PPP.Interface"] != 1 || projection["InternetGatewayDevice.WANDevice"] != 1 {
+		t.Fatalf("projection %v", projection)
+	}
+}
+
+func TestDiffCpeInventory(t *testing.T) {
+	cpes := []Cpe{
+		{"_id": "c1", "sn": "CPE0001", "device_id": "d1", "model": "hEX", "firmware": "6.46", "ipaddr": "10.1.1.1",
+			"wan_ipaddr": "100.64.0.10", "ppp_username": "user01", "subscriber": "user01", "acs_state": CpeAcsPresent},
+		{"_id": "c2", "sn": "CPE0002", "name": "manual record"},
+		{"_id": "c3", "sn": "CPE0003", "device_id": "d3", "acs_state": CpeAcsPresent},
+		{"_id": "c4", "sn": "CPE0004", "device_id": "d4", "acs_state": CpeAcsVanished},
+	}
+	devices := []CpeInventory{
+		{DeviceId: "d1", Sn: "CPE0001", Model: "hEX", Firmware: "6.47.7", WanIpaddr: "100.64.0.10", PppUsername: "user01"},
+		{DeviceId: "d2", Sn: "CPE0002", Model: "hAP", PppUsername: "nouser"},
+		{DeviceId: "d5", Sn: "CPE0005", Model: "hAP", WanIpaddr: "100.64.0.15", PppUsername: "user05"},
+	}
+	linker := func(username string) string {
+		if username == "nouser" {
+			return ""
+		}
+		return username
+	}
+	changes := DiffCpeInventory(cpes, devices, linker, time.UTC)
+	if len(changes) != 4 {
+		t.Fatalf("changes %d", len(changes))
+	}
+	// firmware upgrade, the manual ipaddr is kept
+	c := changes[0]
+	if c.ID != "c1" || c.History.Action != CpeSyncChanged || len(c.History.Changes) != 1 ||
+		c.History.Changes[0].Field != "firmware" || c.Fields["ipaddr"] != "" {
+		t.Fatalf("change %+v %+v", c, c.History)
+	}
+	// manual record matched by sn
+	c = changes[1]
+	if c.ID != "c2" || c.History.Action != CpeSyncChanged || c.Fields["device_id"] != "d2" || c.Fields["subscriber"] != "" {
+		t.Fatalf("change %+v %+v", c, c.History)
+	}
+	c = changes[2]
+	if c.ID != "" || c.History.Action != CpeSyncAdded || c.Fields["subscriber"] != "user05" || c.Fields["acs_state"] != CpeAcsPresent ||
+		c.Fields["ipaddr"] != "100.64.0.15" || c.Fields["ipaddr_source"] != CpeIpaddrSync {
+		t.Fatalf("change %+v %+v", c, c.History)
+	}
+	c = changes[3]
+	if c.ID != "c3" || c.History.Action != CpeSyncVanished || c.Fields["acs_state"] != CpeAcsVanished {
+		t.Fatalf("change %+v %+v", c, c.History)
+	}
+
+	// unchanged devices update the record without history
+	devices[0].Firmware = "6.46"
+	changes = DiffCpeInventory(cpes[:1], devices[:1], linker, time.UTC)
+	if len(changes) != 1 || changes[0].History != nil {
+		t.Fatalf("changes %+v", changes)
+	}
+
+	// a synchronized ipaddr follows the WAN address
+	cpes[0]["ipaddr"] = "100.64.0.10"
+	cpes[0]["ipaddr_source"] = CpeIpaddrSync
+	devices[0].WanIpaddr = "100.64.0.11"
+	changes = DiffCpeInventory(cpes[:1], devices[:1], linker, time.UTC)
+	if len(changes) != 1 || changes[0].Fields["ipaddr"] != "100.64.0.11" || changes[0].Fields["ipaddr_source"] != CpeIpaddrSync {
+		t.Fatalf("changes %+v", changes)
+	}
+}
+
+func TestMarkCpeIpaddrSource(t *testing.T) {
+	doc := map[string]interface{}{"ipaddr": "10.1.1.1"}
+	markCpeIpaddrSource(TeamsacsCpe, doc)
+	if doc["ipaddr_source"] != CpeIpaddrManual {
+		t.Fatal(doc)
+	}
+	doc = map[string]interface{}{"ipaddr": ""}
+	markCpeIpaddrSource(TeamsacsCpe, doc)
+	if doc["ipaddr_source"] != CpeIpaddrSync {
+		t.Fatal(doc)
+	}
+	doc = map[string]interface{}{"ipaddr": "10.1.1.1"}
+	markCpeIpaddrSource(TeamsacsVpe, doc)
+	if _, ok := doc["ipaddr_source"]; ok {
+		t.Fatal(doc)
+	}
+}
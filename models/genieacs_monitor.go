@@ -0,0 +1,410 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package models
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/ca17/teamsacs/common"
+	"github.com/ca17/teamsacs/common/log"
+	"github.com/ca17/teamsacs/common/web"
+	"github.com/ca17/teamsacs/config"
+)
+
+const (
+	CpeStateOnline   = "online"
+	CpeStateLate     = "late"
+	CpeStateOffline  = "offline"
+	CpeStateFlapping = "flapping"
+	// the device is deleted from GenieACS, only in the status events
+	CpeStateRemoved = "removed"
+)
+
+var cpeInformIntervalPaths = []string{
+	"Device.ManagementServer.PeriodicInformInterval",
+	"InternetGatewayDevice.ManagementServer.PeriodicInformInterval",
+}
+
+// CpeStatus
+// The monitored state of a GenieACS device, ID is the device id
+type CpeStatus struct {
+	ID             string      `bson:"_id" json:"id"`
+	Sn             string      `bson:"sn" json:"sn"`
+	Model          string      `bson:"model" json:"model"`
+	Firmware       string      `bson:"firmware" json:"firmware"`
+	State          string      `bson:"state" json:"state"`
+	Since          time.Time   `bson:"since" json:"since"`
+	LastInform     time.Time   `bson:"last_inform" json:"last_inform"`
+	InformInterval int         `bson:"inform_interval" json:"inform_interval"`
+	AlertState     string      `bson:"alert_state" json:"alert_state"`
+	Transitions    []time.Time `bson:"transitions" json:"transitions"`
+	Flapping       bool        `bson:"flapping" json:"flapping"`
+	UpdateTime     time.Time   `bson:"update_time" json:"update_time"`
+}
+
+// CpeStatusEvent
+// A state transition of a device
+type CpeStatusEvent struct {
+	ID         string    `bson:"_id,omitempty" json:"id,omitempty"`
+	DeviceId   string    `bson:"device_id" json:"device_id"`
+	Sn         string    `bson:"sn" json:"sn"`
+	From       string    `bson:"from" json:"from"`
+	To         string    `bson:"to" json:"to"`
+	LastInform time.Time `bson:"last_inform" json:"last_inform"`
+	Timestamp  time.Time `bson:"timestamp" json:"timestamp"`
+}
+
+// CpeAlert
+// The payload of the email and webhook alerts
+type CpeAlert struct {
+	DeviceId   string    `json:"device_id"`
+	Sn         string    `json:"sn"`
+	Model      string    `json:"model"`
+	Firmware   string    `json:"firmware"`
+	State      string    `json:"state"`
+	Since      time.Time `json:"since"`
+	LastInform time.Time `json:"last_inform"`
+}
+
+// CpeHealth
+// The device states of a model and firmware
+type CpeHealth struct {
+	Model    string `bson:"model" json:"model,omitempty"`
+	Firmware string `bson:"firmware" json:"firmware,omitempty"`
+	Total    int64  `bson:"total" json:"total"`
+	Online   int64  `bson:"online" json:"online"`
+	Late     int64  `bson:"late" json:"late"`
+	Offline  int64  `bson:"offline" json:"offline"`
+	Flapping int64  `bson:"flapping" json:"flapping"`
+}
+
+// EvaluateCpeState
+// online within LateFactor inform intervals since the last inform,
+// late within OfflineFactor intervals, offline after that
+func EvaluateCpeState(cfg config.CpeMonitorConfig, lastInform time.Time, interval int, now time.Time) string {
+	if lastInform.IsZero() {
+		return CpeStateOffline
+	}
+	if interval <= 0 {
+		interval = cfg.InformInterval
+	}
+	if interval <= 0 {
+		interval = 300
+	}
+	late, offline := cfg.LateFactor, cfg.OfflineFactor
+	if late <= 0 {
+		late = 1.5
+	}
+	if offline < late {
+		offline = late * 2
+	}
+	elapsed := now.Sub(lastInform).Seconds()
+	switch {
+	case elapsed <= float64(interval)*late:
+		return CpeStateOnline
+	case elapsed <= float64(interval)*offline:
+		return CpeStateLate
+	default:
+		return CpeStateOffline
+	}
+}
+
+// UpdateCpeStatus
+// apply the evaluated state, returns the transition event and the alert if any.
+// Transitions from or to offline within FlapWindow minutes count as flaps, a
+// flapping device is alerted once and then silent until it is stable for a window.
+// Offline is alerted after it holds for HoldDown minutes, online only after an offline alert.
+func UpdateCpeStatus(cfg config.CpeMonitorConfig, status *CpeStatus, state string, now time.Time) (*CpeStatusEvent, *CpeAlert) {
+	var event *CpeStatusEvent
+	if status.State != state {
+		event = &CpeStatusEvent{
+			DeviceId:   status.ID,
+			Sn:         status.Sn,
+			From:       status.State,
+			To:         state,
+			LastInform: status.LastInform,
+			Timestamp:  now,
+		}
+		if status.State == CpeStateOffline || state == CpeStateOffline {
+			status.Transitions = append(status.Transitions, now)
+		}
+		status.State = state
+		status.Since = now
+	}
+
+	window := time.Duration(cfg.FlapWindow) * time.Minute
+	transitions := make([]time.Time, 0, len(status.Transitions))
+	for _, t := range status.Transitions {
+		if now.Sub(t) < window {
+			transitions = append(transitions, t)
+		}
+	}
+	status.Transitions = transitions
+
+	alert := func(state string) *CpeAlert {
+		status.AlertState = state
+		return &CpeAlert{
+			DeviceId:   status.ID,
+			Sn:         status.Sn,
+			Model:      status.Model,
+			Firmware:   status.Firmware,
+			State:      state,
+			Since:      status.Since,
+			LastInform: status.LastInform,
+		}
+	}
+
+	if cfg.FlapThreshold > 0 && len(transitions) >= cfg.FlapThreshold {
+		if !status.Flapping {
+			status.Flapping = true
+			return event, alert(CpeStateFlapping)
+		}
+		return event, nil
+	}
+	if status.Flapping {
+		if len(transitions) > 0 {
+			return event, nil
+		}
+		status.Flapping = false
+	}
+
+	switch state {
+	case CpeStateOffline:
+		holdDown := time.Duration(cfg.HoldDown) * time.Minute
+		if status.AlertState != CpeStateOffline && now.Sub(status.Since) >= holdDown {
+			return event, alert(CpeStateOffline)
+		}
+	case CpeStateOnline:
+		if status.AlertState == CpeStateOffline || status.AlertState == CpeStateFlapping {
+			return event, alert(CpeStateOnline)
+		}
+		status.AlertState = CpeStateOnline
+	}
+	return event, nil
+}
+
+// SetupCpeMonitorDB
+func (m *ModelManager) SetupCpeMonitorDB() {
+	_, err := m.GetTeamsAcsCollection(TeamsacsCpeStatusEvent).Indexes().CreateMany(context.TODO(), []mongo.IndexModel{
+		{Keys: bson.D{{"sn", 1}, {"timestamp", -1}}},
+		{Keys: bson.D{{"timestamp", -1}}},
+	})
+	if err != nil {
+		log.Errorf("create cpe status event indexes error, %s", err.Error())
+	}
+}
+
+// CheckCpeStatus
+// evaluate all GenieACS devices, save the state changes and send the alerts
+func (m *GenieacsManager) CheckCpeStatus() ([]CpeAlert, error) {
+	cfg := m.Config.CpeMonitor
+	coll := m.GetTeamsAcsCollection(TeamsacsCpeStatus)
+	cur, err := coll.Find(context.TODO(), bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	items := make([]CpeStatus, 0)
+	if err = cur.All(context.TODO(), &items); err != nil {
+		return nil, err
+	}
+	statuses := make(map[string]*CpeStatus, len(items))
+	for i := range items {
+		statuses[items[i].ID] = &items[i]
+	}
+
+	projection := cpeInventoryProjection()
+	for k := range paramProjection(cpeInformIntervalPaths) {
+		projection[k] = 1
+	}
+
+	now := time.Now()
+	writes := make([]mongo.WriteModel, 0)
+	events := make([]interface{}, 0)
+	alerts := make([]CpeAlert, 0)
+	seen := make(map[string]bool, len(items))
+	err = m.EachDevice(bson.M{}, projection, func(doc map[string]interface{}) error {
+		inv := ParseCpeInventory(doc)
+		if inv.DeviceId == "" {
+			return nil
+		}
+		seen[inv.DeviceId] = true
+		interval, _ := strconv.Atoi(firstParamValue(FlattenDeviceParams(doc, ""), cpeInformIntervalPaths))
+		state := EvaluateCpeState(cfg, inv.LastInform, interval, now)
+		status, ok := statuses[inv.DeviceId]
+		if !ok {
+			// the first state of a device is not alerted
+			status = &CpeStatus{ID: inv.DeviceId, State: state, Since: now, AlertState: state, Transitions: []time.Time{}}
+		}
+		changed := !ok || !status.LastInform.Equal(inv.LastInform) || status.Model != inv.Model ||
+			status.Firmware != inv.Firmware || status.InformInterval != interval
+		status.Sn, status.Model, status.Firmware = inv.Sn, inv.Model, inv.Firmware
+		status.LastInform, status.InformInterval = inv.LastInform, interval
+
+		var transitions = len(status.Transitions)
+		alertState, flapping := status.AlertState, status.Flapping
+		event, alert := UpdateCpeStatus(cfg, status, state, now)
+		if event != nil {
+			event.ID = common.UUID()
+			event.LastInform = inv.LastInform
+			events = append(events, event)
+		}
+		if alert != nil {
+			alerts = append(alerts, *alert)
+		}
+		if changed || event != nil || alert != nil || transitions != len(status.Transitions) ||
+			alertState != status.AlertState || flapping != status.Flapping {
+			status.UpdateTime = now
+			writes = append(writes, mongo.NewReplaceOneModel().
+				SetFilter(bson.M{"_id": status.ID}).
+				SetReplacement(status).
+				SetUpsert(true))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// the devices deleted from GenieACS are no longer monitored
+	for id, status := range statuses {
+		if seen[id] {
+			continue
+		}
+		writes = append(writes, mongo.NewDeleteOneModel().SetFilter(bson.M{"_id": id}))
+		events = append(events, &CpeStatusEvent{
+			ID:         common.UUID(),
+			DeviceId:   id,
+			Sn:         status.Sn,
+			From:       status.State,
+			To:         CpeStateRemoved,
+			LastInform: status.LastInform,
+			Timestamp:  now,
+		})
+	}
+	if len(writes) > 0 {
+		if _, err = coll.BulkWrite(context.TODO(), writes, options.BulkWrite().SetOrdered(false)); err != nil {
+			return nil, err
+		}
+	}
+	if len(events) > 0 {
+		if _, err = m.GetTeamsAcsCollection(TeamsacsCpeStatusEvent).InsertMany(context.TODO(), events); err != nil {
+			return nil, err
+		}
+	}
+	for i := range alerts {
+		m.sendCpeAlert(&alerts[i])
+	}
+	return alerts, nil
+}
+
+// sendCpeAlert
+// publish the alert event for the webhooks and mail the alert receivers
+func (m *GenieacsManager) sendCpeAlert(alert *CpeAlert) {
+	cfg := m.Config.CpeMonitor
+	if cfg.AlertWebhook && m.Events != nil {
+		event := NewEvent(EventCpeStatus, "", "")
+		event.Data = alert
+		m.Events.Publish(event)
+	}
+	if cfg.AlertEmail {
+		if m.MailSender == nil {
+			log.Error("cpe alert mail server not configured")
+			return
+		}
+		subject := fmt.Sprintf("CPE %s is %s", alert.Sn, alert.State)
+		// the device reported values are escaped
+		body := fmt.Sprintf("<p>CPE %s (%s %s, device %s) is %s since %s, last inform %s.</p>",
+			html.EscapeString(alert.Sn), html.EscapeString(alert.Model), html.EscapeString(alert.Firmware),
+			html.EscapeString(alert.DeviceId), alert.State,
+			alert.Since.In(m.Location).Format(time.RFC3339), alert.LastInform.In(m.Location).Format(time.RFC3339))
+		if err := m.MailSender.SendMail(cfg.Mailtos, subject, body, nil); err != nil {
+			log.Errorf("send cpe alert mail error, %s", err.Error())
+		}
+	}
+}
+
+// QueryCpeStatus
+func (m *GenieacsManager) QueryCpeStatus(params web.RequestParams) (*web.PageResult, error) {
+	return m.QueryPagerItems(params, TeamsacsCpeStatus)
+}
+
+// QueryCpeStatusEvents
+func (m *GenieacsManager) QueryCpeStatusEvents(params web.RequestParams) (*web.PageResult, error) {
+	return m.QueryPagerItems(params, TeamsacsCpeStatusEvent)
+}
+
+// QueryCpeHealth
+// the device states grouped by model, firmware or both
+func (m *GenieacsManager) QueryCpeHealth(by string) ([]CpeHealth, error) {
+	group := bson.M{}
+	switch by {
+	case "model":
+		group["model"] = "$model"
+	case "firmware":
+		group["firmware"] = "$firmware"
+	case "", "model_firmware":
+		group["model"] = "$model"
+		group["firmware"] = "$firmware"
+	default:
+		return nil, fmt.Errorf("unsupported group %s", by)
+	}
+	count := func(field string, value interface{}) bson.M {
+		return bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{field, value}}, 1, 0}}}
+	}
+	pipeline := mongo.Pipeline{
+		{{"$group", bson.M{
+			"_id":      group,
+			"total":    bson.M{"$sum": 1},
+			"online":   count("$state", CpeStateOnline),
+			"late":     count("$state", CpeStateLate),
+			"offline":  count("$state", CpeStateOffline),
+			"flapping": count("$flapping", true),
+		}}},
+		{{"$project", bson.M{
+			"_id": 0, "model": "$_id.model", "firmware": "$_id.firmware",
+			"total": 1, "online": 1, "late": 1, "offline": 1, "flapping": 1,
+		}}},
+		{{"$sort", bson.D{{"model", 1}, {"firmware", 1}}}},
+	}
+	cur, err := m.GetTeamsAcsCollection(TeamsacsCpeStatus).Aggregate(context.TODO(), pipeline)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]CpeHealth, 0)
+	err = cur.All(context.TODO(), &items)
+	return items, err
+}
+
+// RunCpeMonitor
+// Scheduler entry
+func (m *GenieacsManager) RunCpeMonitor() {
+	alerts, err := m.CheckCpeStatus()
+	if err != nil {
+		log.Errorf("check cpe status error, %s", err.Error())
+		return
+	}
+	for _, a := range alerts {
+		log.Infof("cpe %s %s", a.Sn, a.State)
+	}
+}
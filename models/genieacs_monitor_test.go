@@ -0,0 +1,112 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ca17/teamsacs/config"
+)
+
+var testMonitorConfig = config.CpeMonitorConfig{
+	InformInterval: 300,
+	LateFactor:     1.5,
+	OfflineFactor:  3,
+	HoldDown:       5,
+	FlapWindow:     60,
+	FlapThreshold:  4,
+}
+
+func TestEvaluateCpeState(t *testing.T) {
+	now := time.Now()
+	var cases = []struct {
+		elapsed  time.Duration
+		interval int
+		state    string
+	}{
+		{time.Minute, 0, CpeStateOnline},
+		{7 * time.Minute, 0, CpeStateOnline},
+		{8 * time.Minute, 0, CpeStateLate},
+		{16 * time.Minute, 0, CpeStateOffline},
+		{16 * time.Minute, 3600, CpeStateOnline},
+		{2 * time.Hour, 3600, CpeStateLate},
+	}
+	for _, c := range cases {
+		if s := EvaluateCpeState(testMonitorConfig, now.Add(-c.elapsed), c.interval, now); s != c.state {
+			t.Errorf("elapsed %s interval %d, %s != %s", c.elapsed, c.interval, s, c.state)
+		}
+	}
+	if EvaluateCpeState(testMonitorConfig, time.Time{}, 0, now) != CpeStateOffline {
+		t.Fatal("never informed device is not offline")
+	}
+}
+
+func TestUpdateCpeStatus(t *testing.T) {
+	now := time.Now()
+	status := &CpeStatus{ID: "d1", Sn: "CPE0001", State: CpeStateOnline, AlertState: CpeStateOnline}
+
+	// late is recorded but not alerted
+	event, alert := UpdateCpeStatus(testMonitorConfig, status, CpeStateLate, now)
+	if event == nil || event.From != CpeStateOnline || event.To != CpeStateLate || alert != nil {
+		t.Fatalf("event %+v alert %+v", event, alert)
+	}
+
+	// offline is alerted after the hold down
+	event, alert = UpdateCpeStatus(testMonitorConfig, status, CpeStateOffline, now.Add(time.Minute))
+	if event == nil || alert != nil {
+		t.Fatalf("event %+v alert %+v", event, alert)
+	}
+	event, alert = UpdateCpeStatus(testMonitorConfig, status, CpeStateOffline, now.Add(7*time.Minute))
+	if event != nil || alert == nil || alert.State != CpeStateOffline {
+		t.Fatalf("event %+v alert %+v", event, alert)
+	}
+	_, alert = UpdateCpeStatus(testMonitorConfig, status, CpeStateOffline, now.Add(8*time.Minute))
+	if alert != nil {
+		t.Fatalf("repeat alert %+v", alert)
+	}
+
+	// recovery
+	event, alert = UpdateCpeStatus(testMonitorConfig, status, CpeStateOnline, now.Add(9*time.Minute))
+	if event == nil || alert == nil || alert.State != CpeStateOnline {
+		t.Fatalf("event %+v alert %+v", event, alert)
+	}
+
+	// the fourth transition in the window is a flap, alerted once
+	if _, alert = UpdateCpeStatus(testMonitorConfig, status, CpeStateOffline, now.Add(10*time.Minute)); alert != nil {
+		t.Fatalf("alert %+v", alert)
+	}
+	_, alert = UpdateCpeStatus(testMonitorConfig, status, CpeStateOnline, now.Add(11*time.Minute))
+	if alert == nil || alert.State != CpeStateFlapping || !status.Flapping {
+		t.Fatalf("alert %+v status %+v", alert, status)
+	}
+	for i := 12; i < 20; i++ {
+		state := CpeStateOnline
+		if i%2 == 0 {
+			state = CpeStateOffline
+		}
+		if _, alert = UpdateCpeStatus(testMonitorConfig, status, state, now.Add(time.Duration(i)*time.Minute)); alert != nil {
+			t.Fatalf("flapping alert %+v", alert)
+		}
+	}
+
+	// stable for a window, the current state is alerted
+	_, alert = UpdateCpeStatus(testMonitorConfig, status, CpeStateOnline, now.Add(80*time.Minute))
+	if alert == nil || alert.State != CpeStateOnline || status.Flapping {
+		t.Fatalf("alert %+v status %+v", alert, status)
+	}
+}
@@ -0,0 +1,343 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package models
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/ca17/teamsacs/common"
+	"github.com/ca17/teamsacs/common/web"
+)
+
+const (
+	ParamAdded   = "added"
+	ParamRemoved = "removed"
+	ParamChanged = "changed"
+)
+
+// DeviceParam
+// A leaf parameter of the GenieACS device document
+type DeviceParam struct {
+	Path      string      `bson:"path" json:"path"`
+	Value     interface{} `bson:"_value" json:"_value"`
+	Type      string      `bson:"_type,omitempty" json:"_type,omitempty"`
+	Timestamp time.Time   `bson:"_timestamp,omitempty" json:"_timestamp,omitempty"`
+	Writable  bool        `bson:"_writable" json:"_writable"`
+}
+
+// DeviceParamDiff
+type DeviceParamDiff struct {
+	Path string      `json:"path"`
+	Op   string      `json:"op"`
+	Old  interface{} `json:"old,omitempty"`
+	New  interface{} `json:"new,omitempty"`
+}
+
+// DeviceParamSnapshot
+// The parameters of a device at a time
+type DeviceParamSnapshot struct {
+	ID         string        `bson:"_id,omitempty" json:"id,omitempty"`
+	Sn         string        `bson:"sn" json:"sn"`
+	DeviceId   string        `bson:"device_id" json:"device_id"`
+	Paths      []string      `bson:"paths" json:"paths"`
+	Params     []DeviceParam `bson:"params" json:"params,omitempty"`
+	Remark     string        `bson:"remark" json:"remark"`
+	Operator   string        `bson:"operator" json:"operator"`
+	CreateTime time.Time     `bson:"create_time" json:"create_time"`
+}
+
+// asMap
+// the nested documents are decoded as primitive.M or primitive.D
+func asMap(v interface{}) (map[string]interface{}, bool) {
+	switch m := v.(type) {
+	case map[string]interface{}:
+		return m, true
+	case primitive.M:
+		return m, true
+	case primitive.D:
+		return m.Map(), true
+	default:
+		return nil, false
+	}
+}
+
+func paramValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case primitive.DateTime:
+		return t.Time()
+	case primitive.A:
+		// [value, type] of the GenieACS 1.2 documents
+		if len(t) > 0 {
+			return paramValue(t[0])
+		}
+		return nil
+	default:
+		return v
+	}
+}
+
+// FlattenDeviceParams
+// walk the device document, the nodes with _value or without children
+// except the metadata are parameters, the others are objects
+func FlattenDeviceParams(doc map[string]interface{}, prefix string) []DeviceParam {
+	params := make([]DeviceParam, 0)
+	flattenParams(doc, prefix, &params)
+	sort.Slice(params, func(i, j int) bool { return params[i].Path < params[j].Path })
+	return params
+}
+
+func flattenParams(node map[string]interface{}, path string, params *[]DeviceParam) {
+	children := 0
+	for k, v := range node {
+		if strings.HasPrefix(k, "_") {
+			continue
+		}
+		child, ok := asMap(v)
+		if !ok {
+			continue
+		}
+		children++
+		p := k
+		if path != "" {
+			p = path + "." + k
+		}
+		flattenParams(child, p, params)
+	}
+	_, hasValue := node["_value"]
+	isObject, _ := node["_object"].(bool)
+	if path == "" || (!hasValue && (children > 0 || isObject)) {
+		return
+	}
+	param := DeviceParam{Path: path, Value: paramValue(node["_value"])}
+	param.Type, _ = node["_type"].(string)
+	param.Writable, _ = node["_writable"].(bool)
+	if ts, ok := node["_timestamp"].(primitive.DateTime); ok {
+		param.Timestamp = ts.Time()
+	}
+	if arr, ok := node["_value"].(primitive.A); ok && len(arr) > 1 && param.Type == "" {
+		param.Type, _ = arr[1].(string)
+	}
+	*params = append(*params, param)
+}
+
+// MatchParamPath
+// the segments of the pattern match the leading segments of the path,
+// * matches any one segment, a trailing dot is ignored
+func MatchParamPath(pattern, path string) bool {
+	pattern = strings.TrimSuffix(pattern, ".")
+	if pattern == "" || pattern == "*" {
+		return true
+	}
+	ps := strings.Split(pattern, ".")
+	segs := strings.Split(path, ".")
+	if len(ps) > len(segs) {
+		return false
+	}
+	for i, p := range ps {
+		if p != "*" && p != segs[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// FilterDeviceParams
+func FilterDeviceParams(params []DeviceParam, patterns []string) []DeviceParam {
+	if len(patterns) == 0 {
+		return params
+	}
+	result := make([]DeviceParam, 0)
+	for _, p := range params {
+		for _, pattern := range patterns {
+			if MatchParamPath(pattern, p.Path) {
+				result = append(result, p)
+				break
+			}
+		}
+	}
+	return result
+}
+
+// paramProjection
+// the fixed prefix of the patterns before the first wildcard
+func paramProjection(patterns []string) bson.M {
+	projection := bson.M{"_id": 1}
+	for _, pattern := range patterns {
+		segs := strings.Split(strings.TrimSuffix(pattern, "."), ".")
+		fixed := make([]string, 0, len(segs))
+		for _, s := range segs {
+			if s == "*" {
+				break
+			}
+			fixed = append(fixed, s)
+		}
+		if len(fixed) == 0 {
+			return nil
+		}
+		projection[strings.Join(fixed, ".")] = 1
+	}
+	return projection
+}
+
+// DiffDeviceParams
+// the changes from the old parameters to the new
+func DiffDeviceParams(old, new []DeviceParam) []DeviceParamDiff {
+	oldMap := make(map[string]DeviceParam, len(old))
+	for _, p := range old {
+		oldMap[p.Path] = p
+	}
+	diffs := make([]DeviceParamDiff, 0)
+	seen := make(map[string]bool, len(new))
+	for _, p := range new {
+		seen[p.Path] = true
+		o, ok := oldMap[p.Path]
+		if !ok {
+			diffs = append(diffs, DeviceParamDiff{Path: p.Path, Op: ParamAdded, New: p.Value})
+		} else if o.Type != p.Type || fmt.Sprint(o.Value) != fmt.Sprint(p.Value) {
+			diffs = append(diffs, DeviceParamDiff{Path: p.Path, Op: ParamChanged, Old: o.Value, New: p.Value})
+		}
+	}
+	for _, p := range old {
+		if !seen[p.Path] {
+			diffs = append(diffs, DeviceParamDiff{Path: p.Path, Op: ParamRemoved, Old: p.Value})
+		}
+	}
+	sort.Slice(diffs, func(i, j int) bool { return diffs[i].Path < diffs[j].Path })
+	return diffs
+}
+
+// getDeviceQuery
+// the GenieACS device of the CPE device_id, or by the serial number
+// of the device id for any vendor and data model
+func (m *GenieacsManager) getDeviceQuery(sn string) bson.M {
+	if cpe, err := m.GetCpeManager().GetCpeBySn(sn); err == nil {
+		if deviceId := cpe.GetStringValue("device_id", ""); deviceId != "" {
+			return bson.M{"_id": deviceId}
+		}
+	}
+	return bson.M{"_deviceId._SerialNumber": sn}
+}
+
+// QueryDeviceParams
+// the flattened parameters of the paths, e.g. Device.WiFi.SSID.*.SSID,
+// all parameters when paths is empty
+func (m *GenieacsManager) QueryDeviceParams(sn string, paths []string) (string, []DeviceParam, error) {
+	if common.IsEmptyOrNA(sn) {
+		return "", nil, fmt.Errorf("sn is empty")
+	}
+	findOptions := options.FindOne()
+	if projection := paramProjection(paths); projection != nil && len(paths) > 0 {
+		findOptions.SetProjection(projection)
+	}
+	var doc map[string]interface{}
+	err := m.GetGenieAcsCollection(GenieacsDevices).FindOne(context.TODO(), m.getDeviceQuery(sn), findOptions).Decode(&doc)
+	if err != nil {
+		return "", nil, fmt.Errorf("device %s not found", sn)
+	}
+	deviceId, _ := doc["_id"].(string)
+	return deviceId, FilterDeviceParams(FlattenDeviceParams(doc, ""), paths), nil
+}
+
+// AddDeviceParamSnapshot
+// save the current parameters of the paths
+func (m *GenieacsManager) AddDeviceParamSnapshot(sn string, paths []string, remark, operator string) (*DeviceParamSnapshot, error) {
+	deviceId, params, err := m.QueryDeviceParams(sn, paths)
+	if err != nil {
+		return nil, err
+	}
+	if paths == nil {
+		paths = []string{}
+	}
+	snapshot := &DeviceParamSnapshot{
+		ID:         common.UUID(),
+		Sn:         sn,
+		DeviceId:   deviceId,
+		Paths:      paths,
+		Params:     params,
+		Remark:     remark,
+		Operator:   operator,
+		CreateTime: time.Now(),
+	}
+	_, err = m.GetTeamsAcsCollection(TeamsacsCpeParamSnapshot).InsertOne(context.TODO(), snapshot)
+	return snapshot, err
+}
+
+// QueryDeviceParamSnapshots
+// the snapshots of a device without the parameters
+func (m *GenieacsManager) QueryDeviceParamSnapshots(sn string, params web.RequestParams) (*web.PageResult, error) {
+	var pos = params.GetInt64WithDefval("start", 0)
+	findOptions := options.Find().
+		SetSkip(pos).
+		SetLimit(params.GetInt64WithDefval("count", 40)).
+		SetSort(bson.M{"create_time": -1}).
+		SetProjection(bson.M{"params": 0})
+	coll := m.GetTeamsAcsCollection(TeamsacsCpeParamSnapshot)
+	cur, err := coll.Find(context.TODO(), bson.M{"sn": sn}, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]DeviceParamSnapshot, 0)
+	if err = cur.All(context.TODO(), &items); err != nil {
+		return nil, err
+	}
+	total, err := coll.CountDocuments(context.TODO(), bson.M{"sn": sn})
+	if err != nil {
+		return nil, err
+	}
+	return &web.PageResult{TotalCount: total, Pos: pos, Data: items}, nil
+}
+
+// GetDeviceParamSnapshot
+func (m *GenieacsManager) GetDeviceParamSnapshot(sn, id string) (*DeviceParamSnapshot, error) {
+	var item = new(DeviceParamSnapshot)
+	err := m.GetTeamsAcsCollection(TeamsacsCpeParamSnapshot).FindOne(context.TODO(), bson.M{"_id": id, "sn": sn}).Decode(item)
+	if err != nil {
+		return nil, fmt.Errorf("snapshot %s not found", id)
+	}
+	return item, nil
+}
+
+// DiffDeviceParamSnapshot
+// compare the snapshot with another snapshot, or the current
+// parameters of the snapshot paths when to is empty
+func (m *GenieacsManager) DiffDeviceParamSnapshot(sn, from, to string) ([]DeviceParamDiff, error) {
+	old, err := m.GetDeviceParamSnapshot(sn, from)
+	if err != nil {
+		return nil, err
+	}
+	var params []DeviceParam
+	if to == "" {
+		if _, params, err = m.QueryDeviceParams(sn, old.Paths); err != nil {
+			return nil, err
+		}
+	} else {
+		snapshot, err := m.GetDeviceParamSnapshot(sn, to)
+		if err != nil {
+			return nil, err
+		}
+		params = snapshot.Params
+	}
+	return DiffDeviceParams(old.Params, params), nil
+}
@@ -0,0 +1,143 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package models
+
+import (
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func testDeviceDoc() map[string]interface{} {
+	ts := primitive.NewDateTimeFromTime(time.Date(2020, 11, 1, 8, 0, 0, 0, time.UTC))
+	return map[string]interface{}{
+		"_id":       "001122-Router-CPE0001",
+		"_deviceId": primitive.M{"_SerialNumber": "CPE0001"},
+		"Device": primitive.M{
+			"_object": false,
+			"DeviceInfo": primitive.D{
+				{Key: "_object", Value: false},
+				{Key: "SoftwareVersion", Value: primitive.M{"_value": "6.47.7", "_type": "xsd:string", "_timestamp": ts, "_writable": false}},
+			},
+			"WiFi": map[string]interface{}{
+				"SSID": primitive.M{
+					"_object":   true,
+					"_writable": true,
+					"1": primitive.M{
+						"_object": true,
+						"SSID":    primitive.M{"_value": "home", "_type": "xsd:string", "_timestamp": ts, "_writable": true},
+						"Enable":  primitive.M{"_value": true, "_type": "xsd:boolean", "_writable": true},
+					},
+					"2": primitive.M{
+						"_object": true,
+						"SSID":    primitive.M{"_value": "guest", "_type": "xsd:string", "_writable": true},
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestFlattenDeviceParams(t *testing.T) {
+	params := FlattenDeviceParams(testDeviceDoc(), "")
+	var paths []string
+	for _, p := range params {
+		paths = append(paths, p.Path)
+	}
+	expect := []string{
+		"Device.DeviceInfo.SoftwareVersion",
+		"Device.WiFi.SSID.1.Enable",
+		"Device.WiFi.SSID.1.SSID",
+		"Device.WiFi.SSID.2.SSID",
+	}
+	if len(paths) != len(expect) {
+		t.Fatalf("paths %v", paths)
+	}
+	for i := range expect {
+		if paths[i] != expect[i] {
+			t.Fatalf("paths %v", paths)
+		}
+	}
+	if params[0].Value != "6.47.7" || params[0].Type != "xsd:string" || params[0].Writable || params[0].Timestamp.Year() != 2020 {
+		t.Fatalf("param %+v", params[0])
+	}
+	if params[1].Value != true || !params[1].Writable {
+		t.Fatalf("param %+v", params[1])
+	}
+}
+
+func TestMatchParamPath(t *testing.T) {
+	var cases = []struct {
+		pattern string
+		path    string
+		match   bool
+	}{
+		{"", "Device.WiFi.SSID.1.SSID", true},
+		{"Device.WiFi.", "Device.WiFi.SSID.1.SSID", true},
+		{"Device.WiFi.*", "Device.WiFi.SSID.1.SSID", true},
+		{"Device.WiFi.SSID.*.SSID", "Device.WiFi.SSID.2.SSID", true},
+		{"Device.WiFi.SSID.*.SSID", "Device.WiFi.SSID.1.Enable", false},
+		{"Device.WiFi", "Device.WiFiExtra.Enable", false},
+		{"InternetGatewayDevice.WANDevice.*", "Device.WiFi.SSID.1.SSID", false},
+		{"Device.WiFi.SSID.1.SSID.Name", "Device.WiFi.SSID.1.SSID", false},
+	}
+	for _, c := range cases {
+		if MatchParamPath(c.pattern, c.path) != c.match {
+			t.Errorf("%s %s expect %v", c.pattern, c.path, c.match)
+		}
+	}
+	params := FilterDeviceParams(FlattenDeviceParams(testDeviceDoc(), ""), []string{"Device.WiFi.SSID.*.SSID"})
+	if len(params) != 2 {
+		t.Fatalf("params %+v", params)
+	}
+	if p := paramProjection([]string{"Device.WiFi.SSID.*.SSID", "Device.DeviceInfo."}); p["Device.WiFi.SSID"] != 1 || p["Device.DeviceInfo"] != 1 {
+		t.Fatalf("projection %v", p)
+	}
+	if p := paramProjection([]string{"*.WiFi"}); p != nil {
+		t.Fatalf("projection %v", p)
+	}
+}
+
+func TestDiffDeviceParams(t *testing.T) {
+	old := []DeviceParam{
+		{Path: "Device.WiFi.SSID.1.SSID", Value: "home", Type: "xsd:string"},
+		{Path: "Device.WiFi.SSID.1.Enable", Value: true, Type: "xsd:boolean"},
+		{Path: "Device.WiFi.SSID.2.SSID", Value: "guest", Type: "xsd:string"},
+	}
+	new := []DeviceParam{
+		{Path: "Device.WiFi.SSID.1.SSID", Value: "home2", Type: "xsd:string"},
+		{Path: "Device.WiFi.SSID.1.Enable", Value: true, Type: "xsd:boolean"},
+		{Path: "Device.WiFi.SSID.3.SSID", Value: "iot", Type: "xsd:string"},
+	}
+	diffs := DiffDeviceParams(old, new)
+	if len(diffs) != 3 {
+		t.Fatalf("diffs %+v", diffs)
+	}
+	if diffs[0].Op != ParamChanged || diffs[0].Old != "home" || diffs[0].New != "home2" {
+		t.Fatalf("diff %+v", diffs[0])
+	}
+	if diffs[1].Op != ParamRemoved || diffs[1].Path != "Device.WiFi.SSID.2.SSID" {
+		t.Fatalf("diff %+v", diffs[1])
+	}
+	if diffs[2].Op != ParamAdded || diffs[2].New != "iot" {
+		t.Fatalf("diff %+v", diffs[2])
+	}
+	if len(DiffDeviceParams(new, new)) != 0 {
+		t.Fatal("expect no diff")
+	}
+}
@@ -0,0 +1,629 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"gopkg.in/yaml.v2"
+
+	"github.com/ca17/teamsacs/common"
+	"github.com/ca17/teamsacs/common/genieacs"
+	"github.com/ca17/teamsacs/common/web"
+)
+
+const (
+	// the GenieACS preset and provision of a profile
+	cpeProfilePrefix = "teamsacs-"
+
+	DriftMissing  = "missing"
+	DriftMismatch = "mismatch"
+)
+
+var (
+	profileNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_\-]{0,63}$`)
+	profileTagRegexp  = regexp.MustCompile(`^[a-zA-Z0-9_\-]+$`)
+	// the rule name is the Description alias key, it must not carry alias path syntax
+	firewallRuleNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.\-]{0,63}$`)
+
+	firewallTargets   = []string{"Accept", "Drop", "Reject"}
+	firewallProtocols = map[string]int{"any": -1, "icmp": 1, "tcp": 6, "udp": 17}
+	wifiSecurityModes = []string{"None", "WPA2-Personal", "WPA-WPA2-Personal", "WPA3-Personal", "WPA2-WPA3-Personal"}
+)
+
+// ProfileWifi
+// The SSID and access point of a radio instance
+type ProfileWifi struct {
+	Index    int    `yaml:"index" json:"index" bson:"index"`
+	Ssid     string `yaml:"ssid" json:"ssid" bson:"ssid"`
+	Key      string `yaml:"key" json:"key" bson:"key"`
+	Security string `yaml:"security" json:"security" bson:"security"`
+	Disabled bool   `yaml:"disabled" json:"disabled" bson:"disabled"`
+}
+
+// ProfileRoute
+// A static IPv4 route, dest is a CIDR
+type ProfileRoute struct {
+	Dest      string `yaml:"dest" json:"dest" bson:"dest"`
+	Gateway   string `yaml:"gateway" json:"gateway" bson:"gateway"`
+	Interface string `yaml:"interface" json:"interface" bson:"interface"`
+}
+
+// ProfileFirewallRule
+// A rule of a firewall chain, the name identifies the rule on the device
+type ProfileFirewallRule struct {
+	Name     string `yaml:"name" json:"name" bson:"name"`
+	Chain    int    `yaml:"chain" json:"chain" bson:"chain"`
+	Order    int    `yaml:"order" json:"order" bson:"order"`
+	Target   string `yaml:"target" json:"target" bson:"target"`
+	Protocol string `yaml:"protocol" json:"protocol" bson:"protocol"`
+	SrcIp    string `yaml:"src_ip" json:"src_ip" bson:"src_ip"`
+	DestIp   string `yaml:"dest_ip" json:"dest_ip" bson:"dest_ip"`
+	DestPort int    `yaml:"dest_port" json:"dest_port" bson:"dest_port"`
+}
+
+// CpeProfile
+// A declarative provisioning profile of the TR-181 data model, assigned to the
+// devices by tag and/or model (the device product class)
+type CpeProfile struct {
+	ID         string                `yaml:"-" json:"id,omitempty" bson:"_id,omitempty"`
+	Name       string                `yaml:"name" json:"name" bson:"name"`
+	Tag        string                `yaml:"tag" json:"tag" bson:"tag"`
+	Model      string                `yaml:"model" json:"model" bson:"model"`
+	Weight     int                   `yaml:"weight" json:"weight" bson:"weight"`
+	Wifi       []ProfileWifi         `yaml:"wifi" json:"wifi" bson:"wifi"`
+	Dns        []string              `yaml:"dns" json:"dns" bson:"dns"`
+	Ntp        []string              `yaml:"ntp" json:"ntp" bson:"ntp"`
+	Routes     []ProfileRoute        `yaml:"routes" json:"routes" bson:"routes"`
+	Firewall   []ProfileFirewallRule `yaml:"firewall" json:"firewall" bson:"firewall"`
+	Remark     string                `yaml:"remark" json:"remark" bson:"remark"`
+	Spec       string                `yaml:"-" json:"spec,omitempty" bson:"spec"`
+	Operator   string                `yaml:"-" json:"operator" bson:"operator"`
+	UpdateTime time.Time             `yaml:"-" json:"update_time" bson:"update_time"`
+}
+
+// ProfileParam
+// A desired parameter value, write only values like the wifi keys are not read back
+type ProfileParam struct {
+	Path      string      `json:"path"`
+	Value     interface{} `json:"value"`
+	WriteOnly bool        `json:"write_only,omitempty"`
+}
+
+// ProfileInstance
+// A desired object instance identified by the key parameters, the paths are relative
+type ProfileInstance struct {
+	Object string         `json:"object"`
+	Keys   []ProfileParam `json:"keys"`
+	Params []ProfileParam `json:"params"`
+}
+
+// ProfileState
+// The desired state of a profile
+type ProfileState struct {
+	Params    []ProfileParam    `json:"params"`
+	Instances []ProfileInstance `json:"instances"`
+}
+
+// ProfileDrift
+// A difference between the desired state and the device parameters
+type ProfileDrift struct {
+	Profile  string      `json:"profile"`
+	Path     string      `json:"path"`
+	Op       string      `json:"op"`
+	Expected interface{} `json:"expected"`
+	Actual   interface{} `json:"actual,omitempty"`
+}
+
+// ParseCpeProfile
+// parse the YAML or JSON profile source
+func ParseCpeProfile(spec []byte) (*CpeProfile, error) {
+	var p = new(CpeProfile)
+	if err := yaml.UnmarshalStrict(spec, p); err != nil {
+		return nil, fmt.Errorf("profile parse error, %s", err.Error())
+	}
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	p.ID = p.Name
+	p.Spec = string(spec)
+	return p, nil
+}
+
+func cidrMask(v string) (string, string, error) {
+	ip, ipnet, err := net.ParseCIDR(v)
+	if err != nil || ip.To4() == nil {
+		return "", "", fmt.Errorf("invalid ipv4 cidr %s", v)
+	}
+	return ipnet.IP.String(), net.IP(ipnet.Mask).String(), nil
+}
+
+func isIPv4(v string) bool {
+	ip := net.ParseIP(v)
+	return ip != nil && ip.To4() != nil
+}
+
+// Validate
+// check the profile and set the defaults
+func (p *CpeProfile) Validate() error {
+	if !profileNameRegexp.MatchString(p.Name) {
+		return fmt.Errorf("invalid profile name %s", p.Name)
+	}
+	if p.Tag == "" && p.Model == "" {
+		return fmt.Errorf("profile %s requires a tag or model", p.Name)
+	}
+	if p.Tag != "" && !profileTagRegexp.MatchString(p.Tag) {
+		return fmt.Errorf("invalid tag %s", p.Tag)
+	}
+	if strings.ContainsAny(p.Model, "\"\\") {
+		return fmt.Errorf("invalid model %s", p.Model)
+	}
+	indexes := make(map[int]bool)
+	for i := range p.Wifi {
+		w := &p.Wifi[i]
+		if w.Index <= 0 {
+			w.Index = i + 1
+		}
+		if indexes[w.Index] {
+			return fmt.Errorf("duplicate wifi index %d", w.Index)
+		}
+		indexes[w.Index] = true
+		if len(w.Ssid) == 0 || len(w.Ssid) > 32 {
+			return fmt.Errorf("wifi %d ssid length must be 1-32", w.Index)
+		}
+		if w.Security == "" {
+			w.Security = "WPA2-Personal"
+		}
+		if !common.InSlice(w.Security, wifiSecurityModes) {
+			return fmt.Errorf("wifi %d unsupported security %s", w.Index, w.Security)
+		}
+		if w.Security != "None" && (len(w.Key) < 8 || len(w.Key) > 63) {
+			return fmt.Errorf("wifi %d key length must be 8-63", w.Index)
+		}
+	}
+	for _, v := range p.Dns {
+		if net.ParseIP(v) == nil {
+			return fmt.Errorf("invalid dns server %s", v)
+		}
+	}
+	if len(p.Ntp) > 5 {
+		return fmt.Errorf("at most 5 ntp servers")
+	}
+	for _, r := range p.Routes {
+		if _, _, err := cidrMask(r.Dest); err != nil {
+			return err
+		}
+		if r.Gateway == "" && r.Interface == "" {
+			return fmt.Errorf("route %s requires a gateway or interface", r.Dest)
+		}
+		if r.Gateway != "" && !isIPv4(r.Gateway) {
+			return fmt.Errorf("invalid route gateway %s", r.Gateway)
+		}
+	}
+	names := make(map[string]bool)
+	for i := range p.Firewall {
+		r := &p.Firewall[i]
+		if r.Name == "" || names[r.Name] {
+			return fmt.Errorf("firewall rule name is empty or duplicate")
+		}
+		if !firewallRuleNameRegexp.MatchString(r.Name) {
+			return fmt.Errorf("invalid firewall rule name %s", r.Name)
+		}
+		names[r.Name] = true
+		if r.Chain <= 0 {
+			r.Chain = 1
+		}
+		if r.Order <= 0 {
+			r.Order = i + 1
+		}
+		if !common.InSlice(r.Target, firewallTargets) {
+			return fmt.Errorf("firewall rule %s target must be Accept, Drop or Reject", r.Name)
+		}
+		if r.Protocol == "" {
+			r.Protocol = "any"
+		}
+		if _, ok := firewallProtocols[strings.ToLower(r.Protocol)]; !ok {
+			return fmt.Errorf("firewall rule %s unsupported protocol %s", r.Name, r.Protocol)
+		}
+		for _, v := range []string{r.SrcIp, r.DestIp} {
+			if v == "" {
+				continue
+			}
+			if _, _, err := cidrMask(v); err != nil {
+				return err
+			}
+		}
+		if r.DestPort < 0 || r.DestPort > 65535 {
+			return fmt.Errorf("firewall rule %s invalid port %d", r.Name, r.DestPort)
+		}
+	}
+	return nil
+}
+
+// PresetName
+// the name of the GenieACS preset and provision
+func (p *CpeProfile) PresetName() string {
+	return cpeProfilePrefix + p.Name
+}
+
+// Precondition
+// the preset filter expression of the tag and model
+func (p *CpeProfile) Precondition() string {
+	conds := make([]string, 0, 2)
+	if p.Tag != "" {
+		conds = append(conds, fmt.Sprintf("Tags.%s IS NOT NULL", p.Tag))
+	}
+	if p.Model != "" {
+		conds = append(conds, fmt.Sprintf("DeviceID.ProductClass = \"%s\"", p.Model))
+	}
+	return strings.Join(conds, " AND ")
+}
+
+// Match
+// whether the profile is assigned to the device of the tags and product class
+func (p *CpeProfile) Match(tags []string, productClass string) bool {
+	if p.Tag != "" && !common.InSlice(p.Tag, tags) {
+		return false
+	}
+	if p.Model != "" && p.Model != productClass {
+		return false
+	}
+	return true
+}
+
+// DesiredState
+// the TR-181 parameters of the profile
+func (p *CpeProfile) DesiredState() ProfileState {
+	state := ProfileState{Params: make([]ProfileParam, 0), Instances: make([]ProfileInstance, 0)}
+	param := func(path string, value interface{}) {
+		state.Params = append(state.Params, ProfileParam{Path: path, Value: value})
+	}
+	for _, w := range p.Wifi {
+		param(fmt.Sprintf("Device.WiFi.SSID.%d.SSID", w.Index), w.Ssid)
+		param(fmt.Sprintf("Device.WiFi.SSID.%d.Enable", w.Index), !w.Disabled)
+		param(fmt.Sprintf("Device.WiFi.AccessPoint.%d.Security.ModeEnabled", w.Index), w.Security)
+		if w.Security != "None" {
+			state.Params = append(state.Params, ProfileParam{
+				Path:      fmt.Sprintf("Device.WiFi.AccessPoint.%d.Security.KeyPassphrase", w.Index),
+				Value:     w.Key,
+				WriteOnly: true,
+			})
+		}
+	}
+	for _, v := range p.Dns {
+		state.Instances = append(state.Instances, ProfileInstance{
+			Object: "Device.DNS.Client.Server",
+			Keys:   []ProfileParam{{Path: "DNSServer", Value: v}},
+			Params: []ProfileParam{{Path: "Enable", Value: true}},
+		})
+	}
+	if len(p.Ntp) > 0 {
+		param("Device.Time.Enable", true)
+		for i, v := range p.Ntp {
+			param(fmt.Sprintf("Device.Time.NTPServer%d", i+1), v)
+		}
+	}
+	for _, r := range p.Routes {
+		dest, mask, _ := cidrMask(r.Dest)
+		inst := ProfileInstance{
+			Object: "Device.Routing.Router.1.IPv4Forwarding",
+			Keys:   []ProfileParam{{Path: "DestIPAddress", Value: dest}, {Path: "DestSubnetMask", Value: mask}},
+			Params: []ProfileParam{{Path: "Enable", Value: true}},
+		}
+		if r.Gateway != "" {
+			inst.Params = append(inst.Params, ProfileParam{Path: "GatewayIPAddress", Value: r.Gateway})
+		}
+		if r.Interface != "" {
+			inst.Params = append(inst.Params, ProfileParam{Path: "Interface", Value: r.Interface})
+		}
+		state.Instances = append(state.Instances, inst)
+	}
+	for _, r := range p.Firewall {
+		inst := ProfileInstance{
+			Object: fmt.Sprintf("Device.Firewall.Chain.%d.Rule", r.Chain),
+			Keys:   []ProfileParam{{Path: "Description", Value: r.Name}},
+			Params: []ProfileParam{
+				{Path: "Enable", Value: true},
+				{Path: "Order", Value: r.Order},
+				{Path: "Target", Value: r.Target},
+				{Path: "Protocol", Value: firewallProtocols[strings.ToLower(r.Protocol)]},
+			},
+		}
+		if r.SrcIp != "" {
+			ip, mask, _ := cidrMask(r.SrcIp)
+			inst.Params = append(inst.Params, ProfileParam{Path: "SourceIP", Value: ip}, ProfileParam{Path: "SourceMask", Value: mask})
+		}
+		if r.DestIp != "" {
+			ip, mask, _ := cidrMask(r.DestIp)
+			inst.Params = append(inst.Params, ProfileParam{Path: "DestIP", Value: ip}, ProfileParam{Path: "DestMask", Value: mask})
+		}
+		if r.DestPort > 0 {
+			inst.Params = append(inst.Params, ProfileParam{Path: "DestPort", Value: r.DestPort})
+		}
+		state.Instances = append(state.Instances, inst)
+	}
+	return state
+}
+
+func jsLiteral(v interface{}) string {
+	bs, _ := json.Marshal(v)
+	return string(bs)
+}
+
+// aliasPath
+// the GenieACS alias path of an instance, like Device.DNS.Client.Server.[DNSServer:8.8.8.8]
+func (i ProfileInstance) aliasPath() string {
+	keys := make([]string, 0, len(i.Keys))
+	for _, k := range i.Keys {
+		keys = append(keys, fmt.Sprintf("%s:%v", k.Path, k.Value))
+	}
+	return fmt.Sprintf("%s.[%s]", i.Object, strings.Join(keys, ","))
+}
+
+// ProvisionScript
+// the GenieACS provision script declaring the desired state
+func (p *CpeProfile) ProvisionScript() string {
+	state := p.DesiredState()
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("// TeamsACS profile %s, generated, do not edit\n", p.Name))
+	b.WriteString("const now = Date.now();\n")
+	for _, param := range state.Params {
+		b.WriteString(fmt.Sprintf("declare(%s, {value: now}, {value: %s});\n", jsLiteral(param.Path), jsLiteral(param.Value)))
+	}
+	for _, inst := range state.Instances {
+		alias := inst.aliasPath()
+		b.WriteString(fmt.Sprintf("declare(%s, {path: now}, {path: 1});\n", jsLiteral(alias)))
+		for _, param := range inst.Params {
+			b.WriteString(fmt.Sprintf("declare(%s, {value: now}, {value: %s});\n", jsLiteral(alias+"."+param.Path), jsLiteral(param.Value)))
+		}
+	}
+	return b.String()
+}
+
+// Preset
+// the GenieACS preset running the provision of the profile
+func (p *CpeProfile) Preset() *genieacs.Preset {
+	return &genieacs.Preset{
+		ID:           p.PresetName(),
+		Channel:      p.PresetName(),
+		Weight:       p.Weight,
+		Events:       map[string]bool{},
+		Precondition: p.Precondition(),
+		Configurations: []genieacs.PresetConfiguration{
+			{Type: "provision", Name: p.PresetName(), Args: []interface{}{}},
+		},
+	}
+}
+
+func paramEqual(expected, actual interface{}) bool {
+	return fmt.Sprint(expected) == fmt.Sprint(actual)
+}
+
+// DetectProfileDrift
+// compare the desired state with the flattened device parameters, instances
+// are found by the key parameters, extra instances are not drift
+func DetectProfileDrift(p *CpeProfile, params []DeviceParam) []ProfileDrift {
+	values := make(map[string]interface{}, len(params))
+	for _, param := range params {
+		values[param.Path] = param.Value
+	}
+	drifts := make([]ProfileDrift, 0)
+	check := func(path string, expected interface{}) {
+		actual, ok := values[path]
+		if !ok {
+			drifts = append(drifts, ProfileDrift{Profile: p.Name, Path: path, Op: DriftMissing, Expected: expected})
+		} else if !paramEqual(expected, actual) {
+			drifts = append(drifts, ProfileDrift{Profile: p.Name, Path: path, Op: DriftMismatch, Expected: expected, Actual: actual})
+		}
+	}
+	state := p.DesiredState()
+	for _, param := range state.Params {
+		if !param.WriteOnly {
+			check(param.Path, param.Value)
+		}
+	}
+	for _, inst := range state.Instances {
+		prefix := inst.Object + "."
+		instances := make([]string, 0)
+		seen := make(map[string]bool)
+		for _, param := range params {
+			if !strings.HasPrefix(param.Path, prefix) {
+				continue
+			}
+			idx := strings.SplitN(param.Path[len(prefix):], ".", 2)[0]
+			if _, err := strconv.Atoi(idx); err == nil && !seen[idx] {
+				seen[idx] = true
+				instances = append(instances, idx)
+			}
+		}
+		sort.Strings(instances)
+		found := ""
+		for _, idx := range instances {
+			matched := true
+			for _, k := range inst.Keys {
+				if !paramEqual(k.Value, values[prefix+idx+"."+k.Path]) {
+					matched = false
+					break
+				}
+			}
+			if matched {
+				found = idx
+				break
+			}
+		}
+		if found == "" {
+			drifts = append(drifts, ProfileDrift{Profile: p.Name, Path: inst.aliasPath(), Op: DriftMissing, Expected: inst.Params})
+			continue
+		}
+		for _, param := range inst.Params {
+			check(prefix+found+"."+param.Path, param.Value)
+		}
+	}
+	return drifts
+}
+
+// AddCpeProfile
+// parse the profile and save it, an existing profile of the name is replaced
+func (m *GenieacsManager) AddCpeProfile(spec []byte, operator string) (*CpeProfile, error) {
+	p, err := ParseCpeProfile(spec)
+	if err != nil {
+		return nil, err
+	}
+	p.Operator = operator
+	p.UpdateTime = time.Now()
+	_, err = m.GetTeamsAcsCollection(TeamsacsCpeProfile).ReplaceOne(context.TODO(),
+		bson.M{"_id": p.ID}, p, options.Replace().SetUpsert(true))
+	return p, err
+}
+
+// GetCpeProfile
+func (m *GenieacsManager) GetCpeProfile(name string) (*CpeProfile, error) {
+	var p = new(CpeProfile)
+	if err := m.GetTeamsAcsCollection(TeamsacsCpeProfile).FindOne(context.TODO(), bson.M{"_id": name}).Decode(p); err != nil {
+		return nil, fmt.Errorf("profile %s not found", name)
+	}
+	return p, nil
+}
+
+// QueryCpeProfiles
+// the wifi keys and the profile source are never returned
+func (m *GenieacsManager) QueryCpeProfiles(params web.RequestParams) (*web.PageResult, error) {
+	data, err := m.QueryPagerItems(params, TeamsacsCpeProfile)
+	if err != nil {
+		return nil, err
+	}
+	if items, ok := data.Data.([]map[string]interface{}); ok {
+		for _, item := range items {
+			delete(item, "spec")
+			wifis, _ := item["wifi"].(primitive.A)
+			for i, w := range wifis {
+				switch v := w.(type) {
+				case map[string]interface{}:
+					delete(v, "key")
+				case primitive.D:
+					d := make(primitive.D, 0, len(v))
+					for _, e := range v {
+						if e.Key != "key" {
+							d = append(d, e)
+						}
+					}
+					wifis[i] = d
+				}
+			}
+		}
+	}
+	return data, nil
+}
+
+func (m *GenieacsManager) allCpeProfiles() ([]CpeProfile, error) {
+	cur, err := m.GetTeamsAcsCollection(TeamsacsCpeProfile).Find(context.TODO(), bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	items := make([]CpeProfile, 0)
+	err = cur.All(context.TODO(), &items)
+	return items, err
+}
+
+// ApplyCpeProfile
+// write the provision script and the preset of the profile by the NBI
+func (m *GenieacsManager) ApplyCpeProfile(name string) (*genieacs.Preset, error) {
+	p, err := m.GetCpeProfile(name)
+	if err != nil {
+		return nil, err
+	}
+	client, err := m.GetNbiClient()
+	if err != nil {
+		return nil, err
+	}
+	return ApplyProfile(client, p)
+}
+
+// ApplyProfile
+// the provision is written before the preset referencing it
+func ApplyProfile(client *genieacs.Client, p *CpeProfile) (*genieacs.Preset, error) {
+	if err := client.PutProvision(p.PresetName(), p.ProvisionScript()); err != nil {
+		return nil, err
+	}
+	preset := p.Preset()
+	return preset, client.PutPreset(preset)
+}
+
+// DeleteCpeProfile
+// remove the preset and provision from GenieACS and delete the profile
+func (m *GenieacsManager) DeleteCpeProfile(name string) error {
+	p, err := m.GetCpeProfile(name)
+	if err != nil {
+		return err
+	}
+	if client, err := m.GetNbiClient(); err == nil {
+		if err = client.DeletePreset(p.PresetName()); err != nil {
+			return err
+		}
+		if err = client.DeleteProvision(p.PresetName()); err != nil {
+			return err
+		}
+	}
+	_, err = m.GetTeamsAcsCollection(TeamsacsCpeProfile).DeleteOne(context.TODO(), bson.M{"_id": name})
+	return err
+}
+
+// DetectCpeDrift
+// the drift of all profiles assigned to the device
+func (m *GenieacsManager) DetectCpeDrift(sn string) ([]ProfileDrift, error) {
+	profiles, err := m.allCpeProfiles()
+	if err != nil {
+		return nil, err
+	}
+	var doc map[string]interface{}
+	err = m.GetGenieAcsCollection(GenieacsDevices).FindOne(context.TODO(), m.getDeviceQuery(sn),
+		options.FindOne().SetProjection(bson.M{"_tags": 1, "_deviceId": 1})).Decode(&doc)
+	if err != nil {
+		return nil, fmt.Errorf("device %s not found", sn)
+	}
+	tags := make([]string, 0)
+	if arr, ok := doc["_tags"].(bson.A); ok {
+		for _, t := range arr {
+			if s, ok := t.(string); ok {
+				tags = append(tags, s)
+			}
+		}
+	}
+	productClass := deviceIdValue(doc, "_ProductClass")
+	_, params, err := m.QueryDeviceParams(sn, []string{"Device.WiFi.", "Device.DNS.", "Device.Time.", "Device.Routing.", "Device.Firewall."})
+	if err != nil {
+		return nil, err
+	}
+	drifts := make([]ProfileDrift, 0)
+	for i := range profiles {
+		if profiles[i].Match(tags, productClass) {
+			drifts = append(drifts, DetectProfileDrift(&profiles[i], params)...)
+		}
+	}
+	return drifts, nil
+}
@@ -0,0 +1,142 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package models
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ca17/teamsacs/common/genieacs"
+	"github.com/ca17/teamsacs/common/genieacs/genieacstest"
+)
+
+const testProfileYaml = `
+name: home
+tag: home
+model: RB750Gr3
+wifi:
+  - ssid: "Home \"5G\""
+    key: secret1234
+dns: [8.8.8.8, 1.1.1.1]
+ntp: [pool.ntp.org]
+routes:
+  - dest: 10.10.0.0/16
+    gateway: 192.168.88.254
+firewall:
+  - name: block-telnet
+    target: Drop
+    protocol: tcp
+    dest_port: 23
+`
+
+func TestParseCpeProfile(t *testing.T) {
+	p, err := ParseCpeProfile([]byte(testProfileYaml))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Wifi[0].Index != 1 || p.Wifi[0].Security != "WPA2-Personal" || p.Firewall[0].Chain != 1 {
+		t.Fatal("defaults not set", p.Wifi, p.Firewall)
+	}
+	if p.Precondition() != `Tags.home IS NOT NULL AND DeviceID.ProductClass = "RB750Gr3"` {
+		t.Fatal(p.Precondition())
+	}
+	jp, err := ParseCpeProfile([]byte(`{"name": "office", "model": "hAP", "dns": ["9.9.9.9"]}`))
+	if err != nil || jp.Dns[0] != "9.9.9.9" {
+		t.Fatal(jp, err)
+	}
+	for _, spec := range []string{
+		`{"name": "x", "dns": ["8.8.8.8"]}`,
+		`{"name": "x", "tag": "a b"}`,
+		`{"name": "x", "tag": "a", "wifi": [{"ssid": "a", "key": "short"}]}`,
+		`{"name": "x", "tag": "a", "routes": [{"dest": "10.0.0.1", "gateway": "10.0.0.254"}]}`,
+		`{"name": "x", "tag": "a", "firewall": [{"name": "r", "target": "Allow"}]}`,
+		`{"name": "x", "tag": "a", "firewall": [{"name": "r],Enable:true", "target": "Drop"}]}`,
+		`{"name": "x", "tag": "a", "unknown": 1}`,
+	} {
+		if _, err := ParseCpeProfile([]byte(spec)); err == nil {
+			t.Fatal("expected error", spec)
+		}
+	}
+}
+
+func TestProfileProvisionScript(t *testing.T) {
+	p, _ := ParseCpeProfile([]byte(testProfileYaml))
+	script := p.ProvisionScript()
+	for _, line := range []string{
+		`declare("Device.WiFi.SSID.1.SSID", {value: now}, {value: "Home \"5G\""});`,
+		`declare("Device.WiFi.AccessPoint.1.Security.KeyPassphrase", {value: now}, {value: "secret1234"});`,
+		`declare("Device.DNS.Client.Server.[DNSServer:8.8.8.8]", {path: now}, {path: 1});`,
+		`declare("Device.Routing.Router.1.IPv4Forwarding.[DestIPAddress:10.10.0.0,DestSubnetMask:255.255.0.0].GatewayIPAddress", {value: now}, {value: "192.168.88.254"});`,
+		`declare("Device.Firewall.Chain.1.Rule.[Description:block-telnet].Protocol", {value: now}, {value: 6});`,
+		`declare("Device.Time.NTPServer1", {value: now}, {value: "pool.ntp.org"});`,
+	} {
+		if !strings.Contains(script, line) {
+			t.Fatal("missing", line, "\n", script)
+		}
+	}
+}
+
+func TestDetectProfileDrift(t *testing.T) {
+	p, _ := ParseCpeProfile([]byte(testProfileYaml))
+	params := []DeviceParam{
+		{Path: "Device.WiFi.SSID.1.SSID", Value: "Home \"5G\""},
+		{Path: "Device.WiFi.SSID.1.Enable", Value: true},
+		{Path: "Device.WiFi.AccessPoint.1.Security.ModeEnabled", Value: "WPA-WPA2-Personal"},
+		{Path: "Device.DNS.Client.Server.1.DNSServer", Value: "1.1.1.1"},
+		{Path: "Device.DNS.Client.Server.1.Enable", Value: true},
+		{Path: "Device.DNS.Client.Server.2.DNSServer", Value: "8.8.8.8"},
+		{Path: "Device.DNS.Client.Server.2.Enable", Value: false},
+		{Path: "Device.Time.Enable", Value: true},
+		{Path: "Device.Time.NTPServer1", Value: "pool.ntp.org"},
+		{Path: "Device.Routing.Router.1.IPv4Forwarding.3.DestIPAddress", Value: "10.10.0.0"},
+		{Path: "Device.Routing.Router.1.IPv4Forwarding.3.DestSubnetMask", Value: "255.255.0.0"},
+		{Path: "Device.Routing.Router.1.IPv4Forwarding.3.GatewayIPAddress", Value: "192.168.88.254"},
+		{Path: "Device.Routing.Router.1.IPv4Forwarding.3.Enable", Value: true},
+	}
+	drifts := DetectProfileDrift(p, params)
+	expected := map[string]string{
+		"Device.WiFi.AccessPoint.1.Security.ModeEnabled":          DriftMismatch,
+		"Device.DNS.Client.Server.2.Enable":                       DriftMismatch,
+		"Device.Firewall.Chain.1.Rule.[Description:block-telnet]": DriftMissing,
+	}
+	if len(drifts) != len(expected) {
+		t.Fatal(drifts)
+	}
+	for _, d := range drifts {
+		if expected[d.Path] != d.Op {
+			t.Fatal("unexpected drift", d)
+		}
+	}
+}
+
+func TestApplyProfile(t *testing.T) {
+	server := genieacstest.NewServer("CPE-0001")
+	defer server.Close()
+	client := genieacs.NewClient(server.URL, "", "", time.Second*5)
+	p, _ := ParseCpeProfile([]byte(testProfileYaml))
+	if _, err := ApplyProfile(client, p); err != nil {
+		t.Fatal(err)
+	}
+	preset, ok := server.Presets()["teamsacs-home"]
+	if !ok || preset.Precondition != p.Precondition() || preset.Configurations[0].Name != "teamsacs-home" {
+		t.Fatal(server.Presets())
+	}
+	if server.Provisions()["teamsacs-home"] != p.ProvisionScript() {
+		t.Fatal(server.Provisions())
+	}
+}
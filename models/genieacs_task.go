@@ -0,0 +1,273 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package models
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/ca17/teamsacs/common"
+	"github.com/ca17/teamsacs/common/genieacs"
+	"github.com/ca17/teamsacs/common/log"
+	"github.com/ca17/teamsacs/common/web"
+)
+
+const (
+	CpeTaskModeNow    = "now"
+	CpeTaskModeQueued = "queued"
+
+	CpeTaskPending = "pending"
+	CpeTaskDone    = "done"
+	CpeTaskFault   = "fault"
+	CpeTaskDeleted = "deleted"
+)
+
+// CpeTask
+// A TR-069 task pushed to GenieACS, the status is tracked until
+// the task leaves the GenieACS queue or faults
+type CpeTask struct {
+	ID           string        `bson:"_id,omitempty" json:"id,omitempty"`
+	TaskId       string        `bson:"task_id" json:"task_id"`
+	DeviceId     string        `bson:"device_id" json:"device_id"`
+	Sn           string        `bson:"sn" json:"sn"`
+	Name         string        `bson:"name" json:"name"`
+	Task         genieacs.Task `bson:"task" json:"task"`
+	Mode         string        `bson:"mode" json:"mode"`
+	Status       string        `bson:"status" json:"status"`
+	FaultCode    string        `bson:"fault_code,omitempty" json:"fault_code,omitempty"`
+	FaultMessage string        `bson:"fault_message,omitempty" json:"fault_message,omitempty"`
+	Operator     string        `bson:"operator" json:"operator"`
+	CreateTime   time.Time     `bson:"create_time" json:"create_time"`
+	UpdateTime   time.Time     `bson:"update_time" json:"update_time"`
+}
+
+// GetNbiClient
+func (m *GenieacsManager) GetNbiClient() (*genieacs.Client, error) {
+	cfg := m.Config.Genieacs
+	if !cfg.Enabled || cfg.NbiUrl == "" {
+		return nil, fmt.Errorf("genieacs nbi is not enabled")
+	}
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = 10
+	}
+	return genieacs.NewClient(cfg.NbiUrl, cfg.Username, cfg.Password, time.Duration(timeout)*time.Second), nil
+}
+
+// CreateCpeTask
+// push the task to the device of the CPE, now sends a connection request and
+// waits for the device session, otherwise the task runs at the next inform
+func (m *GenieacsManager) CreateCpeTask(sn string, task *genieacs.Task, now bool, operator string) (*CpeTask, error) {
+	if err := task.Validate(); err != nil {
+		return nil, err
+	}
+	cpe, err := m.GetCpeManager().GetCpeBySn(sn)
+	if err != nil {
+		return nil, fmt.Errorf("cpe %s not found", sn)
+	}
+	deviceId := cpe.GetStringValue("device_id", "")
+	if deviceId == "" {
+		return nil, fmt.Errorf("cpe %s device_id is empty", sn)
+	}
+	client, err := m.GetNbiClient()
+	if err != nil {
+		return nil, err
+	}
+	result, err := client.AddTask(deviceId, task, now,
+		time.Duration(m.Config.Genieacs.ConnectionRequestTimeout)*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	item := &CpeTask{
+		ID:         common.UUID(),
+		TaskId:     result.Task.ID,
+		DeviceId:   deviceId,
+		Sn:         sn,
+		Name:       task.Name,
+		Task:       *task,
+		Mode:       CpeTaskModeQueued,
+		Status:     CpeTaskPending,
+		Operator:   operator,
+		CreateTime: time.Now(),
+		UpdateTime: time.Now(),
+	}
+	if now {
+		item.Mode = CpeTaskModeNow
+	}
+	if result.Executed {
+		item.Status = CpeTaskDone
+	}
+	_, err = m.GetTeamsAcsCollection(TeamsacsCpeTask).InsertOne(context.TODO(), item)
+	return item, err
+}
+
+// QueryCpeTasks
+func (m *GenieacsManager) QueryCpeTasks(params web.RequestParams) (*web.PageResult, error) {
+	return m.QueryPagerItems(params, TeamsacsCpeTask)
+}
+
+// GetCpeTask
+func (m *GenieacsManager) GetCpeTask(id string) (*CpeTask, error) {
+	var item = new(CpeTask)
+	err := m.GetTeamsAcsCollection(TeamsacsCpeTask).FindOne(context.TODO(), bson.M{"_id": id}).Decode(item)
+	return item, err
+}
+
+func (m *GenieacsManager) updateCpeTaskStatus(item *CpeTask) error {
+	_, err := m.GetTeamsAcsCollection(TeamsacsCpeTask).UpdateOne(context.TODO(), bson.M{"_id": item.ID}, bson.M{"$set": bson.M{
+		"status":        item.Status,
+		"fault_code":    item.FaultCode,
+		"fault_message": item.FaultMessage,
+		"update_time":   time.Now(),
+	}})
+	return err
+}
+
+// RetryCpeTask
+// clear the fault in GenieACS, the task runs again at the next session
+func (m *GenieacsManager) RetryCpeTask(id string) error {
+	item, err := m.GetCpeTask(id)
+	if err != nil {
+		return err
+	}
+	if item.Status != CpeTaskFault {
+		return fmt.Errorf("task %s is not faulted", id)
+	}
+	client, err := m.GetNbiClient()
+	if err != nil {
+		return err
+	}
+	if err = client.RetryTask(item.TaskId); err != nil {
+		return err
+	}
+	item.Status, item.FaultCode, item.FaultMessage = CpeTaskPending, "", ""
+	return m.updateCpeTaskStatus(item)
+}
+
+// DeleteCpeTask
+// remove the pending or faulted task from the GenieACS queue
+func (m *GenieacsManager) DeleteCpeTask(id string) error {
+	item, err := m.GetCpeTask(id)
+	if err != nil {
+		return err
+	}
+	if item.Status != CpeTaskPending && item.Status != CpeTaskFault {
+		return fmt.Errorf("task %s is %s", id, item.Status)
+	}
+	client, err := m.GetNbiClient()
+	if err != nil {
+		return err
+	}
+	if err = client.DeleteTask(item.TaskId); err != nil {
+		return err
+	}
+	item.Status = CpeTaskDeleted
+	return m.updateCpeTaskStatus(item)
+}
+
+// ResolveCpeTaskStatus
+// a task with a fault is faulted, a task left the GenieACS queue is done,
+// returns the tasks whose status changed
+func ResolveCpeTaskStatus(items []CpeTask, queued []genieacs.Task, faults []genieacs.Fault) []CpeTask {
+	queuedIds := make(map[string]bool, len(queued))
+	for _, t := range queued {
+		queuedIds[t.ID] = true
+	}
+	faultMap := make(map[string]genieacs.Fault, len(faults))
+	for _, f := range faults {
+		if tid := f.TaskId(); tid != "" {
+			faultMap[tid] = f
+		}
+	}
+	changed := make([]CpeTask, 0)
+	for _, item := range items {
+		if f, ok := faultMap[item.TaskId]; ok {
+			if item.Status != CpeTaskFault || item.FaultCode != f.Code {
+				item.Status, item.FaultCode, item.FaultMessage = CpeTaskFault, f.Code, f.Message
+				changed = append(changed, item)
+			}
+			continue
+		}
+		if !queuedIds[item.TaskId] {
+			if item.Status == CpeTaskDone {
+				continue
+			}
+			item.Status, item.FaultCode, item.FaultMessage = CpeTaskDone, "", ""
+			changed = append(changed, item)
+		} else if item.Status == CpeTaskFault {
+			// the fault is cleared outside
+			item.Status, item.FaultCode, item.FaultMessage = CpeTaskPending, "", ""
+			changed = append(changed, item)
+		}
+	}
+	return changed
+}
+
+// SyncCpeTasks
+// poll the GenieACS tasks and faults of the pending and faulted tasks
+func (m *GenieacsManager) SyncCpeTasks() error {
+	client, err := m.GetNbiClient()
+	if err != nil {
+		return err
+	}
+	cur, err := m.GetTeamsAcsCollection(TeamsacsCpeTask).Find(context.TODO(),
+		bson.M{"status": bson.M{"$in": []string{CpeTaskPending, CpeTaskFault}}}, options.Find().SetLimit(1000))
+	if err != nil {
+		return err
+	}
+	items := make([]CpeTask, 0)
+	if err = cur.All(context.TODO(), &items); err != nil {
+		return err
+	}
+	if len(items) == 0 {
+		return nil
+	}
+	taskIds := make([]string, 0, len(items))
+	deviceIds := make([]string, 0)
+	for _, item := range items {
+		taskIds = append(taskIds, item.TaskId)
+		if !common.InSlice(item.DeviceId, deviceIds) {
+			deviceIds = append(deviceIds, item.DeviceId)
+		}
+	}
+	queued, err := client.GetTasks(bson.M{"_id": bson.M{"$in": taskIds}})
+	if err != nil {
+		return err
+	}
+	faults, err := client.GetFaults(bson.M{"device": bson.M{"$in": deviceIds}})
+	if err != nil {
+		return err
+	}
+	for _, item := range ResolveCpeTaskStatus(items, queued, faults) {
+		if err = m.updateCpeTaskStatus(&item); err != nil {
+			log.Errorf("update cpe task %s status error, %s", item.ID, err.Error())
+		}
+	}
+	return nil
+}
+
+// RunCpeTaskSync
+// Scheduler entry
+func (m *GenieacsManager) RunCpeTaskSync() {
+	if err := m.SyncCpeTasks(); err != nil {
+		log.Errorf("sync cpe tasks error, %s", err.Error())
+	}
+}
@@ -0,0 +1,96 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ca17/teamsacs/common/genieacs"
+	"github.com/ca17/teamsacs/common/genieacs/genieacstest"
+)
+
+func TestResolveCpeTaskStatus(t *testing.T) {
+	server := genieacstest.NewServer("CPE-0001")
+	defer server.Close()
+	server.Exec = func(device string, task genieacs.Task) *genieacs.Fault {
+		if task.Name == genieacs.TaskDownload {
+			return &genieacs.Fault{Code: "cwmp.9010", Message: "Download failure"}
+		}
+		return nil
+	}
+	client := genieacs.NewClient(server.URL, "", "", time.Second*5)
+	items := make([]CpeTask, 0)
+	for _, task := range []genieacs.Task{
+		{Name: genieacs.TaskReboot},
+		{Name: genieacs.TaskDownload, FileName: "fw.bin"},
+		{Name: genieacs.TaskRefreshObject, ObjectName: "Device."},
+	} {
+		result, err := client.AddTask("CPE-0001", &task, false, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		items = append(items, CpeTask{ID: task.Name, TaskId: result.Task.ID, DeviceId: "CPE-0001", Status: CpeTaskPending})
+	}
+	sync := func() map[string]CpeTask {
+		queued, err := client.GetTasks(map[string]interface{}{"device": "CPE-0001"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		faults, err := client.GetFaults(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result := make(map[string]CpeTask)
+		for _, item := range ResolveCpeTaskStatus(items, queued, faults) {
+			result[item.ID] = item
+		}
+		for i := range items {
+			if c, ok := result[items[i].ID]; ok {
+				items[i] = c
+			}
+		}
+		return result
+	}
+	if changed := sync(); len(changed) != 0 {
+		t.Fatalf("queued tasks must stay pending, %+v", changed)
+	}
+
+	server.Inform("CPE-0001")
+	changed := sync()
+	if len(changed) != 3 {
+		t.Fatalf("expected 3 changes, got %+v", changed)
+	}
+	if changed[genieacs.TaskReboot].Status != CpeTaskDone || changed[genieacs.TaskRefreshObject].Status != CpeTaskDone {
+		t.Fatalf("executed tasks must be done, %+v", changed)
+	}
+	if f := changed[genieacs.TaskDownload]; f.Status != CpeTaskFault || f.FaultCode != "cwmp.9010" {
+		t.Fatalf("download must be faulted, %+v", f)
+	}
+	// no change on the next poll
+	if changed = sync(); len(changed) != 0 {
+		t.Fatalf("expected no change, got %+v", changed)
+	}
+
+	// the fault is cleared, the task is pending again
+	if err := client.RetryTask(items[1].TaskId); err != nil {
+		t.Fatal(err)
+	}
+	if changed = sync(); changed[genieacs.TaskDownload].Status != CpeTaskPending {
+		t.Fatalf("retried task must be pending, %+v", changed)
+	}
+}
@@ -28,6 +28,7 @@ import (
 
 	"github.com/ca17/teamsacs/common"
 	"github.com/ca17/teamsacs/common/gmail"
+	"github.com/ca17/teamsacs/common/log"
 	"github.com/ca17/teamsacs/common/mongodb"
 	"github.com/ca17/teamsacs/common/tpl"
 	"github.com/ca17/teamsacs/config"
@@ -45,6 +46,38 @@ const (
 	TeamsacsAccounting = "accounting"
 	TeamsacsAuthlog    = "authlog"
 	TeamsacsSyslog     = "syslog"
+	TeamsacsRetention  = "retention"
+	TeamsacsCdrState   = "cdr_state"
+	TeamsacsWebhook    = "webhook"
+
+	TeamsacsWebhookDeadletter = "webhook_deadletter"
+	TeamsacsPortalSession     = "portal_session"
+	TeamsacsVoucherBatch      = "voucher_batch"
+	TeamsacsVoucher           = "voucher"
+	TeamsacsNotifyTemplate    = "notify_template"
+	TeamsacsNotifyLog         = "notify_log"
+	TeamsacsRenewalHistory    = "renewal_history"
+	TeamsacsTariff            = "tariff"
+	TeamsacsBillingAccount    = "billing_account"
+	TeamsacsBillingLedger     = "billing_ledger"
+	TeamsacsTacacsUser        = "tacacs_user"
+	TeamsacsTacacsGroup       = "tacacs_group"
+	TeamsacsTacacsCmdset      = "tacacs_cmdset"
+	TeamsacsTacacsAccounting  = "tacacs_accounting"
+	TeamsacsSnmpTrap          = "snmptrap"
+	TeamsacsSnmpUsmUser       = "snmp_usm_user"
+	TeamsacsFlowStat          = "flow_stat"
+	TeamsacsCpeTask           = "cpe_task"
+	TeamsacsCpeParamSnapshot  = "cpe_param_snapshot"
+	TeamsacsCpeSyncHistory    = "cpe_sync_history"
+	TeamsacsCpeStatus         = "cpe_status"
+	TeamsacsCpeStatusEvent    = "cpe_status_events"
+	TeamsacsFirmwareCampaign  = "firmware_campaign"
+	TeamsacsCampaignDevice    = "firmware_campaign_device"
+	TeamsacsCpeIfStat         = "cpe_if_stat"
+	TeamsacsCpeProfile        = "cpe_profile"
+	TeamsacsSite              = "site"
+	TeamsacsDataSchema        = "data_schema"
 
 	GenieacsDevices = "devices"
 	GenieacsFaults  = "faults"
@@ -69,8 +102,10 @@ type ModelManager struct {
 	Location     *time.Location
 	WebJwtConfig *middleware.JWTConfig
 	MailSender   *gmail.MailSender
+	Events       *EventBus
 	ManagerMap   cmap.ConcurrentMap
 	Dev          bool
+	schedStop    chan struct{}
 }
 
 func NewModelManager(appconfig *config.AppConfig, dev bool) *ModelManager {
@@ -84,11 +119,45 @@ func NewModelManager(appconfig *config.AppConfig, dev bool) *ModelManager {
 	m.Location = loc
 	m.registerManagers()
 	m.TplRender = tpl.NewCommonTemplate([]string{"/resources/templates"}, m.Dev, m.GetTemplateFuncMap())
+	if appconfig.Mail.Server != "" {
+		m.MailSender = &gmail.MailSender{
+			Server:   appconfig.Mail.Server,
+			Port:     appconfig.Mail.Port,
+			Tls:      appconfig.Mail.Tls,
+			Usernam:  appconfig.Mail.Username,
+			Alias:    appconfig.Mail.Alias,
+			Password: appconfig.Mail.Password,
+			Mailtos:  appconfig.Mail.Mailtos,
+		}
+	}
 	m.SetupSyslogDB()
-	go m.StartScheduler()
+	m.SetupSnmpTrapDB()
+	m.SetupFlowDB()
+	m.SetupBillingDB()
+	m.SetupCpeMonitorDB()
+	m.SetupIfStatsDB()
+	m.SetupSubscribeDB()
+	m.SetupCdrDB()
+	m.Events = NewEventBus()
+	m.Events.Subscribe(EventAll, m.GetWebhookManager().HandleEvent)
+	m.StartScheduler()
 	return m
 }
 
+// Close
+// Stop the scheduler, flush pending writes and disconnect mongodb
+func (m *ModelManager) Close(ctx context.Context) error {
+	m.StopScheduler()
+	if err := m.Events.Close(ctx); err != nil {
+		log.Warningf("wait event dispatch error, %s", err.Error())
+	}
+	if err := m.GetWebhookManager().Wait(ctx); err != nil {
+		log.Warningf("wait webhook deliveries error, %s", err.Error())
+	}
+	m.GetCdrManager().Close()
+	return m.Mongo.Disconnect(ctx)
+}
+
 func (m *ModelManager) SetupSyslogDB() {
 	var Capped = true
 	var size = int64(1024 * 64)
@@ -103,12 +172,23 @@ func (m *ModelManager) SetupSyslogDB() {
 func (m *ModelManager) registerManagers() {
 	m.ManagerMap.Set("SubscribeManager", &SubscribeManager{m})
 	m.ManagerMap.Set("RadiusManager", &RadiusManager{m})
-	m.ManagerMap.Set("VpeManager", &VpeManager{m})
+	m.ManagerMap.Set("VpeManager", &VpeManager{ModelManager: m})
 	m.ManagerMap.Set("OperatorManager", &OperatorManager{m})
 	m.ManagerMap.Set("CpeManager", &CpeManager{m})
-	m.ManagerMap.Set("ConfigManager", &ConfigManager{m})
+	m.ManagerMap.Set("ConfigManager", &ConfigManager{ModelManager: m})
 	m.ManagerMap.Set("GenieacsManager", &GenieacsManager{m})
 	m.ManagerMap.Set("DataManager", &DataManager{m})
+	m.ManagerMap.Set("RetentionManager", &RetentionManager{m})
+	m.ManagerMap.Set("PortalManager", &PortalManager{m})
+	m.ManagerMap.Set("VoucherManager", &VoucherManager{m})
+	m.ManagerMap.Set("NotifyManager", &NotifyManager{m})
+	m.ManagerMap.Set("BillingManager", &BillingManager{m})
+	m.ManagerMap.Set("TacacsManager", &TacacsManager{m})
+	m.ManagerMap.Set("SnmpTrapManager", &SnmpTrapManager{m})
+	m.ManagerMap.Set("FlowManager", &FlowManager{m})
+	m.ManagerMap.Set("RouterosManager", &RouterosManager{m})
+	m.ManagerMap.Set("CdrManager", &CdrManager{ModelManager: m})
+	m.ManagerMap.Set("WebhookManager", &WebhookManager{ModelManager: m, sending: make(chan struct{}, webhookMaxSending)})
 }
 
 func (m *ModelManager) GetTeamsAcsCollection(coll string) *mongo.Collection {
@@ -0,0 +1,104 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/ca17/teamsacs/common/log"
+)
+
+var onlineSessionsDesc = prometheus.NewDesc(
+	"teamsacs_radius_online_sessions",
+	"Number of radius online sessions per VPE.",
+	[]string{"nas_addr", "vpe"}, nil,
+)
+
+// OnlineSessionCollector
+// Count online sessions per VPE at scrape time
+type OnlineSessionCollector struct{ *ModelManager }
+
+func NewOnlineSessionCollector(m *ModelManager) *OnlineSessionCollector {
+	return &OnlineSessionCollector{ModelManager: m}
+}
+
+func (c *OnlineSessionCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- onlineSessionsDesc
+}
+
+func (c *OnlineSessionCollector) Collect(ch chan<- prometheus.Metric) {
+	counts, err := c.countOnlineByNas()
+	if err != nil {
+		log.Errorf("collect online sessions error %s", err.Error())
+		return
+	}
+	names := c.vpeNames()
+	for nasaddr, count := range counts {
+		name := names[nasaddr]
+		if name == "" {
+			name = nasaddr
+		}
+		ch <- prometheus.MustNewConstMetric(onlineSessionsDesc, prometheus.GaugeValue, float64(count), nasaddr, name)
+	}
+}
+
+func (c *OnlineSessionCollector) countOnlineByNas() (map[string]int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	coll := c.GetTeamsAcsCollection(TeamsacsOnline)
+	cur, err := coll.Aggregate(ctx, []bson.M{
+		{"$group": bson.M{"_id": "$nas_addr", "count": bson.M{"$sum": 1}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	var result = make(map[string]int64)
+	for cur.Next(ctx) {
+		var item struct {
+			NasAddr string `bson:"_id"`
+			Count   int64  `bson:"count"`
+		}
+		if err := cur.Decode(&item); err != nil {
+			return nil, err
+		}
+		result[item.NasAddr] = item.Count
+	}
+	return result, cur.Err()
+}
+
+func (c *OnlineSessionCollector) vpeNames() map[string]string {
+	var names = make(map[string]string)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	cur, err := c.GetTeamsAcsCollection(TeamsacsVpe).Find(ctx, bson.M{})
+	if err != nil {
+		return names
+	}
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
+		var vpe = make(Vpe)
+		if cur.Decode(&vpe) == nil {
+			names[vpe.GetStringValue("ipaddr", "")] = vpe.GetStringValue("name", "")
+		}
+	}
+	return names
+}
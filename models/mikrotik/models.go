@@ -162,13 +162,18 @@ func (info *DeviceInfo) ParseBson(val map[string]interface{}) {
 	info.UpTime = GetInt64SubValue(val, "UpTime", "_value", "")
 	info.Timestamp = ParseDateTime(val["_timestamp"])
 
-	MemoryStatus := val["MemoryStatus"].(TMap)
-	Free := GetFloat64SubValue(MemoryStatus, "Free", "_value", "")
-	Total := GetFloat64SubValue(MemoryStatus, "Total", "_value", "")
-	info.MemoryUsage = int64(math.Round((Total - Free) / Total * 100))
+	// not all vendors report the memory and process status
+	if MemoryStatus, ok := val["MemoryStatus"].(TMap); ok {
+		Free := GetFloat64SubValue(MemoryStatus, "Free", "_value", "")
+		Total := GetFloat64SubValue(MemoryStatus, "Total", "_value", "")
+		if Total > 0 {
+			info.MemoryUsage = int64(math.Round((Total - Free) / Total * 100))
+		}
+	}
 
-	ProcessStatus := val["ProcessStatus"].(TMap)
-	info.CPUUsage = GetInt64SubValue(ProcessStatus, "CPUUsage", "_value", "")
+	if ProcessStatus, ok := val["ProcessStatus"].(TMap); ok {
+		info.CPUUsage = GetInt64SubValue(ProcessStatus, "CPUUsage", "_value", "")
+	}
 }
 
 type DeviceEthernet struct {
@@ -0,0 +1,402 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	htmltpl "html/template"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/ca17/teamsacs/common"
+	"github.com/ca17/teamsacs/common/gmail"
+	"github.com/ca17/teamsacs/common/log"
+	"github.com/ca17/teamsacs/common/timeutil"
+	"github.com/ca17/teamsacs/common/tpl"
+	"github.com/ca17/teamsacs/common/web"
+	"github.com/ca17/teamsacs/constant"
+)
+
+const (
+	NotifyTypeExpire  = "expire"
+	NotifyTypeQuota   = "quota"
+	NotifyTypeRenewal = "renewal"
+
+	NotifyStatusSuccess = "success"
+	NotifyStatusFailure = "failure"
+)
+
+// renewal period units accepted by timeutil.ComputeEndTime
+var renewalUnits = []string{"day", "week", "month", "year"}
+
+// NotifyTemplate
+// The mail template of a notify type, subject and body are rendered with common/tpl
+type NotifyTemplate struct {
+	ID         string    `bson:"_id" json:"id"`
+	Subject    string    `bson:"subject" json:"subject"`
+	Body       string    `bson:"body" json:"body"`
+	UpdateTime time.Time `bson:"update_time" json:"update_time"`
+}
+
+// DefaultNotifyTemplates
+// used when the template is not stored in mongodb
+var DefaultNotifyTemplates = map[string]NotifyTemplate{
+	NotifyTypeExpire: {
+		ID:      NotifyTypeExpire,
+		Subject: "Your account {{.Username}} will expire in {{.ExpireDays}} days",
+		Body: "<p>Dear {{.Realname}},</p>" +
+			"<p>Your account <b>{{.Username}}</b> will expire at {{.ExpireTime}}, please renew it in time.</p>",
+	},
+	NotifyTypeQuota: {
+		ID:      NotifyTypeQuota,
+		Subject: "Your account {{.Username}} has used {{.FlowPercent}}% of its data quota",
+		Body: "<p>Dear {{.Realname}},</p>" +
+			"<p>Your account <b>{{.Username}}</b> has used {{.FlowUsed}} MB of {{.FlowQuota}} MB.</p>",
+	},
+	NotifyTypeRenewal: {
+		ID:      NotifyTypeRenewal,
+		Subject: "Your account {{.Username}} has been renewed",
+		Body: "<p>Dear {{.Realname}},</p>" +
+			"<p>Your account <b>{{.Username}}</b> has been renewed, the new expire time is {{.ExpireTime}}.</p>",
+	},
+}
+
+// NotifyLog
+// Every mail sent, Ref identifies the notified cycle, usually the expire time
+type NotifyLog struct {
+	ID         string    `bson:"_id" json:"id"`
+	Type       string    `bson:"type" json:"type"`
+	Username   string    `bson:"username" json:"username"`
+	Email      string    `bson:"email" json:"email"`
+	Subject    string    `bson:"subject" json:"subject"`
+	Ref        string    `bson:"ref" json:"ref"`
+	Status     string    `bson:"status" json:"status"`
+	Error      string    `bson:"error" json:"error"`
+	CreateTime time.Time `bson:"create_time" json:"create_time"`
+}
+
+// RenewalHistory
+type RenewalHistory struct {
+	ID         string    `bson:"_id" json:"id"`
+	Username   string    `bson:"username" json:"username"`
+	Times      int       `bson:"times" json:"times"`
+	Unit       string    `bson:"unit" json:"unit"`
+	OldExpire  time.Time `bson:"old_expire" json:"old_expire"`
+	NewExpire  time.Time `bson:"new_expire" json:"new_expire"`
+	Operator   string    `bson:"operator" json:"operator"`
+	Remark     string    `bson:"remark" json:"remark"`
+	CreateTime time.Time `bson:"create_time" json:"create_time"`
+}
+
+// NotifyData
+// The data of notify templates
+type NotifyData struct {
+	Username    string
+	Realname    string
+	Email       string
+	ExpireTime  string
+	ExpireDays  int
+	FlowQuota   int64
+	FlowUsed    int64
+	FlowPercent int
+	Subscribe   Subscribe
+}
+
+// NewNotifyData
+func NewNotifyData(user Subscribe) *NotifyData {
+	username := user.GetUsername()
+	expire := user.GetExpireTime()
+	return &NotifyData{
+		Username:   username,
+		Realname:   user.GetStringValue("realname", username),
+		Email:      user.GetStringValue("email", ""),
+		ExpireTime: timeutil.FmtDatetimeString(expire),
+		ExpireDays: int(time.Until(expire).Hours() / 24),
+		FlowQuota:  user.GetInt64Value("flow_quota", 0),
+		Subscribe:  user,
+	}
+}
+
+// RenderNotify
+// render the subject and body of a notify template, the body is html
+// so the user data is escaped
+func RenderNotify(t NotifyTemplate, data *NotifyData, funcMap map[string]interface{}) (string, string, error) {
+	ct := tpl.NewCommonTemplate(nil, false, funcMap)
+	if err := ct.ParseString("subject", t.Subject); err != nil {
+		return "", "", err
+	}
+	bt, err := htmltpl.New("body").Funcs(funcMap).Parse(t.Body)
+	if err != nil {
+		return "", "", err
+	}
+	subject, err := ct.RenderString("subject", data)
+	if err != nil {
+		return "", "", err
+	}
+	var body strings.Builder
+	err = bt.Execute(&body, data)
+	return subject, body.String(), err
+}
+
+// DeliverNotify
+// render the template and send it to the user's email, the subject is returned for the notify log
+func DeliverNotify(sender *gmail.MailSender, t NotifyTemplate, data *NotifyData, funcMap map[string]interface{}) (string, error) {
+	subject, body, err := RenderNotify(t, data, funcMap)
+	if err != nil {
+		return subject, err
+	}
+	if sender == nil {
+		return subject, fmt.Errorf("mail server not configured")
+	}
+	return subject, sender.SendMail([]string{data.Email}, subject, body, nil)
+}
+
+// ComputeRenewalExpire
+// extend the expire time by a period, an expired user is renewed from now
+func ComputeRenewalExpire(expire time.Time, times int, unit string) time.Time {
+	now := time.Now()
+	period := timeutil.ComputeEndTime(times, unit).Sub(now).Round(time.Second)
+	if expire.Before(now) {
+		expire = now
+	}
+	return expire.Add(period)
+}
+
+// NotifyManager
+type NotifyManager struct{ *ModelManager }
+
+func (m *ModelManager) GetNotifyManager() *NotifyManager {
+	store, _ := m.ManagerMap.Get("NotifyManager")
+	return store.(*NotifyManager)
+}
+
+// GetNotifyTemplate
+func (m *NotifyManager) GetNotifyTemplate(ntype string) (*NotifyTemplate, error) {
+	var t NotifyTemplate
+	err := m.GetTeamsAcsCollection(TeamsacsNotifyTemplate).FindOne(context.TODO(), bson.M{"_id": ntype}).Decode(&t)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		if dt, ok := DefaultNotifyTemplates[ntype]; ok {
+			return &dt, nil
+		}
+		return nil, fmt.Errorf("notify template %s not exists", ntype)
+	}
+	return &t, err
+}
+
+// QueryNotifyTemplates
+// stored templates, the defaults of missing types
+func (m *NotifyManager) QueryNotifyTemplates() ([]NotifyTemplate, error) {
+	var result = make([]NotifyTemplate, 0)
+	for _, ntype := range []string{NotifyTypeExpire, NotifyTypeQuota, NotifyTypeRenewal} {
+		t, err := m.GetNotifyTemplate(ntype)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, *t)
+	}
+	return result, nil
+}
+
+// UpdateNotifyTemplate
+func (m *NotifyManager) UpdateNotifyTemplate(t *NotifyTemplate) error {
+	if _, ok := DefaultNotifyTemplates[t.ID]; !ok {
+		return fmt.Errorf("notify type %s not support", t.ID)
+	}
+	if t.Subject == "" || t.Body == "" {
+		return fmt.Errorf("notify template subject and body can not be empty")
+	}
+	sample := NewNotifyData(Subscribe{"username": "test01", "expire_time": timeutil.FmtDatetimeString(time.Now())})
+	if _, _, err := RenderNotify(*t, sample, m.GetTemplateFuncMap()); err != nil {
+		return fmt.Errorf("notify template error, %s", err.Error())
+	}
+	t.UpdateTime = time.Now()
+	_, err := m.GetTeamsAcsCollection(TeamsacsNotifyTemplate).ReplaceOne(context.TODO(),
+		bson.M{"_id": t.ID}, t, options.Replace().SetUpsert(true))
+	return err
+}
+
+// QueryNotifyLogs
+func (m *NotifyManager) QueryNotifyLogs(params web.RequestParams) (*web.PageResult, error) {
+	return m.QueryPagerItems(params, TeamsacsNotifyLog)
+}
+
+// QueryRenewalHistory
+func (m *NotifyManager) QueryRenewalHistory(params web.RequestParams) (*web.PageResult, error) {
+	return m.QueryPagerItems(params, TeamsacsRenewalHistory)
+}
+
+// isNotified
+// a successful notify of the same cycle exists
+func (m *NotifyManager) isNotified(ntype, username, ref string) bool {
+	count, err := m.GetTeamsAcsCollection(TeamsacsNotifyLog).CountDocuments(context.TODO(),
+		bson.M{"type": ntype, "username": username, "ref": ref, "status": NotifyStatusSuccess})
+	return err == nil && count > 0
+}
+
+// SendNotify
+// render the template, send the mail and write the notify log
+func (m *NotifyManager) SendNotify(ntype string, data *NotifyData, ref string) error {
+	if data.Email == "" {
+		return fmt.Errorf("user %s email is empty", data.Username)
+	}
+	t, err := m.GetNotifyTemplate(ntype)
+	if err != nil {
+		return err
+	}
+	subject, err := DeliverNotify(m.MailSender, *t, data, m.GetTemplateFuncMap())
+	nlog := NotifyLog{
+		ID:         common.UUID(),
+		Type:       ntype,
+		Username:   data.Username,
+		Email:      data.Email,
+		Subject:    subject,
+		Ref:        ref,
+		Status:     NotifyStatusSuccess,
+		CreateTime: time.Now(),
+	}
+	if err != nil {
+		nlog.Status = NotifyStatusFailure
+		nlog.Error = err.Error()
+	}
+	if _, ierr := m.GetTeamsAcsCollection(TeamsacsNotifyLog).InsertOne(context.TODO(), nlog); ierr != nil {
+		log.Errorf("add notify log error, %s", ierr.Error())
+	}
+	return err
+}
+
+// notifyUsers
+// enabled subscribers with email
+func (m *NotifyManager) notifyUsers() ([]Subscribe, error) {
+	cur, err := m.GetTeamsAcsCollection(TeamsacsSubscribe).Find(context.TODO(),
+		bson.M{"status": constant.ENABLED, "email": bson.M{"$nin": []interface{}{"", nil}}})
+	if err != nil {
+		return nil, err
+	}
+	var users []Subscribe
+	err = cur.All(context.TODO(), &users)
+	return users, err
+}
+
+// RunExpireNotify
+// notify subscribers expiring in Notify.ExpireDays days, once per expire time
+func (m *NotifyManager) RunExpireNotify() {
+	users, err := m.notifyUsers()
+	if err != nil {
+		log.Errorf("query notify users error, %s", err.Error())
+		return
+	}
+	deadline := time.Now().Add(time.Hour * 24 * time.Duration(m.Config.Notify.ExpireDays))
+	for _, user := range users {
+		// GetExpireTime defaults to a minute later, a user without a valid expire time is skipped
+		expire := user.GetDateValue("expire_time", time.Time{})
+		if expire.IsZero() || expire.Before(time.Now()) || expire.After(deadline) {
+			continue
+		}
+		ref := user.GetStringValue("expire_time", "")
+		if m.isNotified(NotifyTypeExpire, user.GetUsername(), ref) {
+			continue
+		}
+		if err := m.SendNotify(NotifyTypeExpire, NewNotifyData(user), ref); err != nil {
+			log.Errorf("send expire notify to %s error, %s", user.GetUsername(), err.Error())
+		}
+	}
+}
+
+// RunQuotaNotify
+// notify subscribers over Notify.QuotaPercent of flow_quota, once per expire time
+func (m *NotifyManager) RunQuotaNotify() {
+	users, err := m.notifyUsers()
+	if err != nil {
+		log.Errorf("query notify users error, %s", err.Error())
+		return
+	}
+	for _, user := range users {
+		data := NewNotifyData(user)
+		if data.FlowQuota <= 0 || user.GetDateValue("expire_time", time.Time{}).IsZero() {
+			continue
+		}
+		ref := user.GetStringValue("expire_time", "")
+		if m.isNotified(NotifyTypeQuota, data.Username, ref) {
+			continue
+		}
+		used, err := m.GetRadiusManager().GetUserFlowTotal(data.Username)
+		if err != nil {
+			log.Errorf("query user %s flow error, %s", data.Username, err.Error())
+			continue
+		}
+		data.FlowUsed = used / 1024 / 1024
+		data.FlowPercent = int(data.FlowUsed * 100 / data.FlowQuota)
+		if data.FlowPercent < m.Config.Notify.QuotaPercent {
+			continue
+		}
+		if err := m.SendNotify(NotifyTypeQuota, data, ref); err != nil {
+			log.Errorf("send quota notify to %s error, %s", data.Username, err.Error())
+		}
+	}
+}
+
+// RunNotify
+func (m *NotifyManager) RunNotify() {
+	m.RunExpireNotify()
+	m.RunQuotaNotify()
+}
+
+// RenewSubscribe
+// extend the expire time by times * unit and write the renewal history
+func (m *NotifyManager) RenewSubscribe(username string, times int, unit string, operator string, remark string) (*RenewalHistory, error) {
+	if times <= 0 || !common.InSlice(unit, renewalUnits) {
+		return nil, fmt.Errorf("invalid renewal period %d %s", times, unit)
+	}
+	user, err := m.GetSubscribeManager().GetSubscribeByUser(username)
+	if err != nil {
+		return nil, fmt.Errorf("user %s not exists", username)
+	}
+	oldExpire := user.GetExpireTime()
+	newExpire := ComputeRenewalExpire(oldExpire, times, unit)
+	err = m.GetSubscribeManager().UpdateSubscribeByUsername(username, Attributes{"expire_time": newExpire.Format(subscribeDateLayout)})
+	if err != nil {
+		return nil, err
+	}
+	history := &RenewalHistory{
+		ID:         common.UUID(),
+		Username:   username,
+		Times:      times,
+		Unit:       unit,
+		OldExpire:  oldExpire,
+		NewExpire:  newExpire,
+		Operator:   operator,
+		Remark:     remark,
+		CreateTime: time.Now(),
+	}
+	if _, err = m.GetTeamsAcsCollection(TeamsacsRenewalHistory).InsertOne(context.TODO(), history); err != nil {
+		return nil, err
+	}
+	(*user)["expire_time"] = newExpire.Format(subscribeDateLayout)
+	data := NewNotifyData(*user)
+	if data.Email != "" && m.MailSender != nil {
+		if err := m.SendNotify(NotifyTypeRenewal, data, history.ID); err != nil {
+			log.Errorf("send renewal notify to %s error, %s", username, err.Error())
+		}
+	}
+	return history, nil
+}
@@ -0,0 +1,96 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package models
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ca17/teamsacs/common/gmail"
+	"github.com/ca17/teamsacs/common/gmail/mailtest"
+	"github.com/ca17/teamsacs/common/timeutil"
+)
+
+func TestDeliverNotify(t *testing.T) {
+	server, err := mailtest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	sender := &gmail.MailSender{Server: server.Host, Port: server.Port, Usernam: "noreply@teamsacs.com", Alias: "TeamsACS"}
+
+	expire := time.Now().Add(time.Hour * 24 * 3).Add(time.Minute)
+	user := Subscribe{
+		"username":    "test01",
+		"realname":    "Tester",
+		"email":       "test01@teamsacs.com",
+		"expire_time": timeutil.FmtDatetimeString(expire),
+	}
+	data := NewNotifyData(user)
+	if data.ExpireDays != 3 {
+		t.Fatalf("expire days %d != 3", data.ExpireDays)
+	}
+	subject, err := DeliverNotify(sender, DefaultNotifyTemplates[NotifyTypeExpire], data, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subject != "Your account test01 will expire in 3 days" {
+		t.Fatalf("subject error %s", subject)
+	}
+	messages := server.Messages()
+	if len(messages) != 1 || messages[0].To[0] != "test01@teamsacs.com" {
+		t.Fatalf("messages error %+v", messages)
+	}
+	if !strings.Contains(messages[0].Data, "Dear Tester") {
+		t.Fatal("body not rendered")
+	}
+
+	if _, err = DeliverNotify(nil, DefaultNotifyTemplates[NotifyTypeExpire], data, nil); err == nil {
+		t.Fatal("deliver without mail server must fail")
+	}
+	bad := NotifyTemplate{ID: NotifyTypeQuota, Subject: "{{.Unknown", Body: "body"}
+	if _, err = DeliverNotify(sender, bad, data, nil); err == nil {
+		t.Fatal("bad template must fail")
+	}
+}
+
+func TestRenderNotifyEscape(t *testing.T) {
+	data := NewNotifyData(Subscribe{"username": "test01", "realname": "<script>x</script>"})
+	_, body, err := RenderNotify(NotifyTemplate{Subject: "{{.Username}}", Body: "<p>Dear {{.Realname}}</p>"}, data, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != "<p>Dear &lt;script&gt;x&lt;/script&gt;</p>" {
+		t.Fatalf("body not escaped %s", body)
+	}
+}
+
+func TestComputeRenewalExpire(t *testing.T) {
+	now := time.Now()
+	// active user is extended from the current expire time
+	expire := now.Add(time.Hour * 24 * 10)
+	renewed := ComputeRenewalExpire(expire, 1, "month")
+	if d := renewed.Sub(expire); d < time.Hour*24*30-time.Second || d > time.Hour*24*30+time.Second {
+		t.Fatalf("renewal period error %s", d)
+	}
+	// expired user is extended from now
+	renewed = ComputeRenewalExpire(now.Add(-time.Hour*24*10), 7, "day")
+	if d := renewed.Sub(now); d < time.Hour*24*7-time.Second || d > time.Hour*24*7+time.Second {
+		t.Fatalf("renewal period error %s", d)
+	}
+}
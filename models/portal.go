@@ -0,0 +1,73 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package models
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// PortalSession
+// A user logged in by the portal web page, removed by logout or NTF_LOGOUT
+type PortalSession struct {
+	ID        string    `bson:"_id,omitempty" json:"id,omitempty"`
+	Username  string    `bson:"username" json:"username"`
+	NasAddr   string    `bson:"nas_addr" json:"nas_addr"`
+	Userip    string    `bson:"userip" json:"userip"`
+	Mode      string    `bson:"mode" json:"mode"`
+	LoginTime time.Time `bson:"login_time" json:"login_time"`
+}
+
+// Vpe portal attributes
+func (v DataObject) GetPortalSecret(defval string) string {
+	return v.GetStringValue("portal_secret", defval)
+}
+
+func (v DataObject) GetPortalVersion(defval int) int {
+	return v.GetIntValue("portal_version", defval)
+}
+
+func (v DataObject) GetPortalPort(defval int) int {
+	return v.GetIntValue("portal_port", defval)
+}
+
+// PortalManager
+type PortalManager struct{ *ModelManager }
+
+func (m *ModelManager) GetPortalManager() *PortalManager {
+	store, _ := m.ManagerMap.Get("PortalManager")
+	return store.(*PortalManager)
+}
+
+// AddPortalSession
+// one session per nas and user ip
+func (m *PortalManager) AddPortalSession(session *PortalSession) error {
+	coll := m.GetTeamsAcsCollection(TeamsacsPortalSession)
+	session.ID = session.NasAddr + "-" + session.Userip
+	_, err := coll.ReplaceOne(context.TODO(), bson.M{"_id": session.ID}, session, options.Replace().SetUpsert(true))
+	return err
+}
+
+// DeletePortalSession
+func (m *PortalManager) DeletePortalSession(nasaddr, userip string) error {
+	coll := m.GetTeamsAcsCollection(TeamsacsPortalSession)
+	_, err := coll.DeleteOne(context.TODO(), bson.M{"nas_addr": nasaddr, "userip": userip})
+	return err
+}
@@ -21,9 +21,9 @@ import (
 	"fmt"
 
 	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo/options"
 
+	"github.com/ca17/teamsacs/common"
 	"github.com/ca17/teamsacs/common/log"
 	"github.com/ca17/teamsacs/common/web"
 )
@@ -39,30 +39,33 @@ type SubscribeQueryForm struct {
 }
 
 
-func processQueryParams(params web.RequestParams, findOptions *options.FindOptions) bson.D {
-	var q = bson.D{}
-	for qname, val := range params.GetParamMap("filtermap") {
-		if qname == "remark" {
-			_filter := bson.D{{"$regex", primitive.Regex{Pattern: val.(string), Options: "i"}}}
-			q = append(q, bson.E{Key: qname, Value: _filter})
-		} else {
-			q = append(q, bson.E{Key: qname, Value: val})
-		}
+// buildQuery
+// the query of the filter, the values are coerced by the schema of the collection
+func (m *ModelManager) buildQuery(params web.RequestParams, collname string, findOptions *options.FindOptions) (bson.M, error) {
+	filter, err := ParseQueryFilter(params)
+	if err != nil {
+		return nil, err
 	}
-	for sname, sval := range params.GetParamMap("sortmap") {
-		if sval == "asc" {
-			findOptions.SetSort(bson.D{{sname, 1}})
-		} else if sval == "desc" {
-			findOptions.SetSort(bson.D{{sname, -1}})
-		}
+	schema, err := m.GetDataManager().GetDataSchema(collname)
+	if err != nil {
+		return nil, err
 	}
-	return q
+	return filter.apply(&filterContext{
+		Schema:     schema,
+		Location:   m.Location,
+		DateFields: filterDateFields[collname],
+		Contains:   []string{"remark"},
+		Secrets:    filterSecretFields[collname],
+	}, findOptions)
 }
 
 func (m *ModelManager) QueryItems(params web.RequestParams, collatiion string) (*web.QueryResult, error) {
 	var findOptions = options.Find()
 	coll := m.GetTeamsAcsCollection(collatiion)
-	q := processQueryParams(params, findOptions)
+	q, err := m.buildQuery(params, collatiion, findOptions)
+	if err != nil {
+		return nil, err
+	}
 	cur, err := coll.Find(context.TODO(), q, findOptions)
 	if err != nil {
 		return nil, err
@@ -91,9 +94,12 @@ func (m *ModelManager) QueryItemOptions(params web.RequestParams, collatiion str
 	if optionName == "" {
 		return jsonoptions, fmt.Errorf("option name is empty")
 	}
-	var q = bson.D{}
-	for qname, val := range params.GetParamMap("filtermap") {
-		q = append(q, bson.E{Key: qname, Value: val})
+	if common.InSlice(optionName, filterSecretFields[collatiion]) {
+		return jsonoptions, fmt.Errorf("invalid option name %s", optionName)
+	}
+	q, err := m.buildQuery(params, collatiion, findOptions)
+	if err != nil {
+		return nil, err
 	}
 
 	cur, err := coll.Find(context.TODO(), q, findOptions)
@@ -126,7 +132,10 @@ func (m *ModelManager) QueryPagerItems(params web.RequestParams, collatiion stri
 	findOptions.SetSkip(pos)
 	findOptions.SetLimit(params.GetInt64WithDefval("count", 40))
 	coll := m.GetTeamsAcsCollection(collatiion)
-	q := processQueryParams(params, findOptions)
+	q, err := m.buildQuery(params, collatiion, findOptions)
+	if err != nil {
+		return nil, err
+	}
 	cur, err := coll.Find(context.TODO(), q, findOptions)
 	if err != nil {
 		return nil, err
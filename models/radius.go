@@ -81,6 +81,31 @@ func (m *RadiusManager) GetOnlineCount(username string) (int64, error) {
 }
 
 
+// GetUserFlowTotal
+// input and output bytes of the user's accounting records and online sessions
+func (m *RadiusManager) GetUserFlowTotal(username string) (int64, error) {
+	var total int64
+	for _, collname := range []string{TeamsacsAccounting, TeamsacsOnline} {
+		cur, err := m.GetTeamsAcsCollection(collname).Aggregate(context.TODO(), []bson.M{
+			{"$match": bson.M{"username": username}},
+			{"$group": bson.M{"_id": nil, "total": bson.M{"$sum": bson.M{"$add": []string{"$acct_input_total", "$acct_output_total"}}}}},
+		})
+		if err != nil {
+			return 0, err
+		}
+		var result []struct {
+			Total int64 `bson:"total"`
+		}
+		if err = cur.All(context.TODO(), &result); err != nil {
+			return 0, err
+		}
+		if len(result) > 0 {
+			total += result[0].Total
+		}
+	}
+	return total, nil
+}
+
 func (m *RadiusManager) GetOnlineCountBySessionid(acct_session_id string) (int64, error) {
 	coll := m.GetTeamsAcsCollection(TeamsacsOnline)
 	return coll.CountDocuments(context.TODO(), bson.M{"acct_session_id": acct_session_id})
@@ -182,11 +207,10 @@ func getOutputTotal(form *web.WebForm) int64 {
 	return acctOutputOctets + acctOutputGigawords*4*1024*1024*1024
 }
 
-// 更新记账信息
-func (m *RadiusManager) UpdateRadiusOnline(form *web.WebForm) error {
-	var sessionId = form.GetVal2("acctSessionId", "")
-	var statusType = form.GetVal2("acctStatusType", "")
-	radOnline := Accounting{
+// NewRadiusOnlineFromForm
+// Parse the freeradius accounting form
+func NewRadiusOnlineFromForm(form *web.WebForm) Accounting {
+	return Accounting{
 		ID: common.UUID(),
 		Username:          form.GetVal("username"),
 		NasId:             form.GetVal("nasid"),
@@ -201,7 +225,7 @@ func (m *RadiusManager) UpdateRadiusOnline(form *web.WebForm) error {
 		NasPortId:         form.GetVal2("nasPortId", common.NA),
 		NasPortType:       0,
 		ServiceType:       0,
-		AcctSessionId:     sessionId,
+		AcctSessionId:     form.GetVal2("acctSessionId", ""),
 		AcctSessionTime:   form.GetIntVal("acctSessionTime", 0),
 		AcctInputTotal:    getInputTotal(form),
 		AcctOutputTotal:   getOutputTotal(form),
@@ -210,6 +234,13 @@ func (m *RadiusManager) UpdateRadiusOnline(form *web.WebForm) error {
 		AcctStartTime:     getAcctStartTime(form.GetVal2("acctSessionTime", "0")),
 		LastUpdate:       time.Now(),
 	}
+}
+
+// 更新记账信息
+func (m *RadiusManager) UpdateRadiusOnline(form *web.WebForm) error {
+	var sessionId = form.GetVal2("acctSessionId", "")
+	var statusType = form.GetVal2("acctStatusType", "")
+	radOnline := NewRadiusOnlineFromForm(form)
 	switch statusType {
 	case "Start", "Update", "Alive", "Interim-Update":
 		ocount, _ := m.GetOnlineCountBySessionid(sessionId)
@@ -0,0 +1,247 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package models
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/ca17/teamsacs/common/log"
+	"github.com/ca17/teamsacs/common/timeutil"
+	"github.com/ca17/teamsacs/constant"
+)
+
+const (
+	DefaultRadiuslogHistoryDays     = 365
+	DefaultRadiusAuthlogHistoryDays = 90
+
+	retentionBatchSize = 1000
+)
+
+// RetentionStatus
+// The result of the last retention run of a collection
+type RetentionStatus struct {
+	ID          string    `bson:"_id,omitempty" json:"id,omitempty"`
+	HistoryDays int64     `bson:"history_days" json:"history_days"`
+	Cutoff      time.Time `bson:"cutoff" json:"cutoff"`
+	LastRun     time.Time `bson:"last_run" json:"last_run"`
+	Cast        int64     `bson:"cast" json:"cast"`
+	Removed     int64     `bson:"removed" json:"removed"`
+	ArchiveFile string    `bson:"archive_file" json:"archive_file"`
+	Error       string    `bson:"error" json:"error"`
+}
+
+// RetentionManager
+// Purge expired authlog and accounting records by the history days settings
+type RetentionManager struct{ *ModelManager }
+
+func (m *ModelManager) GetRetentionManager() *RetentionManager {
+	store, _ := m.ManagerMap.Get("RetentionManager")
+	return store.(*RetentionManager)
+}
+
+// RunRetention
+// Scheduler entry, purge all radius history collections
+func (m *RetentionManager) RunRetention() {
+	for _, collname := range []string{TeamsacsAuthlog, TeamsacsAccounting} {
+		st, err := m.PurgeHistory(collname)
+		if err != nil {
+			log.Errorf("purge %s history error, %s", collname, err.Error())
+			continue
+		}
+		log.Infof("purge %s history done, removed %d records before %s", collname, st.Removed,
+			timeutil.FmtDatetimeString(st.Cutoff))
+	}
+}
+
+// PurgeHistory
+// Remove the records of authlog or accounting older than the history days,
+// if RadiusHistoryArchive is enabled, records are archived to backup dir before deleting.
+func (m *RetentionManager) PurgeHistory(collname string) (*RetentionStatus, error) {
+	var start = time.Now()
+	var cfg = m.GetConfigManager()
+	var days int64
+	var timefield string
+	switch collname {
+	case TeamsacsAuthlog:
+		days = cfg.GetRadiusConfigIntValue(constant.RadiusAuthlogHistoryDays, DefaultRadiusAuthlogHistoryDays)
+		timefield = "timestamp"
+	case TeamsacsAccounting:
+		days = cfg.GetRadiusConfigIntValue(constant.RadiuslogHistoryDays, DefaultRadiuslogHistoryDays)
+		timefield = "acct_stop_time"
+	default:
+		return nil, fmt.Errorf("collection %s not support retention", collname)
+	}
+
+	status := &RetentionStatus{ID: collname, HistoryDays: days, LastRun: start}
+	if days <= 0 {
+		// keep forever
+		return status, m.saveRetentionStatus(status)
+	}
+	status.Cutoff = start.Add(-time.Hour * 24 * time.Duration(days))
+	filter := bson.M{timefield: bson.M{"$lt": status.Cutoff}}
+
+	var err error
+	if cfg.GetRadiusConfigStringValue(constant.RadiusHistoryArchive, constant.DISABLED) == constant.ENABLED {
+		status.ArchiveFile, status.Removed, err = m.archiveAndDelete(collname, filter)
+	} else {
+		var r, derr = m.GetTeamsAcsCollection(collname).DeleteMany(context.TODO(), filter)
+		if derr == nil {
+			status.Removed = r.DeletedCount
+		}
+		err = derr
+	}
+	if err != nil {
+		status.Error = err.Error()
+	}
+	status.Cast = time.Since(start).Milliseconds()
+	if serr := m.saveRetentionStatus(status); serr != nil {
+		log.Error(serr)
+	}
+	return status, err
+}
+
+// archiveAndDelete
+// Write the matched records to a gzip compressed JSON-lines file (mongoimport compatible),
+// each batch is deleted only after it has been written.
+func (m *RetentionManager) archiveAndDelete(collname string, filter bson.M) (string, int64, error) {
+	coll := m.GetTeamsAcsCollection(collname)
+	count, err := coll.CountDocuments(context.TODO(), filter)
+	if err != nil || count == 0 {
+		return "", 0, err
+	}
+
+	filename := path.Join(m.Config.GetBackupDir(),
+		fmt.Sprintf("%s-%s.jsonl.gz", collname, timeutil.FmtDatetime14String(time.Now())))
+	archive, err := newArchiveWriter(filename)
+	if err != nil {
+		return "", 0, err
+	}
+	defer archive.Close()
+
+	cur, err := coll.Find(context.TODO(), filter, options.Find().SetBatchSize(retentionBatchSize))
+	if err != nil {
+		return filename, 0, err
+	}
+	defer cur.Close(context.TODO())
+
+	var removed int64
+	ids := bson.A{}
+	flush := func() error {
+		if len(ids) == 0 {
+			return nil
+		}
+		if err := archive.Flush(); err != nil {
+			return err
+		}
+		r, err := coll.DeleteMany(context.TODO(), bson.M{"_id": bson.M{"$in": ids}})
+		if err != nil {
+			return err
+		}
+		removed += r.DeletedCount
+		ids = bson.A{}
+		return nil
+	}
+	for cur.Next(context.TODO()) {
+		if err := archive.Write(cur.Current); err != nil {
+			return filename, removed, err
+		}
+		ids = append(ids, cur.Current.Lookup("_id"))
+		if len(ids) >= retentionBatchSize {
+			if err := flush(); err != nil {
+				return filename, removed, err
+			}
+		}
+	}
+	if err := cur.Err(); err != nil {
+		return filename, removed, err
+	}
+	return filename, removed, flush()
+}
+
+func (m *RetentionManager) saveRetentionStatus(status *RetentionStatus) error {
+	coll := m.GetTeamsAcsCollection(TeamsacsRetention)
+	_, err := coll.ReplaceOne(context.TODO(), bson.M{"_id": status.ID}, status, options.Replace().SetUpsert(true))
+	return err
+}
+
+// QueryRetentionStatus
+func (m *RetentionManager) QueryRetentionStatus() ([]RetentionStatus, error) {
+	coll := m.GetTeamsAcsCollection(TeamsacsRetention)
+	cur, err := coll.Find(context.TODO(), bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	items := make([]RetentionStatus, 0)
+	err = cur.All(context.TODO(), &items)
+	return items, err
+}
+
+// archiveWriter
+// gzip compressed JSON-lines file, one relaxed extended json document per line
+type archiveWriter struct {
+	file *os.File
+	gz   *gzip.Writer
+	buf  *bufio.Writer
+}
+
+func newArchiveWriter(filename string) (*archiveWriter, error) {
+	f, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+	gz := gzip.NewWriter(f)
+	return &archiveWriter{file: f, gz: gz, buf: bufio.NewWriter(gz)}, nil
+}
+
+func (w *archiveWriter) Write(doc bson.Raw) error {
+	bs, err := bson.MarshalExtJSON(doc, false, false)
+	if err != nil {
+		return err
+	}
+	if _, err = w.buf.Write(bs); err != nil {
+		return err
+	}
+	return w.buf.WriteByte('\n')
+}
+
+func (w *archiveWriter) Flush() error {
+	if err := w.buf.Flush(); err != nil {
+		return err
+	}
+	return w.gz.Flush()
+}
+
+func (w *archiveWriter) Close() error {
+	if err := w.Flush(); err != nil {
+		w.file.Close()
+		return err
+	}
+	if err := w.gz.Close(); err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
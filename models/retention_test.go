@@ -0,0 +1,81 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package models
+
+import (
+	"bufio"
+	"compress/gzip"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/ca17/teamsacs/common"
+)
+
+func TestArchiveWriter(t *testing.T) {
+	filename := path.Join(os.TempDir(), "authlog-test.jsonl.gz")
+	defer os.Remove(filename)
+	w, err := newArchiveWriter(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		raw, err := bson.Marshal(Authlog{
+			ID:        common.UUID(),
+			Username:  "test01",
+			Result:    "success",
+			Timestamp: time.Now(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = w.Write(raw); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lines = 0
+	scanner := bufio.NewScanner(gz)
+	for scanner.Scan() {
+		var item Authlog
+		if err := bson.UnmarshalExtJSON(scanner.Bytes(), false, &item); err != nil {
+			t.Fatal(err)
+		}
+		if item.Username != "test01" {
+			t.Fatal("archive username not match")
+		}
+		lines++
+	}
+	if lines != 3 {
+		t.Fatalf("archive lines %d != 3", lines)
+	}
+}
@@ -0,0 +1,230 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package models
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/ca17/teamsacs/common"
+	"github.com/ca17/teamsacs/common/aes"
+	"github.com/ca17/teamsacs/common/log"
+	"github.com/ca17/teamsacs/common/routeros"
+	"github.com/ca17/teamsacs/constant"
+)
+
+const (
+	RouterosDeviceCpe = "cpe"
+	RouterosDeviceVpe = "vpe"
+)
+
+// RouterosCredential
+// The api login of a CPE or VPE, the password is stored encrypted
+// in the api_password attribute of the device, the fingerprint pins
+// the api-ssl certificate
+type RouterosCredential struct {
+	Device      string `json:"device"`
+	Key         string `json:"key"`
+	Username    string `json:"username"`
+	Password    string `json:"password,omitempty"`
+	Port        int    `json:"port"`
+	Tls         bool   `json:"tls"`
+	Fingerprint string `json:"fingerprint"`
+}
+
+// Vpe and Cpe routeros api attributes
+func (v DataObject) GetApiUsername() string {
+	return v.GetStringValue("api_username", "")
+}
+
+func (v DataObject) GetApiTls() bool {
+	return v.GetStringValue("api_tls", "") == constant.ENABLED
+}
+
+func (v DataObject) GetApiFingerprint() string {
+	return v.GetStringValue("api_tls_fingerprint", "")
+}
+
+func (v DataObject) GetApiPort() int {
+	if v.GetApiTls() {
+		return v.GetIntValue("api_port", routeros.DefaultTlsPort)
+	}
+	return v.GetIntValue("api_port", routeros.DefaultPort)
+}
+
+// RouterosManager
+// Live operations of Mikrotik devices by the RouterOS API
+type RouterosManager struct{ *ModelManager }
+
+func (m *ModelManager) GetRouterosManager() *RouterosManager {
+	store, _ := m.ManagerMap.Get("RouterosManager")
+	return store.(*RouterosManager)
+}
+
+// getDevice
+// the CPE by sn or the VPE by identifier
+func (m *RouterosManager) getDevice(device, key string) (DataObject, string, error) {
+	var collname, field string
+	switch device {
+	case RouterosDeviceCpe:
+		collname, field = TeamsacsCpe, "sn"
+	case RouterosDeviceVpe:
+		collname, field = TeamsacsVpe, "identifier"
+	default:
+		return nil, "", fmt.Errorf("unsupported device type %s", device)
+	}
+	if common.IsEmptyOrNA(key) {
+		return nil, "", fmt.Errorf("%s %s is empty", device, field)
+	}
+	var result = DataObject{}
+	err := m.GetTeamsAcsCollection(collname).FindOne(context.TODO(), bson.M{field: key}).Decode(&result)
+	if err != nil {
+		return nil, "", fmt.Errorf("%s %s not found", device, key)
+	}
+	return result, collname, nil
+}
+
+// UpdateCredential
+// save the api login to the device attributes
+func (m *RouterosManager) UpdateCredential(cred *RouterosCredential) error {
+	_, collname, err := m.getDevice(cred.Device, cred.Key)
+	if err != nil {
+		return err
+	}
+	if cred.Username == "" {
+		return fmt.Errorf("username is empty")
+	}
+	if cred.Port < 0 || cred.Port > 65535 {
+		return fmt.Errorf("invalid port %d", cred.Port)
+	}
+	if cred.Fingerprint != "" && !routeros.ValidFingerprint(cred.Fingerprint) {
+		return fmt.Errorf("invalid certificate fingerprint %s", cred.Fingerprint)
+	}
+	attrs := bson.M{
+		"api_username":        cred.Username,
+		"api_tls":             constant.DISABLED,
+		"api_tls_fingerprint": routeros.NormalizeFingerprint(cred.Fingerprint),
+	}
+	if cred.Tls {
+		attrs["api_tls"] = constant.ENABLED
+	}
+	if cred.Port > 0 {
+		attrs["api_port"] = strconv.Itoa(cred.Port)
+	}
+	// an empty password keeps the stored one
+	if cred.Password != "" {
+		encpwd, err := aes.EncryptToB64(cred.Password, m.Config.System.Aeskey)
+		if err != nil {
+			return err
+		}
+		attrs["api_password"] = encpwd
+	}
+	field := "sn"
+	if cred.Device == RouterosDeviceVpe {
+		field = "identifier"
+	}
+	_, err = m.GetTeamsAcsCollection(collname).UpdateOne(context.TODO(), bson.M{field: cred.Key}, bson.M{"$set": attrs})
+	return err
+}
+
+// DeviceConfig
+// the api connection settings of the device attributes
+func (m *RouterosManager) DeviceConfig(dev DataObject) (routeros.Config, error) {
+	ipaddr := dev.GetStringValue("ipaddr", "")
+	if ipaddr == "" || dev.GetApiUsername() == "" {
+		return routeros.Config{}, fmt.Errorf("api login is not configured")
+	}
+	password, err := aes.DecryptFromB64(dev.GetStringValue("api_password", ""), m.Config.System.Aeskey)
+	if err != nil {
+		return routeros.Config{}, fmt.Errorf("api password decrypt error")
+	}
+	timeout := m.Config.Routeros.Timeout
+	if timeout <= 0 {
+		timeout = 10
+	}
+	return routeros.Config{
+		Address:     net.JoinHostPort(ipaddr, strconv.Itoa(dev.GetApiPort())),
+		Username:    dev.GetApiUsername(),
+		Password:    password,
+		Tls:         dev.GetApiTls(),
+		Fingerprint: dev.GetApiFingerprint(),
+		Insecure:    m.Config.Routeros.TlsInsecure,
+		Timeout:     time.Duration(timeout) * time.Second,
+	}, nil
+}
+
+// Dial
+// connect the device with the stored api login
+func (m *RouterosManager) Dial(device, key string) (*routeros.Client, error) {
+	dev, _, err := m.getDevice(device, key)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := m.DeviceConfig(dev)
+	if err != nil {
+		return nil, fmt.Errorf("%s %s %s", device, key, err.Error())
+	}
+	return routeros.Dial(cfg)
+}
+
+func (m *RouterosManager) run(device, key, command string, args map[string]string) ([]map[string]string, error) {
+	client, err := m.Dial(device, key)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+	reply, err := client.Exec(command, args)
+	if err != nil {
+		return nil, err
+	}
+	return reply.Re, nil
+}
+
+// QueryInterfaces
+func (m *RouterosManager) QueryInterfaces(device, key string) ([]map[string]string, error) {
+	return m.run(device, key, "/interface/print", nil)
+}
+
+// QueryPPPActive
+func (m *RouterosManager) QueryPPPActive(device, key string) ([]map[string]string, error) {
+	return m.run(device, key, "/ppp/active/print", nil)
+}
+
+// QueryQueues
+func (m *RouterosManager) QueryQueues(device, key string) ([]map[string]string, error) {
+	return m.run(device, key, "/queue/simple/print", nil)
+}
+
+// QueryDhcpLeases
+func (m *RouterosManager) QueryDhcpLeases(device, key string) ([]map[string]string, error) {
+	return m.run(device, key, "/ip/dhcp-server/lease/print", nil)
+}
+
+// ExecCommand
+// execute the command when it is in the allowlist
+func (m *RouterosManager) ExecCommand(device, key, command string, args map[string]string, operator string) ([]map[string]string, error) {
+	if !common.InSlice(command, m.Config.Routeros.Allowlist) {
+		return nil, fmt.Errorf("command %s is not allowed", command)
+	}
+	log.Infof("operator %s exec routeros command %s %v on %s %s", operator, command, args, device, key)
+	return m.run(device, key, command, args)
+}
@@ -0,0 +1,85 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package models
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/ca17/teamsacs/common/aes"
+	"github.com/ca17/teamsacs/common/routeros"
+	"github.com/ca17/teamsacs/common/routeros/rostest"
+	"github.com/ca17/teamsacs/config"
+)
+
+func TestRouterosDeviceConfig(t *testing.T) {
+	server, err := rostest.NewServer("api", "apipwd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	server.Handle("/ppp/active/print", func(args map[string]string) ([]map[string]string, error) {
+		return []map[string]string{{"name": "test01", "address": "10.10.0.2"}}, nil
+	})
+
+	m := &RouterosManager{&ModelManager{Config: &config.AppConfig{
+		System:   config.DefaultAppConfig.System,
+		Routeros: config.DefaultAppConfig.Routeros,
+	}}}
+	encpwd, err := aes.EncryptToB64("apipwd", m.Config.System.Aeskey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	host, port, _ := net.SplitHostPort(server.Addr())
+	dev := DataObject{"sn": "cpe01", "ipaddr": host, "api_port": port, "api_username": "api", "api_password": encpwd}
+	cfg, err := m.DeviceConfig(dev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Address != server.Addr() || cfg.Password != "apipwd" || cfg.Tls {
+		t.Fatalf("config error %+v", cfg)
+	}
+	client, err := routeros.Dial(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	reply, err := client.Run("/ppp/active/print")
+	if err != nil || len(reply.Re) != 1 || reply.Re[0]["name"] != "test01" {
+		t.Fatalf("reply error %+v %v", reply, err)
+	}
+
+	if _, err = m.DeviceConfig(DataObject{"sn": "cpe02", "ipaddr": host}); err == nil {
+		t.Fatal("device without api login must fail")
+	}
+	dev["api_tls"] = "enabled"
+	delete(dev, "api_port")
+	dev["api_tls_fingerprint"] = strings.Repeat("ab", 32)
+	if cfg, _ = m.DeviceConfig(dev); !cfg.Tls || !strings.HasSuffix(cfg.Address, ":8729") || cfg.Insecure ||
+		cfg.Fingerprint != strings.Repeat("ab", 32) {
+		t.Fatalf("tls config error %+v", cfg)
+	}
+}
+
+func TestRouterosExecAllowlist(t *testing.T) {
+	m := &RouterosManager{&ModelManager{Config: &config.AppConfig{Routeros: config.DefaultAppConfig.Routeros}}}
+	if _, err := m.ExecCommand(RouterosDeviceCpe, "cpe01", "/system/reboot", nil, "admin"); err == nil ||
+		!strings.Contains(err.Error(), "not allowed") {
+		t.Fatalf("expected not allowed, got %v", err)
+	}
+}
@@ -16,10 +16,110 @@
 
 package models
 
-import "github.com/go-co-op/gocron"
+import (
+	"github.com/go-co-op/gocron"
 
-func (m *ModelManager) StartScheduler()  {
+	"github.com/ca17/teamsacs/common/log"
+)
+
+func (m *ModelManager) StartScheduler() {
 	m.Sched = gocron.NewScheduler(m.Location)
-	<-m.Sched.Start()
+	m.setupSchedulerJobs()
+	m.schedStop = m.Sched.Start()
+}
+
+// StopScheduler
+// stop the scheduler ticker, running jobs are not interrupted
+func (m *ModelManager) StopScheduler() {
+	if m.schedStop != nil {
+		close(m.schedStop)
+		m.schedStop = nil
+	}
 }
 
+func (m *ModelManager) setupSchedulerJobs() {
+	// radius authlog & accounting retention
+	if _, err := m.Sched.Every(1).Day().At("03:30").Do(m.GetRetentionManager().RunRetention); err != nil {
+		log.Errorf("setup retention job error, %s", err.Error())
+	}
+
+	// accounting cdr export
+	if m.Config.Cdr.Enabled {
+		var interval = m.Config.Cdr.Interval
+		if interval <= 0 {
+			interval = 5
+		}
+		if _, err := m.Sched.Every(uint64(interval)).Minutes().Do(m.GetCdrManager().ExportIncremental); err != nil {
+			log.Errorf("setup cdr export job error, %s", err.Error())
+		}
+	}
+
+	// subscriber expire and quota notify
+	if m.Config.Notify.Enabled {
+		var interval = m.Config.Notify.Interval
+		if interval <= 0 {
+			interval = 60
+		}
+		if _, err := m.Sched.Every(uint64(interval)).Minutes().Do(m.GetNotifyManager().RunNotify); err != nil {
+			log.Errorf("setup notify job error, %s", err.Error())
+		}
+	}
+
+	// prepaid billing
+	if m.Config.Billing.Enabled {
+		var interval = m.Config.Billing.Interval
+		if interval <= 0 {
+			interval = 5
+		}
+		if _, err := m.Sched.Every(uint64(interval)).Minutes().Do(m.GetBillingManager().RunBilling); err != nil {
+			log.Errorf("setup billing job error, %s", err.Error())
+		}
+	}
+
+	// genieacs task status
+	if m.Config.Genieacs.Enabled {
+		var interval = m.Config.Genieacs.SyncInterval
+		if interval <= 0 {
+			interval = 30
+		}
+		if _, err := m.Sched.Every(uint64(interval)).Seconds().Do(m.GetGenieacsManager().RunCpeTaskSync); err != nil {
+			log.Errorf("setup genieacs task sync job error, %s", err.Error())
+		}
+
+		// cpe inventory from genieacs devices
+		var inventoryInterval = m.Config.Genieacs.InventoryInterval
+		if inventoryInterval <= 0 {
+			inventoryInterval = 10
+		}
+		if _, err := m.Sched.Every(uint64(inventoryInterval)).Minutes().Do(m.GetGenieacsManager().RunCpeInventorySync); err != nil {
+			log.Errorf("setup cpe inventory sync job error, %s", err.Error())
+		}
+
+		// firmware upgrade campaigns
+		if _, err := m.Sched.Every(1).Minute().Do(m.GetGenieacsManager().RunFirmwareCampaigns); err != nil {
+			log.Errorf("setup firmware campaign job error, %s", err.Error())
+		}
+	}
+
+	// cpe online status monitor
+	if m.Config.CpeMonitor.Enabled {
+		var interval = m.Config.CpeMonitor.Interval
+		if interval <= 0 {
+			interval = 60
+		}
+		if _, err := m.Sched.Every(uint64(interval)).Seconds().Do(m.GetGenieacsManager().RunCpeMonitor); err != nil {
+			log.Errorf("setup cpe monitor job error, %s", err.Error())
+		}
+	}
+
+	// cpe interface counters
+	if m.Config.IfStats.Enabled {
+		var interval = m.Config.IfStats.Interval
+		if interval <= 0 {
+			interval = 5
+		}
+		if _, err := m.Sched.Every(uint64(interval)).Minutes().Do(m.GetGenieacsManager().RunIfStatsCollect); err != nil {
+			log.Errorf("setup cpe interface stats job error, %s", err.Error())
+		}
+	}
+}
@@ -0,0 +1,219 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/ca17/teamsacs/common"
+	"github.com/ca17/teamsacs/common/jsonschema"
+)
+
+// ErrProtectedCollection
+// the collection is not managed by the generic data api
+var ErrProtectedCollection = errors.New("the collection is protected")
+
+// the system collections with their own apis, the secrets and the states
+var protectedCollections = []string{
+	TeamsacsConfig,
+	TeamsacsOperator,
+	TeamsacsDataSchema,
+	TeamsacsRetention,
+	TeamsacsCdrState,
+	TeamsacsWebhookDeadletter,
+	TeamsacsPortalSession,
+	TeamsacsVoucher,
+	TeamsacsBillingLedger,
+	TeamsacsBillingAccount,
+	TeamsacsWebhook,
+	TeamsacsVoucherBatch,
+	TeamsacsRenewalHistory,
+	TeamsacsTacacsAccounting,
+	TeamsacsTacacsUser,
+	TeamsacsSnmpUsmUser,
+	TeamsacsCpeStatus,
+	TeamsacsFirmwareCampaign,
+	TeamsacsCampaignDevice,
+	TeamsacsCpeProfile,
+	TeamsacsTariff,
+	TeamsacsTacacsGroup,
+	TeamsacsTacacsCmdset,
+	TeamsacsNotifyTemplate,
+	TeamsacsNotifyLog,
+	TeamsacsCpeTask,
+	TeamsacsCpeSyncHistory,
+	TeamsacsCpeParamSnapshot,
+	TeamsacsCpeStatusEvent,
+	TeamsacsCpeIfStat,
+	TeamsacsFlowStat,
+}
+
+// the collections decoded as DataObject, a map of strings, the values
+// coerced to the other types can not be decoded
+var stringCollections = []string{
+	TeamsacsSubscribe,
+	TeamsacsVpe,
+	TeamsacsCpe,
+}
+
+// DataSchema
+// The JSON Schema of a collection, saved as the JSON text because
+// the keywords like $schema are not valid field names
+type DataSchema struct {
+	ID         string    `bson:"_id" json:"id"`
+	Schema     string    `bson:"schema" json:"schema"`
+	Operator   string    `bson:"operator" json:"operator"`
+	UpdateTime time.Time `bson:"update_time" json:"update_time"`
+}
+
+// IsProtectedCollection
+func IsProtectedCollection(collname string) bool {
+	return common.InSlice(collname, protectedCollections)
+}
+
+// CheckDataCollection
+// the collection name must be valid and not protected
+func (m *DataManager) CheckDataCollection(collname string) error {
+	if collname == "" || !collectionNameRegexp.MatchString(collname) {
+		return fmt.Errorf("invalid collection name %s", collname)
+	}
+	if IsProtectedCollection(collname) {
+		return fmt.Errorf("%s: %w", collname, ErrProtectedCollection)
+	}
+	return nil
+}
+
+// GetDataSchema
+// the schema of the collection, nil if not defined
+func (m *DataManager) GetDataSchema(collname string) (*jsonschema.Schema, error) {
+	var item DataSchema
+	err := m.GetTeamsAcsCollection(TeamsacsDataSchema).FindOne(context.TODO(), bson.M{"_id": collname}).Decode(&item)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return jsonschema.Parse([]byte(item.Schema))
+}
+
+// SetDataSchema
+// check and save the schema of the collection
+func (m *DataManager) SetDataSchema(collname string, schema []byte, operator string) (*jsonschema.Schema, error) {
+	if err := m.CheckDataCollection(collname); err != nil {
+		return nil, err
+	}
+	s, err := jsonschema.Parse(schema)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkSchemaTypes(collname, s); err != nil {
+		return nil, err
+	}
+	item := DataSchema{ID: collname, Schema: string(schema), Operator: operator, UpdateTime: time.Now()}
+	_, err = m.GetTeamsAcsCollection(TeamsacsDataSchema).ReplaceOne(context.TODO(),
+		bson.M{"_id": collname}, item, options.Replace().SetUpsert(true))
+	return s, err
+}
+
+// DeleteDataSchema
+func (m *DataManager) DeleteDataSchema(collname string) error {
+	_, err := m.GetTeamsAcsCollection(TeamsacsDataSchema).DeleteOne(context.TODO(), bson.M{"_id": collname})
+	return err
+}
+
+// ValidateData
+// validate the document by the schema of the collection, the fields of the
+// error are prefixed, e.g. the row of an import
+func (m *DataManager) ValidateData(collname string, doc map[string]interface{}, partial bool, prefix string) (map[string]interface{}, error) {
+	if err := checkSecretFields(collname, doc, prefix); err != nil {
+		return nil, err
+	}
+	s, err := m.getWriteSchema(collname)
+	if err != nil {
+		return nil, err
+	}
+	if s != nil {
+		if doc, err = validateBySchema(s, doc, partial, prefix); err != nil {
+			return nil, err
+		}
+	}
+	markCpeIpaddrSource(collname, doc)
+	return doc, nil
+}
+
+// checkSecretFields
+// the secrets like the subscriber password are encrypted by the typed apis,
+// the generic data api can not write them
+func checkSecretFields(collname string, doc map[string]interface{}, prefix string) error {
+	var ferrs FieldErrors
+	for _, name := range sortedKeys(doc) {
+		if isSecretField(filterSecretFields[collname], name) {
+			ferrs.Add(prefix+name, "secret field can not be written by the data api")
+		}
+	}
+	return ferrs.Err()
+}
+
+// getWriteSchema
+// the schema to validate the documents, a schema saved before the types
+// were checked is refused
+func (m *DataManager) getWriteSchema(collname string) (*jsonschema.Schema, error) {
+	s, err := m.GetDataSchema(collname)
+	if err != nil || s == nil {
+		return s, err
+	}
+	return s, checkSchemaTypes(collname, s)
+}
+
+// checkSchemaTypes
+// the properties of a string collection must be strings
+func checkSchemaTypes(collname string, s *jsonschema.Schema) error {
+	if !common.InSlice(collname, stringCollections) {
+		return nil
+	}
+	var ferrs FieldErrors
+	names := make([]string, 0, len(s.Properties))
+	for name := range s.Properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		prop := s.Properties[name]
+		if prop != nil && len(prop.Type) > 0 && (len(prop.Type) != 1 || !prop.Type.Has("string")) {
+			ferrs.Add(name, "the values of %s are strings, the type must be string", collname)
+		}
+	}
+	return ferrs.Err()
+}
+
+func validateBySchema(s *jsonschema.Schema, doc map[string]interface{}, partial bool, prefix string) (map[string]interface{}, error) {
+	result, errs := s.Validate(doc, partial)
+	var ferrs FieldErrors
+	for _, e := range errs {
+		ferrs = append(ferrs, FieldError{Field: prefix + e.Path, Message: e.Message})
+	}
+	return result, ferrs.Err()
+}
@@ -0,0 +1,66 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package models
+
+import (
+	"testing"
+
+	"github.com/ca17/teamsacs/common/jsonschema"
+)
+
+func TestDataSchema(t *testing.T) {
+	m := &DataManager{}
+	if err := m.CheckDataCollection("operator"); err == nil {
+		t.Fatal("operator is protected")
+	}
+	if err := m.CheckDataCollection(TeamsacsCpeProfile); err == nil {
+		t.Fatal("cpe_profile is protected")
+	}
+	if err := m.CheckDataCollection("olt$"); err == nil {
+		t.Fatal("invalid collection name")
+	}
+	if err := m.CheckDataCollection("olt"); err != nil {
+		t.Fatal(err)
+	}
+	s, err := jsonschema.Parse([]byte(`{"type": "object", "required": ["name"], "properties": {"ports": {"type": "integer"}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, err := validateBySchema(s, map[string]interface{}{"name": "olt01", "ports": "16"}, false, "")
+	if err != nil || doc["ports"] != int64(16) {
+		t.Fatal(doc, err)
+	}
+	if err = checkSchemaTypes("olt", s); err != nil {
+		t.Fatal(err)
+	}
+	ferrs, ok := checkSchemaTypes(TeamsacsVpe, s).(FieldErrors)
+	if !ok || len(ferrs) != 1 || ferrs[0].Field != "ports" {
+		t.Fatal("the properties of vpe must be strings")
+	}
+	ferrs, ok = checkSecretFields(TeamsacsVpe, map[string]interface{}{"name": "bras", "tacacs_secret": "x", "secret": "y"}, "[1].").(FieldErrors)
+	if !ok || len(ferrs) != 2 || ferrs[0].Field != "[1].secret" || ferrs[1].Field != "[1].tacacs_secret" {
+		t.Fatal("the secrets of vpe can not be written", ferrs)
+	}
+	if err = checkSecretFields(TeamsacsSubscribe, map[string]interface{}{"username": "u1"}, ""); err != nil {
+		t.Fatal(err)
+	}
+	_, err = validateBySchema(s, map[string]interface{}{"ports": "x"}, false, "[3].")
+	ferrs, ok = err.(FieldErrors)
+	if !ok || len(ferrs) != 2 || ferrs[0].Field != "[3].name" || ferrs[1].Field != "[3].ports" {
+		t.Fatal(err)
+	}
+}
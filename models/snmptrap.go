@@ -0,0 +1,306 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package models
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/ca17/teamsacs/common"
+	"github.com/ca17/teamsacs/common/aes"
+	"github.com/ca17/teamsacs/common/log"
+	"github.com/ca17/teamsacs/common/web"
+	"github.com/ca17/teamsacs/constant"
+)
+
+var (
+	SnmpAuthProtocols = []string{"NoAuth", "MD5", "SHA", "SHA224", "SHA256", "SHA384", "SHA512"}
+	SnmpPrivProtocols = []string{"NoPriv", "DES", "AES", "AES192", "AES256", "AES192C", "AES256C"}
+)
+
+// SnmpTrap
+// A received trap linked to the sending VPE or CPE
+type SnmpTrap struct {
+	ID           string        `bson:"_id,omitempty" json:"id,omitempty"`
+	Version      string        `bson:"version" json:"version"`
+	Community    string        `bson:"community,omitempty" json:"community,omitempty"`
+	Username     string        `bson:"username,omitempty" json:"username,omitempty"`
+	Source       string        `bson:"source" json:"source"`
+	AgentAddress string        `bson:"agent_address,omitempty" json:"agent_address,omitempty"`
+	VpeName      string        `bson:"vpe_name,omitempty" json:"vpe_name,omitempty"`
+	CpeSn        string        `bson:"cpe_sn,omitempty" json:"cpe_sn,omitempty"`
+	TrapOid      string        `bson:"trap_oid" json:"trap_oid"`
+	TrapName     string        `bson:"trap_name" json:"trap_name"`
+	Uptime       uint32        `bson:"uptime" json:"uptime"`
+	Varbinds     []TrapVarbind `bson:"varbinds" json:"varbinds"`
+	Timestamp    time.Time     `bson:"timestamp" json:"timestamp"`
+}
+
+// TrapVarbind
+type TrapVarbind struct {
+	Oid   string `bson:"oid" json:"oid"`
+	Name  string `bson:"name" json:"name"`
+	Type  string `bson:"type" json:"type"`
+	Value string `bson:"value" json:"value"`
+}
+
+// SnmpUsmUser
+// SNMPv3 USM user of the trap receiver, passphrases are AES encrypted
+type SnmpUsmUser struct {
+	ID             string    `bson:"_id,omitempty" json:"id,omitempty"`
+	Username       string    `bson:"username" json:"username"`
+	AuthProtocol   string    `bson:"auth_protocol" json:"auth_protocol"`
+	AuthPassphrase string    `bson:"auth_passphrase" json:"auth_passphrase,omitempty"`
+	PrivProtocol   string    `bson:"priv_protocol" json:"priv_protocol"`
+	PrivPassphrase string    `bson:"priv_passphrase" json:"priv_passphrase,omitempty"`
+	Status         string    `bson:"status" json:"status"`
+	Remark         string    `bson:"remark" json:"remark"`
+	UpdateTime     time.Time `bson:"update_time" json:"update_time"`
+}
+
+func (u *SnmpUsmUser) Validate() error {
+	if u.Username == "" {
+		return fmt.Errorf("usm username can not be empty")
+	}
+	if u.AuthProtocol == "" {
+		u.AuthProtocol = "NoAuth"
+	}
+	if u.PrivProtocol == "" {
+		u.PrivProtocol = "NoPriv"
+	}
+	if !common.InSlice(u.AuthProtocol, SnmpAuthProtocols) {
+		return fmt.Errorf("auth protocol must be one of %v", SnmpAuthProtocols)
+	}
+	if !common.InSlice(u.PrivProtocol, SnmpPrivProtocols) {
+		return fmt.Errorf("priv protocol must be one of %v", SnmpPrivProtocols)
+	}
+	if u.PrivProtocol != "NoPriv" && u.AuthProtocol == "NoAuth" {
+		return fmt.Errorf("privacy requires authentication")
+	}
+	if u.Status == "" {
+		u.Status = constant.ENABLED
+	}
+	return nil
+}
+
+// Cpe ip address
+func (m *CpeManager) GetCpeByIpaddr(ip string) (*Cpe, error) {
+	var result = new(Cpe)
+	err := m.GetTeamsAcsCollection(TeamsacsCpe).FindOne(context.TODO(), bson.M{"ipaddr": ip}).Decode(result)
+	return result, err
+}
+
+// SetupSnmpTrapDB
+// capped collection like syslog
+func (m *ModelManager) SetupSnmpTrapDB() {
+	var capped = true
+	var size = int64(1024 * 1024 * 64)
+	var max = int64(m.Config.Snmptrapd.MaxRecodes)
+	if max <= 0 {
+		max = 100000
+	}
+	_ = m.Mongo.Database(MDBTeamsacs).CreateCollection(context.TODO(), TeamsacsSnmpTrap, &options.CreateCollectionOptions{
+		Capped:       &capped,
+		MaxDocuments: &max,
+		SizeInBytes:  &size,
+	})
+}
+
+// SnmpTrapManager
+type SnmpTrapManager struct{ *ModelManager }
+
+func (m *ModelManager) GetSnmpTrapManager() *SnmpTrapManager {
+	store, _ := m.ManagerMap.Get("SnmpTrapManager")
+	return store.(*SnmpTrapManager)
+}
+
+// CorrelateTrap
+// link the trap to the VPE or CPE of the source ip
+func (m *SnmpTrapManager) CorrelateTrap(trap *SnmpTrap) {
+	if vpe, err := m.GetVpeManager().GetVpeByIpaddr(trap.Source); err == nil {
+		trap.VpeName = vpe.GetStringValue("name", trap.Source)
+		return
+	}
+	if cpe, err := m.GetCpeManager().GetCpeByIpaddr(trap.Source); err == nil {
+		trap.CpeSn = cpe.GetStringValue("sn", "")
+	}
+}
+
+// AddSnmpTrap
+func (m *SnmpTrapManager) AddSnmpTrap(trap *SnmpTrap) {
+	trap.ID = common.UUID()
+	if _, err := m.GetTeamsAcsCollection(TeamsacsSnmpTrap).InsertOne(context.TODO(), trap); err != nil {
+		log.Error(err)
+	}
+}
+
+// QuerySnmpTrap
+// the same filter, sort and paging semantics as QuerySyslog, trap_name is matched by contains
+func (m *SnmpTrapManager) QuerySnmpTrap(params web.RequestParams) (*web.PageResult, error) {
+	var findOptions = options.Find()
+	var pos = params.GetInt64WithDefval("start", 0)
+	findOptions.SetSkip(pos)
+	findOptions.SetLimit(params.GetInt64WithDefval("count", 40))
+	findOptions.SetSort(bson.D{{"timestamp", -1}})
+	coll := m.GetTeamsAcsCollection(TeamsacsSnmpTrap)
+	q, err := m.snmpTrapQuery(params, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	cur, err := coll.Find(context.TODO(), q, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	total, err := coll.CountDocuments(context.TODO(), q, options.Count())
+	if err != nil {
+		return nil, err
+	}
+	items := make([]map[string]interface{}, 0)
+	for cur.Next(context.TODO()) {
+		var elem map[string]interface{}
+		err := cur.Decode(&elem)
+		if err != nil {
+			log.Error(err)
+		} else {
+			items = append(items, elem)
+		}
+	}
+	return &web.PageResult{TotalCount: total, Pos: pos, Data: items}, nil
+}
+
+// snmpTrapQuery
+// the query of the trap filter, the values are escaped by the filter operators
+func (m *SnmpTrapManager) snmpTrapQuery(params web.RequestParams, findOptions *options.FindOptions) (bson.M, error) {
+	filter, err := ParseQueryFilter(params)
+	if err != nil {
+		return nil, err
+	}
+	return filter.apply(&filterContext{
+		Location:   m.Location,
+		DateFields: filterDateFields[TeamsacsSnmpTrap],
+		Contains:   []string{"trap_name"},
+	}, findOptions)
+}
+
+// QuerySnmpUsmUsers
+// the passphrases are not returned
+func (m *SnmpTrapManager) QuerySnmpUsmUsers(params web.RequestParams) (*web.PageResult, error) {
+	data, err := m.QueryPagerItems(params, TeamsacsSnmpUsmUser)
+	if err != nil {
+		return nil, err
+	}
+	if items, ok := data.Data.([]map[string]interface{}); ok {
+		for _, item := range items {
+			delete(item, "auth_passphrase")
+			delete(item, "priv_passphrase")
+		}
+	}
+	return data, nil
+}
+
+func (m *SnmpTrapManager) encryptUsmUser(user *SnmpUsmUser) error {
+	var err error
+	aeskey := m.Config.System.Aeskey
+	if user.AuthPassphrase != "" {
+		if user.AuthPassphrase, err = aes.EncryptToB64(user.AuthPassphrase, aeskey); err != nil {
+			return err
+		}
+	}
+	if user.PrivPassphrase != "" {
+		if user.PrivPassphrase, err = aes.EncryptToB64(user.PrivPassphrase, aeskey); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddSnmpUsmUser
+func (m *SnmpTrapManager) AddSnmpUsmUser(user *SnmpUsmUser) (string, error) {
+	if err := user.Validate(); err != nil {
+		return "", err
+	}
+	coll := m.GetTeamsAcsCollection(TeamsacsSnmpUsmUser)
+	if count, _ := coll.CountDocuments(context.TODO(), bson.M{"username": user.Username}); count > 0 {
+		return "", fmt.Errorf("usm user %s already exists", user.Username)
+	}
+	if err := m.encryptUsmUser(user); err != nil {
+		return "", err
+	}
+	user.ID = common.UUID()
+	user.UpdateTime = time.Now()
+	_, err := coll.InsertOne(context.TODO(), user)
+	return user.ID, err
+}
+
+// UpdateSnmpUsmUser
+// passphrases are changed when not empty
+func (m *SnmpTrapManager) UpdateSnmpUsmUser(user *SnmpUsmUser) error {
+	if err := user.Validate(); err != nil {
+		return err
+	}
+	if err := m.encryptUsmUser(user); err != nil {
+		return err
+	}
+	valmap := bson.M{
+		"auth_protocol": user.AuthProtocol,
+		"priv_protocol": user.PrivProtocol,
+		"status":        user.Status,
+		"remark":        user.Remark,
+		"update_time":   time.Now(),
+	}
+	if user.AuthPassphrase != "" {
+		valmap["auth_passphrase"] = user.AuthPassphrase
+	}
+	if user.PrivPassphrase != "" {
+		valmap["priv_passphrase"] = user.PrivPassphrase
+	}
+	_, err := m.GetTeamsAcsCollection(TeamsacsSnmpUsmUser).UpdateOne(context.TODO(), bson.M{"_id": user.ID}, bson.M{"$set": valmap})
+	return err
+}
+
+// DeleteSnmpUsmUser
+func (m *SnmpTrapManager) DeleteSnmpUsmUser(id string) error {
+	_, err := m.GetTeamsAcsCollection(TeamsacsSnmpUsmUser).DeleteOne(context.TODO(), bson.M{"_id": id})
+	return err
+}
+
+// GetEnabledUsmUsers
+// enabled users with decrypted passphrases
+func (m *SnmpTrapManager) GetEnabledUsmUsers() ([]SnmpUsmUser, error) {
+	cur, err := m.GetTeamsAcsCollection(TeamsacsSnmpUsmUser).Find(context.TODO(), bson.M{"status": constant.ENABLED})
+	if err != nil {
+		return nil, err
+	}
+	var users []SnmpUsmUser
+	if err = cur.All(context.TODO(), &users); err != nil {
+		return nil, err
+	}
+	aeskey := m.Config.System.Aeskey
+	for i := range users {
+		if users[i].AuthPassphrase != "" {
+			users[i].AuthPassphrase, _ = aes.DecryptFromB64(users[i].AuthPassphrase, aeskey)
+		}
+		if users[i].PrivPassphrase != "" {
+			users[i].PrivPassphrase, _ = aes.DecryptFromB64(users[i].PrivPassphrase, aeskey)
+		}
+	}
+	return users, nil
+}
@@ -33,6 +33,12 @@ func (a Subscribe) GetExpireTime() time.Time {
 	return a.GetDateValue("expire_time", time.Now().Add(time.Second*60))
 }
 
+// GetStartTime
+// the user is not valid before the start time, zero time if not set
+func (a Subscribe) GetStartTime() time.Time {
+	return a.GetDateValue("start_time", time.Time{})
+}
+
 func (a Subscribe) GetInterimInterval() int {
 	return a.GetIntValue("interim_interval", 120)
 }
@@ -135,6 +141,6 @@ func (m *SubscribeManager) GetSubscribeByMac(mac string) (*Subscribe, error) {
 // UpdateSubscribeByUsername
 func (m *SubscribeManager) UpdateSubscribeByUsername(username string, valmap map[string]interface{}) error {
 	coll := m.GetTeamsAcsCollection(TeamsacsSubscribe)
-	_, err := coll.UpdateOne(context.TODO(), bson.M{"username": username}, valmap)
+	_, err := coll.UpdateOne(context.TODO(), bson.M{"username": username}, bson.M{"$set": valmap})
 	return err
 }
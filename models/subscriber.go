@@ -0,0 +1,514 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/ca17/teamsacs/common"
+	"github.com/ca17/teamsacs/common/aes"
+	"github.com/ca17/teamsacs/common/log"
+	"github.com/ca17/teamsacs/common/web"
+	"github.com/ca17/teamsacs/constant"
+)
+
+const (
+	SubscriberActionEnable  = "enable"
+	SubscriberActionDisable = "disable"
+	SubscriberActionExtend  = "extend"
+
+	subscriberPasswordChars = "ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnpqrstuvwxyz23456789"
+	subscriberMaxRateKbps   = 10 * 1000 * 1000
+)
+
+var (
+	subscriberNameRegexp  = regexp.MustCompile(`^[a-zA-Z0-9@._\-]{1,64}$`)
+	subscriberEmailRegexp = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
+	subscriberTimeLayouts = []string{"2006-01-02 15:04:05", "2006-01-02", subscribeDateLayout, time.RFC3339}
+)
+
+// FieldError
+// The validation error of a request field
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// FieldErrors
+// All validation errors of a request
+type FieldErrors []FieldError
+
+func (e FieldErrors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, fe := range e {
+		msgs = append(msgs, fmt.Sprintf("%s: %s", fe.Field, fe.Message))
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Add
+func (e *FieldErrors) Add(field, format string, args ...interface{}) {
+	*e = append(*e, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// Err
+// nil without errors
+func (e FieldErrors) Err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// SubscriberForm
+// The typed fields of a subscriber, the rates are Kbps, a subscriber is
+// stored with string values like the other data objects
+type SubscriberForm struct {
+	Username        string `json:"username"`
+	Password        string `json:"password"`
+	Realname        string `json:"realname"`
+	Email           string `json:"email"`
+	Mobile          string `json:"mobile"`
+	Status          string `json:"status"`
+	Domain          string `json:"domain"`
+	AddrPool        string `json:"addr_pool"`
+	Ipaddr          string `json:"ipaddr"`
+	MacAddr         string `json:"mac_addr"`
+	MacBind         int    `json:"mac_bind"`
+	UpRate          int    `json:"up_rate"`
+	DownRate        int    `json:"down_rate"`
+	LimitPolicy     string `json:"limit_policy"`
+	UpLimitPolicy   string `json:"up_limit_policy"`
+	DownLimitPolicy string `json:"down_limit_policy"`
+	ActiveNum       int    `json:"active_num"`
+	InterimInterval int    `json:"interim_interval"`
+	FlowQuota       int64  `json:"flow_quota"`
+	StartTime       string `json:"start_time"`
+	ExpireTime      string `json:"expire_time"`
+	CpeSn           string `json:"cpe_sn"`
+	Remark          string `json:"remark"`
+}
+
+// SubscriberBulkResult
+type SubscriberBulkResult struct {
+	Action  string   `json:"action"`
+	Updated int      `json:"updated"`
+	Missing []string `json:"missing"`
+}
+
+// parseSubscriberTime
+// a date, a datetime in the location, or a datetime with zone
+func parseSubscriberTime(v string, loc *time.Location) (time.Time, error) {
+	for _, layout := range subscriberTimeLayouts {
+		if t, err := time.ParseInLocation(layout, v, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time format, expected yyyy-mm-dd hh:mm:ss")
+}
+
+// Validate
+// check the fields, the password is required when requirePassword,
+// the times are normalized to the stored format
+func (f *SubscriberForm) Validate(requirePassword bool, loc *time.Location) error {
+	var errs FieldErrors
+	if !subscriberNameRegexp.MatchString(f.Username) {
+		errs.Add("username", "must be 1-64 letters, digits or @._-")
+	}
+	if requirePassword && f.Password == "" {
+		errs.Add("password", "can not be empty")
+	}
+	if len(f.Password) > 128 {
+		errs.Add("password", "at most 128 characters")
+	}
+	if f.Status == "" {
+		f.Status = constant.ENABLED
+	}
+	if f.Status != constant.ENABLED && f.Status != constant.DISABLED {
+		errs.Add("status", "must be enabled or disabled")
+	}
+	if f.Email != "" && !subscriberEmailRegexp.MatchString(f.Email) {
+		errs.Add("email", "invalid email address")
+	}
+	if f.Ipaddr != "" {
+		if ip := net.ParseIP(f.Ipaddr); ip == nil || ip.To4() == nil {
+			errs.Add("ipaddr", "invalid ipv4 address")
+		}
+	}
+	if f.MacAddr != "" {
+		if hw, err := net.ParseMAC(f.MacAddr); err != nil || len(hw) != 6 {
+			errs.Add("mac_addr", "invalid mac address")
+		} else {
+			f.MacAddr = hw.String()
+		}
+	}
+	if f.MacBind != 0 && f.MacBind != 1 {
+		errs.Add("mac_bind", "must be 0 or 1")
+	}
+	if f.UpRate < 0 || f.UpRate > subscriberMaxRateKbps {
+		errs.Add("up_rate", "must be 0-%d Kbps", subscriberMaxRateKbps)
+	}
+	if f.DownRate < 0 || f.DownRate > subscriberMaxRateKbps {
+		errs.Add("down_rate", "must be 0-%d Kbps", subscriberMaxRateKbps)
+	}
+	if f.ActiveNum < 0 {
+		errs.Add("active_num", "can not be negative")
+	}
+	if f.InterimInterval != 0 && (f.InterimInterval < 60 || f.InterimInterval > 86400) {
+		errs.Add("interim_interval", "must be 60-86400 seconds")
+	}
+	if f.FlowQuota < 0 {
+		errs.Add("flow_quota", "can not be negative")
+	}
+	var start, expire time.Time
+	var err error
+	if f.StartTime != "" {
+		if start, err = parseSubscriberTime(f.StartTime, loc); err != nil {
+			errs.Add("start_time", err.Error())
+		} else {
+			f.StartTime = start.Format(subscribeDateLayout)
+		}
+	}
+	if f.ExpireTime == "" {
+		errs.Add("expire_time", "can not be empty")
+	} else if expire, err = parseSubscriberTime(f.ExpireTime, loc); err != nil {
+		errs.Add("expire_time", err.Error())
+	} else {
+		f.ExpireTime = expire.Format(subscribeDateLayout)
+		if !start.IsZero() && !expire.After(start) {
+			errs.Add("expire_time", "must be after the start time")
+		}
+	}
+	return errs.Err()
+}
+
+// document
+// the stored fields without the password
+func (f *SubscriberForm) document() bson.M {
+	return bson.M{
+		"username":          f.Username,
+		"realname":          f.Realname,
+		"email":             f.Email,
+		"mobile":            f.Mobile,
+		"status":            f.Status,
+		"domain":            f.Domain,
+		"addr_pool":         f.AddrPool,
+		"ipaddr":            f.Ipaddr,
+		"mac_addr":          f.MacAddr,
+		"mac_bind":          strconv.Itoa(f.MacBind),
+		"up_rate":           strconv.Itoa(f.UpRate),
+		"down_rate":         strconv.Itoa(f.DownRate),
+		"limit_policy":      f.LimitPolicy,
+		"up_limit_policy":   f.UpLimitPolicy,
+		"down_limit_policy": f.DownLimitPolicy,
+		"active_num":        strconv.Itoa(f.ActiveNum),
+		"interim_interval":  strconv.Itoa(f.InterimInterval),
+		"flow_quota":        strconv.FormatInt(f.FlowQuota, 10),
+		"start_time":        f.StartTime,
+		"expire_time":       f.ExpireTime,
+		"cpe_sn":            f.CpeSn,
+		"remark":            f.Remark,
+	}
+}
+
+// subscriberForm
+// the form of a stored subscriber, the update requests are applied on it
+func subscriberForm(a Attributes) *SubscriberForm {
+	p := web.RequestParams(a)
+	return &SubscriberForm{
+		Username:        p.GetString("username"),
+		Realname:        p.GetString("realname"),
+		Email:           p.GetString("email"),
+		Mobile:          p.GetString("mobile"),
+		Status:          p.GetString("status"),
+		Domain:          p.GetString("domain"),
+		AddrPool:        p.GetString("addr_pool"),
+		Ipaddr:          p.GetString("ipaddr"),
+		MacAddr:         p.GetString("mac_addr"),
+		MacBind:         int(p.GetInt64("mac_bind")),
+		UpRate:          int(p.GetInt64("up_rate")),
+		DownRate:        int(p.GetInt64("down_rate")),
+		LimitPolicy:     p.GetString("limit_policy"),
+		UpLimitPolicy:   p.GetString("up_limit_policy"),
+		DownLimitPolicy: p.GetString("down_limit_policy"),
+		ActiveNum:       int(p.GetInt64("active_num")),
+		InterimInterval: int(p.GetInt64("interim_interval")),
+		FlowQuota:       p.GetInt64("flow_quota"),
+		StartTime:       p.GetString("start_time"),
+		ExpireTime:      p.GetString("expire_time"),
+		CpeSn:           p.GetString("cpe_sn"),
+		Remark:          p.GetString("remark"),
+	}
+}
+
+// SetupSubscribeDB
+// the unique indexes back the username and mac address checks against concurrent writes
+func (m *ModelManager) SetupSubscribeDB() {
+	_, err := m.GetTeamsAcsCollection(TeamsacsSubscribe).Indexes().CreateMany(context.TODO(), []mongo.IndexModel{
+		{Keys: bson.D{{"username", 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{"mac_addr", 1}}, Options: options.Index().SetUnique(true).
+			SetPartialFilterExpression(bson.M{"mac_addr": bson.M{"$gt": ""}})},
+	})
+	if err != nil {
+		log.Errorf("create subscribe indexes error, %s", err.Error())
+	}
+}
+
+// isDuplicateKeyError
+func isDuplicateKeyError(err error) bool {
+	var werrs []mongo.WriteError
+	switch e := err.(type) {
+	case mongo.WriteException:
+		werrs = e.WriteErrors
+	case mongo.BulkWriteException:
+		for _, we := range e.WriteErrors {
+			werrs = append(werrs, we.WriteError)
+		}
+	}
+	for _, we := range werrs {
+		if we.Code == 11000 {
+			return true
+		}
+	}
+	return false
+}
+
+// uniqueError
+// the field error of a write refused by the unique indexes
+func uniqueError(f *SubscriberForm, err error) error {
+	if !isDuplicateKeyError(err) {
+		return err
+	}
+	var errs FieldErrors
+	if strings.Contains(err.Error(), "mac_addr") {
+		errs.Add("mac_addr", "%s is used by another subscriber", f.MacAddr)
+	} else {
+		errs.Add("username", "%s already exists", f.Username)
+	}
+	return errs.Err()
+}
+
+func (m *SubscribeManager) exists(filter bson.M) bool {
+	count, err := m.GetTeamsAcsCollection(TeamsacsSubscribe).CountDocuments(context.TODO(), filter)
+	return err == nil && count > 0
+}
+
+// checkUnique
+// the username and mac address of other subscribers
+func (m *SubscribeManager) checkUnique(f *SubscriberForm, isNew bool) error {
+	var errs FieldErrors
+	if isNew && m.exists(bson.M{"username": f.Username}) {
+		errs.Add("username", "%s already exists", f.Username)
+	}
+	if f.MacAddr != "" && m.exists(bson.M{"username": bson.M{"$ne": f.Username},
+		"mac_addr": equalFoldFilter(f.MacAddr)}) {
+		errs.Add("mac_addr", "%s is used by another subscriber", f.MacAddr)
+	}
+	return errs.Err()
+}
+
+// equalFoldFilter
+// a case insensitive equality filter
+func equalFoldFilter(v string) bson.M {
+	return bson.M{"$regex": "^" + regexp.QuoteMeta(v) + "$", "$options": "i"}
+}
+
+// AddSubscriber
+// validate and add the subscriber, the password is stored encrypted
+func (m *SubscribeManager) AddSubscriber(f *SubscriberForm) (string, error) {
+	if err := f.Validate(true, m.Location); err != nil {
+		return "", err
+	}
+	if err := m.checkUnique(f, true); err != nil {
+		return "", err
+	}
+	encpwd, err := aes.EncryptToB64(f.Password, m.Config.System.Aeskey)
+	if err != nil {
+		return "", err
+	}
+	doc := f.document()
+	doc["_id"] = common.UUID()
+	doc["password"] = encpwd
+	doc["update_time"] = time.Now().Format(subscribeDateLayout)
+	_, err = m.GetTeamsAcsCollection(TeamsacsSubscribe).InsertOne(context.TODO(), doc)
+	if err != nil {
+		return "", uniqueError(f, err)
+	}
+	return doc["_id"].(string), nil
+}
+
+// UpdateSubscriber
+// the fields present in the json request are applied on the stored
+// subscriber, the omitted fields are kept, the password is changed when not empty
+func (m *SubscribeManager) UpdateSubscriber(data []byte) error {
+	var req struct {
+		Username string `json:"username"`
+	}
+	if err := json.Unmarshal(data, &req); err != nil {
+		return err
+	}
+	var stored Attributes
+	err := m.GetTeamsAcsCollection(TeamsacsSubscribe).FindOne(context.TODO(), bson.M{"username": req.Username}).Decode(&stored)
+	if err != nil {
+		return fmt.Errorf("subscriber %s not exists", req.Username)
+	}
+	f := subscriberForm(stored)
+	if err = json.Unmarshal(data, f); err != nil {
+		return err
+	}
+	if err = f.Validate(false, m.Location); err != nil {
+		return err
+	}
+	if f.Username != req.Username {
+		return fmt.Errorf("the username can not be changed")
+	}
+	if err = m.checkUnique(f, false); err != nil {
+		return err
+	}
+	doc := f.document()
+	if f.Password != "" {
+		encpwd, err := aes.EncryptToB64(f.Password, m.Config.System.Aeskey)
+		if err != nil {
+			return err
+		}
+		doc["password"] = encpwd
+	}
+	doc["update_time"] = time.Now().Format(subscribeDateLayout)
+	if err = m.UpdateSubscribeByUsername(f.Username, doc); err != nil {
+		return uniqueError(f, err)
+	}
+	return nil
+}
+
+// GetSubscriber
+// the subscriber without the password
+func (m *SubscribeManager) GetSubscriber(username string) (Attributes, error) {
+	var result Attributes
+	err := m.GetTeamsAcsCollection(TeamsacsSubscribe).FindOne(context.TODO(), bson.M{"username": username}).Decode(&result)
+	if err != nil {
+		return nil, fmt.Errorf("subscriber %s not exists", username)
+	}
+	delete(result, "password")
+	return result, nil
+}
+
+// DeleteSubscriber
+func (m *SubscribeManager) DeleteSubscriber(username string) error {
+	result, err := m.GetTeamsAcsCollection(TeamsacsSubscribe).DeleteOne(context.TODO(), bson.M{"username": username})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return fmt.Errorf("subscriber %s not exists", username)
+	}
+	return nil
+}
+
+// RevealSubscriberPassword
+// the decrypted password
+func (m *SubscribeManager) RevealSubscriberPassword(username string) (string, error) {
+	user, err := m.GetSubscribeByUser(username)
+	if err != nil {
+		return "", fmt.Errorf("subscriber %s not exists", username)
+	}
+	password, err := aes.DecryptFromB64(user.GetPassword(), m.Config.System.Aeskey)
+	if err != nil {
+		return "", fmt.Errorf("subscriber %s password is not encrypted by the server key", username)
+	}
+	return password, nil
+}
+
+// ResetSubscriberPassword
+// set the password, a random password is generated when empty
+func (m *SubscribeManager) ResetSubscriberPassword(username, password string) (string, error) {
+	if !m.exists(bson.M{"username": username}) {
+		return "", fmt.Errorf("subscriber %s not exists", username)
+	}
+	var err error
+	if password == "" {
+		if password, err = randomString(subscriberPasswordChars, 10); err != nil {
+			return "", err
+		}
+	}
+	if len(password) > 128 {
+		return "", FieldErrors{{Field: "password", Message: "at most 128 characters"}}
+	}
+	encpwd, err := aes.EncryptToB64(password, m.Config.System.Aeskey)
+	if err != nil {
+		return "", err
+	}
+	err = m.UpdateSubscribeByUsername(username, bson.M{"password": encpwd, "update_time": time.Now().Format(subscribeDateLayout)})
+	return password, err
+}
+
+// BulkUpdateSubscribers
+// enable, disable, or extend the expire time by times * unit with the renewal history
+func (m *SubscribeManager) BulkUpdateSubscribers(usernames []string, action string, times int, unit, operator, remark string) (*SubscriberBulkResult, error) {
+	if len(usernames) == 0 {
+		return nil, FieldErrors{{Field: "usernames", Message: "can not be empty"}}
+	}
+	result := &SubscriberBulkResult{Action: action, Missing: make([]string, 0)}
+	switch action {
+	case SubscriberActionEnable, SubscriberActionDisable:
+		status := constant.ENABLED
+		if action == SubscriberActionDisable {
+			status = constant.DISABLED
+		}
+		coll := m.GetTeamsAcsCollection(TeamsacsSubscribe)
+		r, err := coll.UpdateMany(context.TODO(), bson.M{"username": bson.M{"$in": usernames}},
+			bson.M{"$set": bson.M{"status": status, "update_time": time.Now().Format(subscribeDateLayout)}})
+		if err != nil {
+			return nil, err
+		}
+		result.Updated = int(r.MatchedCount)
+		if result.Updated < len(usernames) {
+			for _, username := range usernames {
+				if !m.exists(bson.M{"username": username}) {
+					result.Missing = append(result.Missing, username)
+				}
+			}
+		}
+	case SubscriberActionExtend:
+		if times <= 0 || !common.InSlice(unit, renewalUnits) {
+			return nil, FieldErrors{{Field: "unit", Message: fmt.Sprintf("invalid renewal period %d %s", times, unit)}}
+		}
+		for _, username := range usernames {
+			if !m.exists(bson.M{"username": username}) {
+				result.Missing = append(result.Missing, username)
+				continue
+			}
+			if _, err := m.GetNotifyManager().RenewSubscribe(username, times, unit, operator, remark); err != nil {
+				return result, err
+			}
+			result.Updated++
+		}
+	default:
+		return nil, FieldErrors{{Field: "action", Message: "must be enable, disable or extend"}}
+	}
+	return result, nil
+}
@@ -0,0 +1,91 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package models
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestSubscriberFormValidate(t *testing.T) {
+	loc, _ := time.LoadLocation("Asia/Shanghai")
+	f := &SubscriberForm{
+		Username:   "test01",
+		Password:   "111111",
+		MacAddr:    "AA-BB-CC-DD-EE-FF",
+		Ipaddr:     "10.10.0.2",
+		UpRate:     10240,
+		DownRate:   20480,
+		StartTime:  "2020-01-01",
+		ExpireTime: "2021-12-31 23:59:59",
+	}
+	if err := f.Validate(true, loc); err != nil {
+		t.Fatal(err)
+	}
+	if f.Status != "enabled" || f.MacAddr != "aa:bb:cc:dd:ee:ff" {
+		t.Fatal(f.Status, f.MacAddr)
+	}
+	if f.ExpireTime != "2021-12-31 23:59:59 +0800 CST" {
+		t.Fatal(f.ExpireTime)
+	}
+	user := Subscribe(map[string]string{"expire_time": f.ExpireTime})
+	if !user.GetExpireTime().Equal(time.Date(2021, 12, 31, 23, 59, 59, 0, loc)) {
+		t.Fatal(user.GetExpireTime())
+	}
+
+	f = &SubscriberForm{
+		Username:   "bad user",
+		MacAddr:    "aa:bb",
+		Ipaddr:     "10.10.0.256",
+		UpRate:     -1,
+		Status:     "locked",
+		StartTime:  "2021-01-01",
+		ExpireTime: "2020-01-01",
+	}
+	err := f.Validate(true, loc)
+	errs, ok := err.(FieldErrors)
+	if !ok {
+		t.Fatal(err)
+	}
+	fields := make(map[string]bool)
+	for _, fe := range errs {
+		fields[fe.Field] = true
+	}
+	for _, name := range []string{"username", "password", "mac_addr", "ipaddr", "up_rate", "status", "expire_time"} {
+		if !fields[name] {
+			t.Fatal("expected error of", name, errs)
+		}
+	}
+	if err := (&SubscriberForm{Username: "test01", ExpireTime: "31/12/2021"}).Validate(false, loc); err == nil {
+		t.Fatal("expected expire_time error")
+	}
+}
+
+func TestSubscriberFormPartialUpdate(t *testing.T) {
+	stored := Attributes{"username": "test01", "status": "enabled", "mac_addr": "00:11:22:33:44:55",
+		"up_rate": "2048", "down_rate": "4096", "flow_quota": "1073741824", "expire_time": "2021-12-31 23:59:59"}
+	f := subscriberForm(stored)
+	if err := json.Unmarshal([]byte(`{"username": "test01", "down_rate": 8192}`), f); err != nil {
+		t.Fatal(err)
+	}
+	doc := f.document()
+	if doc["down_rate"] != "8192" || doc["up_rate"] != "2048" || doc["flow_quota"] != "1073741824" ||
+		doc["mac_addr"] != "00:11:22:33:44:55" || doc["expire_time"] != "2021-12-31 23:59:59" {
+		t.Fatal(doc)
+	}
+}
@@ -18,10 +18,9 @@ package models
 
 import (
 	"context"
+	"strings"
 	"time"
 
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo/options"
 
 	"github.com/ca17/teamsacs/common/log"
@@ -51,21 +50,18 @@ func (m *OperatorManager) QuerySyslog(params web.RequestParams) (*web.PageResult
 	findOptions.SetSkip(pos)
 	findOptions.SetLimit(params.GetInt64WithDefval("count", 40))
 	coll := m.GetTeamsAcsCollection(TeamsacsSyslog)
-	var q = bson.D{}
-	for qname, val := range params.GetParamMap("filtermap") {
-		if qname == "Message" {
-			_filter := bson.D{{"$regex", primitive.Regex{Pattern: val.(string), Options: "i"}}}
-			q = append(q, bson.E{Key: "attrs."+qname, Value: _filter})
-		} else {
-			q = append(q, bson.E{Key: "attrs."+qname, Value: val})
-		}
+	filter, err := ParseQueryFilter(params)
+	if err != nil {
+		return nil, err
 	}
-	for sname, sval := range params.GetParamMap("sortmap") {
-		if sval == "asc" {
-			findOptions.SetSort(bson.D{{"attrs."+sname, 1}})
-		} else if sval == "desc" {
-			findOptions.SetSort(bson.D{{"attrs."+sname, -1}})
-		}
+	q, err := filter.apply(&filterContext{
+		Location:   m.Location,
+		DateFields: filterDateFields[TeamsacsSyslog],
+		Contains:   []string{"Message"},
+		Path:       syslogFieldPath,
+	}, findOptions)
+	if err != nil {
+		return nil, err
 	}
 	cur, err := coll.Find(context.TODO(), q, findOptions)
 	if err != nil {
@@ -88,3 +84,12 @@ func (m *OperatorManager) QuerySyslog(params web.RequestParams) (*web.PageResult
 	}
 	return &web.PageResult{TotalCount: total, Pos: pos, Data: items}, nil
 }
+
+// syslogFieldPath
+// the fields except the timestamp and the logtype are attributes
+func syslogFieldPath(field string) string {
+	if field == "_id" || field == "timestamp" || field == "logtype" || strings.HasPrefix(field, "attrs.") {
+		return field
+	}
+	return "attrs." + field
+}
@@ -0,0 +1,412 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/ca17/teamsacs/common"
+	"github.com/ca17/teamsacs/common/aes"
+	"github.com/ca17/teamsacs/common/web"
+	"github.com/ca17/teamsacs/constant"
+)
+
+const (
+	TacacsActionPermit = "permit"
+	TacacsActionDeny   = "deny"
+)
+
+// TacacsUser
+// Device administrator, the password is AES encrypted
+type TacacsUser struct {
+	ID         string    `bson:"_id,omitempty" json:"id,omitempty"`
+	Username   string    `bson:"username" json:"username"`
+	Password   string    `bson:"password" json:"-"`
+	Group      string    `bson:"group" json:"group"`
+	Status     string    `bson:"status" json:"status"`
+	Remark     string    `bson:"remark" json:"remark"`
+	UpdateTime time.Time `bson:"update_time" json:"update_time"`
+}
+
+// TacacsGroup
+// Admin group with the privilege level and the command set
+type TacacsGroup struct {
+	ID         string `bson:"_id,omitempty" json:"id,omitempty"`
+	Name       string `bson:"name" json:"name"`
+	PrivLvl    int    `bson:"priv_lvl" json:"priv_lvl"`
+	CommandSet string `bson:"command_set" json:"command_set"`
+	Remark     string `bson:"remark" json:"remark"`
+}
+
+// TacacsCommandSet
+// Deny regexes are checked first, then permit regexes, the default action applies when none matches,
+// a regex must match the whole command line
+type TacacsCommandSet struct {
+	ID            string   `bson:"_id,omitempty" json:"id,omitempty"`
+	Name          string   `bson:"name" json:"name"`
+	Permit        []string `bson:"permit" json:"permit"`
+	Deny          []string `bson:"deny" json:"deny"`
+	DefaultAction string   `bson:"default_action" json:"default_action"`
+	Remark        string   `bson:"remark" json:"remark"`
+}
+
+// TacacsAccounting
+// Command accounting record
+type TacacsAccounting struct {
+	ID         string    `bson:"_id,omitempty" json:"id,omitempty"`
+	Username   string    `bson:"username" json:"username"`
+	NasAddr    string    `bson:"nas_addr" json:"nas_addr"`
+	Port       string    `bson:"port" json:"port"`
+	RemAddr    string    `bson:"rem_addr" json:"rem_addr"`
+	Type       string    `bson:"type" json:"type"`
+	TaskId     string    `bson:"task_id" json:"task_id"`
+	Service    string    `bson:"service" json:"service"`
+	PrivLvl    int       `bson:"priv_lvl" json:"priv_lvl"`
+	Command    string    `bson:"command" json:"command"`
+	Args       []string  `bson:"args" json:"args"`
+	CreateTime time.Time `bson:"create_time" json:"create_time"`
+}
+
+func (s *TacacsCommandSet) Validate() error {
+	if s.Name == "" {
+		return fmt.Errorf("command set name can not be empty")
+	}
+	if s.DefaultAction == "" {
+		s.DefaultAction = TacacsActionDeny
+	}
+	if s.DefaultAction != TacacsActionPermit && s.DefaultAction != TacacsActionDeny {
+		return fmt.Errorf("default action must be permit or deny")
+	}
+	for _, expr := range append(append([]string{}, s.Permit...), s.Deny...) {
+		if _, err := compileTacacsCmd(expr); err != nil {
+			return fmt.Errorf("invalid command regex %s, %s", expr, err.Error())
+		}
+	}
+	return nil
+}
+
+// Authorize
+// check the command line by the deny and permit regexes
+func (s *TacacsCommandSet) Authorize(cmd string) bool {
+	for _, expr := range s.Deny {
+		if re, err := compileTacacsCmd(expr); err == nil && re.MatchString(cmd) {
+			return false
+		}
+	}
+	for _, expr := range s.Permit {
+		if re, err := compileTacacsCmd(expr); err == nil && re.MatchString(cmd) {
+			return true
+		}
+	}
+	return s.DefaultAction == TacacsActionPermit
+}
+
+// the compiled command regexes by the pattern
+var tacacsCmdRegexps sync.Map
+
+// compileTacacsCmd
+// anchor the command regex, a pattern is compiled once when the command set
+// is saved or first authorized
+func compileTacacsCmd(expr string) (*regexp.Regexp, error) {
+	if re, ok := tacacsCmdRegexps.Load(expr); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile("^(?:" + expr + ")$")
+	if err != nil {
+		return nil, err
+	}
+	tacacsCmdRegexps.Store(expr, re)
+	return re, nil
+}
+
+// Vpe tacacs+ key, the radius secret by default
+func (v DataObject) GetTacacsSecret() string {
+	return v.GetStringValue("tacacs_secret", v.GetSecret())
+}
+
+// TacacsManager
+type TacacsManager struct{ *ModelManager }
+
+func (m *ModelManager) GetTacacsManager() *TacacsManager {
+	store, _ := m.ManagerMap.Get("TacacsManager")
+	return store.(*TacacsManager)
+}
+
+func (m *TacacsManager) exists(collname string, filter bson.M) bool {
+	count, err := m.GetTeamsAcsCollection(collname).CountDocuments(context.TODO(), filter)
+	return err == nil && count > 0
+}
+
+// TacacsUserForm
+// The request of a tacacs user, the password is clear text
+type TacacsUserForm struct {
+	TacacsUser
+	Password string `json:"password"`
+}
+
+// User
+func (f *TacacsUserForm) User() *TacacsUser {
+	user := f.TacacsUser
+	user.Password = f.Password
+	return &user
+}
+
+// QueryTacacsUsers
+// the passwords are not returned
+func (m *TacacsManager) QueryTacacsUsers(params web.RequestParams) (*web.PageResult, error) {
+	data, err := m.QueryPagerItems(params, TeamsacsTacacsUser)
+	if err != nil {
+		return nil, err
+	}
+	if items, ok := data.Data.([]map[string]interface{}); ok {
+		for _, item := range items {
+			delete(item, "password")
+		}
+	}
+	return data, nil
+}
+
+// AddTacacsUser
+func (m *TacacsManager) AddTacacsUser(user *TacacsUser) (string, error) {
+	if user.Username == "" || user.Password == "" {
+		return "", fmt.Errorf("username and password can not be empty")
+	}
+	if m.exists(TeamsacsTacacsUser, bson.M{"username": user.Username}) {
+		return "", fmt.Errorf("tacacs user %s already exists", user.Username)
+	}
+	if !m.exists(TeamsacsTacacsGroup, bson.M{"name": user.Group}) {
+		return "", fmt.Errorf("tacacs group %s not exists", user.Group)
+	}
+	encpwd, err := aes.EncryptToB64(user.Password, m.Config.System.Aeskey)
+	if err != nil {
+		return "", err
+	}
+	user.ID = common.UUID()
+	user.Password = encpwd
+	if user.Status == "" {
+		user.Status = constant.ENABLED
+	}
+	user.UpdateTime = time.Now()
+	_, err = m.GetTeamsAcsCollection(TeamsacsTacacsUser).InsertOne(context.TODO(), user)
+	return user.ID, err
+}
+
+// UpdateTacacsUser
+// the password is changed when not empty
+func (m *TacacsManager) UpdateTacacsUser(user *TacacsUser) error {
+	if user.Status == "" {
+		user.Status = constant.ENABLED
+	}
+	if !m.exists(TeamsacsTacacsGroup, bson.M{"name": user.Group}) {
+		return fmt.Errorf("tacacs group %s not exists", user.Group)
+	}
+	valmap := bson.M{"group": user.Group, "status": user.Status, "remark": user.Remark, "update_time": time.Now()}
+	if user.Password != "" {
+		encpwd, err := aes.EncryptToB64(user.Password, m.Config.System.Aeskey)
+		if err != nil {
+			return err
+		}
+		valmap["password"] = encpwd
+	}
+	result, err := m.GetTeamsAcsCollection(TeamsacsTacacsUser).UpdateOne(context.TODO(), bson.M{"_id": user.ID}, bson.M{"$set": valmap})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("tacacs user %s not exists", user.ID)
+	}
+	return nil
+}
+
+// DeleteTacacsUser
+func (m *TacacsManager) DeleteTacacsUser(id string) error {
+	_, err := m.GetTeamsAcsCollection(TeamsacsTacacsUser).DeleteOne(context.TODO(), bson.M{"_id": id})
+	return err
+}
+
+// QueryTacacsGroups
+func (m *TacacsManager) QueryTacacsGroups(params web.RequestParams) (*web.PageResult, error) {
+	return m.QueryPagerItems(params, TeamsacsTacacsGroup)
+}
+
+func (m *TacacsManager) validateGroup(group *TacacsGroup) error {
+	if group.Name == "" {
+		return fmt.Errorf("group name can not be empty")
+	}
+	if group.PrivLvl < 0 || group.PrivLvl > 15 {
+		return fmt.Errorf("privilege level must be 0-15")
+	}
+	if group.CommandSet != "" && !m.exists(TeamsacsTacacsCmdset, bson.M{"name": group.CommandSet}) {
+		return fmt.Errorf("command set %s not exists", group.CommandSet)
+	}
+	return nil
+}
+
+// AddTacacsGroup
+func (m *TacacsManager) AddTacacsGroup(group *TacacsGroup) (string, error) {
+	if err := m.validateGroup(group); err != nil {
+		return "", err
+	}
+	if m.exists(TeamsacsTacacsGroup, bson.M{"name": group.Name}) {
+		return "", fmt.Errorf("tacacs group %s already exists", group.Name)
+	}
+	group.ID = common.UUID()
+	_, err := m.GetTeamsAcsCollection(TeamsacsTacacsGroup).InsertOne(context.TODO(), group)
+	return group.ID, err
+}
+
+// UpdateTacacsGroup
+func (m *TacacsManager) UpdateTacacsGroup(group *TacacsGroup) error {
+	if err := m.validateGroup(group); err != nil {
+		return err
+	}
+	if m.exists(TeamsacsTacacsGroup, bson.M{"name": group.Name, "_id": bson.M{"$ne": group.ID}}) {
+		return fmt.Errorf("tacacs group %s already exists", group.Name)
+	}
+	_, err := m.GetTeamsAcsCollection(TeamsacsTacacsGroup).ReplaceOne(context.TODO(), bson.M{"_id": group.ID}, group)
+	return err
+}
+
+// DeleteTacacsGroup
+// groups in use can not be deleted
+func (m *TacacsManager) DeleteTacacsGroup(id string) error {
+	var group TacacsGroup
+	err := m.GetTeamsAcsCollection(TeamsacsTacacsGroup).FindOne(context.TODO(), bson.M{"_id": id}).Decode(&group)
+	if err != nil {
+		return err
+	}
+	if m.exists(TeamsacsTacacsUser, bson.M{"group": group.Name}) {
+		return fmt.Errorf("tacacs group %s is in use", group.Name)
+	}
+	_, err = m.GetTeamsAcsCollection(TeamsacsTacacsGroup).DeleteOne(context.TODO(), bson.M{"_id": id})
+	return err
+}
+
+// QueryTacacsCommandSets
+func (m *TacacsManager) QueryTacacsCommandSets(params web.RequestParams) (*web.PageResult, error) {
+	return m.QueryPagerItems(params, TeamsacsTacacsCmdset)
+}
+
+// AddTacacsCommandSet
+func (m *TacacsManager) AddTacacsCommandSet(cmdset *TacacsCommandSet) (string, error) {
+	if err := cmdset.Validate(); err != nil {
+		return "", err
+	}
+	if m.exists(TeamsacsTacacsCmdset, bson.M{"name": cmdset.Name}) {
+		return "", fmt.Errorf("command set %s already exists", cmdset.Name)
+	}
+	cmdset.ID = common.UUID()
+	_, err := m.GetTeamsAcsCollection(TeamsacsTacacsCmdset).InsertOne(context.TODO(), cmdset)
+	return cmdset.ID, err
+}
+
+// UpdateTacacsCommandSet
+func (m *TacacsManager) UpdateTacacsCommandSet(cmdset *TacacsCommandSet) error {
+	if err := cmdset.Validate(); err != nil {
+		return err
+	}
+	if m.exists(TeamsacsTacacsCmdset, bson.M{"name": cmdset.Name, "_id": bson.M{"$ne": cmdset.ID}}) {
+		return fmt.Errorf("command set %s already exists", cmdset.Name)
+	}
+	_, err := m.GetTeamsAcsCollection(TeamsacsTacacsCmdset).ReplaceOne(context.TODO(), bson.M{"_id": cmdset.ID}, cmdset)
+	return err
+}
+
+// DeleteTacacsCommandSet
+func (m *TacacsManager) DeleteTacacsCommandSet(id string) error {
+	var cmdset TacacsCommandSet
+	err := m.GetTeamsAcsCollection(TeamsacsTacacsCmdset).FindOne(context.TODO(), bson.M{"_id": id}).Decode(&cmdset)
+	if err != nil {
+		return err
+	}
+	if m.exists(TeamsacsTacacsGroup, bson.M{"command_set": cmdset.Name}) {
+		return fmt.Errorf("command set %s is in use", cmdset.Name)
+	}
+	_, err = m.GetTeamsAcsCollection(TeamsacsTacacsCmdset).DeleteOne(context.TODO(), bson.M{"_id": id})
+	return err
+}
+
+// QueryTacacsAccounting
+func (m *TacacsManager) QueryTacacsAccounting(params web.RequestParams) (*web.PageResult, error) {
+	return m.QueryPagerItems(params, TeamsacsTacacsAccounting)
+}
+
+// AddTacacsAccounting
+func (m *TacacsManager) AddTacacsAccounting(acct *TacacsAccounting) error {
+	acct.ID = common.UUID()
+	_, err := m.GetTeamsAcsCollection(TeamsacsTacacsAccounting).InsertOne(context.TODO(), acct)
+	return err
+}
+
+// GetTacacsUser
+func (m *TacacsManager) GetTacacsUser(username string) (*TacacsUser, error) {
+	var user TacacsUser
+	err := m.GetTeamsAcsCollection(TeamsacsTacacsUser).FindOne(context.TODO(), bson.M{"username": username}).Decode(&user)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, fmt.Errorf("user:%s not exists", username)
+	}
+	return &user, err
+}
+
+// Authenticate
+func (m *TacacsManager) Authenticate(username, password string) error {
+	user, err := m.GetTacacsUser(username)
+	if err != nil {
+		return err
+	}
+	if user.Status == constant.DISABLED {
+		return fmt.Errorf("user:%s status is disabled", username)
+	}
+	plain, err := aes.DecryptFromB64(user.Password, m.Config.System.Aeskey)
+	if err != nil || plain != password {
+		return fmt.Errorf("user:%s password error", username)
+	}
+	return nil
+}
+
+// Authorize
+// the privilege level of the user's group and whether the command is permitted,
+// an empty command is the shell start, a group without command set permits all commands
+func (m *TacacsManager) Authorize(username, cmd string) (int, bool, error) {
+	user, err := m.GetTacacsUser(username)
+	if err != nil {
+		return 0, false, err
+	}
+	if user.Status == constant.DISABLED {
+		return 0, false, fmt.Errorf("user:%s status is disabled", username)
+	}
+	var group TacacsGroup
+	if err = m.GetTeamsAcsCollection(TeamsacsTacacsGroup).FindOne(context.TODO(), bson.M{"name": user.Group}).Decode(&group); err != nil {
+		return 0, false, fmt.Errorf("tacacs group %s not exists", user.Group)
+	}
+	if cmd == "" || group.CommandSet == "" {
+		return group.PrivLvl, true, nil
+	}
+	var cmdset TacacsCommandSet
+	if err = m.GetTeamsAcsCollection(TeamsacsTacacsCmdset).FindOne(context.TODO(), bson.M{"name": group.CommandSet}).Decode(&cmdset); err != nil {
+		return group.PrivLvl, false, fmt.Errorf("command set %s not exists", group.CommandSet)
+	}
+	return group.PrivLvl, cmdset.Authorize(cmd), nil
+}
@@ -0,0 +1,55 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package models
+
+import (
+	"testing"
+)
+
+func TestTacacsCommandSet(t *testing.T) {
+	cmdset := &TacacsCommandSet{
+		Name:   "operator",
+		Permit: []string{`show .*`, `ping .*`},
+		Deny:   []string{`show running-config.*`},
+	}
+	if err := cmdset.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if cmdset.DefaultAction != TacacsActionDeny {
+		t.Fatalf("default action %s != deny", cmdset.DefaultAction)
+	}
+	tests := map[string]bool{
+		"show version":              true,
+		"ping 10.0.0.1":             true,
+		"show running-config":       false,
+		"reload":                    false,
+		"no shutdown; show version": false,
+		"show":                      false,
+	}
+	for cmd, want := range tests {
+		if got := cmdset.Authorize(cmd); got != want {
+			t.Errorf("command %s authorize %v != %v", cmd, got, want)
+		}
+	}
+	cmdset.DefaultAction = TacacsActionPermit
+	if !cmdset.Authorize("reload") {
+		t.Error("default permit must permit unmatched commands")
+	}
+	if err := (&TacacsCommandSet{Name: "bad", Permit: []string{"("}}).Validate(); err == nil {
+		t.Error("invalid regex must fail")
+	}
+}
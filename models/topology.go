@@ -0,0 +1,276 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package models
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/ca17/teamsacs/common"
+)
+
+// Relationships between the documents of a subscriber:
+// subscriber -> cpe by the cpe subscriber or ppp_username field, or the subscriber cpe_sn
+// session -> vpe by the session nas_addr, or the nas_id as the vpe identifier
+// vpe -> site by the vpe site_id
+
+const overviewLimit = 20
+
+var cpeInterfaceFields = []string{"Name", "Status", "Enable", "MACAddress", "Username", "ConnectionStatus",
+	"Stats.BytesReceived", "Stats.BytesSent", "Stats.ErrorsReceived", "Stats.ErrorsSent"}
+
+// CpeInterface
+// The current state of an Ethernet or PPP interface of a CPE
+type CpeInterface struct {
+	Interface      string `json:"interface"`
+	Name           string `json:"name"`
+	Status         string `json:"status"`
+	Enable         string `json:"enable"`
+	MacAddr        string `json:"mac_addr,omitempty"`
+	PppUsername    string `json:"ppp_username,omitempty"`
+	BytesReceived  string `json:"bytes_received"`
+	BytesSent      string `json:"bytes_sent"`
+	ErrorsReceived string `json:"errors_received"`
+	ErrorsSent     string `json:"errors_sent"`
+}
+
+// SubscriberOverview
+// The subscriber with the related sessions, CPE, VPE and site
+type SubscriberOverview struct {
+	Username     string         `json:"username"`
+	Subscriber   Attributes     `json:"subscriber"`
+	Sessions     []Accounting   `json:"sessions"`
+	AuthFailures []Authlog      `json:"auth_failures"`
+	Cpe          Attributes     `json:"cpe"`
+	CpeStatus    *CpeStatus     `json:"cpe_status"`
+	Interfaces   []CpeInterface `json:"interfaces"`
+	Vpes         []Attributes   `json:"vpes"`
+	Sites        []Attributes   `json:"sites"`
+	Syslogs      []Syslog       `json:"syslogs"`
+	Errors       []string       `json:"errors,omitempty"`
+}
+
+// ParseCpeInterfaces
+// group the Device.Ethernet.Interface and Device.PPP.Interface parameters by instance
+func ParseCpeInterfaces(params []DeviceParam) []CpeInterface {
+	items := make(map[string]*CpeInterface)
+	for _, p := range params {
+		for _, table := range ifStatsTables {
+			prefix := "Device." + table + "."
+			if !strings.HasPrefix(p.Path, prefix) {
+				continue
+			}
+			parts := strings.SplitN(p.Path[len(prefix):], ".", 2)
+			if len(parts) != 2 {
+				continue
+			}
+			key := table + "." + parts[0]
+			item, ok := items[key]
+			if !ok {
+				item = &CpeInterface{Interface: key}
+				items[key] = item
+			}
+			value := paramString(p.Value)
+			switch parts[1] {
+			case "Name":
+				item.Name = value
+			case "Status", "ConnectionStatus":
+				item.Status = value
+			case "Enable":
+				item.Enable = value
+			case "MACAddress":
+				item.MacAddr = value
+			case "Username":
+				item.PppUsername = value
+			case "Stats.BytesReceived":
+				item.BytesReceived = value
+			case "Stats.BytesSent":
+				item.BytesSent = value
+			case "Stats.ErrorsReceived":
+				item.ErrorsReceived = value
+			case "Stats.ErrorsSent":
+				item.ErrorsSent = value
+			}
+		}
+	}
+	result := make([]CpeInterface, 0, len(items))
+	for _, item := range items {
+		result = append(result, *item)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Interface < result[j].Interface })
+	return result
+}
+
+// subscriberCpeQuery
+func subscriberCpeQuery(username string, subscriber Attributes) bson.M {
+	conds := []bson.M{{"subscriber": username}, {"ppp_username": username}}
+	if sn, ok := subscriber["cpe_sn"].(string); ok && !common.IsEmptyOrNA(sn) {
+		conds = append(conds, bson.M{"sn": sn})
+	}
+	return bson.M{"$or": conds}
+}
+
+// deviceSyslogQuery
+// the syslog of the CPE by the source address or the hostname
+func deviceSyslogQuery(cpe Attributes, sessions []Accounting) bson.M {
+	addrs := make([]string, 0)
+	names := make([]string, 0)
+	add := func(list []string, v interface{}) []string {
+		s, ok := v.(string)
+		if !ok || common.IsEmptyOrNA(s) || common.InSlice(s, list) {
+			return list
+		}
+		return append(list, s)
+	}
+	for _, s := range sessions {
+		addrs = add(addrs, s.FramedIpaddr)
+	}
+	if cpe != nil {
+		addrs = add(addrs, cpe["wan_ipaddr"])
+		addrs = add(addrs, cpe["ipaddr"])
+		names = add(names, cpe["sn"])
+		names = add(names, cpe["name"])
+	}
+	conds := make([]bson.M, 0, 2)
+	if len(addrs) > 0 {
+		conds = append(conds, bson.M{"attrs.Source": bson.M{"$in": addrs}})
+	}
+	if len(names) > 0 {
+		conds = append(conds, bson.M{"attrs.Hostname": bson.M{"$in": names}})
+	}
+	if len(conds) == 0 {
+		return nil
+	}
+	return bson.M{"$or": conds}
+}
+
+// stripSecretFields
+// remove the radius and tacacs+ secrets and the RouterOS api password of a device
+func stripSecretFields(attrs Attributes) {
+	for _, name := range vpeSecretFields {
+		delete(attrs, name)
+	}
+	delete(attrs, "api_password")
+}
+
+// GetSubscriberOverview
+// resolve the related documents of the subscriber, a failed part is
+// reported in the errors and does not fail the overview
+func (m *ModelManager) GetSubscriberOverview(username string) (*SubscriberOverview, error) {
+	ctx := context.TODO()
+	result := &SubscriberOverview{
+		Username:     username,
+		Sessions:     make([]Accounting, 0),
+		AuthFailures: make([]Authlog, 0),
+		Interfaces:   make([]CpeInterface, 0),
+		Vpes:         make([]Attributes, 0),
+		Sites:        make([]Attributes, 0),
+		Syslogs:      make([]Syslog, 0),
+	}
+	failed := func(part string, err error) {
+		result.Errors = append(result.Errors, fmt.Sprintf("%s: %s", part, err.Error()))
+	}
+
+	err := m.GetTeamsAcsCollection(TeamsacsSubscribe).FindOne(ctx, bson.M{"username": username}).Decode(&result.Subscriber)
+	if err != nil {
+		return nil, fmt.Errorf("subscriber %s not found", username)
+	}
+	delete(result.Subscriber, "password")
+
+	recent := options.Find().SetSort(bson.M{"timestamp": -1}).SetLimit(overviewLimit)
+	if cur, err := m.GetTeamsAcsCollection(TeamsacsOnline).Find(ctx, bson.M{"username": username}); err != nil {
+		failed("sessions", err)
+	} else if err = cur.All(ctx, &result.Sessions); err != nil {
+		failed("sessions", err)
+	}
+	if cur, err := m.GetTeamsAcsCollection(TeamsacsAuthlog).Find(ctx,
+		bson.M{"username": username, "result": "failure"}, recent); err != nil {
+		failed("auth_failures", err)
+	} else if err = cur.All(ctx, &result.AuthFailures); err != nil {
+		failed("auth_failures", err)
+	}
+
+	// vpe of the sessions and their sites
+	vpeIds := make(map[interface{}]bool)
+	siteIds := make(map[interface{}]bool)
+	for _, s := range result.Sessions {
+		conds := make([]bson.M, 0, 2)
+		if s.NasAddr != "" {
+			conds = append(conds, bson.M{"ipaddr": s.NasAddr})
+		}
+		if s.NasId != "" {
+			conds = append(conds, bson.M{"identifier": s.NasId})
+		}
+		if len(conds) == 0 {
+			continue
+		}
+		var vpe Attributes
+		err = m.GetTeamsAcsCollection(TeamsacsVpe).FindOne(ctx, bson.M{"$or": conds}).Decode(&vpe)
+		if err != nil || vpeIds[vpe["_id"]] {
+			continue
+		}
+		vpeIds[vpe["_id"]] = true
+		stripSecretFields(vpe)
+		result.Vpes = append(result.Vpes, vpe)
+		siteId, ok := vpe["site_id"]
+		if !ok || siteIds[siteId] {
+			continue
+		}
+		siteIds[siteId] = true
+		var site Attributes
+		if err = m.GetTeamsAcsCollection(TeamsacsSite).FindOne(ctx, bson.M{"_id": siteId}).Decode(&site); err == nil {
+			result.Sites = append(result.Sites, site)
+		}
+	}
+
+	err = m.GetTeamsAcsCollection(TeamsacsCpe).FindOne(ctx, subscriberCpeQuery(username, result.Subscriber)).Decode(&result.Cpe)
+	if err == nil {
+		stripSecretFields(result.Cpe)
+		sn, _ := result.Cpe["sn"].(string)
+		var status = new(CpeStatus)
+		if err = m.GetTeamsAcsCollection(TeamsacsCpeStatus).FindOne(ctx, bson.M{"sn": sn}).Decode(status); err == nil {
+			result.CpeStatus = status
+		}
+		paths := make([]string, 0)
+		for _, table := range ifStatsTables {
+			for _, field := range cpeInterfaceFields {
+				paths = append(paths, fmt.Sprintf("Device.%s.*.%s", table, field))
+			}
+		}
+		if _, params, err := m.GetGenieacsManager().QueryDeviceParams(sn, paths); err != nil {
+			failed("interfaces", err)
+		} else {
+			result.Interfaces = ParseCpeInterfaces(params)
+		}
+	}
+
+	if q := deviceSyslogQuery(result.Cpe, result.Sessions); q != nil {
+		q["timestamp"] = bson.M{"$gte": time.Now().Add(-time.Hour * 24)}
+		if cur, err := m.GetTeamsAcsCollection(TeamsacsSyslog).Find(ctx, q, recent); err != nil {
+			failed("syslogs", err)
+		} else if err = cur.All(ctx, &result.Syslogs); err != nil {
+			failed("syslogs", err)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,68 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package models
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestParseCpeInterfaces(t *testing.T) {
+	items := ParseCpeInterfaces([]DeviceParam{
+		{Path: "Device.PPP.Interface.1.Username", Value: "test01"},
+		{Path: "Device.PPP.Interface.1.ConnectionStatus", Value: "Connected"},
+		{Path: "Device.Ethernet.Interface.2.Name", Value: "ether2"},
+		{Path: "Device.Ethernet.Interface.1.Name", Value: "ether1"},
+		{Path: "Device.Ethernet.Interface.1.Status", Value: "Up"},
+		{Path: "Device.Ethernet.Interface.1.Stats.BytesReceived", Value: int64(1024)},
+		{Path: "Device.DeviceInfo.SerialNumber", Value: "CPE-0001"},
+	})
+	if len(items) != 3 {
+		t.Fatal(items)
+	}
+	if items[0].Interface != "Ethernet.Interface.1" || items[0].Status != "Up" || items[0].BytesReceived != "1024" {
+		t.Fatal(items[0])
+	}
+	if items[2].PppUsername != "test01" || items[2].Status != "Connected" {
+		t.Fatal(items[2])
+	}
+}
+
+func TestDeviceSyslogQuery(t *testing.T) {
+	if deviceSyslogQuery(nil, nil) != nil {
+		t.Fatal("expected no query")
+	}
+	q := deviceSyslogQuery(Attributes{"sn": "CPE-0001", "wan_ipaddr": "10.0.0.2", "ipaddr": "N/A"},
+		[]Accounting{{FramedIpaddr: "10.0.0.2"}, {FramedIpaddr: "10.0.0.3"}})
+	conds := q["$or"].([]bson.M)
+	if len(conds) != 2 {
+		t.Fatal(q)
+	}
+	addrs := conds[0]["attrs.Source"].(bson.M)["$in"].([]string)
+	if len(addrs) != 2 || addrs[0] != "10.0.0.2" || addrs[1] != "10.0.0.3" {
+		t.Fatal(addrs)
+	}
+}
+
+func TestStripSecretFields(t *testing.T) {
+	dev := Attributes{"ipaddr": "10.0.0.1", "secret": "s", "tacacs_secret": "t", "api_password": "p"}
+	stripSecretFields(dev)
+	if len(dev) != 1 || dev["ipaddr"] != "10.0.0.1" {
+		t.Fatal(dev)
+	}
+}
@@ -0,0 +1,527 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/ca17/teamsacs/common"
+	"github.com/ca17/teamsacs/common/aes"
+	"github.com/ca17/teamsacs/common/log"
+	"github.com/ca17/teamsacs/common/timeutil"
+	"github.com/ca17/teamsacs/common/web"
+	"github.com/ca17/teamsacs/constant"
+)
+
+const (
+	VoucherActivationFirstLogin = "first_login"
+	VoucherActivationFixed      = "fixed"
+
+	VoucherStatusUnused    = "unused"
+	VoucherStatusActive    = "active"
+	VoucherStatusExhausted = "exhausted"
+	VoucherStatusRevoked   = "revoked"
+
+	VoucherMaxBatchCount = 10000
+
+	// subscribe date format, parsed by DataObject.GetDateValue
+	subscribeDateLayout = "2006-01-02 15:04:05 -0700 MST"
+
+	// no ambiguous chars such as 0/O, 1/I
+	voucherCodeChars = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	voucherPinChars  = "0123456789"
+)
+
+// units supported by timeutil.ComputeEndTime
+var voucherValidityUnits = []string{"minute", "hour", "day", "week", "month", "year"}
+
+// subscribe attributes that a voucher plan cannot override
+var voucherReservedAttrs = []string{"_id", "username", "password", "status", "expire_time", "start_time", "voucher_batch"}
+
+// VoucherBatch
+// Time and volume vouchers generated with the same plan
+type VoucherBatch struct {
+	ID           string            `bson:"_id,omitempty" json:"id,omitempty"`
+	Name         string            `bson:"name" json:"name"`
+	Prefix       string            `bson:"prefix" json:"prefix"`
+	Length       int               `bson:"length" json:"length"`
+	PinLength    int               `bson:"pin_length" json:"pin_length"`
+	Count        int               `bson:"count" json:"count"`
+	Plan         map[string]string `bson:"plan" json:"plan"`
+	Validity     int               `bson:"validity" json:"validity"`
+	ValidityUnit string            `bson:"validity_unit" json:"validity_unit"`
+	FlowQuota    int64             `bson:"flow_quota" json:"flow_quota"`
+	Activation   string            `bson:"activation" json:"activation"`
+	StartTime    time.Time         `bson:"start_time" json:"start_time"`
+	EndTime      time.Time         `bson:"end_time" json:"end_time"`
+	Status       string            `bson:"status" json:"status"`
+	CreateTime   time.Time         `bson:"create_time" json:"create_time"`
+	Remark       string            `bson:"remark" json:"remark"`
+}
+
+// Voucher
+// The code is the radius username, the pin is the password, the code is used when the pin is empty
+type Voucher struct {
+	Code       string    `bson:"_id" json:"code"`
+	BatchId    string    `bson:"batch_id" json:"batch_id"`
+	Pin        string    `bson:"pin" json:"-"`
+	Status     string    `bson:"status" json:"status"`
+	ActiveTime time.Time `bson:"active_time,omitempty" json:"active_time,omitempty"`
+	ExpireTime time.Time `bson:"expire_time,omitempty" json:"expire_time,omitempty"`
+}
+
+// VoucherUsage
+// Usage report of a voucher batch
+type VoucherUsage struct {
+	BatchId     string `json:"batch_id"`
+	Total       int64  `json:"total"`
+	Unused      int64  `json:"unused"`
+	Active      int64  `json:"active"`
+	Expired     int64  `json:"expired"`
+	Exhausted   int64  `json:"exhausted"`
+	Revoked     int64  `json:"revoked"`
+	Online      int64  `json:"online"`
+	SessionTime int64  `json:"session_time"`
+	InputTotal  int64  `json:"input_total"`
+	OutputTotal int64  `json:"output_total"`
+}
+
+func (b *VoucherBatch) AddValidate() error {
+	switch {
+	case common.IsEmptyOrNA(b.Name):
+		return fmt.Errorf("invalid voucher batch name")
+	case b.Count <= 0 || b.Count > VoucherMaxBatchCount:
+		return fmt.Errorf("voucher count must be 1-%d", VoucherMaxBatchCount)
+	case b.Length < 6 || b.Length > 32:
+		return fmt.Errorf("voucher code length must be 6-32")
+	case b.PinLength < 0 || b.PinLength > 32:
+		return fmt.Errorf("voucher pin length must be 0-32")
+	case b.FlowQuota < 0:
+		return fmt.Errorf("invalid voucher flow quota")
+	}
+	switch b.Activation {
+	case VoucherActivationFirstLogin:
+		if b.Validity <= 0 || !common.InSlice(b.ValidityUnit, voucherValidityUnits) {
+			return fmt.Errorf("invalid voucher validity %d %s", b.Validity, b.ValidityUnit)
+		}
+	case VoucherActivationFixed:
+		if b.EndTime.IsZero() || !b.EndTime.After(b.StartTime) {
+			return fmt.Errorf("voucher end time must be after start time")
+		}
+	default:
+		return fmt.Errorf("voucher activation must be %s or %s", VoucherActivationFirstLogin, VoucherActivationFixed)
+	}
+	for k := range b.Plan {
+		if common.InSlice(k, voucherReservedAttrs) {
+			return fmt.Errorf("voucher plan cannot set %s", k)
+		}
+	}
+	return nil
+}
+
+// initialExpireTime
+// first login vouchers must be used before the batch end time if set, the expire time is
+// computed on the first accounting start
+func (b *VoucherBatch) initialExpireTime() time.Time {
+	if b.Activation == VoucherActivationFirstLogin && b.EndTime.IsZero() {
+		return time.Now().AddDate(10, 0, 0)
+	}
+	return b.EndTime
+}
+
+func randomString(chars string, length int) (string, error) {
+	var sb strings.Builder
+	max := big.NewInt(int64(len(chars)))
+	for i := 0; i < length; i++ {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		sb.WriteByte(chars[n.Int64()])
+	}
+	return sb.String(), nil
+}
+
+// GenerateVoucherCodes
+// unique random codes with prefix, excludes codes in the exists func
+func GenerateVoucherCodes(prefix string, length, count int, exists func(codes []string) map[string]bool) ([]string, error) {
+	var result = make([]string, 0, count)
+	var seen = make(map[string]bool)
+	for round := 0; len(result) < count; round++ {
+		if round > 10 {
+			return nil, fmt.Errorf("cannot generate enough unique voucher codes, increase the code length")
+		}
+		var codes = make([]string, 0, count-len(result))
+		for len(codes) < count-len(result) {
+			code, err := randomString(voucherCodeChars, length)
+			if err != nil {
+				return nil, err
+			}
+			code = prefix + code
+			if seen[code] {
+				continue
+			}
+			seen[code] = true
+			codes = append(codes, code)
+		}
+		used := exists(codes)
+		for _, code := range codes {
+			if !used[code] {
+				result = append(result, code)
+			}
+		}
+	}
+	return result, nil
+}
+
+// VoucherManager
+type VoucherManager struct{ *ModelManager }
+
+func (m *ModelManager) GetVoucherManager() *VoucherManager {
+	store, _ := m.ManagerMap.Get("VoucherManager")
+	return store.(*VoucherManager)
+}
+
+// existsUsernames
+// codes already used as voucher codes or subscribe usernames
+func (m *VoucherManager) existsUsernames(codes []string) map[string]bool {
+	var result = make(map[string]bool)
+	for _, q := range []struct {
+		coll  string
+		field string
+	}{{TeamsacsVoucher, "_id"}, {TeamsacsSubscribe, "username"}} {
+		values, err := m.GetTeamsAcsCollection(q.coll).Distinct(context.TODO(), q.field, bson.M{q.field: bson.M{"$in": codes}})
+		if err != nil {
+			log.Error(err)
+			// treat all as used, generate again
+			for _, code := range codes {
+				result[code] = true
+			}
+			return result
+		}
+		for _, v := range values {
+			if s, ok := v.(string); ok {
+				result[s] = true
+			}
+		}
+	}
+	return result
+}
+
+// AddVoucherBatch
+// Generate vouchers and the subscribes with the plan, the voucher code is the username
+func (m *VoucherManager) AddVoucherBatch(batch *VoucherBatch) (string, error) {
+	if err := batch.AddValidate(); err != nil {
+		return "", err
+	}
+	codes, err := GenerateVoucherCodes(batch.Prefix, batch.Length, batch.Count, m.existsUsernames)
+	if err != nil {
+		return "", err
+	}
+	batch.ID = common.UUID()
+	batch.Status = constant.ENABLED
+	batch.CreateTime = time.Now()
+	aeskey := m.Config.System.Aeskey
+	expire := batch.initialExpireTime().Format(subscribeDateLayout)
+	vouchers := make([]interface{}, 0, len(codes))
+	subscribes := make([]interface{}, 0, len(codes))
+	for _, code := range codes {
+		password := code
+		if batch.PinLength > 0 {
+			if password, err = randomString(voucherPinChars, batch.PinLength); err != nil {
+				return "", err
+			}
+		}
+		encpwd, err := aes.EncryptToB64(password, aeskey)
+		if err != nil {
+			return "", err
+		}
+		vouchers = append(vouchers, Voucher{Code: code, BatchId: batch.ID, Pin: encpwd, Status: VoucherStatusUnused})
+		subscribe := bson.M{}
+		for k, v := range batch.Plan {
+			subscribe[k] = v
+		}
+		subscribe["_id"] = common.UUID()
+		subscribe["username"] = code
+		subscribe["password"] = encpwd
+		subscribe["status"] = constant.ENABLED
+		subscribe["expire_time"] = expire
+		subscribe["voucher_batch"] = batch.ID
+		if batch.Activation == VoucherActivationFixed {
+			subscribe["start_time"] = batch.StartTime.Format(subscribeDateLayout)
+		}
+		if batch.FlowQuota > 0 {
+			subscribe["flow_quota"] = strconv.FormatInt(batch.FlowQuota, 10)
+		}
+		subscribes = append(subscribes, subscribe)
+	}
+	if _, err = m.GetTeamsAcsCollection(TeamsacsVoucherBatch).InsertOne(context.TODO(), batch); err != nil {
+		return "", err
+	}
+	if _, err = m.GetTeamsAcsCollection(TeamsacsVoucher).InsertMany(context.TODO(), vouchers); err != nil {
+		m.removeVoucherBatch(batch.ID)
+		return "", err
+	}
+	if _, err = m.GetTeamsAcsCollection(TeamsacsSubscribe).InsertMany(context.TODO(), subscribes); err != nil {
+		m.removeVoucherBatch(batch.ID)
+		return "", err
+	}
+	return batch.ID, nil
+}
+
+// removeVoucherBatch
+// remove the partially inserted batch, the vouchers and the subscribes
+func (m *VoucherManager) removeVoucherBatch(id string) {
+	if _, err := m.GetTeamsAcsCollection(TeamsacsSubscribe).DeleteMany(context.TODO(), bson.M{"voucher_batch": id}); err != nil {
+		log.Errorf("remove subscribes of voucher batch %s error, %s", id, err.Error())
+	}
+	if _, err := m.GetTeamsAcsCollection(TeamsacsVoucher).DeleteMany(context.TODO(), bson.M{"batch_id": id}); err != nil {
+		log.Errorf("remove vouchers of voucher batch %s error, %s", id, err.Error())
+	}
+	if _, err := m.GetTeamsAcsCollection(TeamsacsVoucherBatch).DeleteOne(context.TODO(), bson.M{"_id": id}); err != nil {
+		log.Errorf("remove voucher batch %s error, %s", id, err.Error())
+	}
+}
+
+// GetVoucherBatch
+func (m *VoucherManager) GetVoucherBatch(id string) (*VoucherBatch, error) {
+	doc := m.GetTeamsAcsCollection(TeamsacsVoucherBatch).FindOne(context.TODO(), bson.M{"_id": id})
+	if err := doc.Err(); err != nil {
+		return nil, err
+	}
+	var result = new(VoucherBatch)
+	err := doc.Decode(result)
+	return result, err
+}
+
+// QueryVoucherBatchs
+func (m *VoucherManager) QueryVoucherBatchs(params web.RequestParams) (*web.PageResult, error) {
+	return m.QueryPagerItems(params, TeamsacsVoucherBatch)
+}
+
+// QueryVouchers
+func (m *VoucherManager) QueryVouchers(params web.RequestParams) (*web.PageResult, error) {
+	return m.QueryPagerItems(params, TeamsacsVoucher)
+}
+
+// GetBatchVouchers
+// all vouchers of a batch with the decrypted pin, for export
+func (m *VoucherManager) GetBatchVouchers(batchId string) ([]Voucher, error) {
+	findOptions := options.Find().SetSort(bson.M{"_id": 1})
+	cur, err := m.GetTeamsAcsCollection(TeamsacsVoucher).Find(context.TODO(), bson.M{"batch_id": batchId}, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	var items = make([]Voucher, 0)
+	if err = cur.All(context.TODO(), &items); err != nil {
+		return nil, err
+	}
+	for i := range items {
+		pin, err := aes.DecryptFromB64(items[i].Pin, m.Config.System.Aeskey)
+		if err != nil {
+			return nil, err
+		}
+		items[i].Pin = pin
+	}
+	return items, nil
+}
+
+// RevokeVoucherBatch
+// Revoke the batch and all vouchers, the subscribes are disabled
+func (m *VoucherManager) RevokeVoucherBatch(id string) error {
+	if _, err := m.GetVoucherBatch(id); err != nil {
+		return err
+	}
+	_, err := m.GetTeamsAcsCollection(TeamsacsVoucherBatch).UpdateOne(context.TODO(),
+		bson.M{"_id": id}, bson.M{"$set": bson.M{"status": VoucherStatusRevoked}})
+	if err != nil {
+		return err
+	}
+	_, err = m.GetTeamsAcsCollection(TeamsacsVoucher).UpdateMany(context.TODO(),
+		bson.M{"batch_id": id}, bson.M{"$set": bson.M{"status": VoucherStatusRevoked}})
+	if err != nil {
+		return err
+	}
+	_, err = m.GetTeamsAcsCollection(TeamsacsSubscribe).UpdateMany(context.TODO(),
+		bson.M{"voucher_batch": id}, bson.M{"$set": bson.M{"status": constant.DISABLED}})
+	return err
+}
+
+// RevokeVouchers
+// Revoke vouchers by codes
+func (m *VoucherManager) RevokeVouchers(codes []string) error {
+	if len(codes) == 0 {
+		return fmt.Errorf("voucher codes is empty")
+	}
+	_, err := m.GetTeamsAcsCollection(TeamsacsVoucher).UpdateMany(context.TODO(),
+		bson.M{"_id": bson.M{"$in": codes}}, bson.M{"$set": bson.M{"status": VoucherStatusRevoked}})
+	if err != nil {
+		return err
+	}
+	_, err = m.GetTeamsAcsCollection(TeamsacsSubscribe).UpdateMany(context.TODO(),
+		bson.M{"username": bson.M{"$in": codes}, "voucher_batch": bson.M{"$exists": true}},
+		bson.M{"$set": bson.M{"status": constant.DISABLED}})
+	return err
+}
+
+// ActivateVoucher
+// set the real expire time of the voucher on the first accounting start, it is
+// called on the accounting path, not by the event bus which may drop the event
+func (m *VoucherManager) ActivateVoucher(code string) error {
+	coll := m.GetTeamsAcsCollection(TeamsacsVoucher)
+	var voucher Voucher
+	err := coll.FindOne(context.TODO(), bson.M{"_id": code, "status": VoucherStatusUnused}).Decode(&voucher)
+	if err != nil {
+		// not a voucher or activated already
+		return nil
+	}
+	batch, err := m.GetVoucherBatch(voucher.BatchId)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	expire := batch.EndTime
+	if batch.Activation == VoucherActivationFirstLogin {
+		expire = timeutil.ComputeEndTime(batch.Validity, batch.ValidityUnit)
+	}
+	res, err := coll.UpdateOne(context.TODO(), bson.M{"_id": code, "status": VoucherStatusUnused},
+		bson.M{"$set": bson.M{"status": VoucherStatusActive, "active_time": now, "expire_time": expire}})
+	if err != nil || res.ModifiedCount == 0 {
+		return err
+	}
+	return m.GetSubscribeManager().UpdateSubscribeByUsername(code, Attributes{"expire_time": expire.Format(subscribeDateLayout)})
+}
+
+// CheckVoucherQuota
+// exhaust the voucher and disconnect the sessions when the flow quota is used up,
+// it is called on the accounting interim and stop path, the time quota is the
+// expire time checked on the interim path
+func (m *VoucherManager) CheckVoucherQuota(code string) error {
+	user, err := m.GetSubscribeManager().GetSubscribeByUser(code)
+	if err != nil || user.GetStringValue("voucher_batch", "") == "" {
+		return nil
+	}
+	quota := user.GetInt64Value("flow_quota", 0)
+	if quota <= 0 {
+		return nil
+	}
+	used, err := m.GetRadiusManager().GetUserFlowTotal(code)
+	if err != nil {
+		return err
+	}
+	if used < quota*1024*1024 {
+		return nil
+	}
+	_, err = m.GetTeamsAcsCollection(TeamsacsVoucher).UpdateOne(context.TODO(),
+		bson.M{"_id": code}, bson.M{"$set": bson.M{"status": VoucherStatusExhausted}})
+	if err != nil {
+		return err
+	}
+	if err = m.GetSubscribeManager().UpdateSubscribeByUsername(code, Attributes{"status": constant.DISABLED}); err != nil {
+		return err
+	}
+	go m.GetRadiusManager().DisconnectUser(code, "voucher quota exhausted")
+	return nil
+}
+
+// GetVoucherUsage
+func (m *VoucherManager) GetVoucherUsage(batchId string) (*VoucherUsage, error) {
+	if _, err := m.GetVoucherBatch(batchId); err != nil {
+		return nil, err
+	}
+	usage := &VoucherUsage{BatchId: batchId}
+	coll := m.GetTeamsAcsCollection(TeamsacsVoucher)
+	cur, err := coll.Aggregate(context.TODO(), []bson.M{
+		{"$match": bson.M{"batch_id": batchId}},
+		{"$group": bson.M{"_id": "$status", "count": bson.M{"$sum": 1}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var groups []struct {
+		Status string `bson:"_id"`
+		Count  int64  `bson:"count"`
+	}
+	if err = cur.All(context.TODO(), &groups); err != nil {
+		return nil, err
+	}
+	for _, g := range groups {
+		usage.Total += g.Count
+		switch g.Status {
+		case VoucherStatusUnused:
+			usage.Unused = g.Count
+		case VoucherStatusActive:
+			usage.Active = g.Count
+		case VoucherStatusExhausted:
+			usage.Exhausted = g.Count
+		case VoucherStatusRevoked:
+			usage.Revoked = g.Count
+		}
+	}
+	usage.Expired, err = coll.CountDocuments(context.TODO(), bson.M{
+		"batch_id": batchId, "status": VoucherStatusActive, "expire_time": bson.M{"$lt": time.Now()}})
+	if err != nil {
+		return nil, err
+	}
+
+	codes, err := coll.Distinct(context.TODO(), "_id", bson.M{"batch_id": batchId, "status": bson.M{"$ne": VoucherStatusUnused}})
+	if err != nil {
+		return nil, err
+	}
+	if len(codes) == 0 {
+		return usage, nil
+	}
+	usage.Online, err = m.GetTeamsAcsCollection(TeamsacsOnline).CountDocuments(context.TODO(), bson.M{"username": bson.M{"$in": codes}})
+	if err != nil {
+		return nil, err
+	}
+	acur, err := m.GetTeamsAcsCollection(TeamsacsAccounting).Aggregate(context.TODO(), []bson.M{
+		{"$match": bson.M{"username": bson.M{"$in": codes}}},
+		{"$group": bson.M{
+			"_id":          nil,
+			"session_time": bson.M{"$sum": "$acct_session_time"},
+			"input_total":  bson.M{"$sum": "$acct_input_total"},
+			"output_total": bson.M{"$sum": "$acct_output_total"},
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var totals []struct {
+		SessionTime int64 `bson:"session_time"`
+		InputTotal  int64 `bson:"input_total"`
+		OutputTotal int64 `bson:"output_total"`
+	}
+	if err = acur.All(context.TODO(), &totals); err != nil {
+		return nil, err
+	}
+	if len(totals) > 0 {
+		usage.SessionTime = totals[0].SessionTime
+		usage.InputTotal = totals[0].InputTotal
+		usage.OutputTotal = totals[0].OutputTotal
+	}
+	return usage, nil
+}
@@ -0,0 +1,118 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package models
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/360EntSecGroup-Skylar/excelize"
+	"github.com/jung-kurt/gofpdf"
+
+	"github.com/ca17/teamsacs/common/timeutil"
+)
+
+const (
+	voucherCardCols   = 3
+	voucherCardRows   = 8
+	voucherCardWidth  = 60.0
+	voucherCardHeight = 32.0
+	voucherCardGap    = 5.0
+	voucherPageMargin = 10.0
+)
+
+// ValidityText
+// describe the voucher validity on cards
+func (b *VoucherBatch) ValidityText() string {
+	var text string
+	if b.Activation == VoucherActivationFixed {
+		text = fmt.Sprintf("Valid %s ~ %s", timeutil.FmtDatetimeMString(b.StartTime), timeutil.FmtDatetimeMString(b.EndTime))
+	} else {
+		text = fmt.Sprintf("Valid %d %s after first login", b.Validity, b.ValidityUnit)
+	}
+	return text
+}
+
+func (b *VoucherBatch) QuotaText() string {
+	if b.FlowQuota > 0 {
+		return fmt.Sprintf("Data %d MB", b.FlowQuota)
+	}
+	return "Data unlimited"
+}
+
+// WriteVoucherPdf
+// printable voucher cards, the font is the bundled jetbrainsmono.ttf
+func WriteVoucherPdf(w io.Writer, batch *VoucherBatch, vouchers []Voucher, font []byte) error {
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	pdf.AddUTF8FontFromBytes("jetbrainsmono", "", font)
+	pdf.SetAutoPageBreak(false, 0)
+	pdf.SetTitle(batch.Name, true)
+	perPage := voucherCardCols * voucherCardRows
+	for i, v := range vouchers {
+		if i%perPage == 0 {
+			pdf.AddPage()
+		}
+		n := i % perPage
+		x := voucherPageMargin + float64(n%voucherCardCols)*(voucherCardWidth+voucherCardGap)
+		y := voucherPageMargin + float64(n/voucherCardCols)*(voucherCardHeight+2)
+		pdf.SetDrawColor(160, 160, 160)
+		pdf.SetDashPattern([]float64{1, 1}, 0)
+		pdf.Rect(x, y, voucherCardWidth, voucherCardHeight, "D")
+		pdf.SetFont("jetbrainsmono", "", 8)
+		pdf.Text(x+3, y+5, batch.Name)
+		pdf.SetFont("jetbrainsmono", "", 11)
+		pdf.Text(x+3, y+12, "Code: "+v.Code)
+		if batch.PinLength > 0 {
+			pdf.Text(x+3, y+18, "PIN:  "+v.Pin)
+		}
+		pdf.SetFont("jetbrainsmono", "", 7)
+		pdf.Text(x+3, y+25, batch.ValidityText())
+		pdf.Text(x+3, y+29, batch.QuotaText())
+	}
+	if len(vouchers) == 0 {
+		pdf.AddPage()
+	}
+	return pdf.Output(w)
+}
+
+// WriteVoucherXlsx
+func WriteVoucherXlsx(w io.Writer, batch *VoucherBatch, vouchers []Voucher) error {
+	sheet := "vouchers"
+	xlsx := excelize.NewFile()
+	xlsx.SetSheetName("Sheet1", sheet)
+	for j, name := range []string{"code", "pin", "status", "validity", "flow_quota", "expire_time"} {
+		xlsx.SetCellValue(sheet, fmt.Sprintf("%s%d", xlsxColName(j), 1), name)
+	}
+	for i, v := range vouchers {
+		pin := v.Pin
+		if batch.PinLength == 0 {
+			pin = ""
+		}
+		expire := ""
+		if !v.ExpireTime.IsZero() {
+			expire = timeutil.FmtDatetimeString(v.ExpireTime)
+		}
+		for j, value := range []interface{}{v.Code, pin, v.Status, batch.ValidityText(), batch.FlowQuota, expire} {
+			xlsx.SetCellValue(sheet, fmt.Sprintf("%s%d", xlsxColName(j), i+2), value)
+		}
+	}
+	return xlsx.Write(w)
+}
+
+func xlsxColName(i int) string {
+	return string(rune('A' + i))
+}
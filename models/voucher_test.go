@@ -0,0 +1,105 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package models
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/360EntSecGroup-Skylar/excelize"
+)
+
+func TestGenerateVoucherCodes(t *testing.T) {
+	var rounds int
+	codes, err := GenerateVoucherCodes("HS", 8, 500, func(codes []string) map[string]bool {
+		rounds++
+		// the first code of the first round is used
+		if rounds == 1 {
+			return map[string]bool{codes[0]: true}
+		}
+		return map[string]bool{}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != 500 || rounds != 2 {
+		t.Fatalf("codes %d, rounds %d", len(codes), rounds)
+	}
+	seen := make(map[string]bool)
+	for _, code := range codes {
+		if !strings.HasPrefix(code, "HS") || len(code) != 10 || seen[code] {
+			t.Fatalf("invalid code %s", code)
+		}
+		seen[code] = true
+	}
+}
+
+func TestVoucherBatchValidate(t *testing.T) {
+	batch := &VoucherBatch{Name: "cafe", Count: 10, Length: 8, Activation: VoucherActivationFirstLogin, Validity: 2, ValidityUnit: "hour"}
+	if err := batch.AddValidate(); err != nil {
+		t.Fatal(err)
+	}
+	batch.ValidityUnit = "fortnight"
+	if err := batch.AddValidate(); err == nil {
+		t.Fatal("invalid validity unit must fail")
+	}
+	batch = &VoucherBatch{Name: "event", Count: 10, Length: 8, Activation: VoucherActivationFixed,
+		StartTime: time.Now(), EndTime: time.Now().Add(-time.Hour)}
+	if err := batch.AddValidate(); err == nil {
+		t.Fatal("end time before start time must fail")
+	}
+	batch.EndTime = time.Now().Add(time.Hour)
+	batch.Plan = map[string]string{"up_rate": "1024", "username": "admin"}
+	if err := batch.AddValidate(); err == nil {
+		t.Fatal("plan cannot override username")
+	}
+}
+
+func TestVoucherExport(t *testing.T) {
+	batch := &VoucherBatch{Name: "cafe", PinLength: 4, Activation: VoucherActivationFirstLogin, Validity: 1, ValidityUnit: "day", FlowQuota: 1024}
+	vouchers := make([]Voucher, 0)
+	for i := 0; i < 30; i++ {
+		vouchers = append(vouchers, Voucher{Code: "HS0000000" + string(rune('A'+i%26)), Pin: "1234", Status: VoucherStatusUnused})
+	}
+	font, err := ioutil.ReadFile("../resources/jetbrainsmono.ttf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pdfbuf bytes.Buffer
+	if err := WriteVoucherPdf(&pdfbuf, batch, vouchers, font); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(pdfbuf.Bytes(), []byte("%PDF")) {
+		t.Fatal("invalid pdf")
+	}
+
+	var xlsbuf bytes.Buffer
+	if err := WriteVoucherXlsx(&xlsbuf, batch, vouchers); err != nil {
+		t.Fatal(err)
+	}
+	xlsx, err := excelize.OpenReader(&xlsbuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := xlsx.GetRows("vouchers")
+	if len(rows) != 31 || rows[1][0] != vouchers[0].Code || rows[1][1] != "1234" {
+		t.Fatalf("invalid xlsx rows %v", rows[:2])
+	}
+}
@@ -18,8 +18,29 @@ package models
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+	"layeh.com/radius/rfc2866"
+	"layeh.com/radius/rfc2869"
+	"layeh.com/radius/rfc3576"
+
+	"github.com/ca17/teamsacs/common"
+	"github.com/ca17/teamsacs/common/aes"
+	"github.com/ca17/teamsacs/common/log"
+	"github.com/ca17/teamsacs/common/web"
+	"github.com/ca17/teamsacs/radiusd/vendors"
 )
 
 // Vpe
@@ -35,7 +56,14 @@ func (v DataObject) GetVendorCode() string {
 }
 
 // VpeManager
-type VpeManager struct{ *ModelManager }
+// the vpes are cached by ipaddr and identifier with the decrypted secrets,
+// any change by the vpe or data api invalidates the cache
+type VpeManager struct {
+	*ModelManager
+	cacheLock sync.RWMutex
+	cache     map[string]Vpe
+	cacheTime time.Time
+}
 
 func (m *ModelManager) GetVpeManager() *VpeManager {
 	store, _ := m.ManagerMap.Get("VpeManager")
@@ -45,26 +73,391 @@ func (m *ModelManager) GetVpeManager() *VpeManager {
 
 // GetVpeByIpaddr
 func (m *VpeManager) GetVpeByIpaddr(ip string) (*Vpe, error) {
-	coll := m.GetTeamsAcsCollection(TeamsacsVpe)
-	doc := coll.FindOne(context.TODO(), bson.M{"ipaddr": ip})
-	err := doc.Err()
+	return m.getCachedVpe("ipaddr:" + ip)
+}
+
+// GetVpeByIdentifier
+func (m *VpeManager) GetVpeByIdentifier(identifier string) (*Vpe, error) {
+	return m.getCachedVpe("identifier:" + identifier)
+}
+
+const (
+	// the prefix of an encrypted secret, a secret without it is plain text
+	vpeSecretPrefix = "aes:"
+	vpeCacheTTL     = time.Minute
+
+	VpeTestStatus = "status"
+	VpeTestCoa    = "coa"
+)
+
+var (
+	vpeIdentifierRegexp = regexp.MustCompile(`^[a-zA-Z0-9_.:\-]{1,64}$`)
+	vpeSecretFields     = []string{"secret", "tacacs_secret"}
+)
+
+// InvalidateCache
+// the vpes are loaded again by the next lookup
+func (m *VpeManager) InvalidateCache() {
+	m.cacheLock.Lock()
+	m.cache = nil
+	m.cacheLock.Unlock()
+}
+
+func (m *VpeManager) getCachedVpe(key string) (*Vpe, error) {
+	m.cacheLock.RLock()
+	cache := m.cache
+	expired := time.Since(m.cacheTime) > vpeCacheTTL
+	m.cacheLock.RUnlock()
+	if cache == nil || expired {
+		var err error
+		if cache, err = m.reloadCache(); err != nil {
+			return nil, err
+		}
+	}
+	vpe, ok := cache[key]
+	if !ok {
+		return nil, mongo.ErrNoDocuments
+	}
+	var result = make(Vpe, len(vpe))
+	for k, v := range vpe {
+		result[k] = v
+	}
+	return &result, nil
+}
+
+func (m *VpeManager) reloadCache() (map[string]Vpe, error) {
+	cur, err := m.GetTeamsAcsCollection(TeamsacsVpe).Find(context.TODO(), bson.M{})
 	if err != nil {
 		return nil, err
 	}
-	var result = new(Vpe)
-	err = doc.Decode(result)
+	var items []Vpe
+	if err = cur.All(context.TODO(), &items); err != nil {
+		return nil, err
+	}
+	cache := make(map[string]Vpe, len(items)*2)
+	for _, vpe := range items {
+		for _, name := range vpeSecretFields {
+			if v, ok := vpe[name]; ok {
+				if vpe[name], err = m.decryptSecret(v); err != nil {
+					log.Errorf("vpe %s %s decrypt error, %s", vpe.GetStringValue("ipaddr", ""), name, err.Error())
+				}
+			}
+		}
+		if ip := vpe.GetStringValue("ipaddr", ""); ip != "" {
+			cache["ipaddr:"+ip] = vpe
+		}
+		if identifier := vpe.GetStringValue("identifier", ""); identifier != "" {
+			cache["identifier:"+identifier] = vpe
+		}
+	}
+	m.cacheLock.Lock()
+	m.cache = cache
+	m.cacheTime = time.Now()
+	m.cacheLock.Unlock()
+	return cache, nil
+}
+
+func (m *VpeManager) encryptSecret(secret string) (string, error) {
+	if secret == "" {
+		return "", nil
+	}
+	encsecret, err := aes.EncryptToB64(secret, m.Config.System.Aeskey)
+	if err != nil {
+		return "", err
+	}
+	return vpeSecretPrefix + encsecret, nil
+}
+
+// decryptSecret
+// a secret without the prefix is a plain text secret of the data api
+func (m *VpeManager) decryptSecret(secret string) (string, error) {
+	if !strings.HasPrefix(secret, vpeSecretPrefix) {
+		return secret, nil
+	}
+	result, err := aes.DecryptFromB64(strings.TrimPrefix(secret, vpeSecretPrefix), m.Config.System.Aeskey)
+	if err == nil && result == "" {
+		err = fmt.Errorf("invalid encrypted secret")
+	}
 	return result, err
 }
 
-// GetVpeByIdentifier
-func (m *VpeManager) GetVpeByIdentifier(identifier string) (*Vpe, error) {
+// VpeForm
+// The typed fields of a vpe, the secrets are stored encrypted
+type VpeForm struct {
+	ID           string `json:"id"`
+	Name         string `json:"name"`
+	Identifier   string `json:"identifier"`
+	Ipaddr       string `json:"ipaddr"`
+	Secret       string `json:"secret"`
+	TacacsSecret string `json:"tacacs_secret"`
+	VendorCode   string `json:"vendor_code"`
+	CoaPort      int    `json:"coa_port"`
+	SiteId       string `json:"site_id"`
+	Remark       string `json:"remark"`
+}
+
+// VpeTestResult
+type VpeTestResult struct {
+	Mode        string `json:"mode"`
+	Address     string `json:"address"`
+	Reachable   bool   `json:"reachable"`
+	SecretValid bool   `json:"secret_valid"`
+	Response    string `json:"response,omitempty"`
+	ErrorCause  string `json:"error_cause,omitempty"`
+	Latency     int64  `json:"latency"`
+	Message     string `json:"message"`
+}
+
+// Validate
+// check the fields, the secret is required when requireSecret
+func (f *VpeForm) Validate(requireSecret bool) error {
+	var errs FieldErrors
+	if strings.TrimSpace(f.Name) == "" {
+		errs.Add("name", "can not be empty")
+	}
+	if !vpeIdentifierRegexp.MatchString(f.Identifier) {
+		errs.Add("identifier", "must be 1-64 letters, digits or _.:-")
+	}
+	if net.ParseIP(f.Ipaddr) == nil {
+		errs.Add("ipaddr", "invalid ip address")
+	}
+	if requireSecret && f.Secret == "" {
+		errs.Add("secret", "can not be empty")
+	}
+	if len(f.Secret) > 128 {
+		errs.Add("secret", "at most 128 characters")
+	}
+	if len(f.TacacsSecret) > 128 {
+		errs.Add("tacacs_secret", "at most 128 characters")
+	}
+	if f.VendorCode == "" {
+		f.VendorCode = vendors.VendorStandard
+	}
+	if _, ok := vendors.Vendors[f.VendorCode]; !ok {
+		errs.Add("vendor_code", "unknown vendor code %s", f.VendorCode)
+	}
+	if f.CoaPort == 0 {
+		f.CoaPort = 3799
+	}
+	if f.CoaPort < 1 || f.CoaPort > 65535 {
+		errs.Add("coa_port", "must be 1-65535")
+	}
+	return errs.Err()
+}
+
+// document
+// the stored fields without the secrets
+func (f *VpeForm) document() bson.M {
+	return bson.M{
+		"name":        f.Name,
+		"identifier":  f.Identifier,
+		"ipaddr":      f.Ipaddr,
+		"vendor_code": f.VendorCode,
+		"coa_port":    strconv.Itoa(f.CoaPort),
+		"site_id":     f.SiteId,
+		"remark":      f.Remark,
+	}
+}
+
+// checkUnique
+// the ipaddr and identifier of other vpes
+func (m *VpeManager) checkUnique(f *VpeForm) error {
+	var errs FieldErrors
 	coll := m.GetTeamsAcsCollection(TeamsacsVpe)
-	doc := coll.FindOne(context.TODO(), bson.M{"identifier": identifier})
-	err := doc.Err()
+	for _, field := range []string{"ipaddr", "identifier"} {
+		filter := bson.M{field: f.document()[field]}
+		if f.ID != "" {
+			filter["_id"] = bson.M{"$ne": f.ID}
+		}
+		if count, err := coll.CountDocuments(context.TODO(), filter); err == nil && count > 0 {
+			errs.Add(field, "%s is used by another vpe", filter[field])
+		}
+	}
+	return errs.Err()
+}
+
+// QueryVpes
+// the secrets are not returned
+func (m *VpeManager) QueryVpes(params web.RequestParams) (*web.PageResult, error) {
+	data, err := m.QueryPagerItems(params, TeamsacsVpe)
 	if err != nil {
 		return nil, err
 	}
-	var result = new(Vpe)
-	err = doc.Decode(result)
-	return result, err
+	if items, ok := data.Data.([]map[string]interface{}); ok {
+		for _, item := range items {
+			for _, name := range vpeSecretFields {
+				delete(item, name)
+			}
+		}
+	}
+	return data, nil
+}
+
+// AddVpe
+func (m *VpeManager) AddVpe(f *VpeForm) (string, error) {
+	f.ID = ""
+	if err := f.Validate(true); err != nil {
+		return "", err
+	}
+	if err := m.checkUnique(f); err != nil {
+		return "", err
+	}
+	doc := f.document()
+	for name, value := range map[string]string{"secret": f.Secret, "tacacs_secret": f.TacacsSecret} {
+		encsecret, err := m.encryptSecret(value)
+		if err != nil {
+			return "", err
+		}
+		doc[name] = encsecret
+	}
+	doc["_id"] = common.UUID()
+	_, err := m.GetTeamsAcsCollection(TeamsacsVpe).InsertOne(context.TODO(), doc)
+	m.InvalidateCache()
+	return doc["_id"].(string), err
+}
+
+// UpdateVpe
+// the secrets are changed when not empty
+func (m *VpeManager) UpdateVpe(f *VpeForm) error {
+	if f.ID == "" {
+		return FieldErrors{{Field: "id", Message: "can not be empty"}}
+	}
+	if err := f.Validate(false); err != nil {
+		return err
+	}
+	if err := m.checkUnique(f); err != nil {
+		return err
+	}
+	doc := f.document()
+	for name, value := range map[string]string{"secret": f.Secret, "tacacs_secret": f.TacacsSecret} {
+		if value == "" {
+			continue
+		}
+		encsecret, err := m.encryptSecret(value)
+		if err != nil {
+			return err
+		}
+		doc[name] = encsecret
+	}
+	result, err := m.GetTeamsAcsCollection(TeamsacsVpe).UpdateOne(context.TODO(), bson.M{"_id": f.ID}, bson.M{"$set": doc})
+	m.InvalidateCache()
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("vpe %s not exists", f.ID)
+	}
+	return nil
+}
+
+// DeleteVpe
+func (m *VpeManager) DeleteVpe(id string) error {
+	_, err := m.GetTeamsAcsCollection(TeamsacsVpe).DeleteOne(context.TODO(), bson.M{"_id": id})
+	m.InvalidateCache()
+	return err
+}
+
+// getVpeById
+// the vpe with the decrypted secrets
+func (m *VpeManager) getVpeById(id string) (*Vpe, error) {
+	var vpe Vpe
+	if err := m.GetTeamsAcsCollection(TeamsacsVpe).FindOne(context.TODO(), bson.M{"_id": id}).Decode(&vpe); err != nil {
+		return nil, fmt.Errorf("vpe %s not exists", id)
+	}
+	secret, err := m.decryptSecret(vpe.GetSecret())
+	if err != nil {
+		return nil, err
+	}
+	vpe["secret"] = secret
+	return &vpe, nil
+}
+
+// NewVpeTestPacket
+// a Status-Server with the Message-Authenticator, or a CoA-Request of a
+// session that does not exist, either is harmless to the NAS
+func NewVpeTestPacket(mode string, secret []byte) ([]byte, error) {
+	switch mode {
+	case VpeTestStatus:
+		packet := radius.New(radius.CodeStatusServer, secret)
+		packet.Add(rfc2869.MessageAuthenticator_Type, make([]byte, md5.Size))
+		b, err := packet.Encode()
+		if err != nil {
+			return nil, err
+		}
+		mac := hmac.New(md5.New, secret)
+		mac.Write(b)
+		copy(b[len(b)-md5.Size:], mac.Sum(nil))
+		return b, nil
+	case VpeTestCoa:
+		packet := radius.New(radius.CodeCoARequest, secret)
+		_ = rfc2865.UserName_SetString(packet, "teamsacs-test")
+		_ = rfc2866.AcctSessionID_SetString(packet, common.UUID())
+		return packet.Encode()
+	default:
+		return nil, FieldErrors{{Field: "mode", Message: "must be status or coa"}}
+	}
+}
+
+// CheckVpeTestResponse
+// any response proves the NAS is reachable, the response authenticator
+// proves the secret is correct
+func CheckVpeTestResponse(result *VpeTestResult, request, response, secret []byte) {
+	result.Reachable = true
+	if !radius.IsAuthenticResponse(response, request, secret) {
+		result.Message = "response authenticator mismatch, the secret is not correct"
+		return
+	}
+	result.SecretValid = true
+	packet, err := radius.Parse(response, secret)
+	if err != nil {
+		result.Message = err.Error()
+		return
+	}
+	result.Response = packet.Code.String()
+	if cause := rfc3576.ErrorCause_Get(packet); cause != 0 {
+		result.ErrorCause = cause.String()
+	}
+	result.Message = "the NAS is reachable and the secret is correct"
+}
+
+// TestVpe
+// send a test packet to the coa port of the vpe
+func (m *VpeManager) TestVpe(id, mode string, timeout time.Duration) (*VpeTestResult, error) {
+	vpe, err := m.getVpeById(id)
+	if err != nil {
+		return nil, err
+	}
+	if mode == "" {
+		mode = VpeTestCoa
+	}
+	secret := []byte(vpe.GetSecret())
+	request, err := NewVpeTestPacket(mode, secret)
+	if err != nil {
+		return nil, err
+	}
+	result := &VpeTestResult{
+		Mode:    mode,
+		Address: net.JoinHostPort(vpe.GetStringValue("ipaddr", ""), strconv.Itoa(vpe.GetIntValue("coa_port", 3799))),
+	}
+	conn, err := net.Dial("udp", result.Address)
+	if err != nil {
+		result.Message = err.Error()
+		return result, nil
+	}
+	defer conn.Close()
+	start := time.Now()
+	_ = conn.SetDeadline(start.Add(timeout))
+	if _, err = conn.Write(request); err != nil {
+		result.Message = err.Error()
+		return result, nil
+	}
+	var buf [radius.MaxPacketLength]byte
+	n, err := conn.Read(buf[:])
+	result.Latency = time.Since(start).Milliseconds()
+	if err != nil {
+		result.Message = "no response, the NAS is not reachable, or it drops the packet of a wrong secret"
+		return result, nil
+	}
+	CheckVpeTestResponse(result, request, buf[:n], secret)
+	return result, nil
 }
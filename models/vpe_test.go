@@ -0,0 +1,91 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package models
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"testing"
+
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2869"
+	"layeh.com/radius/rfc3576"
+)
+
+func TestVpeFormValidate(t *testing.T) {
+	f := &VpeForm{Name: "bras01", Identifier: "bras01", Ipaddr: "10.0.0.1", Secret: "secret"}
+	if err := f.Validate(true); err != nil {
+		t.Fatal(err)
+	}
+	if f.VendorCode != "0" || f.CoaPort != 3799 {
+		t.Fatal(f.VendorCode, f.CoaPort)
+	}
+	f = &VpeForm{Identifier: "bras 01", Ipaddr: "10.0.0", VendorCode: "12345", CoaPort: 70000}
+	errs, ok := f.Validate(true).(FieldErrors)
+	if !ok || len(errs) != 6 {
+		t.Fatal(errs)
+	}
+	if err := (&VpeForm{Name: "bras01", Identifier: "bras01", Ipaddr: "10.0.0.1", VendorCode: "14988"}).Validate(false); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVpeTestPacket(t *testing.T) {
+	secret := []byte("secret")
+	b, err := NewVpeTestPacket(VpeTestStatus, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request, err := radius.Parse(b, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if request.Code != radius.CodeStatusServer {
+		t.Fatal(request.Code)
+	}
+	ma := rfc2869.MessageAuthenticator_Get(request)
+	copy(b[len(b)-md5.Size:], make([]byte, md5.Size))
+	mac := hmac.New(md5.New, secret)
+	mac.Write(b)
+	if !hmac.Equal(ma, mac.Sum(nil)) {
+		t.Fatal("invalid message authenticator")
+	}
+
+	b, err = NewVpeTestPacket(VpeTestCoa, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request, _ = radius.Parse(b, secret)
+	response := request.Response(radius.CodeCoANAK)
+	_ = rfc3576.ErrorCause_Set(response, rfc3576.ErrorCause_Value_SessionContextNotFound)
+	rb, _ := response.Encode()
+
+	result := new(VpeTestResult)
+	CheckVpeTestResponse(result, b, rb, secret)
+	if !result.Reachable || !result.SecretValid || result.Response != "CoA-NAK" || result.ErrorCause == "" {
+		t.Fatal(result)
+	}
+	result = new(VpeTestResult)
+	CheckVpeTestResponse(result, b, rb, []byte("wrong"))
+	if !result.Reachable || result.SecretValid {
+		t.Fatal(result)
+	}
+
+	if _, err = NewVpeTestPacket("ping", secret); err == nil {
+		t.Fatal("expected mode error")
+	}
+}
@@ -0,0 +1,475 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package models
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/ca17/teamsacs/common"
+	"github.com/ca17/teamsacs/common/aes"
+	"github.com/ca17/teamsacs/common/log"
+	"github.com/ca17/teamsacs/common/web"
+	"github.com/ca17/teamsacs/constant"
+)
+
+const (
+	WebhookSignatureHeader = "X-TeamsACS-Signature"
+	WebhookEventHeader     = "X-TeamsACS-Event"
+	WebhookDeliveryHeader  = "X-TeamsACS-Delivery"
+
+	webhookDefaultRetry   = 5
+	webhookDefaultTimeout = 10
+	webhookCacheTTL       = time.Second * 30
+	webhookMaxSending     = 64
+	// the prefix of an encrypted signing secret, a secret without it is plain text
+	webhookSecretPrefix = "aes:"
+)
+
+var errWebhookSaturated = fmt.Errorf("webhook deliveries are saturated")
+
+// Webhook
+// A webhook subscriber of the session lifecycle events
+type Webhook struct {
+	ID       string   `bson:"_id,omitempty" json:"id,omitempty"`
+	Name     string   `bson:"name" json:"name"`
+	Url      string   `bson:"url" json:"url"`
+	Secret   string   `bson:"secret" json:"secret,omitempty"`
+	Events   []string `bson:"events" json:"events"`
+	MaxRetry int      `bson:"max_retry" json:"max_retry"`
+	Timeout  int      `bson:"timeout" json:"timeout"`
+	Status   string   `bson:"status" json:"status"`
+	Remark   string   `bson:"remark" json:"remark"`
+}
+
+func (w *Webhook) AddValidate() error {
+	switch {
+	case common.IsEmptyOrNA(w.Name):
+		return fmt.Errorf("invalid webhook name")
+	case !strings.HasPrefix(w.Url, "http://") && !strings.HasPrefix(w.Url, "https://"):
+		return fmt.Errorf("invalid webhook url")
+	}
+	return nil
+}
+
+// Accept
+// Whether the webhook subscribes the event type, empty events means all
+func (w *Webhook) Accept(etype string) bool {
+	if w.Status == constant.DISABLED {
+		return false
+	}
+	if len(w.Events) == 0 || etype == EventWebhookPing {
+		return true
+	}
+	return common.InSlice(etype, w.Events) || common.InSlice(EventAll, w.Events)
+}
+
+// WebhookDeadletter
+// A failed delivery after all retries
+type WebhookDeadletter struct {
+	ID        string    `bson:"_id,omitempty" json:"id,omitempty"`
+	WebhookId string    `bson:"webhook_id" json:"webhook_id"`
+	EventId   string    `bson:"event_id" json:"event_id"`
+	EventType string    `bson:"event_type" json:"event_type"`
+	Payload   string    `bson:"payload" json:"payload"`
+	Attempts  int       `bson:"attempts" json:"attempts"`
+	LastError string    `bson:"last_error" json:"last_error"`
+	Timestamp time.Time `bson:"timestamp" json:"timestamp"`
+}
+
+// SignWebhookPayload
+// hex encoded HMAC-SHA256 of the payload
+func SignWebhookPayload(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// PostWebhook
+// Send the payload once, any non 2xx status is a failure
+func PostWebhook(client *http.Client, hook *Webhook, etype, deliveryId string, payload []byte) error {
+	req, err := http.NewRequest(http.MethodPost, hook.Url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookEventHeader, etype)
+	req.Header.Set(WebhookDeliveryHeader, deliveryId)
+	if hook.Secret != "" {
+		req.Header.Set(WebhookSignatureHeader, SignWebhookPayload(hook.Secret, payload))
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook %s response status %d", hook.Url, resp.StatusCode)
+	}
+	return nil
+}
+
+// DeliverWebhook
+// Send the payload with exponential backoff retries, returns the attempts and the last error
+func DeliverWebhook(hook *Webhook, etype, deliveryId string, payload []byte, backoff time.Duration) (int, error) {
+	var timeout = hook.Timeout
+	if timeout <= 0 {
+		timeout = webhookDefaultTimeout
+	}
+	var retry = hook.MaxRetry
+	if retry <= 0 {
+		retry = webhookDefaultRetry
+	}
+	client := &http.Client{Timeout: time.Second * time.Duration(timeout)}
+	var err error
+	for attempt := 1; attempt <= retry; attempt++ {
+		if err = PostWebhook(client, hook, etype, deliveryId, payload); err == nil {
+			return attempt, nil
+		}
+		if attempt < retry {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+	return retry, err
+}
+
+// WebhookManager
+type WebhookManager struct {
+	*ModelManager
+	sync.Mutex
+	hooks    []Webhook
+	loadTime time.Time
+	sending  chan struct{}
+	inflight sync.WaitGroup
+}
+
+func (m *ModelManager) GetWebhookManager() *WebhookManager {
+	store, _ := m.ManagerMap.Get("WebhookManager")
+	return store.(*WebhookManager)
+}
+
+// getWebhooks
+// enabled webhooks, cached for a short time
+func (m *WebhookManager) getWebhooks() []Webhook {
+	m.Lock()
+	defer m.Unlock()
+	if m.hooks != nil && time.Since(m.loadTime) < webhookCacheTTL {
+		return m.hooks
+	}
+	cur, err := m.GetTeamsAcsCollection(TeamsacsWebhook).Find(context.TODO(), bson.M{"status": bson.M{"$ne": constant.DISABLED}})
+	if err != nil {
+		log.Error(err)
+		return m.hooks
+	}
+	hooks := make([]Webhook, 0)
+	if err = cur.All(context.TODO(), &hooks); err != nil {
+		log.Error(err)
+		return m.hooks
+	}
+	for i := range hooks {
+		if hooks[i].Secret, err = m.decryptSecret(hooks[i].Secret); err != nil {
+			log.Errorf("webhook %s decrypt secret error, %s", hooks[i].Name, err.Error())
+		}
+	}
+	m.hooks = hooks
+	m.loadTime = time.Now()
+	return hooks
+}
+
+func (m *WebhookManager) invalidate() {
+	m.Lock()
+	defer m.Unlock()
+	m.hooks = nil
+}
+
+// HandleEvent
+// EventBus handler, deliver the event to all subscribed webhooks
+func (m *WebhookManager) HandleEvent(event *Event) {
+	hooks := m.getWebhooks()
+	if len(hooks) == 0 {
+		return
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	for i := range hooks {
+		hook := hooks[i]
+		if !hook.Accept(event.Type) {
+			continue
+		}
+		// never block the event bus, the event is dead-lettered for a replay when all slots are busy
+		m.inflight.Add(1)
+		select {
+		case m.sending <- struct{}{}:
+			go func() {
+				defer m.inflight.Done()
+				defer func() { <-m.sending }()
+				m.deliver(&hook, event.Type, event.ID, payload)
+			}()
+		default:
+			go func() {
+				defer m.inflight.Done()
+				log.Warningf("webhook %s deliveries are saturated, dead-letter event %s", hook.Name, event.Type)
+				m.deadletter(&hook, event.Type, event.ID, payload, 0, errWebhookSaturated)
+			}()
+		}
+	}
+}
+
+// Wait
+// wait for in-flight deliveries until the context is done
+func (m *WebhookManager) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		m.inflight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (m *WebhookManager) deliver(hook *Webhook, etype, eventId string, payload []byte) {
+	attempts, err := DeliverWebhook(hook, etype, eventId, payload, time.Second)
+	if err == nil {
+		return
+	}
+	log.Errorf("webhook %s deliver event %s failure, %s", hook.Name, etype, err.Error())
+	m.deadletter(hook, etype, eventId, payload, attempts, err)
+}
+
+func (m *WebhookManager) deadletter(hook *Webhook, etype, eventId string, payload []byte, attempts int, err error) {
+	_, derr := m.GetTeamsAcsCollection(TeamsacsWebhookDeadletter).InsertOne(context.TODO(), WebhookDeadletter{
+		ID:        common.UUID(),
+		WebhookId: hook.ID,
+		EventId:   eventId,
+		EventType: etype,
+		Payload:   string(payload),
+		Attempts:  attempts,
+		LastError: err.Error(),
+		Timestamp: time.Now(),
+	})
+	if derr != nil {
+		log.Error(derr)
+	}
+}
+
+func (m *WebhookManager) encryptSecret(secret string) (string, error) {
+	encsecret, err := aes.EncryptToB64(secret, m.Config.System.Aeskey)
+	if err != nil {
+		return "", err
+	}
+	return webhookSecretPrefix + encsecret, nil
+}
+
+// decryptSecret
+// a secret without the prefix is a plain text secret saved before the encryption
+func (m *WebhookManager) decryptSecret(secret string) (string, error) {
+	if !strings.HasPrefix(secret, webhookSecretPrefix) {
+		return secret, nil
+	}
+	result, err := aes.DecryptFromB64(strings.TrimPrefix(secret, webhookSecretPrefix), m.Config.System.Aeskey)
+	if err == nil && result == "" {
+		err = fmt.Errorf("invalid encrypted secret")
+	}
+	return result, err
+}
+
+// GetWebhook
+// the webhook with the decrypted signing secret
+func (m *WebhookManager) GetWebhook(id string) (*Webhook, error) {
+	var result = new(Webhook)
+	err := m.GetTeamsAcsCollection(TeamsacsWebhook).FindOne(context.TODO(), bson.M{"_id": id}).Decode(result)
+	if err != nil {
+		return nil, err
+	}
+	if result.Secret, err = m.decryptSecret(result.Secret); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// QueryWebhooks
+// the signing secrets are not returned
+func (m *WebhookManager) QueryWebhooks(params web.RequestParams) (*web.PageResult, error) {
+	data, err := m.QueryPagerItems(params, TeamsacsWebhook)
+	if err != nil {
+		return nil, err
+	}
+	if items, ok := data.Data.([]map[string]interface{}); ok {
+		for _, item := range items {
+			delete(item, "secret")
+		}
+	}
+	return data, nil
+}
+
+// AddWebhook
+// the signing secret is stored encrypted, the hook keeps the plain text secret
+func (m *WebhookManager) AddWebhook(hook *Webhook) (string, error) {
+	if err := hook.AddValidate(); err != nil {
+		return "", err
+	}
+	hook.ID = common.UUID()
+	if hook.Secret == "" {
+		hook.Secret = common.UUID()
+	}
+	if hook.Status == "" {
+		hook.Status = constant.ENABLED
+	}
+	if hook.Events == nil {
+		hook.Events = []string{}
+	}
+	encsecret, err := m.encryptSecret(hook.Secret)
+	if err != nil {
+		return "", err
+	}
+	item := *hook
+	item.Secret = encsecret
+	_, err = m.GetTeamsAcsCollection(TeamsacsWebhook).InsertOne(context.TODO(), item)
+	m.invalidate()
+	return hook.ID, err
+}
+
+// UpdateWebhook
+func (m *WebhookManager) UpdateWebhook(hook *Webhook) error {
+	if common.IsEmptyOrNA(hook.ID) {
+		return fmt.Errorf("webhook id is empty")
+	}
+	if err := hook.AddValidate(); err != nil {
+		return err
+	}
+	data := bson.M{
+		"name":      hook.Name,
+		"url":       hook.Url,
+		"events":    hook.Events,
+		"max_retry": hook.MaxRetry,
+		"timeout":   hook.Timeout,
+		"remark":    hook.Remark,
+	}
+	if hook.Secret != "" {
+		encsecret, err := m.encryptSecret(hook.Secret)
+		if err != nil {
+			return err
+		}
+		data["secret"] = encsecret
+	}
+	if common.InSlice(hook.Status, []string{constant.ENABLED, constant.DISABLED}) {
+		data["status"] = hook.Status
+	}
+	_, err := m.GetTeamsAcsCollection(TeamsacsWebhook).UpdateOne(context.TODO(), bson.M{"_id": hook.ID}, bson.M{"$set": data})
+	m.invalidate()
+	return err
+}
+
+// DeleteWebhook
+func (m *WebhookManager) DeleteWebhook(id string) error {
+	if common.IsEmptyOrNA(id) {
+		return fmt.Errorf("webhook id is empty")
+	}
+	_, err := m.GetTeamsAcsCollection(TeamsacsWebhook).DeleteOne(context.TODO(), bson.M{"_id": id})
+	m.invalidate()
+	return err
+}
+
+// PingWebhook
+// Send a test event synchronously
+func (m *WebhookManager) PingWebhook(id string) error {
+	hook, err := m.GetWebhook(id)
+	if err != nil {
+		return err
+	}
+	event := NewEvent(EventWebhookPing, "", "")
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: time.Second * webhookDefaultTimeout}
+	return PostWebhook(client, hook, event.Type, event.ID, payload)
+}
+
+// QueryDeadletters
+func (m *WebhookManager) QueryDeadletters(params web.RequestParams) (*web.PageResult, error) {
+	return m.QueryPagerItems(params, TeamsacsWebhookDeadletter)
+}
+
+// ReplayDeadletter
+// Queue the redelivery of a failed delivery, it is removed after success
+func (m *WebhookManager) ReplayDeadletter(id string) error {
+	var item = new(WebhookDeadletter)
+	err := m.GetTeamsAcsCollection(TeamsacsWebhookDeadletter).FindOne(context.TODO(), bson.M{"_id": id}).Decode(item)
+	if err != nil {
+		return err
+	}
+	hook, err := m.GetWebhook(item.WebhookId)
+	if err != nil {
+		return fmt.Errorf("webhook %s not exists", item.WebhookId)
+	}
+	m.inflight.Add(1)
+	select {
+	case m.sending <- struct{}{}:
+		go func() {
+			defer m.inflight.Done()
+			defer func() { <-m.sending }()
+			m.replay(hook, item)
+		}()
+		return nil
+	default:
+		m.inflight.Done()
+		return errWebhookSaturated
+	}
+}
+
+// replay
+// the failed replay updates the attempts and the error of the dead letter
+func (m *WebhookManager) replay(hook *Webhook, item *WebhookDeadletter) {
+	coll := m.GetTeamsAcsCollection(TeamsacsWebhookDeadletter)
+	attempts, err := DeliverWebhook(hook, item.EventType, item.EventId, []byte(item.Payload), time.Second)
+	var derr error
+	if err != nil {
+		log.Errorf("webhook %s replay event %s failure, %s", hook.Name, item.EventType, err.Error())
+		_, derr = coll.UpdateOne(context.TODO(), bson.M{"_id": item.ID}, bson.M{
+			"$inc": bson.M{"attempts": attempts},
+			"$set": bson.M{"last_error": err.Error(), "timestamp": time.Now()},
+		})
+	} else {
+		_, derr = coll.DeleteOne(context.TODO(), bson.M{"_id": item.ID})
+	}
+	if derr != nil {
+		log.Error(derr)
+	}
+}
@@ -0,0 +1,127 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ca17/teamsacs/config"
+)
+
+func TestDeliverWebhook(t *testing.T) {
+	var requests int32
+	var received = make(chan Event, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// fail the first attempt
+		if atomic.AddInt32(&requests, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.Header.Get(WebhookSignatureHeader) != SignWebhookPayload("secret", body) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var event Event
+		_ = json.Unmarshal(body, &event)
+		received <- event
+	}))
+	defer server.Close()
+
+	hook := &Webhook{Name: "test", Url: server.URL, Secret: "secret", MaxRetry: 3}
+	event := NewAuthEvent(EventAuthAccept, "test01", "10.0.0.1", "")
+	payload, _ := json.Marshal(event)
+	attempts, err := DeliverWebhook(hook, event.Type, event.ID, payload, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 2 {
+		t.Fatalf("attempts %d != 2", attempts)
+	}
+	if e := <-received; e.Username != "test01" || e.Type != EventAuthAccept {
+		t.Fatalf("received event error %+v", e)
+	}
+}
+
+func TestDeliverWebhookFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	hook := &Webhook{Name: "test", Url: server.URL, MaxRetry: 2}
+	attempts, err := DeliverWebhook(hook, EventAcctStop, "1", []byte("{}"), time.Millisecond)
+	if err == nil || attempts != 2 {
+		t.Fatalf("delivery must fail after 2 attempts, attempts=%d", attempts)
+	}
+}
+
+func TestEventBus(t *testing.T) {
+	bus := NewEventBus()
+	var count int32
+	bus.Subscribe(EventAcctStart, func(event *Event) {
+		atomic.AddInt32(&count, 1)
+	})
+	bus.Subscribe(EventAll, func(event *Event) {
+		atomic.AddInt32(&count, 1)
+	})
+	bus.Publish(NewSessionEvent(EventAcctStart, &Accounting{Username: "test01"}))
+	bus.Publish(NewSessionEvent(EventAcctStop, &Accounting{Username: "test01"}))
+	if err := bus.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt32(&count) != 3 {
+		t.Fatalf("handled events %d != 3", count)
+	}
+}
+
+func TestEventBusCloseTimeout(t *testing.T) {
+	bus := NewEventBus()
+	release := make(chan struct{})
+	defer close(release)
+	bus.Subscribe(EventAll, func(event *Event) {
+		<-release
+	})
+	bus.Publish(NewEvent(EventAcctStart, "test01", ""))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	if err := bus.Close(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("close error %v", err)
+	}
+}
+
+func TestWebhookSecret(t *testing.T) {
+	m := &WebhookManager{ModelManager: &ModelManager{Config: &config.AppConfig{System: config.DefaultAppConfig.System}}}
+	encsecret, err := m.encryptSecret("hooksecret")
+	if err != nil || !strings.HasPrefix(encsecret, webhookSecretPrefix) || strings.Contains(encsecret, "hooksecret") {
+		t.Fatal(encsecret, err)
+	}
+	if secret, err := m.decryptSecret(encsecret); err != nil || secret != "hooksecret" {
+		t.Fatal(secret, err)
+	}
+	// the plain text secrets saved before the encryption
+	if secret, err := m.decryptSecret("plainsecret"); err != nil || secret != "plainsecret" {
+		t.Fatal(secret, err)
+	}
+}
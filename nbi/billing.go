@@ -0,0 +1,149 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package nbi
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/ca17/teamsacs/common"
+	"github.com/ca17/teamsacs/common/timeutil"
+	"github.com/ca17/teamsacs/constant"
+	"github.com/ca17/teamsacs/models"
+)
+
+// QueryTariff
+func (h *HttpHandler) QueryTariff(c echo.Context) error {
+	params := h.RequestParse(c)
+	data, err := h.GetManager().GetBillingManager().QueryTariffs(params)
+	if err != nil {
+		return h.GetInternalError(err)
+	}
+	return c.JSON(http.StatusOK, data)
+}
+
+// AddTariff
+func (h *HttpHandler) AddTariff(c echo.Context) error {
+	if h.GetUserLevel(c) != constant.NBIAdminLevel {
+		return c.NoContent(http.StatusForbidden)
+	}
+	item := new(models.Tariff)
+	common.Must(c.Bind(item))
+	id, err := h.GetManager().GetBillingManager().AddTariff(item)
+	if err != nil {
+		return h.GetInternalError(err)
+	}
+	return c.JSON(http.StatusOK, h.RestResult(map[string]string{"id": id}))
+}
+
+// UpdateTariff
+func (h *HttpHandler) UpdateTariff(c echo.Context) error {
+	if h.GetUserLevel(c) != constant.NBIAdminLevel {
+		return c.NoContent(http.StatusForbidden)
+	}
+	item := new(models.Tariff)
+	common.Must(c.Bind(item))
+	err := h.GetManager().GetBillingManager().UpdateTariff(item)
+	if err != nil {
+		return h.GetInternalError(err)
+	}
+	return c.JSON(http.StatusOK, h.RestSucc("Success"))
+}
+
+// DeleteTariff
+func (h *HttpHandler) DeleteTariff(c echo.Context) error {
+	if h.GetUserLevel(c) != constant.NBIAdminLevel {
+		return c.NoContent(http.StatusForbidden)
+	}
+	params := h.RequestParse(c)
+	id := params.GetParamMap("querymap").GetMustString("id")
+	common.Must(h.GetManager().GetBillingManager().DeleteTariff(id))
+	return c.JSON(http.StatusOK, h.RestSucc("Success"))
+}
+
+// BillingRecharge
+// amount in the smallest currency unit
+func (h *HttpHandler) BillingRecharge(c echo.Context) error {
+	if h.GetUserLevel(c) != constant.NBIAdminLevel {
+		return c.NoContent(http.StatusForbidden)
+	}
+	var form struct {
+		Username string `json:"username" form:"username"`
+		Amount   int64  `json:"amount" form:"amount"`
+		Remark   string `json:"remark" form:"remark"`
+	}
+	common.Must(c.Bind(&form))
+	entry, err := h.GetManager().GetBillingManager().Recharge(form.Username, form.Amount, h.GetUsername(c), form.Remark)
+	if err != nil {
+		return h.GetInternalError(err)
+	}
+	return c.JSON(http.StatusOK, h.RestResult(entry))
+}
+
+// QueryBillingBalance
+func (h *HttpHandler) QueryBillingBalance(c echo.Context) error {
+	account, err := h.GetManager().GetBillingManager().GetAccount(c.QueryParam("username"))
+	if err != nil {
+		return h.GetInternalError(err)
+	}
+	return c.JSON(http.StatusOK, h.RestResult(account))
+}
+
+// QueryBillingLedger
+func (h *HttpHandler) QueryBillingLedger(c echo.Context) error {
+	params := h.RequestParse(c)
+	data, err := h.GetManager().GetBillingManager().QueryLedger(params)
+	if err != nil {
+		return h.GetInternalError(err)
+	}
+	return c.JSON(http.StatusOK, data)
+}
+
+// QueryBillingStatement
+// the statement of the current month by default
+func (h *HttpHandler) QueryBillingStatement(c echo.Context) error {
+	loc := h.GetManager().Location
+	now := time.Now().In(loc)
+	start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, loc)
+	end := now
+	var err error
+	if v := c.QueryParam("start"); v != "" {
+		start, err = time.ParseInLocation(timeutil.YYYYMMDDHHMMSS_LAYOUT, v, loc)
+		common.Must(err)
+	}
+	if v := c.QueryParam("end"); v != "" {
+		end, err = time.ParseInLocation(timeutil.YYYYMMDDHHMMSS_LAYOUT, v, loc)
+		common.Must(err)
+	}
+	st, err := h.GetManager().GetBillingManager().GetStatement(c.QueryParam("username"), start, end)
+	if err != nil {
+		return h.GetInternalError(err)
+	}
+	return c.JSON(http.StatusOK, h.RestResult(st))
+}
+
+// RunBilling
+// charge the stopped sessions now
+func (h *HttpHandler) RunBilling(c echo.Context) error {
+	if h.GetUserLevel(c) != constant.NBIAdminLevel {
+		return c.NoContent(http.StatusForbidden)
+	}
+	go h.GetManager().GetBillingManager().RunBilling()
+	return c.JSON(http.StatusOK, h.RestSucc("Success"))
+}
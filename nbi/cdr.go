@@ -0,0 +1,71 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package nbi
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/ca17/teamsacs/common"
+	"github.com/ca17/teamsacs/common/timeutil"
+)
+
+// QueryCdrFiles
+func (h *HttpHandler) QueryCdrFiles(c echo.Context) error {
+	data, err := h.GetManager().GetCdrManager().ListCdrFiles()
+	common.Must(err)
+	return c.JSON(http.StatusOK, h.RestResult(data))
+}
+
+// DownloadCdrFile
+// download cdr file or checksum file by name
+func (h *HttpHandler) DownloadCdrFile(c echo.Context) error {
+	name := c.QueryParam("name")
+	filename, err := h.GetManager().GetCdrManager().GetCdrFilePath(name)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	return c.Attachment(filename, name)
+}
+
+// ExportCdrRange
+// re-export the accounting records of a time range
+func (h *HttpHandler) ExportCdrRange(c echo.Context) error {
+	params := h.RequestParse(c)
+	loc := h.GetManager().Location
+	start, err := time.ParseInLocation(timeutil.YYYYMMDDHHMMSS_LAYOUT, params.GetMustString("start"), loc)
+	common.Must(err)
+	end, err := time.ParseInLocation(timeutil.YYYYMMDDHHMMSS_LAYOUT, params.GetMustString("end"), loc)
+	common.Must(err)
+	data, err := h.GetManager().GetCdrManager().ExportRange(start, end, params.GetStringWithDefval("format", "csv"))
+	if err != nil {
+		return h.GetInternalError(err)
+	}
+	return c.JSON(http.StatusOK, h.RestResult(data))
+}
+
+// RunCdrExport
+// append new accounting records to the rotating cdr files now
+func (h *HttpHandler) RunCdrExport(c echo.Context) error {
+	total, err := h.GetManager().GetCdrManager().ExportNewRecords()
+	if err != nil {
+		return h.GetInternalError(err)
+	}
+	return c.JSON(http.StatusOK, h.RestResult(map[string]int64{"total": total}))
+}
@@ -17,7 +17,9 @@
 package nbi
 
 import (
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"path"
 	"sort"
@@ -27,30 +29,45 @@ import (
 
 	"github.com/ca17/teamsacs/common"
 	"github.com/ca17/teamsacs/common/log"
+	"github.com/ca17/teamsacs/constant"
+	"github.com/ca17/teamsacs/models"
 )
 
-// A generic data CRUD management API with no predefined schema,
+// A generic data CRUD management API, validated by the schema registry
 
 // QueryData
 func (h *HttpHandler) QueryData(c echo.Context) error {
+	if err := h.checkDataCollection(c); err != nil {
+		return err
+	}
 	params := h.RequestParse(c)
 	params["collname"] = c.Param("collname")
 	data, err := h.GetManager().GetDataManager().QueryItems(params,c.Param("collname"))
-	common.Must(err)
+	if err != nil {
+		return h.GetValidateError(c, err)
+	}
 	return c.JSON(http.StatusOK, data)
 }
 
 // QueryData
 func (h *HttpHandler) QueryPageData(c echo.Context) error {
+	if err := h.checkDataCollection(c); err != nil {
+		return err
+	}
 	params := h.RequestParse(c)
 	params["collname"] = c.Param("collname")
 	data, err := h.GetManager().GetDataManager().QueryPagerItems(params, c.Param("collname"))
-	common.Must(err)
+	if err != nil {
+		return h.GetValidateError(c, err)
+	}
 	return c.JSON(http.StatusOK, data)
 }
 
 // QueryData
 func (h *HttpHandler) QueryDataOptions(c echo.Context) error {
+	if err := h.checkDataCollection(c); err != nil {
+		return err
+	}
 	params := h.RequestParse(c)
 	params["collname"] = c.Param("collname")
 	data, err := h.GetManager().GetDataManager().QueryItemOptions(params, c.Param("collname"))
@@ -60,6 +77,9 @@ func (h *HttpHandler) QueryDataOptions(c echo.Context) error {
 
 // AddData
 func (h *HttpHandler) GetData(c echo.Context) error {
+	if err := h.checkDataCollection(c); err != nil {
+		return err
+	}
 	params := h.RequestParse(c)
 	params["collname"] = c.Param("collname")
 	r, err := h.GetManager().GetDataManager().GetData(params)
@@ -69,6 +89,9 @@ func (h *HttpHandler) GetData(c echo.Context) error {
 
 // AddData
 func (h *HttpHandler) GetDataValues(c echo.Context) error {
+	if err := h.checkDataCollection(c); err != nil {
+		return err
+	}
 	params := h.RequestParse(c)
 	params["collname"] = c.Param("collname")
 	r, err := h.GetManager().GetDataManager().GetDataNameValues(params)
@@ -78,22 +101,35 @@ func (h *HttpHandler) GetDataValues(c echo.Context) error {
 
 // AddData
 func (h *HttpHandler) AddData(c echo.Context) error {
+	if err := h.checkDataCollection(c); err != nil {
+		return err
+	}
 	params := h.RequestParse(c)
 	params["collname"] = c.Param("collname")
-	common.Must(h.GetManager().GetDataManager().AddData(params))
+	if err := h.GetManager().GetDataManager().AddData(params); err != nil {
+		return h.GetValidateError(c, err)
+	}
 	return c.JSON(http.StatusOK, h.RestSucc("Success"))
 }
 
 // UpdateData
 func (h *HttpHandler) UpdateData(c echo.Context) error {
+	if err := h.checkDataCollection(c); err != nil {
+		return err
+	}
 	params := h.RequestParse(c)
 	params["collname"] = c.Param("collname")
-	common.Must(h.GetManager().GetDataManager().UpdateData(params))
+	if err := h.GetManager().GetDataManager().UpdateData(params); err != nil {
+		return h.GetValidateError(c, err)
+	}
 	return c.JSON(http.StatusOK, h.RestSucc("Success"))
 }
 
 // DeleteData
 func (h *HttpHandler) DeleteData(c echo.Context) error {
+	if err := h.checkDataCollection(c); err != nil {
+		return err
+	}
 	params := h.RequestParse(c)
 	params["collname"] = c.Param("collname")
 	common.Must(h.GetManager().GetDataManager().DeleteData(params))
@@ -102,20 +138,91 @@ func (h *HttpHandler) DeleteData(c echo.Context) error {
 
 // ImportData
 func (h *HttpHandler) ImportData(c echo.Context) error {
+	if err := h.checkDataCollection(c); err != nil {
+		return err
+	}
 	params := h.RequestParse(c)
 	params["collname"] = c.Param("collname")
 	collname := params.GetMustString("collname")
 	items, err := h.FetchExcelData(c, collname)
-	var datas = make([]interface{}, 0)
+	common.Must(err)
+	var datas = make([]map[string]interface{}, 0, len(items))
 	for _, item := range items {
-		_id, ok := item["_id"]
-		if !ok || common.IsEmptyOrNA(_id) {
-			item["_id"] = common.UUID()
+		data := make(map[string]interface{}, len(item))
+		for k, v := range item {
+			data[k] = v
 		}
-		datas = append(datas, item)
+		if common.IsEmptyOrNA(item["_id"]) {
+			data["_id"] = common.UUID()
+		}
+		datas = append(datas, data)
+	}
+	if err = h.GetManager().GetDataManager().AddBatchData(collname, datas); err != nil {
+		return h.GetValidateError(c, err)
+	}
+	return c.JSON(http.StatusOK, h.RestSucc("Success"))
+}
+
+// checkDataCollection
+// the protected collections are forbidden
+func (h *HttpHandler) checkDataCollection(c echo.Context) error {
+	err := h.GetManager().GetDataManager().CheckDataCollection(c.Param("collname"))
+	if errors.Is(err, models.ErrProtectedCollection) {
+		return c.JSON(http.StatusForbidden, h.RestError(err.Error()))
+	}
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, h.RestError(err.Error()))
+	}
+	return nil
+}
+
+// GetDataSchema
+// the JSON Schema of the collection, an empty object if not defined
+func (h *HttpHandler) GetDataSchema(c echo.Context) error {
+	if err := h.checkDataCollection(c); err != nil {
+		return err
+	}
+	schema, err := h.GetManager().GetDataManager().GetDataSchema(c.Param("collname"))
+	if err != nil {
+		return h.GetInternalError(err)
+	}
+	if schema == nil {
+		return c.JSON(http.StatusOK, h.RestResult(map[string]interface{}{}))
+	}
+	return c.JSON(http.StatusOK, h.RestResult(schema))
+}
+
+// UpdateDataSchema
+// the request body is the JSON Schema
+func (h *HttpHandler) UpdateDataSchema(c echo.Context) error {
+	if h.GetUserLevel(c) != constant.NBIAdminLevel {
+		return c.NoContent(http.StatusForbidden)
+	}
+	if err := h.checkDataCollection(c); err != nil {
+		return err
+	}
+	body, err := ioutil.ReadAll(c.Request().Body)
+	if err != nil {
+		return h.GetInternalError(err)
+	}
+	schema, err := h.GetManager().GetDataManager().SetDataSchema(c.Param("collname"), body, h.GetUsername(c))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, h.RestError(err.Error()))
+	}
+	return c.JSON(http.StatusOK, h.RestResult(schema))
+}
+
+// DeleteDataSchema
+func (h *HttpHandler) DeleteDataSchema(c echo.Context) error {
+	if h.GetUserLevel(c) != constant.NBIAdminLevel {
+		return c.NoContent(http.StatusForbidden)
+	}
+	if err := h.checkDataCollection(c); err != nil {
+		return err
+	}
+	if err := h.GetManager().GetDataManager().DeleteDataSchema(c.Param("collname")); err != nil {
+		return h.GetInternalError(err)
 	}
-	common.Must(h.GetManager().GetDataManager().AddBatchData(collname, datas))
-	common.Must(err)
 	return c.JSON(http.StatusOK, h.RestSucc("Success"))
 }
 
@@ -127,11 +234,16 @@ var COLNAMES = map[int]string{0: "A", 1: "B", 2: "C", 3: "D", 4: "E", 5: "F", 6:
 
 // ExportData
 func (h *HttpHandler) ExportData(c echo.Context) error {
+	if err := h.checkDataCollection(c); err != nil {
+		return err
+	}
 	params := h.RequestParse(c)
 	params["collname"] = c.Param("collname")
 	collname := params.GetMustString("collname")
 	data, err := h.GetManager().GetDataManager().QueryItems(params, collname)
-	common.Must(err)
+	if err != nil {
+		return h.GetValidateError(c, err)
+	}
 	sheet := collname
 	filename := fmt.Sprintf("%s-%d.xlsx", sheet, common.UUIDint64())
 	filepath := path.Join(h.GetConfig().GetDataDir(), filename)
@@ -0,0 +1,67 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package nbi
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/ca17/teamsacs/common"
+	"github.com/ca17/teamsacs/common/timeutil"
+)
+
+// QueryFlowStat
+// the minute aggregates, filter by username
+func (h *HttpHandler) QueryFlowStat(c echo.Context) error {
+	params := h.RequestParse(c)
+	data, err := h.GetManager().GetFlowManager().QueryFlowStats(params)
+	if err != nil {
+		return h.GetInternalError(err)
+	}
+	return c.JSON(http.StatusOK, data)
+}
+
+// QueryFlowTop
+// the top talkers, by subscriber (default), port or subscriber_port,
+// the default time range is the last hour
+func (h *HttpHandler) QueryFlowTop(c echo.Context) error {
+	loc := h.GetManager().Location
+	end := time.Now().In(loc)
+	start := end.Add(-time.Hour)
+	var err error
+	if v := c.QueryParam("start"); v != "" {
+		start, err = time.ParseInLocation(timeutil.YYYYMMDDHHMMSS_LAYOUT, v, loc)
+		common.Must(err)
+	}
+	if v := c.QueryParam("end"); v != "" {
+		end, err = time.ParseInLocation(timeutil.YYYYMMDDHHMMSS_LAYOUT, v, loc)
+		common.Must(err)
+	}
+	var limit int64 = 10
+	if v := c.QueryParam("limit"); v != "" {
+		limit, err = strconv.ParseInt(v, 10, 64)
+		common.Must(err)
+	}
+	data, err := h.GetManager().GetFlowManager().QueryFlowTop(c.QueryParam("by"), c.QueryParam("username"), start, end, limit)
+	if err != nil {
+		return h.GetInternalError(err)
+	}
+	return c.JSON(http.StatusOK, h.RestResult(data))
+}
@@ -0,0 +1,405 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package nbi
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/ca17/teamsacs/common"
+	"github.com/ca17/teamsacs/common/genieacs"
+	"github.com/ca17/teamsacs/constant"
+	"github.com/ca17/teamsacs/models"
+)
+
+// AddCpeTask
+// push a TR-069 task to the CPE by GenieACS, now sends a connection request
+func (h *HttpHandler) AddCpeTask(c echo.Context) error {
+	if h.GetUserLevel(c) != constant.NBIAdminLevel {
+		return c.NoContent(http.StatusForbidden)
+	}
+	var form struct {
+		Sn              string          `json:"sn"`
+		Name            string          `json:"name"`
+		Now             bool            `json:"now"`
+		ParameterNames  []string        `json:"parameter_names"`
+		ParameterValues [][]interface{} `json:"parameter_values"`
+		ObjectName      string          `json:"object_name"`
+		FileType        string          `json:"file_type"`
+		FileName        string          `json:"file_name"`
+		TargetFileName  string          `json:"target_file_name"`
+	}
+	common.Must(c.Bind(&form))
+	task := &genieacs.Task{
+		Name:            form.Name,
+		ParameterNames:  form.ParameterNames,
+		ParameterValues: form.ParameterValues,
+		ObjectName:      form.ObjectName,
+		FileType:        form.FileType,
+		FileName:        form.FileName,
+		TargetFileName:  form.TargetFileName,
+	}
+	item, err := h.GetManager().GetGenieacsManager().CreateCpeTask(form.Sn, task, form.Now, h.GetUsername(c))
+	if err != nil {
+		return h.GetInternalError(err)
+	}
+	return c.JSON(http.StatusOK, h.RestResult(item))
+}
+
+// QueryCpeTask
+func (h *HttpHandler) QueryCpeTask(c echo.Context) error {
+	params := h.RequestParse(c)
+	data, err := h.GetManager().GetGenieacsManager().QueryCpeTasks(params)
+	if err != nil {
+		return h.GetInternalError(err)
+	}
+	return c.JSON(http.StatusOK, data)
+}
+
+// RetryCpeTask
+func (h *HttpHandler) RetryCpeTask(c echo.Context) error {
+	if h.GetUserLevel(c) != constant.NBIAdminLevel {
+		return c.NoContent(http.StatusForbidden)
+	}
+	params := h.RequestParse(c)
+	id := params.GetParamMap("querymap").GetMustString("id")
+	err := h.GetManager().GetGenieacsManager().RetryCpeTask(id)
+	if err != nil {
+		return h.GetInternalError(err)
+	}
+	return c.JSON(http.StatusOK, h.RestSucc("Success"))
+}
+
+// DeleteCpeTask
+func (h *HttpHandler) DeleteCpeTask(c echo.Context) error {
+	if h.GetUserLevel(c) != constant.NBIAdminLevel {
+		return c.NoContent(http.StatusForbidden)
+	}
+	params := h.RequestParse(c)
+	id := params.GetParamMap("querymap").GetMustString("id")
+	err := h.GetManager().GetGenieacsManager().DeleteCpeTask(id)
+	if err != nil {
+		return h.GetInternalError(err)
+	}
+	return c.JSON(http.StatusOK, h.RestSucc("Success"))
+}
+
+// SyncCpeTask
+// poll the task status now
+func (h *HttpHandler) SyncCpeTask(c echo.Context) error {
+	err := h.GetManager().GetGenieacsManager().SyncCpeTasks()
+	if err != nil {
+		return h.GetInternalError(err)
+	}
+	return c.JSON(http.StatusOK, h.RestSucc("Success"))
+}
+
+// paramPaths
+// the path query parameters, repeated or comma separated
+func paramPaths(c echo.Context) []string {
+	paths := make([]string, 0)
+	for _, v := range c.QueryParams()["path"] {
+		for _, p := range strings.Split(v, ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				paths = append(paths, p)
+			}
+		}
+	}
+	return paths
+}
+
+// QueryCpeParams
+// the flattened parameter tree of the CPE, path supports * wildcards
+func (h *HttpHandler) QueryCpeParams(c echo.Context) error {
+	_, items, err := h.GetManager().GetGenieacsManager().QueryDeviceParams(c.Param("sn"), paramPaths(c))
+	if err != nil {
+		return h.GetInternalError(err)
+	}
+	return c.JSON(http.StatusOK, items)
+}
+
+// AddCpeParamSnapshot
+func (h *HttpHandler) AddCpeParamSnapshot(c echo.Context) error {
+	if h.GetUserLevel(c) != constant.NBIAdminLevel {
+		return c.NoContent(http.StatusForbidden)
+	}
+	var form struct {
+		Paths  []string `json:"paths"`
+		Remark string   `json:"remark"`
+	}
+	common.Must(c.Bind(&form))
+	item, err := h.GetManager().GetGenieacsManager().AddDeviceParamSnapshot(c.Param("sn"), form.Paths, form.Remark, h.GetUsername(c))
+	if err != nil {
+		return h.GetInternalError(err)
+	}
+	item.Params = nil
+	return c.JSON(http.StatusOK, h.RestResult(item))
+}
+
+// QueryCpeParamSnapshot
+func (h *HttpHandler) QueryCpeParamSnapshot(c echo.Context) error {
+	params := h.RequestParse(c)
+	data, err := h.GetManager().GetGenieacsManager().QueryDeviceParamSnapshots(c.Param("sn"), params)
+	if err != nil {
+		return h.GetInternalError(err)
+	}
+	return c.JSON(http.StatusOK, data)
+}
+
+// DiffCpeParams
+// the changes from the snapshot to another snapshot or the current parameters
+func (h *HttpHandler) DiffCpeParams(c echo.Context) error {
+	items, err := h.GetManager().GetGenieacsManager().DiffDeviceParamSnapshot(c.Param("sn"), c.QueryParam("from"), c.QueryParam("to"))
+	if err != nil {
+		return h.GetInternalError(err)
+	}
+	return c.JSON(http.StatusOK, items)
+}
+
+// SyncCpeInventory
+// update the cpe records from the GenieACS devices now
+func (h *HttpHandler) SyncCpeInventory(c echo.Context) error {
+	if h.GetUserLevel(c) != constant.NBIAdminLevel {
+		return c.NoContent(http.StatusForbidden)
+	}
+	result, err := h.GetManager().GetGenieacsManager().SyncCpeInventory()
+	if err != nil {
+		return h.GetInternalError(err)
+	}
+	return c.JSON(http.StatusOK, h.RestResult(result))
+}
+
+// QueryCpeSyncHistory
+func (h *HttpHandler) QueryCpeSyncHistory(c echo.Context) error {
+	params := h.RequestParse(c)
+	data, err := h.GetManager().GetGenieacsManager().QueryCpeSyncHistory(params)
+	if err != nil {
+		return h.GetInternalError(err)
+	}
+	return c.JSON(http.StatusOK, data)
+}
+
+// QueryCpeStatus
+// the monitored online states of the devices
+func (h *HttpHandler) QueryCpeStatus(c echo.Context) error {
+	params := h.RequestParse(c)
+	data, err := h.GetManager().GetGenieacsManager().QueryCpeStatus(params)
+	if err != nil {
+		return h.GetInternalError(err)
+	}
+	return c.JSON(http.StatusOK, data)
+}
+
+// QueryCpeStatusEvents
+func (h *HttpHandler) QueryCpeStatusEvents(c echo.Context) error {
+	params := h.RequestParse(c)
+	data, err := h.GetManager().GetGenieacsManager().QueryCpeStatusEvents(params)
+	if err != nil {
+		return h.GetInternalError(err)
+	}
+	return c.JSON(http.StatusOK, data)
+}
+
+// CheckCpeStatus
+// evaluate the device states now, returns the alerts sent
+func (h *HttpHandler) CheckCpeStatus(c echo.Context) error {
+	if h.GetUserLevel(c) != constant.NBIAdminLevel {
+		return c.NoContent(http.StatusForbidden)
+	}
+	alerts, err := h.GetManager().GetGenieacsManager().CheckCpeStatus()
+	if err != nil {
+		return h.GetInternalError(err)
+	}
+	return c.JSON(http.StatusOK, h.RestResult(alerts))
+}
+
+// QueryCpeHealth
+// the fleet health summary, by model, firmware or model_firmware
+func (h *HttpHandler) QueryCpeHealth(c echo.Context) error {
+	data, err := h.GetManager().GetGenieacsManager().QueryCpeHealth(c.QueryParam("by"))
+	if err != nil {
+		return h.GetInternalError(err)
+	}
+	return c.JSON(http.StatusOK, data)
+}
+
+// AddFirmwareCampaign
+func (h *HttpHandler) AddFirmwareCampaign(c echo.Context) error {
+	if h.GetUserLevel(c) != constant.NBIAdminLevel {
+		return c.NoContent(http.StatusForbidden)
+	}
+	item := new(models.FirmwareCampaign)
+	common.Must(c.Bind(item))
+	item.Operator = h.GetUsername(c)
+	if err := h.GetManager().GetGenieacsManager().AddFirmwareCampaign(item); err != nil {
+		return h.GetInternalError(err)
+	}
+	return c.JSON(http.StatusOK, h.RestResult(item))
+}
+
+// QueryFirmwareCampaign
+func (h *HttpHandler) QueryFirmwareCampaign(c echo.Context) error {
+	params := h.RequestParse(c)
+	data, err := h.GetManager().GetGenieacsManager().QueryFirmwareCampaigns(params)
+	if err != nil {
+		return h.GetInternalError(err)
+	}
+	return c.JSON(http.StatusOK, data)
+}
+
+// QueryCampaignDevice
+// the per device progress of a campaign
+func (h *HttpHandler) QueryCampaignDevice(c echo.Context) error {
+	params := h.RequestParse(c)
+	data, err := h.GetManager().GetGenieacsManager().QueryCampaignDevices(params)
+	if err != nil {
+		return h.GetInternalError(err)
+	}
+	return c.JSON(http.StatusOK, data)
+}
+
+// StartFirmwareCampaign
+// start a draft campaign, or resume a paused or stopped campaign
+func (h *HttpHandler) StartFirmwareCampaign(c echo.Context) error {
+	if h.GetUserLevel(c) != constant.NBIAdminLevel {
+		return c.NoContent(http.StatusForbidden)
+	}
+	params := h.RequestParse(c)
+	id := params.GetParamMap("querymap").GetMustString("id")
+	item, err := h.GetManager().GetGenieacsManager().StartFirmwareCampaign(id)
+	if err != nil {
+		return h.GetInternalError(err)
+	}
+	return c.JSON(http.StatusOK, h.RestResult(item))
+}
+
+// PauseFirmwareCampaign
+func (h *HttpHandler) PauseFirmwareCampaign(c echo.Context) error {
+	if h.GetUserLevel(c) != constant.NBIAdminLevel {
+		return c.NoContent(http.StatusForbidden)
+	}
+	params := h.RequestParse(c)
+	id := params.GetParamMap("querymap").GetMustString("id")
+	if err := h.GetManager().GetGenieacsManager().PauseFirmwareCampaign(id); err != nil {
+		return h.GetInternalError(err)
+	}
+	return c.JSON(http.StatusOK, h.RestSucc("Success"))
+}
+
+// StepFirmwareCampaign
+// run the campaign orchestrator now
+func (h *HttpHandler) StepFirmwareCampaign(c echo.Context) error {
+	if h.GetUserLevel(c) != constant.NBIAdminLevel {
+		return c.NoContent(http.StatusForbidden)
+	}
+	params := h.RequestParse(c)
+	id := params.GetParamMap("querymap").GetMustString("id")
+	manager := h.GetManager().GetGenieacsManager()
+	item, err := manager.GetFirmwareCampaign(id)
+	if err != nil {
+		return h.GetInternalError(err)
+	}
+	if err = manager.StepFirmwareCampaign(item); err != nil {
+		return h.GetInternalError(err)
+	}
+	return c.JSON(http.StatusOK, h.RestResult(item))
+}
+
+// DeleteFirmwareCampaign
+func (h *HttpHandler) DeleteFirmwareCampaign(c echo.Context) error {
+	if h.GetUserLevel(c) != constant.NBIAdminLevel {
+		return c.NoContent(http.StatusForbidden)
+	}
+	params := h.RequestParse(c)
+	id := params.GetParamMap("querymap").GetMustString("id")
+	if err := h.GetManager().GetGenieacsManager().DeleteFirmwareCampaign(id); err != nil {
+		return h.GetInternalError(err)
+	}
+	return c.JSON(http.StatusOK, h.RestSucc("Success"))
+}
+
+// AddCpeProfile
+// the request body is the YAML or JSON profile source
+func (h *HttpHandler) AddCpeProfile(c echo.Context) error {
+	if h.GetUserLevel(c) != constant.NBIAdminLevel {
+		return c.NoContent(http.StatusForbidden)
+	}
+	spec, err := ioutil.ReadAll(c.Request().Body)
+	if err != nil {
+		return h.GetInternalError(err)
+	}
+	manager := h.GetManager().GetGenieacsManager()
+	item, err := manager.AddCpeProfile(spec, h.GetUsername(c))
+	if err != nil {
+		return h.GetInternalError(err)
+	}
+	if _, err = manager.ApplyCpeProfile(item.Name); err != nil {
+		return h.GetInternalError(err)
+	}
+	return c.JSON(http.StatusOK, h.RestResult(item))
+}
+
+// QueryCpeProfile
+func (h *HttpHandler) QueryCpeProfile(c echo.Context) error {
+	params := h.RequestParse(c)
+	data, err := h.GetManager().GetGenieacsManager().QueryCpeProfiles(params)
+	if err != nil {
+		return h.GetInternalError(err)
+	}
+	return c.JSON(http.StatusOK, data)
+}
+
+// ApplyCpeProfile
+// write the preset and provision of the profile to GenieACS again
+func (h *HttpHandler) ApplyCpeProfile(c echo.Context) error {
+	if h.GetUserLevel(c) != constant.NBIAdminLevel {
+		return c.NoContent(http.StatusForbidden)
+	}
+	params := h.RequestParse(c)
+	id := params.GetParamMap("querymap").GetMustString("id")
+	preset, err := h.GetManager().GetGenieacsManager().ApplyCpeProfile(id)
+	if err != nil {
+		return h.GetInternalError(err)
+	}
+	return c.JSON(http.StatusOK, h.RestResult(preset))
+}
+
+// DeleteCpeProfile
+func (h *HttpHandler) DeleteCpeProfile(c echo.Context) error {
+	if h.GetUserLevel(c) != constant.NBIAdminLevel {
+		return c.NoContent(http.StatusForbidden)
+	}
+	params := h.RequestParse(c)
+	id := params.GetParamMap("querymap").GetMustString("id")
+	if err := h.GetManager().GetGenieacsManager().DeleteCpeProfile(id); err != nil {
+		return h.GetInternalError(err)
+	}
+	return c.JSON(http.StatusOK, h.RestSucc("Success"))
+}
+
+// QueryCpeDrift
+// the difference between the assigned profiles and the device parameters
+func (h *HttpHandler) QueryCpeDrift(c echo.Context) error {
+	data, err := h.GetManager().GetGenieacsManager().DetectCpeDrift(c.Param("sn"))
+	if err != nil {
+		return h.GetInternalError(err)
+	}
+	return c.JSON(http.StatusOK, h.RestResult(data))
+}
@@ -18,10 +18,13 @@ package nbi
 
 import (
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/labstack/echo/v4"
 
 	"github.com/ca17/teamsacs/common"
+	"github.com/ca17/teamsacs/common/timeutil"
 )
 
 // QueryMikrotikDeviceInterfaces
@@ -67,3 +70,32 @@ func (h *HttpHandler) QueryMikrotikDeviceIpInterfaces(c echo.Context) error {
 	result["data"] = data.Items
 	return c.JSON(http.StatusOK, result)
 }
+
+// QueryMikrotikDeviceInterfaceHistory
+// the interface rates of the time range, the default range is the last day
+func (h *HttpHandler) QueryMikrotikDeviceInterfaceHistory(c echo.Context) error {
+	loc := h.GetManager().Location
+	end := time.Now().In(loc)
+	start := end.Add(-time.Hour * 24)
+	var err error
+	if v := c.QueryParam("start"); v != "" {
+		start, err = time.ParseInLocation(timeutil.YYYYMMDDHHMMSS_LAYOUT, v, loc)
+		common.Must(err)
+	}
+	if v := c.QueryParam("end"); v != "" {
+		end, err = time.ParseInLocation(timeutil.YYYYMMDDHHMMSS_LAYOUT, v, loc)
+		common.Must(err)
+	}
+	var points = 288
+	if v := c.QueryParam("points"); v != "" {
+		points, err = strconv.Atoi(v)
+		common.Must(err)
+	}
+	data, err := h.GetManager().GetGenieacsManager().QueryIfRates(c.QueryParam("sn"), c.QueryParam("interface"), start, end, points)
+	if err != nil {
+		return h.GetInternalError(err)
+	}
+	var result = make(map[string]interface{})
+	result["data"] = data
+	return c.JSON(http.StatusOK, result)
+}
@@ -116,6 +116,20 @@ func (h *HttpHandler) RestError(msg string) *RestResult {
 	}
 }
 
+// GetValidateError
+// the field errors are a bad request with the errors as data
+func (h *HttpHandler) GetValidateError(c echo.Context, err error) error {
+	if ferrs, ok := err.(models.FieldErrors); ok {
+		return c.JSON(http.StatusBadRequest, &RestResult{
+			Code:    9999,
+			Msgtype: "error",
+			Msg:     ferrs.Error(),
+			Data:    ferrs,
+		})
+	}
+	return h.GetInternalError(err)
+}
+
 func (h *HttpHandler) ParseFormInt64(c echo.Context, name string) (int64, error) {
 	return strconv.ParseInt(c.FormValue("id"), 10, 64)
 
@@ -163,6 +177,7 @@ func (h *HttpHandler) RequestParseGet(c echo.Context) web.RequestParams {
 	querymap := make(map[string]interface{})
 	filtermap := make(map[string]interface{})
 	sortmap := make(map[string]interface{})
+	ormap := make(map[string]interface{})
 	for k, vs := range c.QueryParams() {
 		if common.InSlice(k, []string{"start", "count"}){
 			query[k] = vs[0]
@@ -170,6 +185,8 @@ func (h *HttpHandler) RequestParseGet(c echo.Context) web.RequestParams {
 			filtermap[k[7:len(k)-1]] = vs[0]
 		} else if strings.HasPrefix(k, "sort[") && vs[0] != ""{
 			sortmap[k[5:len(k)-1]] = vs[0]
+		} else if strings.HasPrefix(k, "or[") && vs[0] != "" {
+			ormap[k[3:len(k)-1]] = vs[0]
 		}else if vs[0] != "" {
 			querymap[k] = vs[0]
 		}
@@ -177,6 +194,7 @@ func (h *HttpHandler) RequestParseGet(c echo.Context) web.RequestParams {
 	query["querymap"] = querymap
 	query["filtermap"] = filtermap
 	query["sortmap"] = sortmap
+	query["ormap"] = ormap
 	return query
 }
 
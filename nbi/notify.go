@@ -0,0 +1,100 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package nbi
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/ca17/teamsacs/common"
+	"github.com/ca17/teamsacs/constant"
+	"github.com/ca17/teamsacs/models"
+)
+
+// QueryNotifyTemplate
+func (h *HttpHandler) QueryNotifyTemplate(c echo.Context) error {
+	data, err := h.GetManager().GetNotifyManager().QueryNotifyTemplates()
+	if err != nil {
+		return h.GetInternalError(err)
+	}
+	return c.JSON(http.StatusOK, h.RestResult(data))
+}
+
+// UpdateNotifyTemplate
+func (h *HttpHandler) UpdateNotifyTemplate(c echo.Context) error {
+	if h.GetUserLevel(c) != constant.NBIAdminLevel {
+		return c.NoContent(http.StatusForbidden)
+	}
+	item := new(models.NotifyTemplate)
+	common.Must(c.Bind(item))
+	err := h.GetManager().GetNotifyManager().UpdateNotifyTemplate(item)
+	if err != nil {
+		return h.GetInternalError(err)
+	}
+	return c.JSON(http.StatusOK, h.RestSucc("Success"))
+}
+
+// QueryNotifyLog
+func (h *HttpHandler) QueryNotifyLog(c echo.Context) error {
+	params := h.RequestParse(c)
+	data, err := h.GetManager().GetNotifyManager().QueryNotifyLogs(params)
+	if err != nil {
+		return h.GetInternalError(err)
+	}
+	return c.JSON(http.StatusOK, data)
+}
+
+// RunNotify
+// run the expire and quota notify now
+func (h *HttpHandler) RunNotify(c echo.Context) error {
+	if h.GetUserLevel(c) != constant.NBIAdminLevel {
+		return c.NoContent(http.StatusForbidden)
+	}
+	go h.GetManager().GetNotifyManager().RunNotify()
+	return c.JSON(http.StatusOK, h.RestSucc("Success"))
+}
+
+// RenewSubscribe
+// extend the subscriber expire time by a plan period
+func (h *HttpHandler) RenewSubscribe(c echo.Context) error {
+	if h.GetUserLevel(c) != constant.NBIAdminLevel {
+		return c.NoContent(http.StatusForbidden)
+	}
+	var form struct {
+		Username string `json:"username" form:"username"`
+		Times    int    `json:"times" form:"times"`
+		Unit     string `json:"unit" form:"unit"`
+		Remark   string `json:"remark" form:"remark"`
+	}
+	common.Must(c.Bind(&form))
+	history, err := h.GetManager().GetNotifyManager().RenewSubscribe(form.Username, form.Times, form.Unit, h.GetUsername(c), form.Remark)
+	if err != nil {
+		return h.GetInternalError(err)
+	}
+	return c.JSON(http.StatusOK, h.RestResult(history))
+}
+
+// QueryRenewalHistory
+func (h *HttpHandler) QueryRenewalHistory(c echo.Context) error {
+	params := h.RequestParse(c)
+	data, err := h.GetManager().GetNotifyManager().QueryRenewalHistory(params)
+	if err != nil {
+		return h.GetInternalError(err)
+	}
+	return c.JSON(http.StatusOK, data)
+}
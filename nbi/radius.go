@@ -22,6 +22,8 @@ import (
 	"github.com/labstack/echo/v4"
 
 	"github.com/ca17/teamsacs/common"
+	"github.com/ca17/teamsacs/constant"
+	"github.com/ca17/teamsacs/models"
 )
 
 func (h *HttpHandler) QueryRadiusAccounting(c echo.Context) error {
@@ -45,3 +47,28 @@ func (h *HttpHandler) QueryRadiusOnline(c echo.Context) error {
 	return c.JSON(http.StatusOK, data)
 }
 
+
+// QueryRadiusRetention
+// last run status of authlog & accounting retention
+func (h *HttpHandler) QueryRadiusRetention(c echo.Context) error {
+	data, err := h.GetManager().GetRetentionManager().QueryRetentionStatus()
+	common.Must(err)
+	return c.JSON(http.StatusOK, h.RestResult(data))
+}
+
+// RunRadiusRetention
+// run authlog & accounting retention now
+func (h *HttpHandler) RunRadiusRetention(c echo.Context) error {
+	if h.GetUserLevel(c) != constant.NBIAdminLevel {
+		return c.NoContent(http.StatusForbidden)
+	}
+	var result = make([]*models.RetentionStatus, 0)
+	for _, collname := range []string{models.TeamsacsAuthlog, models.TeamsacsAccounting} {
+		st, err := h.GetManager().GetRetentionManager().PurgeHistory(collname)
+		if err != nil {
+			return h.GetInternalError(err)
+		}
+		result = append(result, st)
+	}
+	return c.JSON(http.StatusOK, h.RestResult(result))
+}
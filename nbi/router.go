@@ -23,11 +23,49 @@ import (
 func (h *HttpHandler) InitAllRouter(e *echo.Echo) {
 	// mikrotik cpe query apis
 	e.Any("/nbi/mikrotik/device/interfaces", h.QueryMikrotikDeviceInterfaces)
+	e.GET("/nbi/mikrotik/device/interfaces/history", h.QueryMikrotikDeviceInterfaceHistory)
 	e.Any("/nbi/mikrotik/device/pppinterfaces", h.QueryMikrotikDevicePPPInterfaces)
 	e.Any("/nbi/mikrotik/device/ipinterfaces", h.QueryMikrotikDeviceIpInterfaces)
 	e.Any("/nbi/mikrotik/device/routers", h.QueryMikrotikDeviceRouters)
 	e.Any("/nbi/mikrotik/device/dns", h.QueryMikrotikDeviceDnsClientServer)
 
+	// mikrotik routeros api live apis
+	e.GET("/nbi/mikrotik/live/interfaces", h.QueryRouterosInterfaces)
+	e.GET("/nbi/mikrotik/live/ppp/active", h.QueryRouterosPPPActive)
+	e.GET("/nbi/mikrotik/live/queues", h.QueryRouterosQueues)
+	e.GET("/nbi/mikrotik/live/dhcp/leases", h.QueryRouterosDhcpLeases)
+	e.POST("/nbi/mikrotik/live/exec", h.ExecRouterosCommand)
+	e.POST("/nbi/mikrotik/live/credential/update", h.UpdateRouterosCredential)
+
+	// genieacs cpe task apis
+	e.POST("/nbi/cpe/task/add", h.AddCpeTask)
+	e.Any("/nbi/cpe/task/query", h.QueryCpeTask)
+	e.Any("/nbi/cpe/task/retry", h.RetryCpeTask)
+	e.Any("/nbi/cpe/task/delete", h.DeleteCpeTask)
+	e.POST("/nbi/cpe/task/sync", h.SyncCpeTask)
+	e.POST("/nbi/cpe/inventory/sync", h.SyncCpeInventory)
+	e.Any("/nbi/cpe/inventory/history", h.QueryCpeSyncHistory)
+	e.Any("/nbi/cpe/status/query", h.QueryCpeStatus)
+	e.Any("/nbi/cpe/status/events", h.QueryCpeStatusEvents)
+	e.POST("/nbi/cpe/status/check", h.CheckCpeStatus)
+	e.GET("/nbi/cpe/health", h.QueryCpeHealth)
+	e.POST("/nbi/cpe/firmware/campaign/add", h.AddFirmwareCampaign)
+	e.Any("/nbi/cpe/firmware/campaign/query", h.QueryFirmwareCampaign)
+	e.Any("/nbi/cpe/firmware/campaign/devices", h.QueryCampaignDevice)
+	e.Any("/nbi/cpe/firmware/campaign/start", h.StartFirmwareCampaign)
+	e.Any("/nbi/cpe/firmware/campaign/pause", h.PauseFirmwareCampaign)
+	e.Any("/nbi/cpe/firmware/campaign/step", h.StepFirmwareCampaign)
+	e.Any("/nbi/cpe/firmware/campaign/delete", h.DeleteFirmwareCampaign)
+	e.POST("/nbi/cpe/profile/add", h.AddCpeProfile)
+	e.Any("/nbi/cpe/profile/query", h.QueryCpeProfile)
+	e.Any("/nbi/cpe/profile/apply", h.ApplyCpeProfile)
+	e.Any("/nbi/cpe/profile/delete", h.DeleteCpeProfile)
+	e.GET("/nbi/cpe/:sn/params", h.QueryCpeParams)
+	e.POST("/nbi/cpe/:sn/params/snapshot", h.AddCpeParamSnapshot)
+	e.GET("/nbi/cpe/:sn/params/snapshots", h.QueryCpeParamSnapshot)
+	e.GET("/nbi/cpe/:sn/params/diff", h.DiffCpeParams)
+	e.GET("/nbi/cpe/:sn/drift", h.QueryCpeDrift)
+
 	// opr apis
 	e.Any("/nbi/opr/query", h.QueryOperator)
 	e.Any("/nbi/opr/delete", h.DeleteOperator)
@@ -44,11 +82,104 @@ func (h *HttpHandler) InitAllRouter(e *echo.Echo) {
 	e.POST( "/nbi/data/:collname/update", h.UpdateData)
 	e.POST( "/nbi/data/:collname/import", h.ImportData)
 	e.Any( "/nbi/data/:collname/export", h.ExportData)
+	e.GET("/nbi/data/:collname/schema", h.GetDataSchema)
+	e.POST("/nbi/data/:collname/schema/update", h.UpdateDataSchema)
+	e.Any("/nbi/data/:collname/schema/delete", h.DeleteDataSchema)
 
 	// radius apis
 	e.Any("/nbi/radius/accounting/query", h.QueryRadiusAccounting)
 	e.Any("/nbi/radius/authlog/query", h.QueryRadiusAuthlog)
 	e.Any("/nbi/radius/online/query", h.QueryRadiusOnline)
+	e.Any("/nbi/radius/retention/status", h.QueryRadiusRetention)
+	e.POST("/nbi/radius/retention/run", h.RunRadiusRetention)
+
+	// cdr apis
+	e.Any("/nbi/cdr/files", h.QueryCdrFiles)
+	e.GET("/nbi/cdr/download", h.DownloadCdrFile)
+	e.POST("/nbi/cdr/export", h.ExportCdrRange)
+	e.POST("/nbi/cdr/run", h.RunCdrExport)
+
+	// webhook apis
+	e.Any("/nbi/webhook/query", h.QueryWebhook)
+	e.Any("/nbi/webhook/delete", h.DeleteWebhook)
+	e.POST("/nbi/webhook/add", h.AddWebhook)
+	e.POST("/nbi/webhook/update", h.UpdateWebhook)
+	e.POST("/nbi/webhook/ping", h.PingWebhook)
+	e.Any("/nbi/webhook/deadletter/query", h.QueryWebhookDeadletter)
+	e.POST("/nbi/webhook/deadletter/replay", h.ReplayWebhookDeadletter)
+
+	// voucher apis
+	e.Any("/nbi/voucher/batch/query", h.QueryVoucherBatch)
+	e.POST("/nbi/voucher/batch/add", h.AddVoucherBatch)
+	e.POST("/nbi/voucher/batch/revoke", h.RevokeVoucherBatch)
+	e.GET("/nbi/voucher/batch/export", h.ExportVoucherBatch)
+	e.Any("/nbi/voucher/batch/usage", h.QueryVoucherUsage)
+	e.Any("/nbi/voucher/query", h.QueryVoucher)
+	e.POST("/nbi/voucher/revoke", h.RevokeVoucher)
+
+	// notify apis
+	e.Any("/nbi/notify/template/query", h.QueryNotifyTemplate)
+	e.POST("/nbi/notify/template/update", h.UpdateNotifyTemplate)
+	e.Any("/nbi/notify/log/query", h.QueryNotifyLog)
+	e.POST("/nbi/notify/run", h.RunNotify)
+	e.POST("/nbi/subscribe/renew", h.RenewSubscribe)
+	e.Any("/nbi/subscribe/renewal/query", h.QueryRenewalHistory)
+
+	// vpe apis
+	e.Any("/nbi/vpe/query", h.QueryVpe)
+	e.GET("/nbi/vpe/vendors", h.QueryVpeVendors)
+	e.POST("/nbi/vpe/add", h.AddVpe)
+	e.POST("/nbi/vpe/update", h.UpdateVpe)
+	e.Any("/nbi/vpe/delete", h.DeleteVpe)
+	e.Any("/nbi/vpe/test", h.TestVpe)
+
+	// subscriber apis
+	e.Any("/nbi/subscriber/query", h.QuerySubscriber)
+	e.GET("/nbi/subscriber/get", h.GetSubscriber)
+	e.POST("/nbi/subscriber/add", h.AddSubscriber)
+	e.POST("/nbi/subscriber/update", h.UpdateSubscriber)
+	e.Any("/nbi/subscriber/delete", h.DeleteSubscriber)
+	e.GET("/nbi/subscriber/password/reveal", h.RevealSubscriberPassword)
+	e.POST("/nbi/subscriber/password/reset", h.ResetSubscriberPassword)
+	e.POST("/nbi/subscriber/bulk", h.BulkSubscriber)
+	e.GET("/nbi/subscriber/:username/overview", h.QuerySubscriberOverview)
+
+	// billing apis
+	e.Any("/nbi/billing/tariff/query", h.QueryTariff)
+	e.POST("/nbi/billing/tariff/add", h.AddTariff)
+	e.POST("/nbi/billing/tariff/update", h.UpdateTariff)
+	e.Any("/nbi/billing/tariff/delete", h.DeleteTariff)
+	e.POST("/nbi/billing/recharge", h.BillingRecharge)
+	e.GET("/nbi/billing/balance", h.QueryBillingBalance)
+	e.Any("/nbi/billing/ledger/query", h.QueryBillingLedger)
+	e.GET("/nbi/billing/statement", h.QueryBillingStatement)
+	e.POST("/nbi/billing/run", h.RunBilling)
+
+	// tacacs+ apis
+	e.Any("/nbi/tacacs/user/query", h.QueryTacacsUser)
+	e.POST("/nbi/tacacs/user/add", h.AddTacacsUser)
+	e.POST("/nbi/tacacs/user/update", h.UpdateTacacsUser)
+	e.Any("/nbi/tacacs/user/delete", h.DeleteTacacsUser)
+	e.Any("/nbi/tacacs/group/query", h.QueryTacacsGroup)
+	e.POST("/nbi/tacacs/group/add", h.AddTacacsGroup)
+	e.POST("/nbi/tacacs/group/update", h.UpdateTacacsGroup)
+	e.Any("/nbi/tacacs/group/delete", h.DeleteTacacsGroup)
+	e.Any("/nbi/tacacs/cmdset/query", h.QueryTacacsCommandSet)
+	e.POST("/nbi/tacacs/cmdset/add", h.AddTacacsCommandSet)
+	e.POST("/nbi/tacacs/cmdset/update", h.UpdateTacacsCommandSet)
+	e.Any("/nbi/tacacs/cmdset/delete", h.DeleteTacacsCommandSet)
+	e.Any("/nbi/tacacs/accounting/query", h.QueryTacacsAccounting)
+
+	// snmp trap apis
+	e.Any("/nbi/snmptrap/query", h.QuerySnmpTrap)
+	e.Any("/nbi/snmptrap/usm/query", h.QuerySnmpUsmUser)
+	e.POST("/nbi/snmptrap/usm/add", h.AddSnmpUsmUser)
+	e.POST("/nbi/snmptrap/usm/update", h.UpdateSnmpUsmUser)
+	e.Any("/nbi/snmptrap/usm/delete", h.DeleteSnmpUsmUser)
+
+	// flow apis
+	e.Any("/nbi/flow/query", h.QueryFlowStat)
+	e.GET("/nbi/flow/top", h.QueryFlowTop)
 
 	// config apis
 	e.POST("/nbi/config/radius/update", h.UpdateRadiusConfigs)
@@ -0,0 +1,98 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package nbi
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/ca17/teamsacs/common"
+	"github.com/ca17/teamsacs/constant"
+	"github.com/ca17/teamsacs/models"
+)
+
+// routerosDevice
+// the CPE by sn or the VPE by identifier
+func routerosDevice(c echo.Context) (string, string) {
+	if identifier := c.QueryParam("identifier"); identifier != "" {
+		return models.RouterosDeviceVpe, identifier
+	}
+	return models.RouterosDeviceCpe, c.QueryParam("sn")
+}
+
+func (h *HttpHandler) routerosQuery(c echo.Context, query func(device, key string) ([]map[string]string, error)) error {
+	data, err := query(routerosDevice(c))
+	if err != nil {
+		return h.GetInternalError(err)
+	}
+	return c.JSON(http.StatusOK, h.RestResult(data))
+}
+
+// QueryRouterosInterfaces
+func (h *HttpHandler) QueryRouterosInterfaces(c echo.Context) error {
+	return h.routerosQuery(c, h.GetManager().GetRouterosManager().QueryInterfaces)
+}
+
+// QueryRouterosPPPActive
+func (h *HttpHandler) QueryRouterosPPPActive(c echo.Context) error {
+	return h.routerosQuery(c, h.GetManager().GetRouterosManager().QueryPPPActive)
+}
+
+// QueryRouterosQueues
+func (h *HttpHandler) QueryRouterosQueues(c echo.Context) error {
+	return h.routerosQuery(c, h.GetManager().GetRouterosManager().QueryQueues)
+}
+
+// QueryRouterosDhcpLeases
+func (h *HttpHandler) QueryRouterosDhcpLeases(c echo.Context) error {
+	return h.routerosQuery(c, h.GetManager().GetRouterosManager().QueryDhcpLeases)
+}
+
+// ExecRouterosCommand
+// execute an allowlisted command
+func (h *HttpHandler) ExecRouterosCommand(c echo.Context) error {
+	if h.GetUserLevel(c) != constant.NBIAdminLevel {
+		return c.NoContent(http.StatusForbidden)
+	}
+	var form struct {
+		Device  string            `json:"device"`
+		Key     string            `json:"key"`
+		Command string            `json:"command"`
+		Args    map[string]string `json:"args"`
+	}
+	common.Must(c.Bind(&form))
+	data, err := h.GetManager().GetRouterosManager().ExecCommand(form.Device, form.Key, form.Command, form.Args, h.GetUsername(c))
+	if err != nil {
+		return h.GetInternalError(err)
+	}
+	return c.JSON(http.StatusOK, h.RestResult(data))
+}
+
+// UpdateRouterosCredential
+func (h *HttpHandler) UpdateRouterosCredential(c echo.Context) error {
+	if h.GetUserLevel(c) != constant.NBIAdminLevel {
+		return c.NoContent(http.StatusForbidden)
+	}
+	item := new(models.RouterosCredential)
+	common.Must(c.Bind(item))
+	err := h.GetManager().GetRouterosManager().UpdateCredential(item)
+	if err != nil {
+		return h.GetInternalError(err)
+	}
+	return c.JSON(http.StatusOK, h.RestSucc("Success"))
+}
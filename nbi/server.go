@@ -17,11 +17,14 @@
 package nbi
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"os"
 	"path"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
@@ -30,17 +33,20 @@ import (
 
 	"github.com/ca17/teamsacs/common"
 	"github.com/ca17/teamsacs/common/log"
+	"github.com/ca17/teamsacs/constant"
+	"github.com/ca17/teamsacs/common/metrics"
 	"github.com/ca17/teamsacs/common/tpl"
 	"github.com/ca17/teamsacs/models"
 )
 
 // 运行管理系统
-func ListenNBIServer(manager *models.ModelManager) error {
+func ListenNBIServer(ctx context.Context, manager *models.ModelManager) error {
 	e := echo.New()
 	e.Pre(middleware.RemoveTrailingSlash())
 	e.Use(middleware.GzipWithConfig(middleware.GzipConfig{
 		Level: 5,
 	}))
+	e.Use(ServerMetrics())
 	if os.Getenv("ELASTIC_APM_SERVER_URL") != "" {
 		e.Use(apmechov4.Middleware())
 	} else {
@@ -78,17 +84,52 @@ func ListenNBIServer(manager *models.ModelManager) error {
 	e.HideBanner = true
 	e.Logger.SetLevel(common.If(manager.Config.NBI.Debug, elog.DEBUG, elog.INFO).(elog.Lvl))
 	e.Debug = manager.Config.NBI.Debug
+	go shutdownOnDone(ctx, e)
 	log.Info("try start tls web server")
 	err := e.StartTLS(fmt.Sprintf("%s:%d", manager.Config.NBI.Host, manager.Config.NBI.Port),
 		path.Join(manager.Config.GetPrivateDir(), "teamsacs-nbi.tls.crt"), path.Join(manager.Config.GetPrivateDir(), "teamsacs-nbi.tls.key"))
-	if err != nil {
+	if err != nil && err != http.ErrServerClosed && ctx.Err() == nil {
 		log.Warningf("start tls server error %s", err)
 		log.Info("start web server")
 		err = e.Start(fmt.Sprintf("%s:%d", manager.Config.NBI.Host, manager.Config.NBI.Port))
 	}
+	if err == http.ErrServerClosed {
+		return nil
+	}
 	return err
 }
 
+// ServerMetrics
+// Record request latency by route
+func ServerMetrics() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			start := time.Now()
+			err := next(c)
+			status := c.Response().Status
+			if err != nil {
+				if he, ok := err.(*echo.HTTPError); ok {
+					status = he.Code
+				}
+			}
+			metrics.NbiRequestDuration.WithLabelValues(c.Request().Method, c.Path(), strconv.Itoa(status)).
+				Observe(time.Since(start).Seconds())
+			return err
+		}
+	}
+}
+
+// shutdownOnDone
+// Stop the echo server gracefully when the context is done
+func shutdownOnDone(ctx context.Context, e *echo.Echo) {
+	<-ctx.Done()
+	sctx, cancel := context.WithTimeout(context.Background(), constant.ShutdownTimeout)
+	defer cancel()
+	if err := e.Shutdown(sctx); err != nil {
+		log.Errorf("shutdown web server error, %s", err.Error())
+	}
+}
+
 func ServerRecover(debug bool) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
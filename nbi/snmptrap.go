@@ -0,0 +1,89 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package nbi
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/ca17/teamsacs/common"
+	"github.com/ca17/teamsacs/constant"
+	"github.com/ca17/teamsacs/models"
+)
+
+// QuerySnmpTrap
+func (h *HttpHandler) QuerySnmpTrap(c echo.Context) error {
+	params := h.RequestParse(c)
+	data, err := h.GetManager().GetSnmpTrapManager().QuerySnmpTrap(params)
+	if err != nil {
+		return h.GetValidateError(c, err)
+	}
+	return c.JSON(http.StatusOK, data)
+}
+
+// QuerySnmpUsmUser
+func (h *HttpHandler) QuerySnmpUsmUser(c echo.Context) error {
+	params := h.RequestParse(c)
+	data, err := h.GetManager().GetSnmpTrapManager().QuerySnmpUsmUsers(params)
+	if err != nil {
+		return h.GetInternalError(err)
+	}
+	return c.JSON(http.StatusOK, data)
+}
+
+// AddSnmpUsmUser
+func (h *HttpHandler) AddSnmpUsmUser(c echo.Context) error {
+	if h.GetUserLevel(c) != constant.NBIAdminLevel {
+		return c.NoContent(http.StatusForbidden)
+	}
+	item := new(models.SnmpUsmUser)
+	common.Must(c.Bind(item))
+	id, err := h.GetManager().GetSnmpTrapManager().AddSnmpUsmUser(item)
+	if err != nil {
+		return h.GetInternalError(err)
+	}
+	return c.JSON(http.StatusOK, h.RestResult(map[string]string{"id": id}))
+}
+
+// UpdateSnmpUsmUser
+func (h *HttpHandler) UpdateSnmpUsmUser(c echo.Context) error {
+	if h.GetUserLevel(c) != constant.NBIAdminLevel {
+		return c.NoContent(http.StatusForbidden)
+	}
+	item := new(models.SnmpUsmUser)
+	common.Must(c.Bind(item))
+	err := h.GetManager().GetSnmpTrapManager().UpdateSnmpUsmUser(item)
+	if err != nil {
+		return h.GetInternalError(err)
+	}
+	return c.JSON(http.StatusOK, h.RestSucc("Success"))
+}
+
+// DeleteSnmpUsmUser
+func (h *HttpHandler) DeleteSnmpUsmUser(c echo.Context) error {
+	if h.GetUserLevel(c) != constant.NBIAdminLevel {
+		return c.NoContent(http.StatusForbidden)
+	}
+	params := h.RequestParse(c)
+	id := params.GetParamMap("querymap").GetMustString("id")
+	err := h.GetManager().GetSnmpTrapManager().DeleteSnmpUsmUser(id)
+	if err != nil {
+		return h.GetInternalError(err)
+	}
+	return c.JSON(http.StatusOK, h.RestSucc("Success"))
+}
@@ -0,0 +1,157 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package nbi
+
+import (
+	"io/ioutil"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/ca17/teamsacs/common"
+	"github.com/ca17/teamsacs/constant"
+	"github.com/ca17/teamsacs/models"
+)
+
+// QuerySubscriberOverview
+// the subscriber with the sessions, auth failures, CPE, VPE, site and device syslog
+func (h *HttpHandler) QuerySubscriberOverview(c echo.Context) error {
+	data, err := h.GetManager().GetSubscriberOverview(c.Param("username"))
+	if err != nil {
+		return h.GetInternalError(err)
+	}
+	return c.JSON(http.StatusOK, h.RestResult(data))
+}
+
+// QuerySubscriber
+func (h *HttpHandler) QuerySubscriber(c echo.Context) error {
+	params := h.RequestParse(c)
+	data, err := h.GetManager().GetSubscribeManager().QuerySubscribes(params)
+	if err != nil {
+		return h.GetInternalError(err)
+	}
+	if items, ok := data.Data.([]map[string]interface{}); ok {
+		for _, item := range items {
+			delete(item, "password")
+		}
+	}
+	return c.JSON(http.StatusOK, data)
+}
+
+// GetSubscriber
+func (h *HttpHandler) GetSubscriber(c echo.Context) error {
+	data, err := h.GetManager().GetSubscribeManager().GetSubscriber(c.QueryParam("username"))
+	if err != nil {
+		return h.GetInternalError(err)
+	}
+	return c.JSON(http.StatusOK, h.RestResult(data))
+}
+
+// AddSubscriber
+func (h *HttpHandler) AddSubscriber(c echo.Context) error {
+	if h.GetUserLevel(c) != constant.NBIAdminLevel {
+		return c.NoContent(http.StatusForbidden)
+	}
+	form := new(models.SubscriberForm)
+	common.Must(c.Bind(form))
+	id, err := h.GetManager().GetSubscribeManager().AddSubscriber(form)
+	if err != nil {
+		return h.GetValidateError(c, err)
+	}
+	return c.JSON(http.StatusOK, h.RestResult(map[string]string{"id": id}))
+}
+
+// UpdateSubscriber
+// the request body is the json of the changed fields,
+// the password is changed when not empty
+func (h *HttpHandler) UpdateSubscriber(c echo.Context) error {
+	if h.GetUserLevel(c) != constant.NBIAdminLevel {
+		return c.NoContent(http.StatusForbidden)
+	}
+	data, err := ioutil.ReadAll(c.Request().Body)
+	if err != nil {
+		return h.GetInternalError(err)
+	}
+	if err = h.GetManager().GetSubscribeManager().UpdateSubscriber(data); err != nil {
+		return h.GetValidateError(c, err)
+	}
+	return c.JSON(http.StatusOK, h.RestSucc("Success"))
+}
+
+// DeleteSubscriber
+func (h *HttpHandler) DeleteSubscriber(c echo.Context) error {
+	if h.GetUserLevel(c) != constant.NBIAdminLevel {
+		return c.NoContent(http.StatusForbidden)
+	}
+	if err := h.GetManager().GetSubscribeManager().DeleteSubscriber(c.QueryParam("username")); err != nil {
+		return h.GetInternalError(err)
+	}
+	return c.JSON(http.StatusOK, h.RestSucc("Success"))
+}
+
+// RevealSubscriberPassword
+func (h *HttpHandler) RevealSubscriberPassword(c echo.Context) error {
+	if h.GetUserLevel(c) != constant.NBIAdminLevel {
+		return c.NoContent(http.StatusForbidden)
+	}
+	username := c.QueryParam("username")
+	password, err := h.GetManager().GetSubscribeManager().RevealSubscriberPassword(username)
+	if err != nil {
+		return h.GetInternalError(err)
+	}
+	return c.JSON(http.StatusOK, h.RestResult(map[string]string{"username": username, "password": password}))
+}
+
+// ResetSubscriberPassword
+// a random password is generated when the password is empty
+func (h *HttpHandler) ResetSubscriberPassword(c echo.Context) error {
+	if h.GetUserLevel(c) != constant.NBIAdminLevel {
+		return c.NoContent(http.StatusForbidden)
+	}
+	var form struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	common.Must(c.Bind(&form))
+	password, err := h.GetManager().GetSubscribeManager().ResetSubscriberPassword(form.Username, form.Password)
+	if err != nil {
+		return h.GetValidateError(c, err)
+	}
+	return c.JSON(http.StatusOK, h.RestResult(map[string]string{"username": form.Username, "password": password}))
+}
+
+// BulkSubscriber
+// enable, disable or extend the subscribers
+func (h *HttpHandler) BulkSubscriber(c echo.Context) error {
+	if h.GetUserLevel(c) != constant.NBIAdminLevel {
+		return c.NoContent(http.StatusForbidden)
+	}
+	var form struct {
+		Usernames []string `json:"usernames"`
+		Action    string   `json:"action"`
+		Times     int      `json:"times"`
+		Unit      string   `json:"unit"`
+		Remark    string   `json:"remark"`
+	}
+	common.Must(c.Bind(&form))
+	result, err := h.GetManager().GetSubscribeManager().BulkUpdateSubscribers(form.Usernames, form.Action,
+		form.Times, form.Unit, h.GetUsername(c), form.Remark)
+	if err != nil {
+		return h.GetValidateError(c, err)
+	}
+	return c.JSON(http.StatusOK, h.RestResult(result))
+}
@@ -26,7 +26,7 @@ func (h *HttpHandler) QuerySyslog(c echo.Context) error {
 	params := h.RequestParse(c)
 	data, err := h.GetManager().GetOpsManager().QuerySyslog(params)
 	if err != nil {
-		return h.GetInternalError(err)
+		return h.GetValidateError(c, err)
 	}
 	return c.JSON(http.StatusOK, data)
 }
@@ -0,0 +1,193 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package nbi
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/ca17/teamsacs/common"
+	"github.com/ca17/teamsacs/constant"
+	"github.com/ca17/teamsacs/models"
+)
+
+// QueryTacacsUser
+func (h *HttpHandler) QueryTacacsUser(c echo.Context) error {
+	params := h.RequestParse(c)
+	data, err := h.GetManager().GetTacacsManager().QueryTacacsUsers(params)
+	if err != nil {
+		return h.GetInternalError(err)
+	}
+	return c.JSON(http.StatusOK, data)
+}
+
+// AddTacacsUser
+func (h *HttpHandler) AddTacacsUser(c echo.Context) error {
+	if h.GetUserLevel(c) != constant.NBIAdminLevel {
+		return c.NoContent(http.StatusForbidden)
+	}
+	item := new(models.TacacsUserForm)
+	common.Must(c.Bind(item))
+	id, err := h.GetManager().GetTacacsManager().AddTacacsUser(item.User())
+	if err != nil {
+		return h.GetInternalError(err)
+	}
+	return c.JSON(http.StatusOK, h.RestResult(map[string]string{"id": id}))
+}
+
+// UpdateTacacsUser
+func (h *HttpHandler) UpdateTacacsUser(c echo.Context) error {
+	if h.GetUserLevel(c) != constant.NBIAdminLevel {
+		return c.NoContent(http.StatusForbidden)
+	}
+	item := new(models.TacacsUserForm)
+	common.Must(c.Bind(item))
+	err := h.GetManager().GetTacacsManager().UpdateTacacsUser(item.User())
+	if err != nil {
+		return h.GetInternalError(err)
+	}
+	return c.JSON(http.StatusOK, h.RestSucc("Success"))
+}
+
+// DeleteTacacsUser
+func (h *HttpHandler) DeleteTacacsUser(c echo.Context) error {
+	if h.GetUserLevel(c) != constant.NBIAdminLevel {
+		return c.NoContent(http.StatusForbidden)
+	}
+	params := h.RequestParse(c)
+	id := params.GetParamMap("querymap").GetMustString("id")
+	err := h.GetManager().GetTacacsManager().DeleteTacacsUser(id)
+	if err != nil {
+		return h.GetInternalError(err)
+	}
+	return c.JSON(http.StatusOK, h.RestSucc("Success"))
+}
+
+// QueryTacacsGroup
+func (h *HttpHandler) QueryTacacsGroup(c echo.Context) error {
+	params := h.RequestParse(c)
+	data, err := h.GetManager().GetTacacsManager().QueryTacacsGroups(params)
+	if err != nil {
+		return h.GetInternalError(err)
+	}
+	return c.JSON(http.StatusOK, data)
+}
+
+// AddTacacsGroup
+func (h *HttpHandler) AddTacacsGroup(c echo.Context) error {
+	if h.GetUserLevel(c) != constant.NBIAdminLevel {
+		return c.NoContent(http.StatusForbidden)
+	}
+	item := new(models.TacacsGroup)
+	common.Must(c.Bind(item))
+	id, err := h.GetManager().GetTacacsManager().AddTacacsGroup(item)
+	if err != nil {
+		return h.GetInternalError(err)
+	}
+	return c.JSON(http.StatusOK, h.RestResult(map[string]string{"id": id}))
+}
+
+// UpdateTacacsGroup
+func (h *HttpHandler) UpdateTacacsGroup(c echo.Context) error {
+	if h.GetUserLevel(c) != constant.NBIAdminLevel {
+		return c.NoContent(http.StatusForbidden)
+	}
+	item := new(models.TacacsGroup)
+	common.Must(c.Bind(item))
+	err := h.GetManager().GetTacacsManager().UpdateTacacsGroup(item)
+	if err != nil {
+		return h.GetInternalError(err)
+	}
+	return c.JSON(http.StatusOK, h.RestSucc("Success"))
+}
+
+// DeleteTacacsGroup
+func (h *HttpHandler) DeleteTacacsGroup(c echo.Context) error {
+	if h.GetUserLevel(c) != constant.NBIAdminLevel {
+		return c.NoContent(http.StatusForbidden)
+	}
+	params := h.RequestParse(c)
+	id := params.GetParamMap("querymap").GetMustString("id")
+	err := h.GetManager().GetTacacsManager().DeleteTacacsGroup(id)
+	if err != nil {
+		return h.GetInternalError(err)
+	}
+	return c.JSON(http.StatusOK, h.RestSucc("Success"))
+}
+
+// QueryTacacsCommandSet
+func (h *HttpHandler) QueryTacacsCommandSet(c echo.Context) error {
+	params := h.RequestParse(c)
+	data, err := h.GetManager().GetTacacsManager().QueryTacacsCommandSets(params)
+	if err != nil {
+		return h.GetInternalError(err)
+	}
+	return c.JSON(http.StatusOK, data)
+}
+
+// AddTacacsCommandSet
+func (h *HttpHandler) AddTacacsCommandSet(c echo.Context) error {
+	if h.GetUserLevel(c) != constant.NBIAdminLevel {
+		return c.NoContent(http.StatusForbidden)
+	}
+	item := new(models.TacacsCommandSet)
+	common.Must(c.Bind(item))
+	id, err := h.GetManager().GetTacacsManager().AddTacacsCommandSet(item)
+	if err != nil {
+		return h.GetInternalError(err)
+	}
+	return c.JSON(http.StatusOK, h.RestResult(map[string]string{"id": id}))
+}
+
+// UpdateTacacsCommandSet
+func (h *HttpHandler) UpdateTacacsCommandSet(c echo.Context) error {
+	if h.GetUserLevel(c) != constant.NBIAdminLevel {
+		return c.NoContent(http.StatusForbidden)
+	}
+	item := new(models.TacacsCommandSet)
+	common.Must(c.Bind(item))
+	err := h.GetManager().GetTacacsManager().UpdateTacacsCommandSet(item)
+	if err != nil {
+		return h.GetInternalError(err)
+	}
+	return c.JSON(http.StatusOK, h.RestSucc("Success"))
+}
+
+// DeleteTacacsCommandSet
+func (h *HttpHandler) DeleteTacacsCommandSet(c echo.Context) error {
+	if h.GetUserLevel(c) != constant.NBIAdminLevel {
+		return c.NoContent(http.StatusForbidden)
+	}
+	params := h.RequestParse(c)
+	id := params.GetParamMap("querymap").GetMustString("id")
+	err := h.GetManager().GetTacacsManager().DeleteTacacsCommandSet(id)
+	if err != nil {
+		return h.GetInternalError(err)
+	}
+	return c.JSON(http.StatusOK, h.RestSucc("Success"))
+}
+
+// QueryTacacsAccounting
+func (h *HttpHandler) QueryTacacsAccounting(c echo.Context) error {
+	params := h.RequestParse(c)
+	data, err := h.GetManager().GetTacacsManager().QueryTacacsAccounting(params)
+	if err != nil {
+		return h.GetInternalError(err)
+	}
+	return c.JSON(http.StatusOK, data)
+}
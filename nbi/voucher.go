@@ -0,0 +1,152 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package nbi
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/ca17/teamsacs/common"
+	"github.com/ca17/teamsacs/common/resources"
+	"github.com/ca17/teamsacs/constant"
+	"github.com/ca17/teamsacs/models"
+)
+
+// QueryVoucherBatch
+func (h *HttpHandler) QueryVoucherBatch(c echo.Context) error {
+	params := h.RequestParse(c)
+	data, err := h.GetManager().GetVoucherManager().QueryVoucherBatchs(params)
+	if err != nil {
+		return h.GetInternalError(err)
+	}
+	return c.JSON(http.StatusOK, data)
+}
+
+// AddVoucherBatch
+// generate the vouchers and the radius users of a batch
+func (h *HttpHandler) AddVoucherBatch(c echo.Context) error {
+	if h.GetUserLevel(c) != constant.NBIAdminLevel {
+		return c.NoContent(http.StatusForbidden)
+	}
+	batch := new(models.VoucherBatch)
+	common.Must(c.Bind(batch))
+	id, err := h.GetManager().GetVoucherManager().AddVoucherBatch(batch)
+	if err != nil {
+		return h.GetInternalError(err)
+	}
+	return c.JSON(http.StatusOK, h.RestResult(map[string]string{"id": id}))
+}
+
+// RevokeVoucherBatch
+// revoke all unused and active vouchers of a batch
+func (h *HttpHandler) RevokeVoucherBatch(c echo.Context) error {
+	if h.GetUserLevel(c) != constant.NBIAdminLevel {
+		return c.NoContent(http.StatusForbidden)
+	}
+	params := h.RequestParse(c)
+	err := h.GetManager().GetVoucherManager().RevokeVoucherBatch(params.GetMustString("id"))
+	if err != nil {
+		return h.GetInternalError(err)
+	}
+	return c.JSON(http.StatusOK, h.RestSucc("Success"))
+}
+
+// QueryVoucher
+func (h *HttpHandler) QueryVoucher(c echo.Context) error {
+	params := h.RequestParse(c)
+	data, err := h.GetManager().GetVoucherManager().QueryVouchers(params)
+	if err != nil {
+		return h.GetInternalError(err)
+	}
+	return c.JSON(http.StatusOK, data)
+}
+
+// RevokeVoucher
+// voucher codes split by comma
+func (h *HttpHandler) RevokeVoucher(c echo.Context) error {
+	if h.GetUserLevel(c) != constant.NBIAdminLevel {
+		return c.NoContent(http.StatusForbidden)
+	}
+	params := h.RequestParse(c)
+	codes := strings.Split(params.GetMustString("codes"), ",")
+	err := h.GetManager().GetVoucherManager().RevokeVouchers(codes)
+	if err != nil {
+		return h.GetInternalError(err)
+	}
+	return c.JSON(http.StatusOK, h.RestSucc("Success"))
+}
+
+// ExportVoucherBatch
+// printable pdf cards or xlsx list of a batch
+func (h *HttpHandler) ExportVoucherBatch(c echo.Context) error {
+	if h.GetUserLevel(c) != constant.NBIAdminLevel {
+		return c.NoContent(http.StatusForbidden)
+	}
+	id := c.QueryParam("id")
+	format := c.QueryParam("format")
+	if format == "" {
+		format = "pdf"
+	}
+	vm := h.GetManager().GetVoucherManager()
+	batch, err := vm.GetVoucherBatch(id)
+	if err != nil {
+		return h.GetInternalError(err)
+	}
+	vouchers, err := vm.GetBatchVouchers(id)
+	if err != nil {
+		return h.GetInternalError(err)
+	}
+	var buff bytes.Buffer
+	var ctype string
+	switch format {
+	case "pdf":
+		font, err := resources.FSByte(false, "/resources/jetbrainsmono.ttf")
+		if err != nil {
+			return h.GetInternalError(err)
+		}
+		err = models.WriteVoucherPdf(&buff, batch, vouchers, font)
+		if err != nil {
+			return h.GetInternalError(err)
+		}
+		ctype = "application/pdf"
+	case "xlsx":
+		err = models.WriteVoucherXlsx(&buff, batch, vouchers)
+		if err != nil {
+			return h.GetInternalError(err)
+		}
+		ctype = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	default:
+		return c.JSON(http.StatusOK, h.RestError("unsupported format "+format))
+	}
+	c.Response().Header().Set("Content-Disposition", fmt.Sprintf("attachment;filename=voucher-%s.%s", id, format))
+	return c.Blob(http.StatusOK, ctype, buff.Bytes())
+}
+
+// QueryVoucherUsage
+// usage report of a batch
+func (h *HttpHandler) QueryVoucherUsage(c echo.Context) error {
+	params := h.RequestParse(c)
+	usage, err := h.GetManager().GetVoucherManager().GetVoucherUsage(params.GetParamMap("querymap").GetMustString("id"))
+	if err != nil {
+		return h.GetInternalError(err)
+	}
+	return c.JSON(http.StatusOK, h.RestResult(usage))
+}
@@ -0,0 +1,107 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package nbi
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/ca17/teamsacs/common"
+	"github.com/ca17/teamsacs/constant"
+	"github.com/ca17/teamsacs/models"
+	"github.com/ca17/teamsacs/radiusd/vendors"
+)
+
+// QueryVpe
+func (h *HttpHandler) QueryVpe(c echo.Context) error {
+	params := h.RequestParse(c)
+	data, err := h.GetManager().GetVpeManager().QueryVpes(params)
+	if err != nil {
+		return h.GetInternalError(err)
+	}
+	return c.JSON(http.StatusOK, data)
+}
+
+// QueryVpeVendors
+// the vendor codes of the radius dictionaries
+func (h *HttpHandler) QueryVpeVendors(c echo.Context) error {
+	result := make([]models.NameValue, 0, len(vendors.Vendors))
+	for code, name := range vendors.Vendors {
+		result = append(result, models.NameValue{Name: name, Value: code})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return c.JSON(http.StatusOK, h.RestResult(result))
+}
+
+// AddVpe
+func (h *HttpHandler) AddVpe(c echo.Context) error {
+	if h.GetUserLevel(c) != constant.NBIAdminLevel {
+		return c.NoContent(http.StatusForbidden)
+	}
+	form := new(models.VpeForm)
+	common.Must(c.Bind(form))
+	id, err := h.GetManager().GetVpeManager().AddVpe(form)
+	if err != nil {
+		return h.GetValidateError(c, err)
+	}
+	return c.JSON(http.StatusOK, h.RestResult(map[string]string{"id": id}))
+}
+
+// UpdateVpe
+// the secrets are changed when not empty
+func (h *HttpHandler) UpdateVpe(c echo.Context) error {
+	if h.GetUserLevel(c) != constant.NBIAdminLevel {
+		return c.NoContent(http.StatusForbidden)
+	}
+	form := new(models.VpeForm)
+	common.Must(c.Bind(form))
+	if err := h.GetManager().GetVpeManager().UpdateVpe(form); err != nil {
+		return h.GetValidateError(c, err)
+	}
+	return c.JSON(http.StatusOK, h.RestSucc("Success"))
+}
+
+// DeleteVpe
+func (h *HttpHandler) DeleteVpe(c echo.Context) error {
+	if h.GetUserLevel(c) != constant.NBIAdminLevel {
+		return c.NoContent(http.StatusForbidden)
+	}
+	params := h.RequestParse(c)
+	id := params.GetParamMap("querymap").GetMustString("id")
+	if err := h.GetManager().GetVpeManager().DeleteVpe(id); err != nil {
+		return h.GetInternalError(err)
+	}
+	return c.JSON(http.StatusOK, h.RestSucc("Success"))
+}
+
+// TestVpe
+// send a Status-Server or CoA test packet, mode is status or coa
+func (h *HttpHandler) TestVpe(c echo.Context) error {
+	if h.GetUserLevel(c) != constant.NBIAdminLevel {
+		return c.NoContent(http.StatusForbidden)
+	}
+	params := h.RequestParse(c)
+	query := params.GetParamMap("querymap")
+	result, err := h.GetManager().GetVpeManager().TestVpe(query.GetMustString("id"), query.GetString("mode"), time.Second*3)
+	if err != nil {
+		return h.GetValidateError(c, err)
+	}
+	return c.JSON(http.StatusOK, h.RestResult(result))
+}
@@ -0,0 +1,116 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package nbi
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/ca17/teamsacs/common"
+	"github.com/ca17/teamsacs/constant"
+	"github.com/ca17/teamsacs/models"
+)
+
+// QueryWebhook
+func (h *HttpHandler) QueryWebhook(c echo.Context) error {
+	params := h.RequestParse(c)
+	data, err := h.GetManager().GetWebhookManager().QueryWebhooks(params)
+	if err != nil {
+		return h.GetInternalError(err)
+	}
+	return c.JSON(http.StatusOK, data)
+}
+
+// AddWebhook
+func (h *HttpHandler) AddWebhook(c echo.Context) error {
+	if h.GetUserLevel(c) != constant.NBIAdminLevel {
+		return c.NoContent(http.StatusForbidden)
+	}
+	item := new(models.Webhook)
+	common.Must(c.Bind(item))
+	id, err := h.GetManager().GetWebhookManager().AddWebhook(item)
+	if err != nil {
+		return h.GetInternalError(err)
+	}
+	return c.JSON(http.StatusOK, h.RestResult(map[string]string{"id": id, "secret": item.Secret}))
+}
+
+// UpdateWebhook
+func (h *HttpHandler) UpdateWebhook(c echo.Context) error {
+	if h.GetUserLevel(c) != constant.NBIAdminLevel {
+		return c.NoContent(http.StatusForbidden)
+	}
+	item := new(models.Webhook)
+	common.Must(c.Bind(item))
+	err := h.GetManager().GetWebhookManager().UpdateWebhook(item)
+	if err != nil {
+		return h.GetInternalError(err)
+	}
+	return c.JSON(http.StatusOK, h.RestSucc("Success"))
+}
+
+// DeleteWebhook
+func (h *HttpHandler) DeleteWebhook(c echo.Context) error {
+	if h.GetUserLevel(c) != constant.NBIAdminLevel {
+		return c.NoContent(http.StatusForbidden)
+	}
+	params := h.RequestParse(c)
+	id := params.GetParamMap("querymap").GetMustString("id")
+	common.Must(h.GetManager().GetWebhookManager().DeleteWebhook(id))
+	return c.JSON(http.StatusOK, h.RestSucc("Success"))
+}
+
+// PingWebhook
+// send a test event to the webhook
+func (h *HttpHandler) PingWebhook(c echo.Context) error {
+	params := h.RequestParse(c)
+	err := h.GetManager().GetWebhookManager().PingWebhook(params.GetMustString("id"))
+	if err != nil {
+		return h.GetInternalError(err)
+	}
+	return c.JSON(http.StatusOK, h.RestSucc("Success"))
+}
+
+// QueryWebhookDeadletter
+// failed deliveries
+func (h *HttpHandler) QueryWebhookDeadletter(c echo.Context) error {
+	params := h.RequestParse(c)
+	data, err := h.GetManager().GetWebhookManager().QueryDeadletters(params)
+	if err != nil {
+		return h.GetInternalError(err)
+	}
+	return c.JSON(http.StatusOK, data)
+}
+
+// ReplayWebhookDeadletter
+// queue the redelivery of failed deliveries, ids split by comma
+func (h *HttpHandler) ReplayWebhookDeadletter(c echo.Context) error {
+	params := h.RequestParse(c)
+	ids := params.GetMustString("ids")
+	var failures = make(map[string]string)
+	for _, id := range strings.Split(ids, ",") {
+		if err := h.GetManager().GetWebhookManager().ReplayDeadletter(id); err != nil {
+			failures[id] = err.Error()
+		}
+	}
+	if len(failures) > 0 {
+		return c.JSON(http.StatusOK, &RestResult{Code: 9999, Msgtype: "error", Msg: "Replay failure", Data: failures})
+	}
+	return c.JSON(http.StatusOK, h.RestSucc("Success"))
+}
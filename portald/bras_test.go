@@ -0,0 +1,162 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package portald
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// simBras
+// A simulated BRAS, answers portal requests and authenticates users from a password table
+// the way a real BRAS does with radius
+type simBras struct {
+	conn       *net.UDPConn
+	secret     []byte
+	version    byte
+	users      map[string]string
+	lock       sync.Mutex
+	challenges map[string][]byte
+	reqIds     map[string]uint16
+	online     map[string]string
+	affAcks    chan *Packet
+	ntfAcks    chan *Packet
+}
+
+func newSimBras(secret string, version byte, users map[string]string) (*simBras, error) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		return nil, err
+	}
+	b := &simBras{
+		conn:       conn,
+		secret:     []byte(secret),
+		version:    version,
+		users:      users,
+		challenges: make(map[string][]byte),
+		reqIds:     make(map[string]uint16),
+		online:     make(map[string]string),
+		affAcks:    make(chan *Packet, 16),
+		ntfAcks:    make(chan *Packet, 16),
+	}
+	go b.serve()
+	return b, nil
+}
+
+func (b *simBras) Addr() *net.UDPAddr {
+	return b.conn.LocalAddr().(*net.UDPAddr)
+}
+
+func (b *simBras) Close() {
+	b.conn.Close()
+}
+
+func (b *simBras) isOnline(userip string) (string, bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	username, ok := b.online[userip]
+	return username, ok
+}
+
+func (b *simBras) serve() {
+	for {
+		data := make([]byte, 4096)
+		n, raddr, err := b.conn.ReadFromUDP(data)
+		if err != nil {
+			return
+		}
+		pkt, err := DecodePacket(data[:n])
+		if err != nil {
+			continue
+		}
+		if pkt.Type == AckNtfLogout {
+			b.ntfAcks <- pkt
+			continue
+		}
+		if pkt.Verify(b.secret, nil) != nil {
+			continue
+		}
+		b.handle(raddr, pkt)
+	}
+}
+
+func (b *simBras) reply(raddr *net.UDPAddr, req *Packet, ptype, errcode byte, attrs ...Attribute) {
+	resp := NewPacket(req.Version, ptype, req.Mode, req.SerialNo, req.ReqId, req.UserIp)
+	resp.ErrCode = errcode
+	resp.Attrs = attrs
+	data, _ := resp.Encode(b.secret, req.Authenticator)
+	_, _ = b.conn.WriteToUDP(data, raddr)
+}
+
+func (b *simBras) handle(raddr *net.UDPAddr, req *Packet) {
+	userip := req.UserIp.String()
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	switch req.Type {
+	case ReqChallenge:
+		challenge := make([]byte, 16)
+		_, _ = rand.Read(challenge)
+		reqId := uint16(len(b.challenges) + 1)
+		b.challenges[userip] = challenge
+		b.reqIds[userip] = reqId
+		req.ReqId = reqId
+		b.reply(raddr, req, AckChallenge, ErrCodeSuccess, Attribute{Type: AttrChallenge, Value: challenge})
+	case ReqAuth:
+		username := string(req.GetAttr(AttrUserName))
+		password, ok := b.users[username]
+		if ok && req.Mode == ModeChap {
+			expect := ChapPassword(b.reqIds[userip], password, b.challenges[userip])
+			ok = req.ReqId == b.reqIds[userip] && bytes.Equal(expect, req.GetAttr(AttrChapPassword))
+		} else if ok {
+			ok = string(req.GetAttr(AttrPassword)) == password
+		}
+		if !ok {
+			b.reply(raddr, req, AckAuth, ErrCodeReject, Attribute{Type: AttrTextInfo, Value: []byte("radius reject")})
+			return
+		}
+		b.online[userip] = username
+		b.reply(raddr, req, AckAuth, ErrCodeSuccess)
+	case AffAckAuth:
+		b.affAcks <- req
+	case ReqLogout:
+		delete(b.online, userip)
+		b.reply(raddr, req, AckLogout, ErrCodeSuccess)
+	}
+}
+
+// NotifyLogout
+// send NTF_LOGOUT to the portal server and wait for ACK_NTF_LOGOUT
+func (b *simBras) NotifyLogout(portal *net.UDPAddr, userip string) (*Packet, error) {
+	b.lock.Lock()
+	delete(b.online, userip)
+	b.lock.Unlock()
+	ntf := NewPacket(b.version, NtfLogout, ModeChap, 1000, 0, net.ParseIP(userip).To4())
+	data, _ := ntf.Encode(b.secret, nil)
+	if _, err := b.conn.WriteToUDP(data, portal); err != nil {
+		return nil, err
+	}
+	select {
+	case ack := <-b.ntfAcks:
+		return ack, ack.Verify(b.secret, ntf.Authenticator)
+	case <-time.After(time.Second * 2):
+		return nil, errors.New("wait ACK_NTF_LOGOUT timeout")
+	}
+}
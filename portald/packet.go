@@ -0,0 +1,275 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package portald
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// Huawei/CMCC Portal protocol packet types
+const (
+	ReqChallenge     byte = 0x01
+	AckChallenge     byte = 0x02
+	ReqAuth          byte = 0x03
+	AckAuth          byte = 0x04
+	ReqLogout        byte = 0x05
+	AckLogout        byte = 0x06
+	AffAckAuth       byte = 0x07
+	NtfLogout        byte = 0x08
+	ReqInfo          byte = 0x09
+	AckInfo          byte = 0x0a
+	AckNtfLogout     byte = 0x0e
+	PortalVersion1   byte = 0x01
+	PortalVersion2   byte = 0x02
+	ModeChap         byte = 0x00
+	ModePap          byte = 0x01
+	headerLen             = 16
+	authenticatorLen      = 16
+)
+
+// Attribute types
+const (
+	AttrUserName     byte = 0x01
+	AttrPassword     byte = 0x02
+	AttrChallenge    byte = 0x03
+	AttrChapPassword byte = 0x04
+	AttrTextInfo     byte = 0x05
+	AttrBasIP        byte = 0x0a
+)
+
+// Ack error codes
+const (
+	ErrCodeSuccess byte = 0x00
+	ErrCodeReject  byte = 0x01
+	ErrCodeOnline  byte = 0x02
+	ErrCodeWait    byte = 0x03
+	ErrCodeFailure byte = 0x04
+)
+
+var typeNames = map[byte]string{
+	ReqChallenge: "REQ_CHALLENGE",
+	AckChallenge: "ACK_CHALLENGE",
+	ReqAuth:      "REQ_AUTH",
+	AckAuth:      "ACK_AUTH",
+	ReqLogout:    "REQ_LOGOUT",
+	AckLogout:    "ACK_LOGOUT",
+	AffAckAuth:   "AFF_ACK_AUTH",
+	NtfLogout:    "NTF_LOGOUT",
+	ReqInfo:      "REQ_INFO",
+	AckInfo:      "ACK_INFO",
+	AckNtfLogout: "ACK_NTF_LOGOUT",
+}
+
+var ErrInvalidAuthenticator = errors.New("portal packet authenticator is invalid")
+
+type Attribute struct {
+	Type  byte
+	Value []byte
+}
+
+// Packet
+// Portal v1 header is 16 bytes, v2 adds a 16 bytes authenticator
+type Packet struct {
+	Version       byte
+	Type          byte
+	Mode          byte
+	Rsv           byte
+	SerialNo      uint16
+	ReqId         uint16
+	UserIp        net.IP
+	UserPort      uint16
+	ErrCode       byte
+	Authenticator []byte
+	Attrs         []Attribute
+}
+
+func NewPacket(version, ptype, mode byte, serialNo, reqId uint16, userip net.IP) *Packet {
+	return &Packet{
+		Version:  version,
+		Type:     ptype,
+		Mode:     mode,
+		SerialNo: serialNo,
+		ReqId:    reqId,
+		UserIp:   userip,
+	}
+}
+
+func (p *Packet) TypeName() string {
+	if name, ok := typeNames[p.Type]; ok {
+		return name
+	}
+	return fmt.Sprintf("UNKNOWN(%d)", p.Type)
+}
+
+func (p *Packet) String() string {
+	return fmt.Sprintf("Portal v%d %s serial=%d reqid=%d userip=%s errcode=%d attrs=%d",
+		p.Version, p.TypeName(), p.SerialNo, p.ReqId, p.UserIp, p.ErrCode, len(p.Attrs))
+}
+
+func (p *Packet) AddAttr(atype byte, value []byte) {
+	p.Attrs = append(p.Attrs, Attribute{Type: atype, Value: value})
+}
+
+func (p *Packet) GetAttr(atype byte) []byte {
+	for _, attr := range p.Attrs {
+		if attr.Type == atype {
+			return attr.Value
+		}
+	}
+	return nil
+}
+
+// IsResponse
+// packets sent by the BRAS in reply to a portal server request
+func (p *Packet) IsResponse() bool {
+	switch p.Type {
+	case AckChallenge, AckAuth, AckLogout, AckInfo:
+		return true
+	}
+	return false
+}
+
+func (p *Packet) header() []byte {
+	buf := make([]byte, headerLen)
+	buf[0] = p.Version
+	buf[1] = p.Type
+	buf[2] = p.Mode
+	buf[3] = p.Rsv
+	binary.BigEndian.PutUint16(buf[4:], p.SerialNo)
+	binary.BigEndian.PutUint16(buf[6:], p.ReqId)
+	copy(buf[8:12], p.UserIp.To4())
+	binary.BigEndian.PutUint16(buf[12:], p.UserPort)
+	buf[14] = p.ErrCode
+	buf[15] = byte(len(p.Attrs))
+	return buf
+}
+
+func (p *Packet) attrBytes() []byte {
+	var buf bytes.Buffer
+	for _, attr := range p.Attrs {
+		buf.WriteByte(attr.Type)
+		buf.WriteByte(byte(len(attr.Value) + 2))
+		buf.Write(attr.Value)
+	}
+	return buf.Bytes()
+}
+
+// authenticator
+// MD5(header + request authenticator + attributes + secret),
+// a request uses 16 zero bytes as the request authenticator
+func (p *Packet) authenticator(reqAuth, secret []byte) []byte {
+	if reqAuth == nil {
+		reqAuth = make([]byte, authenticatorLen)
+	}
+	hash := md5.New()
+	hash.Write(p.header())
+	hash.Write(reqAuth)
+	hash.Write(p.attrBytes())
+	hash.Write(secret)
+	return hash.Sum(nil)
+}
+
+// Encode
+// reqAuth is the authenticator of the request when encoding a response, nil for a request
+func (p *Packet) Encode(secret []byte, reqAuth []byte) ([]byte, error) {
+	for _, attr := range p.Attrs {
+		if len(attr.Value) > 253 {
+			return nil, fmt.Errorf("portal attribute %d too long", attr.Type)
+		}
+	}
+	var buf bytes.Buffer
+	buf.Write(p.header())
+	if p.Version == PortalVersion2 {
+		p.Authenticator = p.authenticator(reqAuth, secret)
+		buf.Write(p.Authenticator)
+	}
+	buf.Write(p.attrBytes())
+	return buf.Bytes(), nil
+}
+
+// Verify
+// check the v2 authenticator, v1 packets have no authenticator
+func (p *Packet) Verify(secret []byte, reqAuth []byte) error {
+	if p.Version != PortalVersion2 {
+		return nil
+	}
+	if !bytes.Equal(p.Authenticator, p.authenticator(reqAuth, secret)) {
+		return ErrInvalidAuthenticator
+	}
+	return nil
+}
+
+// DecodePacket
+func DecodePacket(data []byte) (*Packet, error) {
+	if len(data) < headerLen {
+		return nil, errors.New("portal packet too short")
+	}
+	p := &Packet{
+		Version:  data[0],
+		Type:     data[1],
+		Mode:     data[2],
+		Rsv:      data[3],
+		SerialNo: binary.BigEndian.Uint16(data[4:]),
+		ReqId:    binary.BigEndian.Uint16(data[6:]),
+		UserIp:   net.IPv4(data[8], data[9], data[10], data[11]).To4(),
+		UserPort: binary.BigEndian.Uint16(data[12:]),
+		ErrCode:  data[14],
+	}
+	attrNum := int(data[15])
+	offset := headerLen
+	switch p.Version {
+	case PortalVersion1:
+	case PortalVersion2:
+		if len(data) < headerLen+authenticatorLen {
+			return nil, errors.New("portal v2 packet too short")
+		}
+		p.Authenticator = append([]byte(nil), data[headerLen:headerLen+authenticatorLen]...)
+		offset += authenticatorLen
+	default:
+		return nil, fmt.Errorf("unsupported portal version %d", p.Version)
+	}
+	for i := 0; i < attrNum; i++ {
+		if offset+2 > len(data) {
+			return nil, errors.New("portal attribute truncated")
+		}
+		alen := int(data[offset+1])
+		if alen < 2 || offset+alen > len(data) {
+			return nil, errors.New("portal attribute length invalid")
+		}
+		p.Attrs = append(p.Attrs, Attribute{
+			Type:  data[offset],
+			Value: append([]byte(nil), data[offset+2:offset+alen]...),
+		})
+		offset += alen
+	}
+	return p, nil
+}
+
+// ChapPassword
+// MD5(chap id + password + challenge), the chap id is the low byte of ReqID
+func ChapPassword(reqId uint16, password string, challenge []byte) []byte {
+	hash := md5.New()
+	hash.Write([]byte{byte(reqId)})
+	hash.Write([]byte(password))
+	hash.Write(challenge)
+	return hash.Sum(nil)
+}
@@ -0,0 +1,236 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package portald
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/ca17/teamsacs/config"
+	"github.com/ca17/teamsacs/models"
+)
+
+func TestPacketEncodeDecode(t *testing.T) {
+	secret := []byte("portal-secret")
+	p := NewPacket(PortalVersion2, ReqAuth, ModePap, 12, 34, net.ParseIP("10.0.0.2"))
+	p.AddAttr(AttrUserName, []byte("test01"))
+	p.AddAttr(AttrPassword, []byte("888888"))
+	data, err := p.Encode(secret, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != headerLen+authenticatorLen+8+8 {
+		t.Fatalf("packet length %d error", len(data))
+	}
+	p2, err := DecodePacket(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p2.SerialNo != 12 || p2.ReqId != 34 || p2.UserIp.String() != "10.0.0.2" ||
+		string(p2.GetAttr(AttrUserName)) != "test01" || string(p2.GetAttr(AttrPassword)) != "888888" {
+		t.Fatalf("decode packet error %s", p2)
+	}
+	if err := p2.Verify(secret, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := p2.Verify([]byte("bad"), nil); err != ErrInvalidAuthenticator {
+		t.Fatal("bad secret must fail")
+	}
+
+	v1 := NewPacket(PortalVersion1, ReqChallenge, ModeChap, 1, 0, net.ParseIP("10.0.0.2"))
+	data, _ = v1.Encode(secret, nil)
+	if len(data) != headerLen {
+		t.Fatalf("v1 packet length %d error", len(data))
+	}
+}
+
+type testSessions struct {
+	sync.Mutex
+	sessions map[string]string
+}
+
+func (ts *testSessions) get(userip string) (string, bool) {
+	ts.Lock()
+	defer ts.Unlock()
+	username, ok := ts.sessions[userip]
+	return username, ok
+}
+
+func newTestPortal(t *testing.T, bras *simBras, mode byte) (*PortalServer, *testSessions, *net.UDPAddr, context.CancelFunc) {
+	s := newPortalServer(config.PortaldConfig{Timeout: 1})
+	s.GetBras = func(ip string) (*Bras, error) {
+		return &Bras{Addr: bras.Addr(), Secret: bras.secret, Version: bras.version, Mode: mode}, nil
+	}
+	store := &testSessions{sessions: make(map[string]string)}
+	s.OnLogin = func(session *models.PortalSession) {
+		store.Lock()
+		store.sessions[session.Userip] = session.Username
+		store.Unlock()
+	}
+	s.OnLogout = func(nasaddr, userip string) {
+		store.Lock()
+		delete(store.sessions, userip)
+		store.Unlock()
+	}
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	go s.Serve(ctx, conn)
+	time.Sleep(time.Millisecond * 10)
+	return s, store, conn.LocalAddr().(*net.UDPAddr), cancel
+}
+
+func TestPortalChapAuth(t *testing.T) {
+	for _, version := range []byte{PortalVersion1, PortalVersion2} {
+		bras, err := newSimBras("secret", version, map[string]string{"test01": "888888"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, store, portalAddr, cancel := newTestPortal(t, bras, ModeChap)
+
+		if err := s.Auth("127.0.0.1", "10.0.0.2", "test01", "000000"); err == nil {
+			t.Fatal("bad password must be rejected")
+		}
+		if err := s.Auth("127.0.0.1", "10.0.0.2", "test01", "888888"); err != nil {
+			t.Fatal(err)
+		}
+		if username, ok := bras.isOnline("10.0.0.2"); !ok || username != "test01" {
+			t.Fatal("user must be online")
+		}
+		select {
+		case <-bras.affAcks:
+		case <-time.After(time.Second):
+			t.Fatal("AFF_ACK_AUTH not received")
+		}
+		if username, _ := store.get("10.0.0.2"); username != "test01" {
+			t.Fatal("portal session not saved")
+		}
+
+		// the BRAS kicks the user
+		ack, err := bras.NotifyLogout(portalAddr, "10.0.0.2")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ack.Type != AckNtfLogout {
+			t.Fatalf("unexpected ack %s", ack)
+		}
+		if _, ok := store.get("10.0.0.2"); ok {
+			t.Fatal("portal session must be removed by NTF_LOGOUT")
+		}
+		cancel()
+		bras.Close()
+	}
+}
+
+func TestPortalPapAuthAndLogout(t *testing.T) {
+	bras, err := newSimBras("secret", PortalVersion2, map[string]string{"test02": "123456"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bras.Close()
+	s, store, _, cancel := newTestPortal(t, bras, ModePap)
+	defer cancel()
+	if err := s.Auth("127.0.0.1", "10.0.0.3", "test02", "123456"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Logout("127.0.0.1", "10.0.0.3"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := bras.isOnline("10.0.0.3"); ok {
+		t.Fatal("user must be offline")
+	}
+	if _, ok := store.get("10.0.0.3"); ok {
+		t.Fatal("portal session must be removed by logout")
+	}
+}
+
+func TestPortalSecretMismatch(t *testing.T) {
+	bras, err := newSimBras("secret", PortalVersion2, map[string]string{"test01": "888888"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bras.Close()
+	s, _, _, cancel := newTestPortal(t, bras, ModePap)
+	defer cancel()
+	s.GetBras = func(ip string) (*Bras, error) {
+		return &Bras{Addr: bras.Addr(), Secret: []byte("wrong"), Version: PortalVersion2, Mode: ModePap}, nil
+	}
+	if err := s.Auth("127.0.0.1", "10.0.0.4", "test01", "888888"); err == nil {
+		t.Fatal("auth with wrong secret must fail")
+	}
+}
+
+func TestChapPassword(t *testing.T) {
+	challenge := bytes.Repeat([]byte{0x01}, 16)
+	if bytes.Equal(ChapPassword(1, "888888", challenge), ChapPassword(2, "888888", challenge)) {
+		t.Fatal("chap id must change the chap password")
+	}
+}
+
+func TestPortalSpoofedAck(t *testing.T) {
+	s := newPortalServer(config.PortaldConfig{})
+	bras := &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 2000}
+	pending := &pendingRequest{addr: bras, ch: make(chan *Packet, 1)}
+	s.pending[100] = pending
+	data, err := NewPacket(PortalVersion1, AckAuth, ModePap, 100, 0, net.ParseIP("10.0.0.2").To4()).Encode(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.handlePacket(&net.UDPAddr{IP: net.ParseIP("127.0.0.2"), Port: 2000}, data)
+	if len(pending.ch) != 0 {
+		t.Fatal("the ack of another address must be discarded")
+	}
+	s.handlePacket(bras, data)
+	if len(pending.ch) != 1 {
+		t.Fatal("the ack of the bras is not accepted")
+	}
+}
+
+func TestPortalUserip(t *testing.T) {
+	e := echo.New()
+	e.IPExtractor = echo.ExtractIPDirect()
+	for _, tc := range []struct {
+		form   url.Values
+		expect string
+		ok     bool
+	}{
+		{url.Values{}, "10.0.0.2", true},
+		{url.Values{"userip": {"10.0.0.2"}}, "10.0.0.2", true},
+		{url.Values{"wlanuserip": {"10.0.0.3"}}, "10.0.0.2", false},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/portal/logout", strings.NewReader(tc.form.Encode()))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+		req.Header.Set(echo.HeaderXForwardedFor, "10.0.0.3")
+		req.RemoteAddr = "10.0.0.2:50000"
+		ip, err := userip(e.NewContext(req, httptest.NewRecorder()))
+		if ip != tc.expect || (err == nil) != tc.ok {
+			t.Fatalf("%v: %s %v", tc.form, ip, err)
+		}
+	}
+}
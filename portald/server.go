@@ -0,0 +1,399 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package portald
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ca17/teamsacs/common/log"
+	"github.com/ca17/teamsacs/config"
+	"github.com/ca17/teamsacs/models"
+)
+
+// Bras
+// The BRAS that the portal server talks to
+type Bras struct {
+	Addr    *net.UDPAddr
+	Secret  []byte
+	Version byte
+	Mode    byte
+}
+
+type pendingRequest struct {
+	addr    *net.UDPAddr
+	reqAuth []byte
+	secret  []byte
+	ch      chan *Packet
+}
+
+// PortalServer
+// Huawei/CMCC Portal v1/v2 server, sends REQ_CHALLENGE/REQ_AUTH/REQ_LOGOUT to the BRAS
+// and handles NTF_LOGOUT from the BRAS on the same udp socket
+type PortalServer struct {
+	Manager *models.ModelManager
+	Config  config.PortaldConfig
+
+	// GetBras lookup the BRAS by ip address
+	GetBras func(ip string) (*Bras, error)
+	// OnLogin/OnLogout session hooks
+	OnLogin  func(session *models.PortalSession)
+	OnLogout func(nasaddr, userip string)
+
+	conn    *net.UDPConn
+	serial  uint32
+	lock    sync.Mutex
+	pending map[uint16]*pendingRequest
+}
+
+func newPortalServer(cfg config.PortaldConfig) *PortalServer {
+	return &PortalServer{
+		Config:   cfg,
+		serial:   uint32(time.Now().UnixNano()),
+		pending:  make(map[uint16]*pendingRequest),
+		OnLogin:  func(session *models.PortalSession) {},
+		OnLogout: func(nasaddr, userip string) {},
+	}
+}
+
+func NewPortalServer(manager *models.ModelManager) *PortalServer {
+	s := newPortalServer(manager.Config.Portald)
+	s.Manager = manager
+	s.GetBras = s.getVpeBras
+	s.OnLogin = func(session *models.PortalSession) {
+		if err := manager.GetPortalManager().AddPortalSession(session); err != nil {
+			log.Errorf("add portal session error, %s", err.Error())
+		}
+	}
+	s.OnLogout = func(nasaddr, userip string) {
+		if err := manager.GetPortalManager().DeletePortalSession(nasaddr, userip); err != nil {
+			log.Errorf("delete portal session error, %s", err.Error())
+		}
+	}
+	return s
+}
+
+// getVpeBras
+// the BRAS is a VPE, portal_secret/portal_version/portal_port/portal_mode override the portald config
+func (s *PortalServer) getVpeBras(ip string) (*Bras, error) {
+	vpe, err := s.Manager.GetVpeManager().GetVpeByIpaddr(ip)
+	if err != nil {
+		return nil, fmt.Errorf("unauthorized bras %s, %s", ip, err.Error())
+	}
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return nil, fmt.Errorf("invalid bras ip %s", ip)
+	}
+	mode := ModeChap
+	if strings.ToLower(vpe.GetStringValue("portal_mode", "chap")) == "pap" {
+		mode = ModePap
+	}
+	return &Bras{
+		Addr:    &net.UDPAddr{IP: addr, Port: vpe.GetPortalPort(s.Config.BrasPort)},
+		Secret:  []byte(vpe.GetPortalSecret(s.Config.Secret)),
+		Version: byte(vpe.GetPortalVersion(s.Config.Version)),
+		Mode:    mode,
+	}, nil
+}
+
+func (s *PortalServer) timeout() time.Duration {
+	if s.Config.Timeout <= 0 {
+		return time.Second * 5
+	}
+	return time.Second * time.Duration(s.Config.Timeout)
+}
+
+func (s *PortalServer) nextSerial() uint16 {
+	return uint16(atomic.AddUint32(&s.serial, 1))
+}
+
+// ListenAndServe
+func (s *PortalServer) ListenAndServe(ctx context.Context) error {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP(s.Config.Host), Port: s.Config.Port})
+	if err != nil {
+		return err
+	}
+	log.Infof("Starting Portal server on %s", conn.LocalAddr())
+	return s.Serve(ctx, conn)
+}
+
+// Serve
+// Read packets until the context is done
+func (s *PortalServer) Serve(ctx context.Context, conn *net.UDPConn) error {
+	s.lock.Lock()
+	s.conn = conn
+	s.lock.Unlock()
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+	for {
+		data := make([]byte, 4096)
+		n, raddr, err := conn.ReadFromUDP(data)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			log.Error(err)
+			continue
+		}
+		go s.handlePacket(raddr, data[:n])
+	}
+}
+
+func (s *PortalServer) handlePacket(raddr *net.UDPAddr, data []byte) {
+	pkt, err := DecodePacket(data)
+	if err != nil {
+		log.Errorf("decode portal packet from %s error, %s", raddr, err.Error())
+		return
+	}
+	if s.Config.Debug {
+		log.Infof("Received %s from %s", pkt, raddr)
+	}
+
+	if pkt.IsResponse() {
+		s.lock.Lock()
+		req, ok := s.pending[pkt.SerialNo]
+		s.lock.Unlock()
+		if !ok {
+			log.Warningf("discard unexpected %s from %s", pkt, raddr)
+			return
+		}
+		// the v1 packets have no authenticator, the reply must come from the BRAS
+		if !raddr.IP.Equal(req.addr.IP) {
+			log.Warningf("discard %s from %s, the request was sent to %s", pkt, raddr, req.addr)
+			return
+		}
+		if err := pkt.Verify(req.secret, req.reqAuth); err != nil {
+			log.Errorf("discard %s from %s, %s", pkt, raddr, err.Error())
+			return
+		}
+		select {
+		case req.ch <- pkt:
+		default:
+		}
+		return
+	}
+
+	switch pkt.Type {
+	case NtfLogout:
+		s.handleNtfLogout(raddr, pkt)
+	default:
+		log.Warningf("unsupported %s from %s", pkt, raddr)
+	}
+}
+
+// handleNtfLogout
+// The BRAS notifies that the user is offline, remove the session and reply ACK_NTF_LOGOUT
+func (s *PortalServer) handleNtfLogout(raddr *net.UDPAddr, pkt *Packet) {
+	bras, err := s.GetBras(raddr.IP.String())
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	if err := pkt.Verify(bras.Secret, nil); err != nil {
+		log.Errorf("discard %s from %s, %s", pkt, raddr, err.Error())
+		return
+	}
+	s.OnLogout(raddr.IP.String(), pkt.UserIp.String())
+	ack := NewPacket(pkt.Version, AckNtfLogout, pkt.Mode, pkt.SerialNo, pkt.ReqId, pkt.UserIp)
+	if err := s.send(raddr, ack, bras.Secret, pkt.Authenticator); err != nil {
+		log.Error(err)
+	}
+}
+
+func (s *PortalServer) send(raddr *net.UDPAddr, pkt *Packet, secret, reqAuth []byte) error {
+	s.lock.Lock()
+	conn := s.conn
+	s.lock.Unlock()
+	if conn == nil {
+		return errors.New("portal server is not running")
+	}
+	data, err := pkt.Encode(secret, reqAuth)
+	if err != nil {
+		return err
+	}
+	if s.Config.Debug {
+		log.Infof("Writing %s to %s", pkt, raddr)
+	}
+	_, err = conn.WriteToUDP(data, raddr)
+	return err
+}
+
+// request
+// send a request to the BRAS and wait for the expected ack
+func (s *PortalServer) request(bras *Bras, req *Packet, expect byte) (*Packet, error) {
+	req.SerialNo = s.nextSerial()
+	pending := &pendingRequest{addr: bras.Addr, secret: bras.Secret, ch: make(chan *Packet, 1)}
+	s.lock.Lock()
+	s.pending[req.SerialNo] = pending
+	s.lock.Unlock()
+	defer func() {
+		s.lock.Lock()
+		delete(s.pending, req.SerialNo)
+		s.lock.Unlock()
+	}()
+
+	// Encode computes the request authenticator, the ack is verified against it
+	data, err := req.Encode(bras.Secret, nil)
+	if err != nil {
+		return nil, err
+	}
+	pending.reqAuth = req.Authenticator
+	s.lock.Lock()
+	conn := s.conn
+	s.lock.Unlock()
+	if conn == nil {
+		return nil, errors.New("portal server is not running")
+	}
+	if s.Config.Debug {
+		log.Infof("Writing %s to %s", req, bras.Addr)
+	}
+	if _, err = conn.WriteToUDP(data, bras.Addr); err != nil {
+		return nil, err
+	}
+
+	select {
+	case resp := <-pending.ch:
+		if resp.Type != expect {
+			return nil, fmt.Errorf("unexpected portal response %s", resp.TypeName())
+		}
+		return resp, nil
+	case <-time.After(s.timeout()):
+		return nil, fmt.Errorf("portal %s to %s timeout", req.TypeName(), bras.Addr)
+	}
+}
+
+func ackError(resp *Packet) error {
+	text := string(resp.GetAttr(AttrTextInfo))
+	if text == "" {
+		switch resp.ErrCode {
+		case ErrCodeReject:
+			text = "rejected"
+		case ErrCodeWait:
+			text = "in progress, please wait"
+		default:
+			text = "failure"
+		}
+	}
+	return fmt.Errorf("portal %s error(%d): %s", resp.TypeName(), resp.ErrCode, text)
+}
+
+// Auth
+// Challenge (chap mode) and auth the user with the BRAS, the BRAS then performs radius auth
+func (s *PortalServer) Auth(basip, userip, username, password string) error {
+	bras, err := s.GetBras(basip)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(userip).To4()
+	if ip == nil {
+		return fmt.Errorf("invalid user ip %s", userip)
+	}
+	if username == "" || password == "" {
+		return errors.New("username and password cannot be empty")
+	}
+
+	var reqId uint16
+	auth := NewPacket(bras.Version, ReqAuth, bras.Mode, 0, 0, ip)
+	auth.AddAttr(AttrUserName, []byte(username))
+	if bras.Mode == ModeChap {
+		challenge, err := s.request(bras, NewPacket(bras.Version, ReqChallenge, ModeChap, 0, 0, ip), AckChallenge)
+		if err != nil {
+			return err
+		}
+		switch challenge.ErrCode {
+		case ErrCodeSuccess:
+		case ErrCodeOnline:
+			return nil
+		default:
+			return ackError(challenge)
+		}
+		chapChallenge := challenge.GetAttr(AttrChallenge)
+		if len(chapChallenge) != 16 {
+			return errors.New("portal challenge must be 16 bytes")
+		}
+		reqId = challenge.ReqId
+		auth.ReqId = reqId
+		auth.AddAttr(AttrChapPassword, ChapPassword(reqId, password, chapChallenge))
+	} else {
+		auth.AddAttr(AttrPassword, []byte(password))
+	}
+
+	resp, err := s.request(bras, auth, AckAuth)
+	if err != nil {
+		// notify the BRAS to cancel the auth request
+		cancel := NewPacket(bras.Version, ReqLogout, bras.Mode, 0, reqId, ip)
+		cancel.ErrCode = 0x01
+		cancel.SerialNo = s.nextSerial()
+		if serr := s.send(bras.Addr, cancel, bras.Secret, nil); serr != nil {
+			log.Error(serr)
+		}
+		return err
+	}
+	switch resp.ErrCode {
+	case ErrCodeSuccess:
+		aff := NewPacket(bras.Version, AffAckAuth, bras.Mode, resp.SerialNo, reqId, ip)
+		if err := s.send(bras.Addr, aff, bras.Secret, nil); err != nil {
+			log.Error(err)
+		}
+	case ErrCodeOnline:
+	default:
+		return ackError(resp)
+	}
+	mode := "chap"
+	if bras.Mode == ModePap {
+		mode = "pap"
+	}
+	s.OnLogin(&models.PortalSession{
+		Username:  username,
+		NasAddr:   basip,
+		Userip:    userip,
+		Mode:      mode,
+		LoginTime: time.Now(),
+	})
+	return nil
+}
+
+// Logout
+// Request the BRAS to logout the user
+func (s *PortalServer) Logout(basip, userip string) error {
+	bras, err := s.GetBras(basip)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(userip).To4()
+	if ip == nil {
+		return fmt.Errorf("invalid user ip %s", userip)
+	}
+	resp, err := s.request(bras, NewPacket(bras.Version, ReqLogout, bras.Mode, 0, 0, ip), AckLogout)
+	if err != nil {
+		return err
+	}
+	// errcode 2 means the user is already offline
+	if resp.ErrCode != ErrCodeSuccess && resp.ErrCode != ErrCodeOnline {
+		return ackError(resp)
+	}
+	s.OnLogout(basip, userip)
+	return nil
+}
@@ -0,0 +1,144 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package portald
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+
+	"github.com/ca17/teamsacs/common/log"
+	"github.com/ca17/teamsacs/constant"
+)
+
+// LoginPage template data
+type LoginPage struct {
+	Username string
+	Userip   string
+	Basip    string
+	Message  string
+}
+
+// formValue
+// BRAS redirect urls use different parameter names by vendor
+func formValue(c echo.Context, names ...string) string {
+	for _, name := range names {
+		if v := strings.TrimSpace(c.FormValue(name)); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// userip
+// the client address of the request, the userip of the BRAS redirect
+// must be the same so that a user can not login or logout another address
+func userip(c echo.Context) (string, error) {
+	ip := c.RealIP()
+	if v := formValue(c, "userip", "wlanuserip", "user-ip"); v != "" && v != ip {
+		return ip, fmt.Errorf("user ip %s does not match the client address", v)
+	}
+	return ip, nil
+}
+
+func basip(c echo.Context) string {
+	return formValue(c, "basip", "wlanacip", "nasip", "ac-ip")
+}
+
+// ListenWebServer
+// The portal web login page
+func (s *PortalServer) ListenWebServer(ctx context.Context) error {
+	e := echo.New()
+	e.Pre(middleware.RemoveTrailingSlash())
+	e.Use(middleware.Recover())
+	e.Use(middleware.LoggerWithConfig(middleware.LoggerConfig{
+		Format: "portal ${time_rfc3339} ${remote_ip} ${method} ${uri} ${protocol} ${status} ${id} ${user_agent} ${latency} ${bytes_in} ${bytes_out} ${error}\n",
+		Output: os.Stdout,
+	}))
+	e.Renderer = s.Manager.TplRender
+	e.HideBanner = true
+	// the forwarded headers are set by the client, the portal is reached directly
+	e.IPExtractor = echo.ExtractIPDirect()
+	e.GET("/", s.LoginPage)
+	e.GET("/portal", s.LoginPage)
+	e.GET("/portal/login", s.LoginPage)
+	e.POST("/portal/login", s.Login)
+	e.POST("/portal/logout", s.LogoutPage)
+
+	go func() {
+		<-ctx.Done()
+		sctx, cancel := context.WithTimeout(context.Background(), constant.ShutdownTimeout)
+		defer cancel()
+		if err := e.Shutdown(sctx); err != nil {
+			log.Errorf("shutdown portal web server error, %s", err.Error())
+		}
+	}()
+	err := e.Start(fmt.Sprintf("%s:%d", s.Config.Host, s.Config.WebPort))
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+// LoginPage
+func (s *PortalServer) LoginPage(c echo.Context) error {
+	page := &LoginPage{Basip: basip(c)}
+	var err error
+	if page.Userip, err = userip(c); err != nil {
+		page.Message = err.Error()
+	}
+	return c.Render(http.StatusOK, "portal_login", page)
+}
+
+// Login
+func (s *PortalServer) Login(c echo.Context) error {
+	page := &LoginPage{
+		Username: strings.TrimSpace(c.FormValue("username")),
+		Basip:    basip(c),
+	}
+	var err error
+	if page.Userip, err = userip(c); err == nil {
+		err = s.Auth(page.Basip, page.Userip, page.Username, c.FormValue("password"))
+	}
+	if err != nil {
+		log.Errorf("portal login user:%s userip:%s failure, %s", page.Username, page.Userip, err.Error())
+		page.Message = err.Error()
+		return c.Render(http.StatusOK, "portal_login", page)
+	}
+	return c.Render(http.StatusOK, "portal_success", page)
+}
+
+// LogoutPage
+func (s *PortalServer) LogoutPage(c echo.Context) error {
+	page := &LoginPage{Basip: basip(c)}
+	var err error
+	if page.Userip, err = userip(c); err == nil {
+		err = s.Logout(page.Basip, page.Userip)
+	}
+	if err != nil {
+		log.Errorf("portal logout userip:%s failure, %s", page.Userip, err.Error())
+		page.Message = err.Error()
+		return c.Render(http.StatusOK, "portal_success", page)
+	}
+	page.Message = "You have been logged out"
+	return c.Render(http.StatusOK, "portal_login", page)
+}
@@ -22,6 +22,10 @@ func (s *AcctService) processAcctStart(r *radius.Request, vr *radparser.VendorRe
 	if err!= nil {
 		radlog.Errorf("AddRadiusOnline user:%s error %s", username, err.Error())
 	}
+	if err = s.Manager.GetVoucherManager().ActivateVoucher(username); err != nil {
+		radlog.Errorf("ActivateVoucher user:%s error %s", username, err.Error())
+	}
+	s.Manager.Events.Publish(models.NewSessionEvent(models.EventAcctStart, &online))
 }
 
 
@@ -29,15 +33,25 @@ func (s *AcctService) processAcctUpdateBefore(r *radius.Request, vr *radparser.V
 	// 用户状态变更为停用后触发下线
 	var username = user.GetStringValue("username",constant.NA)
 	if user.GetStringValue("status", constant.DISABLED) == constant.DISABLED {
-		s.processAcctDisconnect(r, vpe, username, nasrip)
+		s.processAcctDisconnect(r, vpe, username, nasrip, "user disabled")
 	}
 
 	// 用户过期后触发下线
 	if user.GetExpireTime().Before(time.Now()) {
-		s.processAcctDisconnect(r, vpe, username, nasrip)
+		s.processAcctDisconnect(r, vpe, username, nasrip, "user expire")
+	}
+
+	// 预付费余额耗尽后触发下线
+	if s.Manager.GetBillingManager().IsBalanceExhausted(username) {
+		s.processAcctDisconnect(r, vpe, username, nasrip, "balance exhausted")
 	}
 
 	s.processAcctUpdate(r, vr, username, vpe, nasrip)
+
+	// 代金券流量耗尽后停用并触发下线
+	if err := s.Manager.GetVoucherManager().CheckVoucherQuota(username); err != nil {
+		radlog.Errorf("CheckVoucherQuota user:%s error %s", username, err.Error())
+	}
 }
 
 
@@ -60,6 +74,11 @@ func (s *AcctService) processAcctStop(r *radius.Request, vr *radparser.VendorReq
 	if err := s.Manager.GetRadiusManager().DeleteRadiusOnline(online.AcctSessionId); err != nil {
 		radlog.Errorf("DeleteRadiusOnline user:%s error %s ", username, err.Error())
 	}
+	if err := s.Manager.GetVoucherManager().CheckVoucherQuota(username); err != nil {
+		radlog.Errorf("CheckVoucherQuota user:%s error %s", username, err.Error())
+	}
+	online.AcctStopTime = time.Now()
+	s.Manager.Events.Publish(models.NewSessionEvent(models.EventAcctStop, &online))
 }
 
 
@@ -84,7 +103,7 @@ func (s *AcctService) processAcctNasOff(r *radius.Request) {
 }
 
 
-func (s *AcctService) processAcctDisconnect(r *radius.Request, vpe *models.Vpe, username, nasrip string) {
+func (s *AcctService) processAcctDisconnect(r *radius.Request, vpe *models.Vpe, username, nasrip, reason string) {
 	packet := radius.New(radius.CodeDisconnectRequest, []byte(vpe.GetStringValue("secret",constant.NA)))
 	sessionid := rfc2866.AcctSessionID_GetString(r.Packet)
 	if sessionid == "" {
@@ -99,5 +118,8 @@ func (s *AcctService) processAcctDisconnect(r *radius.Request, vpe *models.Vpe,
 	if err != nil {
 		radlog.Errorf("radius disconnect user:%s failure", username)
 	}
+	event := models.NewAuthEvent(models.EventSessionDisconnect, username, nasrip, reason)
+	event.Data = map[string]interface{}{"acct_session_id": sessionid, "coa_port": coaPort, "success": err == nil}
+	s.Manager.Events.Publish(event)
 	radlog.Info("radius disconnect resp from (%s:%s): %s ", nasrip, coaPort, debug.FormatPacket(response))
 }
@@ -3,11 +3,13 @@ package radiusd
 import (
 	"errors"
 	"strings"
+	"time"
 
 	"layeh.com/radius"
 	"layeh.com/radius/rfc2865"
 	"layeh.com/radius/rfc2866"
 
+	"github.com/ca17/teamsacs/common/metrics"
 	"github.com/ca17/teamsacs/radiusd/debug"
 	"github.com/ca17/teamsacs/radiusd/radlog"
 	"github.com/ca17/teamsacs/radiusd/radparser"
@@ -23,6 +25,12 @@ func NewAcctService(radiusService *RadiusService) *AcctService {
 }
 
 func (s *AcctService) ServeRADIUS(w radius.ResponseWriter, r *radius.Request) {
+	var start = time.Now()
+	var vendor = "unknown"
+	defer func() {
+		metrics.RadiusRequests.WithLabelValues("acct", r.Code.String(), vendor).Inc()
+		metrics.RadiusHandleDuration.WithLabelValues("acct").Observe(time.Since(start).Seconds())
+	}()
 	defer func() {
 		if ret := recover(); ret != nil {
 			err, ok := ret.(error)
@@ -42,6 +50,7 @@ func (s *AcctService) ServeRADIUS(w radius.ResponseWriter, r *radius.Request) {
 	var identifier = rfc2865.NASIdentifier_GetString(r.Packet)
 	vpe, err := s.GetNas(nasrip, identifier)
 	radlog.CheckError(err)
+	vendor = vpe.GetVendorCode()
 
 	// 重新设置数据报文秘钥
 	r.Secret = []byte(vpe.GetSecret())
@@ -9,6 +9,7 @@ import (
 	"layeh.com/radius"
 	"layeh.com/radius/rfc2865"
 
+	"github.com/ca17/teamsacs/common/metrics"
 	"github.com/ca17/teamsacs/radiusd/authorization"
 	"github.com/ca17/teamsacs/radiusd/debug"
 	"github.com/ca17/teamsacs/radiusd/radlog"
@@ -27,6 +28,11 @@ func NewAuthService(radiusService *RadiusService) *AuthService {
 // RADIUS Auth
 func (s *AuthService) ServeRADIUS(w radius.ResponseWriter, r *radius.Request) {
 	var start = time.Now()
+	var vendor = "unknown"
+	defer func() {
+		metrics.RadiusRequests.WithLabelValues("auth", r.Code.String(), vendor).Inc()
+		metrics.RadiusHandleDuration.WithLabelValues("auth").Observe(time.Since(start).Seconds())
+	}()
 	defer func() {
 		if ret := recover(); ret != nil {
 			err, ok := ret.(error)
@@ -54,6 +60,7 @@ func (s *AuthService) ServeRADIUS(w radius.ResponseWriter, r *radius.Request) {
 
 	vpe, err := s.GetNas(ip, identifier)
 	s.CheckRadAuthError(start, username, ip, err)
+	vendor = vpe.GetVendorCode()
 
 	//  setup new packet secret
 	r.Secret = []byte(vpe.GetSecret())
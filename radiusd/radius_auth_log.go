@@ -1,12 +1,15 @@
 package radiusd
 
 import (
+	"strings"
 	"time"
 
 	"github.com/pkg/errors"
 
 	"github.com/ca17/teamsacs/common/log"
+	"github.com/ca17/teamsacs/common/metrics"
 	"github.com/ca17/teamsacs/constant"
+	"github.com/ca17/teamsacs/models"
 	"github.com/ca17/teamsacs/radiusd/radlog"
 )
 
@@ -19,6 +22,8 @@ func (s *RadiusService) addAuthlog(start time.Time, username string, nasip strin
 
 func (s *RadiusService) CheckRadAuthError(start time.Time,username, nasip string, err error) {
 	if err != nil {
+		s.Manager.Events.Publish(models.NewAuthEvent(models.EventAuthReject, username, nasip, err.Error()))
+		metrics.RadiusAuthResults.WithLabelValues("reject", AuthRejectReason(err)).Inc()
 		logLevel := s.GetStringConfig(constant.RadiusAuthlogLevel, RadiusAuthlogAll)
 		if logLevel != RadiusAuthlogNone && (logLevel == RadiusAuthlogAll || logLevel == RadiusAuthFailure) {
 			s.addAuthlog(start, username, nasip, RadiusAuthFailure, err.Error())
@@ -32,9 +37,41 @@ func (s *RadiusService) CheckRadAuthError(start time.Time,username, nasip string
 }
 
 func (s *RadiusService) LogAuthSucess(start time.Time,username, nasip string) {
+	s.Manager.Events.Publish(models.NewAuthEvent(models.EventAuthAccept, username, nasip, ""))
+	metrics.RadiusAuthResults.WithLabelValues("accept", "").Inc()
 	logLevel := s.GetStringConfig(constant.RadiusAuthlogLevel, RadiusAuthlogAll)
 	if logLevel != RadiusAuthlogNone && (logLevel == RadiusAuthlogAll || logLevel == RadiusAuthSucces) {
 		s.addAuthlog(start, username, nasip, RadiusAuthSucces, RadiusAuthSucces)
 	}
 }
 
+
+// AuthRejectReason
+// Classify auth errors into a small set of reason categories for metrics
+func AuthRejectReason(err error) string {
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, "Unauthorized access to device"):
+		return "nas_unauthorized"
+	case strings.Contains(msg, "username is empty"):
+		return "username_empty"
+	case strings.Contains(msg, "not exists"):
+		return "user_not_exists"
+	case strings.Contains(msg, "disabled"):
+		return "user_disabled"
+	case strings.Contains(msg, "expire"):
+		return "user_expire"
+	case strings.Contains(msg, "not yet valid"):
+		return "user_not_valid"
+	case strings.Contains(msg, "balance exhausted"):
+		return "balance_exhausted"
+	case strings.Contains(msg, "over limit"):
+		return "online_limit"
+	case strings.Contains(msg, "bind not match"):
+		return "bind_mismatch"
+	case strings.Contains(msg, "password"), strings.Contains(msg, "mschap"):
+		return "password"
+	default:
+		return "other"
+	}
+}
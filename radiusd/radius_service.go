@@ -7,6 +7,7 @@ import (
 	"net"
 	"time"
 
+	"go.mongodb.org/mongo-driver/mongo"
 	"layeh.com/radius"
 	"layeh.com/radius/rfc2865"
 	"layeh.com/radius/rfc2866"
@@ -73,18 +74,15 @@ func (s *RadiusService) GetUser(username string, macauth bool) (*models.Subscrib
 	user := new(models.Subscribe)
 	var err error
 	if macauth {
-		user, err = m.GetSubscribeByUser(username)
-		if err != nil {
-			return nil, err
-		}
-	} else {
 		user, err = m.GetSubscribeByMac(username)
-		if err != nil {
-			if err == sql.ErrNoRows {
-				return nil, fmt.Errorf("user:%s not exists", username)
-			}
-			return nil, err
+	} else {
+		user, err = m.GetSubscribeByUser(username)
+	}
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, fmt.Errorf("user:%s not exists", username)
 		}
+		return nil, err
 	}
 	if user.GetStatus() == common.DISABLED {
 		return nil, fmt.Errorf("user:%s status is disabled", username)
@@ -93,6 +91,16 @@ func (s *RadiusService) GetUser(username string, macauth bool) (*models.Subscrib
 	if user.GetExpireTime().Before(time.Now()) {
 		return nil, fmt.Errorf("user:%s expire", username)
 	}
+
+	// such as fixed date vouchers
+	if user.GetStartTime().After(time.Now()) {
+		return nil, fmt.Errorf("user:%s not yet valid", username)
+	}
+
+	// prepaid users
+	if err = s.Manager.GetBillingManager().CheckBalance(user.GetUsername()); err != nil {
+		return nil, err
+	}
 	return user, nil
 }
 
@@ -9,20 +9,32 @@ import (
 
 var radlog = logging.MustGetLogger("Radiusd")
 
+var radbackend logging.LeveledBackend
+
 func SetupLog(level logging.Level, syslogaddr string, logdir string, module string) {
 	if syslogaddr != "" {
 		bf := log.SetupSyslog(level, syslogaddr, module)
 		if bf != nil {
 			radlog.SetBackend(bf)
+			radbackend = bf
 		}
 	} else {
 		bf := log.FileSyslog(level, logdir, module)
 		if bf != nil {
 			radlog.SetBackend(bf)
+			radbackend = bf
 		}
 	}
 }
 
+// SetLevel
+// change the radius log level at runtime
+func SetLevel(level logging.Level, module string) {
+	if radbackend != nil {
+		radbackend.SetLevel(level, module)
+	}
+}
+
 var (
 	Error    = radlog.Error
 	Errorf   = radlog.Errorf
@@ -1,15 +1,17 @@
 package radiusd
 
 import (
+	"context"
 	"fmt"
 
 	"layeh.com/radius"
 
 	"github.com/ca17/teamsacs/common/log"
+	"github.com/ca17/teamsacs/constant"
 	"github.com/ca17/teamsacs/models"
 )
 
-func ListenRadiusAuthServer(manager *models.ModelManager) error {
+func ListenRadiusAuthServer(ctx context.Context, manager *models.ModelManager) error {
 	service := NewAuthService(NewRadiusService(manager))
 	server := radius.PacketServer{
 		Addr: fmt.Sprintf("%s:%d", manager.Config.Radiusd.Host, manager.Config.Radiusd.AuthPort),
@@ -19,10 +21,10 @@ func ListenRadiusAuthServer(manager *models.ModelManager) error {
 	}
 
 	log.Infof("Starting Radius Auth server on %s", server.Addr)
-	return server.ListenAndServe()
+	return serveRadius(ctx, &server)
 }
 
-func ListenRadiusAcctServer(manager *models.ModelManager) error {
+func ListenRadiusAcctServer(ctx context.Context, manager *models.ModelManager) error {
 	service := NewAcctService(NewRadiusService(manager))
 	server := radius.PacketServer{
 		Addr: fmt.Sprintf("%s:%d", manager.Config.Radiusd.Host, manager.Config.Radiusd.AcctPort),
@@ -32,6 +34,24 @@ func ListenRadiusAcctServer(manager *models.ModelManager) error {
 	}
 
 	log.Infof("Starting Radius Acct server on %s", server.Addr)
-	return server.ListenAndServe()
+	return serveRadius(ctx, &server)
 }
 
+
+// serveRadius
+// Serve until the context is done, then wait for in-flight requests to complete
+func serveRadius(ctx context.Context, server *radius.PacketServer) error {
+	go func() {
+		<-ctx.Done()
+		sctx, cancel := context.WithTimeout(context.Background(), constant.ShutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(sctx); err != nil {
+			log.Errorf("shutdown radius server %s error, %s", server.Addr, err.Error())
+		}
+	}()
+	err := server.ListenAndServe()
+	if err == radius.ErrServerShutdown {
+		return nil
+	}
+	return err
+}
@@ -1,12 +1,41 @@
 package vendors
 
 const (
-	VendorMikrotik = "14988"
-	VendorIkuai    = "10055"
-	VendorHuawei   = "2011"
-	VendorZte      = "3902"
-	VendorH3c      = "25506"
-	VendorRadback  = "2352"
-	VendorCisco    = "9"
+	VendorStandard  = "0"
+	VendorMikrotik  = "14988"
+	VendorIkuai     = "10055"
+	VendorHuawei    = "2011"
+	VendorZte       = "3902"
+	VendorH3c       = "25506"
+	VendorRadback   = "2352"
+	VendorCisco     = "9"
+	VendorAlcatel   = "3041"
+	VendorAruba     = "14823"
+	VendorF5        = "3375"
+	VendorHillstone = "28557"
+	VendorJuniper   = "2636"
+	VendorMicrosoft = "311"
+	VendorPfSense   = "13644"
+	VendorUnix      = "4"
 )
 
+// Vendors
+// The vendor codes of the dictionaries, the standard attributes are vendor 0
+var Vendors = map[string]string{
+	VendorStandard:  "Standard",
+	VendorMikrotik:  "Mikrotik",
+	VendorIkuai:     "iKuai",
+	VendorHuawei:    "Huawei",
+	VendorZte:       "ZTE",
+	VendorH3c:       "H3C",
+	VendorRadback:   "Redback",
+	VendorCisco:     "Cisco",
+	VendorAlcatel:   "Alcatel",
+	VendorAruba:     "Aruba",
+	VendorF5:        "F5",
+	VendorHillstone: "Hillstone",
+	VendorJuniper:   "Juniper",
+	VendorMicrosoft: "Microsoft",
+	VendorPfSense:   "pfSense",
+	VendorUnix:      "Unix",
+}
@@ -0,0 +1,200 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package snmptrapd
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ca17/teamsacs/common/log"
+)
+
+// well known names, the roots of the loaded MIB modules
+var baseMibNames = map[string]string{
+	"iso":                   "1",
+	"org":                   "1.3",
+	"dod":                   "1.3.6",
+	"internet":              "1.3.6.1",
+	"directory":             "1.3.6.1.1",
+	"mgmt":                  "1.3.6.1.2",
+	"mib-2":                 "1.3.6.1.2.1",
+	"system":                "1.3.6.1.2.1.1",
+	"sysDescr":              "1.3.6.1.2.1.1.1",
+	"sysObjectID":           "1.3.6.1.2.1.1.2",
+	"sysUpTime":             "1.3.6.1.2.1.1.3",
+	"sysName":               "1.3.6.1.2.1.1.5",
+	"interfaces":            "1.3.6.1.2.1.2",
+	"ifIndex":               "1.3.6.1.2.1.2.2.1.1",
+	"ifDescr":               "1.3.6.1.2.1.2.2.1.2",
+	"ifAdminStatus":         "1.3.6.1.2.1.2.2.1.7",
+	"ifOperStatus":          "1.3.6.1.2.1.2.2.1.8",
+	"transmission":          "1.3.6.1.2.1.10",
+	"experimental":          "1.3.6.1.3",
+	"private":               "1.3.6.1.4",
+	"enterprises":           "1.3.6.1.4.1",
+	"security":              "1.3.6.1.5",
+	"snmpV2":                "1.3.6.1.6",
+	"snmpDomains":           "1.3.6.1.6.1",
+	"snmpProxys":            "1.3.6.1.6.2",
+	"snmpModules":           "1.3.6.1.6.3",
+	"snmpTrapOID":           "1.3.6.1.6.3.1.1.4.1",
+	"snmpTrapEnterprise":    "1.3.6.1.6.3.1.1.4.3",
+	"coldStart":             "1.3.6.1.6.3.1.1.5.1",
+	"warmStart":             "1.3.6.1.6.3.1.1.5.2",
+	"linkDown":              "1.3.6.1.6.3.1.1.5.3",
+	"linkUp":                "1.3.6.1.6.3.1.1.5.4",
+	"authenticationFailure": "1.3.6.1.6.3.1.1.5.5",
+	"egpNeighborLoss":       "1.3.6.1.6.3.1.1.5.6",
+}
+
+var (
+	mibCommentRe = regexp.MustCompile(`--[^\n]*`)
+	// name MACRO ... ::= { parent 1 } , the body can not contain another assignment
+	mibAssignRe = regexp.MustCompile(`(?s)([a-zA-Z][\w-]*)\s+(OBJECT\s+IDENTIFIER|OBJECT-TYPE|OBJECT-IDENTITY|MODULE-IDENTITY|NOTIFICATION-TYPE|OBJECT-GROUP|NOTIFICATION-GROUP|MODULE-COMPLIANCE|AGENT-CAPABILITIES)\b[^=]*?::=\s*\{([^}]*)\}`)
+	// v1 TRAP-TYPE, the oid is enterprise.0.n
+	mibTrapTypeRe = regexp.MustCompile(`(?s)([a-zA-Z][\w-]*)\s+TRAP-TYPE\s+ENTERPRISE\s+([a-zA-Z][\w-]*)[^=]*?::=\s*(\d+)`)
+	mibSubIdRe    = regexp.MustCompile(`^[a-zA-Z][\w-]*\((\d+)\)$`)
+)
+
+type mibDef struct {
+	name   string
+	parent string
+	subids []string
+}
+
+// MibTree
+// OID and name translation of the loaded MIB modules
+type MibTree struct {
+	lock  sync.RWMutex
+	names map[string]string
+	oids  map[string]string
+}
+
+func NewMibTree() *MibTree {
+	t := &MibTree{names: make(map[string]string), oids: make(map[string]string)}
+	for name, oid := range baseMibNames {
+		t.add(name, oid)
+	}
+	return t
+}
+
+func (t *MibTree) add(name, oid string) {
+	t.names[name] = oid
+	if _, ok := t.oids[oid]; !ok {
+		t.oids[oid] = name
+	}
+}
+
+// LoadDir
+// load all MIB files of the directory, definitions are resolved across files
+func (t *MibTree) LoadDir(dir string) error {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	var texts []string
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(dir, f.Name()))
+		if err != nil {
+			log.Errorf("read mib file %s error, %s", f.Name(), err.Error())
+			continue
+		}
+		texts = append(texts, string(data))
+	}
+	t.Load(texts...)
+	return nil
+}
+
+// Load
+// parse the MIB module texts
+func (t *MibTree) Load(texts ...string) {
+	var defs []mibDef
+	for _, text := range texts {
+		text = mibCommentRe.ReplaceAllString(text, "")
+		for _, m := range mibAssignRe.FindAllStringSubmatch(text, -1) {
+			fields := strings.Fields(m[3])
+			if len(fields) < 2 {
+				continue
+			}
+			defs = append(defs, mibDef{name: m[1], parent: fields[0], subids: fields[1:]})
+		}
+		for _, m := range mibTrapTypeRe.FindAllStringSubmatch(text, -1) {
+			defs = append(defs, mibDef{name: m[1], parent: m[2], subids: []string{"0", m[3]}})
+		}
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	// resolve until no definition can be resolved
+	for resolved := true; resolved && len(defs) > 0; {
+		resolved = false
+		var pending []mibDef
+		for _, def := range defs {
+			oid, ok := t.names[def.parent]
+			if !ok {
+				if _, err := strconv.Atoi(def.parent); err != nil {
+					pending = append(pending, def)
+					continue
+				}
+				oid = def.parent
+			}
+			for _, sub := range def.subids {
+				if sm := mibSubIdRe.FindStringSubmatch(sub); sm != nil {
+					sub = sm[1]
+				}
+				oid += "." + sub
+			}
+			t.add(def.name, oid)
+			resolved = true
+		}
+		defs = pending
+	}
+}
+
+// Translate
+// the name of the longest known prefix with the remaining index, such as ifIndex.3
+func (t *MibTree) Translate(oid string) string {
+	oid = strings.TrimPrefix(oid, ".")
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	for prefix := oid; prefix != ""; {
+		if name, ok := t.oids[prefix]; ok {
+			return name + oid[len(prefix):]
+		}
+		i := strings.LastIndex(prefix, ".")
+		if i < 0 {
+			break
+		}
+		prefix = prefix[:i]
+	}
+	return oid
+}
+
+// Resolve
+// the oid of a name
+func (t *MibTree) Resolve(name string) (string, bool) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	oid, ok := t.names[name]
+	return oid, ok
+}
@@ -0,0 +1,331 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package snmptrapd
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	stdlog "log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gosnmp/gosnmp"
+
+	"github.com/ca17/teamsacs/common/log"
+	"github.com/ca17/teamsacs/config"
+	"github.com/ca17/teamsacs/models"
+)
+
+const (
+	usmCacheTTL   = time.Second * 30
+	maxPacketSize = 65535
+	// the received packets wait in the queue for the workers, a trap storm
+	// beyond the queue is dropped instead of blocking the udp reads
+	trapQueueSize = 4096
+	trapWorkers   = 4
+)
+
+type trapPacket struct {
+	addr *net.UDPAddr
+	data []byte
+}
+
+var (
+	discardLogger = stdlog.New(ioutil.Discard, "", 0)
+
+	authProtocols = map[string]gosnmp.SnmpV3AuthProtocol{
+		"NoAuth": gosnmp.NoAuth, "MD5": gosnmp.MD5, "SHA": gosnmp.SHA, "SHA224": gosnmp.SHA224,
+		"SHA256": gosnmp.SHA256, "SHA384": gosnmp.SHA384, "SHA512": gosnmp.SHA512,
+	}
+	privProtocols = map[string]gosnmp.SnmpV3PrivProtocol{
+		"NoPriv": gosnmp.NoPriv, "DES": gosnmp.DES, "AES": gosnmp.AES, "AES192": gosnmp.AES192,
+		"AES256": gosnmp.AES256, "AES192C": gosnmp.AES192C, "AES256C": gosnmp.AES256C,
+	}
+)
+
+// TrapServer
+// SNMP v1/v2c/v3 trap receiver
+type TrapServer struct {
+	Config config.SnmptrapdConfig
+	Mibs   *MibTree
+
+	// GetUsmUsers the SNMPv3 users
+	GetUsmUsers func() ([]models.SnmpUsmUser, error)
+	// OnTrap decoded trap hook
+	OnTrap func(trap *models.SnmpTrap)
+
+	usmLock    sync.Mutex
+	usmUsers   []models.SnmpUsmUser
+	usmExpires time.Time
+}
+
+func NewTrapServer(manager *models.ModelManager) *TrapServer {
+	cfg := manager.Config.Snmptrapd
+	mibs := NewMibTree()
+	if cfg.MibDir != "" {
+		if err := mibs.LoadDir(cfg.MibDir); err != nil {
+			log.Warningf("load mibs from %s error, %s", cfg.MibDir, err.Error())
+		}
+	}
+	tm := manager.GetSnmpTrapManager()
+	return &TrapServer{
+		Config:      cfg,
+		Mibs:        mibs,
+		GetUsmUsers: tm.GetEnabledUsmUsers,
+		OnTrap: func(trap *models.SnmpTrap) {
+			tm.CorrelateTrap(trap)
+			tm.AddSnmpTrap(trap)
+		},
+	}
+}
+
+// ListenAndServe
+func (s *TrapServer) ListenAndServe(ctx context.Context) error {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP(s.Config.Host), Port: s.Config.Port})
+	if err != nil {
+		return err
+	}
+	log.Infof("Starting SNMP trap server on %s", conn.LocalAddr())
+	return s.Serve(ctx, conn)
+}
+
+// Serve
+// Read packets until the context is done, the packets are decoded and
+// stored by the workers
+func (s *TrapServer) Serve(ctx context.Context, conn *net.UDPConn) error {
+	go func() {
+		<-ctx.Done()
+		_ = conn.Close()
+	}()
+	queue := make(chan trapPacket, trapQueueSize)
+	var wg sync.WaitGroup
+	for i := 0; i < trapWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range queue {
+				s.handlePacket(p)
+			}
+		}()
+	}
+	defer func() {
+		close(queue)
+		wg.Wait()
+	}()
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, addr, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		packet := make([]byte, n)
+		copy(packet, buf[:n])
+		select {
+		case queue <- trapPacket{addr: addr, data: packet}:
+		default:
+			log.Warningf("snmp trap queue is full, drop the trap from %s", addr)
+		}
+	}
+}
+
+func (s *TrapServer) handlePacket(p trapPacket) {
+	trap, err := s.Decode(p.data, p.addr.IP.String())
+	if err != nil {
+		log.Errorf("decode snmp trap from %s error, %s", p.addr, err.Error())
+		return
+	}
+	if s.Config.Debug {
+		log.Debugf("snmp trap from %s %s %s", p.addr, trap.Version, trap.TrapName)
+	}
+	s.OnTrap(trap)
+}
+
+// packetVersion
+// the version field of the message sequence
+func packetVersion(packet []byte) (gosnmp.SnmpVersion, error) {
+	if len(packet) < 5 || packet[0] != 0x30 {
+		return 0, fmt.Errorf("invalid snmp packet")
+	}
+	pos := 2
+	if packet[1]&0x80 != 0 {
+		pos += int(packet[1] & 0x7f)
+	}
+	if len(packet) < pos+3 || packet[pos] != 0x02 || packet[pos+1] != 0x01 {
+		return 0, fmt.Errorf("invalid snmp version field")
+	}
+	switch v := gosnmp.SnmpVersion(packet[pos+2]); v {
+	case gosnmp.Version1, gosnmp.Version2c, gosnmp.Version3:
+		return v, nil
+	default:
+		return 0, fmt.Errorf("unsupported snmp version %d", v)
+	}
+}
+
+func (s *TrapServer) getUsmUsers() []models.SnmpUsmUser {
+	s.usmLock.Lock()
+	defer s.usmLock.Unlock()
+	if time.Now().After(s.usmExpires) {
+		users, err := s.GetUsmUsers()
+		if err != nil {
+			log.Errorf("query usm users error, %s", err.Error())
+		} else {
+			s.usmUsers = users
+			s.usmExpires = time.Now().Add(usmCacheTTL)
+		}
+	}
+	return s.usmUsers
+}
+
+// unmarshalV3
+// try the USM users until the packet is authentic and decrypted
+func (s *TrapServer) unmarshalV3(packet []byte) (*gosnmp.SnmpPacket, error) {
+	for _, user := range s.getUsmUsers() {
+		params := &gosnmp.GoSNMP{
+			Version:       gosnmp.Version3,
+			SecurityModel: gosnmp.UserSecurityModel,
+			Logger:        discardLogger,
+			SecurityParameters: &gosnmp.UsmSecurityParameters{
+				UserName:                 user.Username,
+				AuthenticationProtocol:   authProtocols[user.AuthProtocol],
+				AuthenticationPassphrase: user.AuthPassphrase,
+				PrivacyProtocol:          privProtocols[user.PrivProtocol],
+				PrivacyPassphrase:        user.PrivPassphrase,
+				Logger:                   discardLogger,
+			},
+		}
+		// authentication and decryption work on the buffer in place
+		result := params.UnmarshalTrap(append([]byte(nil), packet...), true)
+		if result == nil {
+			continue
+		}
+		if sp, ok := result.SecurityParameters.(*gosnmp.UsmSecurityParameters); ok && sp.UserName == user.Username {
+			return result, nil
+		}
+	}
+	return nil, fmt.Errorf("no usm user matches the snmpv3 trap")
+}
+
+// Decode
+// decode the trap packet received from the source ip
+func (s *TrapServer) Decode(packet []byte, source string) (*models.SnmpTrap, error) {
+	version, err := packetVersion(packet)
+	if err != nil {
+		return nil, err
+	}
+	var result *gosnmp.SnmpPacket
+	if version == gosnmp.Version3 {
+		if result, err = s.unmarshalV3(packet); err != nil {
+			return nil, err
+		}
+	} else {
+		params := &gosnmp.GoSNMP{Version: version, Logger: discardLogger}
+		if result = params.UnmarshalTrap(packet, false); result == nil {
+			return nil, fmt.Errorf("invalid snmp %s trap", version)
+		}
+		if s.Config.Community != "" && result.Community != s.Config.Community {
+			return nil, fmt.Errorf("snmp community %s not match", result.Community)
+		}
+	}
+	trap := &models.SnmpTrap{
+		Version:   versionName(version),
+		Community: result.Community,
+		Source:    source,
+		Varbinds:  make([]models.TrapVarbind, 0, len(result.Variables)),
+		Timestamp: time.Now(),
+	}
+	if sp, ok := result.SecurityParameters.(*gosnmp.UsmSecurityParameters); ok && version == gosnmp.Version3 {
+		trap.Username = sp.UserName
+	}
+	if version == gosnmp.Version1 {
+		trap.AgentAddress = result.AgentAddress
+		trap.Uptime = uint32(result.Timestamp)
+		trap.TrapOid = v1TrapOid(result.Enterprise, result.GenericTrap, result.SpecificTrap)
+	}
+	for _, pdu := range result.Variables {
+		name := strings.TrimPrefix(pdu.Name, ".")
+		switch name {
+		case "1.3.6.1.2.1.1.3.0":
+			trap.Uptime = uint32(gosnmp.ToBigInt(pdu.Value).Uint64())
+			continue
+		case "1.3.6.1.6.3.1.1.4.1.0":
+			trap.TrapOid = strings.TrimPrefix(fmt.Sprint(pdu.Value), ".")
+			continue
+		}
+		trap.Varbinds = append(trap.Varbinds, models.TrapVarbind{
+			Oid:   name,
+			Name:  s.Mibs.Translate(name),
+			Type:  pdu.Type.String(),
+			Value: s.formatValue(pdu),
+		})
+	}
+	trap.TrapName = s.Mibs.Translate(trap.TrapOid)
+	return trap, nil
+}
+
+func versionName(v gosnmp.SnmpVersion) string {
+	switch v {
+	case gosnmp.Version1:
+		return "v1"
+	case gosnmp.Version2c:
+		return "v2c"
+	default:
+		return "v3"
+	}
+}
+
+// v1TrapOid
+// RFC 3584 translation of the v1 trap to the snmpTrapOID
+func v1TrapOid(enterprise string, generic, specific int) string {
+	if generic >= 0 && generic < 6 {
+		return fmt.Sprintf("1.3.6.1.6.3.1.1.5.%d", generic+1)
+	}
+	return fmt.Sprintf("%s.0.%d", strings.TrimPrefix(enterprise, "."), specific)
+}
+
+func (s *TrapServer) formatValue(pdu gosnmp.SnmpPDU) string {
+	switch pdu.Type {
+	case gosnmp.OctetString:
+		b, _ := pdu.Value.([]byte)
+		if utf8.Valid(b) && isPrintable(b) {
+			return string(b)
+		}
+		return hex.EncodeToString(b)
+	case gosnmp.ObjectIdentifier:
+		return s.Mibs.Translate(fmt.Sprint(pdu.Value))
+	case gosnmp.Null, gosnmp.NoSuchObject, gosnmp.NoSuchInstance, gosnmp.EndOfMibView:
+		return ""
+	default:
+		return fmt.Sprint(pdu.Value)
+	}
+}
+
+func isPrintable(b []byte) bool {
+	for _, c := range string(b) {
+		if c < 0x20 && c != '\t' && c != '\r' && c != '\n' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,211 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package snmptrapd
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gosnmp/gosnmp"
+
+	"github.com/ca17/teamsacs/config"
+	"github.com/ca17/teamsacs/models"
+)
+
+const testMib = `
+TEAMSACS-TEST-MIB DEFINITIONS ::= BEGIN
+-- test module
+teamsacs OBJECT IDENTIFIER ::= { enterprises 58888 }
+tsNotifications OBJECT IDENTIFIER ::= { teamsacs 0 }
+tsObjects OBJECT IDENTIFIER ::= { teamsacs 1 }
+
+tsPortName OBJECT-TYPE
+    SYNTAX      DisplayString
+    MAX-ACCESS  accessible-for-notify
+    STATUS      current
+    DESCRIPTION "The port name"
+    ::= { tsObjects 1 }
+
+tsPortDown NOTIFICATION-TYPE
+    OBJECTS     { tsPortName }
+    STATUS      current
+    DESCRIPTION "A port is down"
+    ::= { tsNotifications 1 }
+
+tsV1Alarm TRAP-TYPE
+    ENTERPRISE  teamsacs
+    VARIABLES   { tsPortName }
+    DESCRIPTION "v1 alarm"
+    ::= 7
+END
+`
+
+func TestMibTree(t *testing.T) {
+	mibs := NewMibTree()
+	mibs.Load(testMib)
+	tests := map[string]string{
+		"1.3.6.1.4.1.58888.0.1":    "tsPortDown",
+		".1.3.6.1.4.1.58888.1.1.0": "tsPortName.0",
+		"1.3.6.1.4.1.58888.0.7":    "tsV1Alarm",
+		"1.3.6.1.6.3.1.1.5.3":      "linkDown",
+		"1.3.6.1.2.1.2.2.1.1.3":    "ifIndex.3",
+		"1.3.6.1.4.1.9.9.41.2.0.1": "enterprises.9.9.41.2.0.1",
+	}
+	for oid, name := range tests {
+		if got := mibs.Translate(oid); got != name {
+			t.Errorf("translate %s = %s, expected %s", oid, got, name)
+		}
+	}
+	if oid, ok := mibs.Resolve("tsPortDown"); !ok || oid != "1.3.6.1.4.1.58888.0.1" {
+		t.Errorf("resolve tsPortDown = %s", oid)
+	}
+}
+
+func startTrapServer(t *testing.T, community string) (int, chan *models.SnmpTrap, func()) {
+	mibs := NewMibTree()
+	mibs.Load(testMib)
+	traps := make(chan *models.SnmpTrap, 4)
+	s := &TrapServer{
+		Config: config.SnmptrapdConfig{Community: community},
+		Mibs:   mibs,
+		GetUsmUsers: func() ([]models.SnmpUsmUser, error) {
+			return []models.SnmpUsmUser{
+				{Username: "other", AuthProtocol: "MD5", AuthPassphrase: "otherpass1"},
+				{Username: "trapuser", AuthProtocol: "SHA", AuthPassphrase: "authpass123", PrivProtocol: "AES", PrivPassphrase: "privpass123"},
+			}, nil
+		},
+		OnTrap: func(trap *models.SnmpTrap) { traps <- trap },
+	}
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		_ = s.Serve(ctx, conn)
+		close(done)
+	}()
+	return conn.LocalAddr().(*net.UDPAddr).Port, traps, func() {
+		cancel()
+		<-done
+	}
+}
+
+func sendTrap(t *testing.T, client *gosnmp.GoSNMP, trap gosnmp.SnmpTrap) {
+	if err := client.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Conn.Close()
+	if _, err := client.SendTrap(trap); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func receiveTrap(t *testing.T, traps chan *models.SnmpTrap) *models.SnmpTrap {
+	select {
+	case trap := <-traps:
+		return trap
+	case <-time.After(time.Second * 3):
+		t.Fatal("trap not received")
+		return nil
+	}
+}
+
+var v2Varbinds = []gosnmp.SnmpPDU{
+	{Name: ".1.3.6.1.2.1.1.3.0", Type: gosnmp.TimeTicks, Value: uint32(12345)},
+	{Name: ".1.3.6.1.6.3.1.1.4.1.0", Type: gosnmp.ObjectIdentifier, Value: ".1.3.6.1.4.1.58888.0.1"},
+	{Name: ".1.3.6.1.4.1.58888.1.1.0", Type: gosnmp.OctetString, Value: "GigabitEthernet0/0/1"},
+}
+
+func TestV2cTrap(t *testing.T) {
+	port, traps, stop := startTrapServer(t, "public")
+	defer stop()
+	client := &gosnmp.GoSNMP{Target: "127.0.0.1", Port: uint16(port), Version: gosnmp.Version2c,
+		Community: "public", Timeout: time.Second}
+	sendTrap(t, client, gosnmp.SnmpTrap{Variables: v2Varbinds})
+	trap := receiveTrap(t, traps)
+	if trap.Version != "v2c" || trap.Community != "public" || trap.Source != "127.0.0.1" {
+		t.Fatalf("trap header error %+v", trap)
+	}
+	if trap.TrapName != "tsPortDown" || trap.Uptime != 12345 {
+		t.Fatalf("trap oid error %s %d", trap.TrapName, trap.Uptime)
+	}
+	if len(trap.Varbinds) != 1 || trap.Varbinds[0].Name != "tsPortName.0" || trap.Varbinds[0].Value != "GigabitEthernet0/0/1" {
+		t.Fatalf("varbinds error %+v", trap.Varbinds)
+	}
+
+	// wrong community is dropped
+	client.Community = "private"
+	sendTrap(t, client, gosnmp.SnmpTrap{Variables: v2Varbinds})
+	select {
+	case trap := <-traps:
+		t.Fatalf("trap with wrong community received %+v", trap)
+	case <-time.After(time.Millisecond * 200):
+	}
+}
+
+func TestV1Trap(t *testing.T) {
+	port, traps, stop := startTrapServer(t, "")
+	defer stop()
+	client := &gosnmp.GoSNMP{Target: "127.0.0.1", Port: uint16(port), Version: gosnmp.Version1,
+		Community: "public", Timeout: time.Second}
+	sendTrap(t, client, gosnmp.SnmpTrap{
+		Enterprise:   ".1.3.6.1.4.1.58888",
+		AgentAddress: "10.0.0.1",
+		GenericTrap:  6,
+		SpecificTrap: 7,
+		Timestamp:    300,
+		Variables:    []gosnmp.SnmpPDU{{Name: ".1.3.6.1.4.1.58888.1.1.0", Type: gosnmp.OctetString, Value: "eth0"}},
+	})
+	trap := receiveTrap(t, traps)
+	if trap.Version != "v1" || trap.TrapName != "tsV1Alarm" || trap.AgentAddress != "10.0.0.1" || trap.Uptime != 300 {
+		t.Fatalf("v1 trap error %+v", trap)
+	}
+}
+
+func TestV3Trap(t *testing.T) {
+	port, traps, stop := startTrapServer(t, "")
+	defer stop()
+	client := &gosnmp.GoSNMP{Target: "127.0.0.1", Port: uint16(port), Version: gosnmp.Version3,
+		Timeout: time.Second, SecurityModel: gosnmp.UserSecurityModel, MsgFlags: gosnmp.AuthPriv,
+		SecurityParameters: &gosnmp.UsmSecurityParameters{
+			UserName:                 "trapuser",
+			AuthoritativeEngineID:    "\x80\x00\x1f\x88\x80\x01\x02\x03\x04",
+			AuthenticationProtocol:   gosnmp.SHA,
+			AuthenticationPassphrase: "authpass123",
+			PrivacyProtocol:          gosnmp.AES,
+			PrivacyPassphrase:        "privpass123",
+		}}
+	sendTrap(t, client, gosnmp.SnmpTrap{Variables: v2Varbinds})
+	trap := receiveTrap(t, traps)
+	if trap.Version != "v3" || trap.Username != "trapuser" || trap.TrapName != "tsPortDown" {
+		t.Fatalf("v3 trap error %+v", trap)
+	}
+
+	// unknown passphrase is dropped
+	client.SecurityParameters.(*gosnmp.UsmSecurityParameters).AuthenticationPassphrase = "wrongpass1"
+	client.SecurityParameters.(*gosnmp.UsmSecurityParameters).SecretKey = nil
+	sendTrap(t, client, gosnmp.SnmpTrap{Variables: v2Varbinds})
+	select {
+	case trap := <-traps:
+		t.Fatalf("trap with wrong passphrase received %+v", trap)
+	case <-time.After(time.Millisecond * 300):
+	}
+}
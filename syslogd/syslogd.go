@@ -17,6 +17,7 @@
 package syslogd
 
 import (
+	"context"
 	"net"
 	"time"
 
@@ -25,6 +26,7 @@ import (
 	"github.com/influxdata/go-syslog/v3/rfc5424"
 
 	"github.com/ca17/teamsacs/common/log"
+	"github.com/ca17/teamsacs/common/metrics"
 	"github.com/ca17/teamsacs/models"
 )
 
@@ -44,6 +46,19 @@ func NewSyslogServer(manager *models.ModelManager) *SyslogServer {
 	return s
 }
 
+// sourceAddr
+// the sender address, used to find the syslog of a device
+func sourceAddr(remoteaddr net.Addr) string {
+	if remoteaddr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(remoteaddr.String())
+	if err != nil {
+		return remoteaddr.String()
+	}
+	return host
+}
+
 // HandleRfc3164
 // Handling Rfc3164 messages
 func (s SyslogServer) HandleRfc3164(remoteaddr net.Addr, data []byte) {
@@ -57,6 +72,7 @@ func (s SyslogServer) HandleRfc3164(remoteaddr net.Addr, data []byte) {
 	}()
 	message, err := s.Rfc3164Parser.Parse(data)
 	if err != nil {
+		metrics.SyslogDropped.WithLabelValues("rfc3164").Inc()
 		log.Error(err)
 		return
 	}
@@ -74,6 +90,7 @@ func (s SyslogServer) HandleRfc3164(remoteaddr net.Addr, data []byte) {
 			"Timestamp" : *slog.Timestamp,
 			"Hostname" : *slog.Hostname,
 			"Appname" : *slog.Appname,
+			"Source" : sourceAddr(remoteaddr),
 		},
 		Timestamp: time.Now(),
 	})
@@ -92,6 +109,7 @@ func (s SyslogServer) HandleRfc5424(remoteaddr net.Addr, data []byte) {
 	}()
 	message, err := s.Rfc5424Parser.Parse(data)
 	if err != nil {
+		metrics.SyslogDropped.WithLabelValues("rfc5424").Inc()
 		log.Error(err)
 		return
 	}
@@ -111,6 +129,7 @@ func (s SyslogServer) HandleRfc5424(remoteaddr net.Addr, data []byte) {
 			"ProcID" : *slog.ProcID,
 			"MsgID" : *slog.MsgID,
 			"Version" : slog.Version,
+			"Source" : sourceAddr(remoteaddr),
 		},
 		Timestamp: time.Now(),
 	})
@@ -132,71 +151,54 @@ func (s SyslogServer) HandleText(remoteaddr net.Addr, data []byte) {
 		Logtype:   "text",
 		Attrs:     map[string]interface{}{
 			"Message" : message,
+			"Source" : sourceAddr(remoteaddr),
 		},
 		Timestamp: time.Now(),
 	})
 }
 
 
-func (s SyslogServer) StartRfc3164() error {
-	ip := net.ParseIP(s.Manager.Config.Syslogd.Host)
-	port := s.Manager.Config.Syslogd.Rfc3164Port
-	listener, err := net.ListenUDP("udp", &net.UDPAddr{IP: ip, Port: port})
-	if err != nil {
-		return err
-	}
-	for {
-		data := make([]byte, 1024)
-		n, remoteAddr, err := listener.ReadFrom(data)
-		if err != nil {
-			log.Error(err)
-		}
-		var logdata = data[:n]
-		if s.Debug {
-			log.Info(string(logdata))
-		}
-		go s.HandleRfc3164(remoteAddr,logdata)
-	}
+func (s SyslogServer) StartRfc3164(ctx context.Context) error {
+	return s.serveUDP(ctx, "rfc3164", s.Manager.Config.Syslogd.Rfc3164Port, 1024, s.HandleRfc3164)
 }
 
-func (s SyslogServer) StartRfc5424() error {
-	ip := net.ParseIP(s.Manager.Config.Syslogd.Host)
-	port := s.Manager.Config.Syslogd.Rfc5424Port
-	listener, err := net.ListenUDP("udp", &net.UDPAddr{IP: ip, Port: port})
-	if err != nil {
-		return err
-	}
-	for {
-		data := make([]byte, 2048)
-		n, remoteAddr, err := listener.ReadFrom(data)
-		if err != nil {
-			log.Error(err)
-		}
-		var logdata = data[:n]
-		if s.Debug {
-			log.Info(string(logdata))
-		}
-		go s.HandleRfc5424(remoteAddr, logdata)
-	}
+func (s SyslogServer) StartRfc5424(ctx context.Context) error {
+	return s.serveUDP(ctx, "rfc5424", s.Manager.Config.Syslogd.Rfc5424Port, 2048, s.HandleRfc5424)
 }
 
-func (s SyslogServer) StartTextlog() error {
+func (s SyslogServer) StartTextlog(ctx context.Context) error {
+	return s.serveUDP(ctx, "text", s.Manager.Config.Syslogd.TextlogPort, 8912, s.HandleText)
+}
+
+// serveUDP
+// Read messages until the context is done, the listener is closed on shutdown
+func (s SyslogServer) serveUDP(ctx context.Context, name string, port int, bufsize int,
+	handler func(remoteaddr net.Addr, data []byte)) error {
 	ip := net.ParseIP(s.Manager.Config.Syslogd.Host)
-	port := s.Manager.Config.Syslogd.TextlogPort
 	listener, err := net.ListenUDP("udp", &net.UDPAddr{IP: ip, Port: port})
 	if err != nil {
 		return err
 	}
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
 	for {
-		data := make([]byte, 8912)
+		data := make([]byte, bufsize)
 		n, remoteAddr, err := listener.ReadFrom(data)
 		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			metrics.SyslogDropped.WithLabelValues(name).Inc()
 			log.Error(err)
+			continue
 		}
+		metrics.SyslogMessages.WithLabelValues(name).Inc()
 		var logdata = data[:n]
 		if s.Debug {
 			log.Info(string(logdata))
 		}
-		go s.HandleText(remoteAddr, logdata)
+		go handler(remoteAddr, logdata)
 	}
 }
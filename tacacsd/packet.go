@@ -0,0 +1,422 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package tacacsd
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// TACACS+ header fields, RFC 8907
+const (
+	VersionDefault byte = 0xc0
+	VersionOne     byte = 0xc1
+
+	TypeAuthen byte = 0x01
+	TypeAuthor byte = 0x02
+	TypeAcct   byte = 0x03
+
+	FlagUnencrypted   byte = 0x01
+	FlagSingleConnect byte = 0x04
+
+	headerLen  = 12
+	maxBodyLen = 65535
+)
+
+// Authentication
+const (
+	AuthenLogin byte = 0x01
+
+	AuthenTypeASCII byte = 0x01
+	AuthenTypePAP   byte = 0x02
+
+	AuthenStatusPass    byte = 0x01
+	AuthenStatusFail    byte = 0x02
+	AuthenStatusGetData byte = 0x03
+	AuthenStatusGetUser byte = 0x04
+	AuthenStatusGetPass byte = 0x05
+	AuthenStatusError   byte = 0x07
+
+	AuthenReplyFlagNoEcho   byte = 0x01
+	AuthenContinueFlagAbort byte = 0x01
+)
+
+// Authorization
+const (
+	AuthorStatusPassAdd  byte = 0x01
+	AuthorStatusPassRepl byte = 0x02
+	AuthorStatusFail     byte = 0x10
+	AuthorStatusError    byte = 0x11
+)
+
+// Accounting
+const (
+	AcctFlagStart    byte = 0x02
+	AcctFlagStop     byte = 0x04
+	AcctFlagWatchdog byte = 0x08
+
+	AcctStatusSuccess byte = 0x01
+	AcctStatusError   byte = 0x02
+)
+
+// Header
+type Header struct {
+	Version   byte
+	Type      byte
+	SeqNo     byte
+	Flags     byte
+	SessionId uint32
+	Length    uint32
+}
+
+// Packet
+// The header and the clear body
+type Packet struct {
+	Header
+	Body []byte
+}
+
+// obfuscate
+// xor the body with the md5 pad, the same operation decodes the body
+func obfuscate(h Header, key []byte, body []byte) []byte {
+	if h.Flags&FlagUnencrypted != 0 || len(key) == 0 {
+		return body
+	}
+	var prefix = make([]byte, 4, 4+len(key)+2)
+	binary.BigEndian.PutUint32(prefix, h.SessionId)
+	prefix = append(prefix, key...)
+	prefix = append(prefix, h.Version, h.SeqNo)
+	var result = make([]byte, len(body))
+	var pad []byte
+	for i := 0; i < len(body); i += md5.Size {
+		hash := md5.New()
+		hash.Write(prefix)
+		hash.Write(pad)
+		pad = hash.Sum(nil)
+		for j := 0; j < md5.Size && i+j < len(body); j++ {
+			result[i+j] = body[i+j] ^ pad[j]
+		}
+	}
+	return result
+}
+
+// ReadPacket
+// read and decode one packet
+func ReadPacket(r io.Reader, key []byte) (*Packet, error) {
+	var buf = make([]byte, headerLen)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	p := &Packet{Header: Header{
+		Version:   buf[0],
+		Type:      buf[1],
+		SeqNo:     buf[2],
+		Flags:     buf[3],
+		SessionId: binary.BigEndian.Uint32(buf[4:8]),
+		Length:    binary.BigEndian.Uint32(buf[8:12]),
+	}}
+	if p.Version>>4 != 0xc {
+		return nil, fmt.Errorf("unsupported tacacs+ version 0x%x", p.Version)
+	}
+	if p.Length > maxBodyLen {
+		return nil, fmt.Errorf("tacacs+ body length %d too large", p.Length)
+	}
+	body := make([]byte, p.Length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	p.Body = obfuscate(p.Header, key, body)
+	return p, nil
+}
+
+// WritePacket
+// encode and write one packet, the length is set from the body
+func WritePacket(w io.Writer, key []byte, p *Packet) error {
+	p.Length = uint32(len(p.Body))
+	var buf = make([]byte, headerLen, headerLen+len(p.Body))
+	buf[0] = p.Version
+	buf[1] = p.Type
+	buf[2] = p.SeqNo
+	buf[3] = p.Flags
+	binary.BigEndian.PutUint32(buf[4:8], p.SessionId)
+	binary.BigEndian.PutUint32(buf[8:12], p.Length)
+	buf = append(buf, obfuscate(p.Header, key, p.Body)...)
+	_, err := w.Write(buf)
+	return err
+}
+
+var errBodyShort = errors.New("tacacs+ body too short")
+
+// reader
+// sequential reads of the body fields
+type reader struct {
+	buf []byte
+	pos int
+	err error
+}
+
+func (r *reader) byte() byte {
+	if r.err != nil || r.pos+1 > len(r.buf) {
+		r.err = errBodyShort
+		return 0
+	}
+	r.pos++
+	return r.buf[r.pos-1]
+}
+
+func (r *reader) uint16() int {
+	if r.err != nil || r.pos+2 > len(r.buf) {
+		r.err = errBodyShort
+		return 0
+	}
+	r.pos += 2
+	return int(binary.BigEndian.Uint16(r.buf[r.pos-2:]))
+}
+
+func (r *reader) string(n int) string {
+	if r.err != nil || r.pos+n > len(r.buf) {
+		r.err = errBodyShort
+		return ""
+	}
+	r.pos += n
+	return string(r.buf[r.pos-n : r.pos])
+}
+
+// AuthenStart
+type AuthenStart struct {
+	Action  byte
+	PrivLvl byte
+	Type    byte
+	Service byte
+	User    string
+	Port    string
+	RemAddr string
+	Data    string
+}
+
+func DecodeAuthenStart(body []byte) (*AuthenStart, error) {
+	r := &reader{buf: body}
+	s := &AuthenStart{Action: r.byte(), PrivLvl: r.byte(), Type: r.byte(), Service: r.byte()}
+	ul, pl, rl, dl := int(r.byte()), int(r.byte()), int(r.byte()), int(r.byte())
+	s.User, s.Port, s.RemAddr, s.Data = r.string(ul), r.string(pl), r.string(rl), r.string(dl)
+	return s, r.err
+}
+
+func (s *AuthenStart) Encode() []byte {
+	buf := []byte{s.Action, s.PrivLvl, s.Type, s.Service,
+		byte(len(s.User)), byte(len(s.Port)), byte(len(s.RemAddr)), byte(len(s.Data))}
+	buf = append(buf, s.User...)
+	buf = append(buf, s.Port...)
+	buf = append(buf, s.RemAddr...)
+	return append(buf, s.Data...)
+}
+
+// AuthenReply
+type AuthenReply struct {
+	Status    byte
+	Flags     byte
+	ServerMsg string
+	Data      string
+}
+
+func DecodeAuthenReply(body []byte) (*AuthenReply, error) {
+	r := &reader{buf: body}
+	s := &AuthenReply{Status: r.byte(), Flags: r.byte()}
+	ml, dl := r.uint16(), r.uint16()
+	s.ServerMsg, s.Data = r.string(ml), r.string(dl)
+	return s, r.err
+}
+
+func (s *AuthenReply) Encode() []byte {
+	buf := []byte{s.Status, s.Flags, 0, 0, 0, 0}
+	binary.BigEndian.PutUint16(buf[2:], uint16(len(s.ServerMsg)))
+	binary.BigEndian.PutUint16(buf[4:], uint16(len(s.Data)))
+	buf = append(buf, s.ServerMsg...)
+	return append(buf, s.Data...)
+}
+
+// AuthenContinue
+type AuthenContinue struct {
+	UserMsg string
+	Data    string
+	Flags   byte
+}
+
+func DecodeAuthenContinue(body []byte) (*AuthenContinue, error) {
+	r := &reader{buf: body}
+	ml, dl := r.uint16(), r.uint16()
+	s := &AuthenContinue{Flags: r.byte()}
+	s.UserMsg, s.Data = r.string(ml), r.string(dl)
+	return s, r.err
+}
+
+func (s *AuthenContinue) Encode() []byte {
+	buf := []byte{0, 0, 0, 0, s.Flags}
+	binary.BigEndian.PutUint16(buf[0:], uint16(len(s.UserMsg)))
+	binary.BigEndian.PutUint16(buf[2:], uint16(len(s.Data)))
+	buf = append(buf, s.UserMsg...)
+	return append(buf, s.Data...)
+}
+
+// Request
+// The authorization request, the accounting request has the leading flags
+type Request struct {
+	Flags        byte
+	AuthenMethod byte
+	PrivLvl      byte
+	AuthenType   byte
+	Service      byte
+	User         string
+	Port         string
+	RemAddr      string
+	Args         []string
+}
+
+func decodeRequest(r *reader, req *Request) error {
+	req.AuthenMethod, req.PrivLvl, req.AuthenType, req.Service = r.byte(), r.byte(), r.byte(), r.byte()
+	ul, pl, rl, cnt := int(r.byte()), int(r.byte()), int(r.byte()), int(r.byte())
+	var lens = make([]int, cnt)
+	for i := range lens {
+		lens[i] = int(r.byte())
+	}
+	req.User, req.Port, req.RemAddr = r.string(ul), r.string(pl), r.string(rl)
+	for _, l := range lens {
+		req.Args = append(req.Args, r.string(l))
+	}
+	return r.err
+}
+
+func encodeRequest(buf []byte, req *Request) []byte {
+	buf = append(buf, req.AuthenMethod, req.PrivLvl, req.AuthenType, req.Service,
+		byte(len(req.User)), byte(len(req.Port)), byte(len(req.RemAddr)), byte(len(req.Args)))
+	for _, arg := range req.Args {
+		buf = append(buf, byte(len(arg)))
+	}
+	buf = append(buf, req.User...)
+	buf = append(buf, req.Port...)
+	buf = append(buf, req.RemAddr...)
+	for _, arg := range req.Args {
+		buf = append(buf, arg...)
+	}
+	return buf
+}
+
+func DecodeAuthorRequest(body []byte) (*Request, error) {
+	req := new(Request)
+	return req, decodeRequest(&reader{buf: body}, req)
+}
+
+func (req *Request) EncodeAuthor() []byte {
+	return encodeRequest(nil, req)
+}
+
+func DecodeAcctRequest(body []byte) (*Request, error) {
+	r := &reader{buf: body}
+	req := &Request{Flags: r.byte()}
+	return req, decodeRequest(r, req)
+}
+
+func (req *Request) EncodeAcct() []byte {
+	return encodeRequest([]byte{req.Flags}, req)
+}
+
+// Arg
+// the value of an attribute-value pair, both mandatory (=) and optional (*) separators
+func (req *Request) Arg(name string) (string, bool) {
+	for _, arg := range req.Args {
+		if len(arg) > len(name) && arg[:len(name)] == name && (arg[len(name)] == '=' || arg[len(name)] == '*') {
+			return arg[len(name)+1:], true
+		}
+	}
+	return "", false
+}
+
+// Command
+// the shell command line of cmd and cmd-arg, <cr> is omitted
+func (req *Request) Command() string {
+	cmd, _ := req.Arg("cmd")
+	for _, arg := range req.Args {
+		if len(arg) > 8 && arg[:7] == "cmd-arg" && (arg[7] == '=' || arg[7] == '*') && arg[8:] != "<cr>" {
+			cmd += " " + arg[8:]
+		}
+	}
+	return cmd
+}
+
+// AuthorReply
+type AuthorReply struct {
+	Status    byte
+	Args      []string
+	ServerMsg string
+	Data      string
+}
+
+func DecodeAuthorReply(body []byte) (*AuthorReply, error) {
+	r := &reader{buf: body}
+	s := &AuthorReply{Status: r.byte()}
+	cnt, ml, dl := int(r.byte()), r.uint16(), r.uint16()
+	var lens = make([]int, cnt)
+	for i := range lens {
+		lens[i] = int(r.byte())
+	}
+	s.ServerMsg, s.Data = r.string(ml), r.string(dl)
+	for _, l := range lens {
+		s.Args = append(s.Args, r.string(l))
+	}
+	return s, r.err
+}
+
+func (s *AuthorReply) Encode() []byte {
+	buf := []byte{s.Status, byte(len(s.Args)), 0, 0, 0, 0}
+	binary.BigEndian.PutUint16(buf[2:], uint16(len(s.ServerMsg)))
+	binary.BigEndian.PutUint16(buf[4:], uint16(len(s.Data)))
+	for _, arg := range s.Args {
+		buf = append(buf, byte(len(arg)))
+	}
+	buf = append(buf, s.ServerMsg...)
+	buf = append(buf, s.Data...)
+	for _, arg := range s.Args {
+		buf = append(buf, arg...)
+	}
+	return buf
+}
+
+// AcctReply
+type AcctReply struct {
+	Status    byte
+	ServerMsg string
+	Data      string
+}
+
+func DecodeAcctReply(body []byte) (*AcctReply, error) {
+	r := &reader{buf: body}
+	ml, dl := r.uint16(), r.uint16()
+	s := &AcctReply{Status: r.byte()}
+	s.ServerMsg, s.Data = r.string(ml), r.string(dl)
+	return s, r.err
+}
+
+func (s *AcctReply) Encode() []byte {
+	buf := []byte{0, 0, 0, 0, s.Status}
+	binary.BigEndian.PutUint16(buf[0:], uint16(len(s.ServerMsg)))
+	binary.BigEndian.PutUint16(buf[2:], uint16(len(s.Data)))
+	buf = append(buf, s.ServerMsg...)
+	return append(buf, s.Data...)
+}
@@ -0,0 +1,304 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package tacacsd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ca17/teamsacs/common/log"
+	"github.com/ca17/teamsacs/config"
+	"github.com/ca17/teamsacs/models"
+)
+
+const idleTimeout = time.Minute * 5
+
+// ascii login states
+const (
+	stateGetUser = iota
+	stateGetPass
+)
+
+type authenSession struct {
+	state int
+	user  string
+}
+
+// TacacsServer
+// TACACS+ server for device administrators, the shared key is taken from the VPE of the client address
+type TacacsServer struct {
+	Manager *models.ModelManager
+	Config  config.TacacsdConfig
+
+	// GetSecret lookup the shared key by the client ip
+	GetSecret func(ip string) (string, error)
+	// Authenticate the login password
+	Authenticate func(username, password string) error
+	// Authorize returns the privilege level and whether the command is permitted
+	Authorize func(username, cmd string) (int, bool, error)
+	// OnAccounting command accounting hook
+	OnAccounting func(nasip string, req *Request)
+
+	wg sync.WaitGroup
+}
+
+func NewTacacsServer(manager *models.ModelManager) *TacacsServer {
+	tm := manager.GetTacacsManager()
+	return &TacacsServer{
+		Manager: manager,
+		Config:  manager.Config.Tacacsd,
+		GetSecret: func(ip string) (string, error) {
+			vpe, err := manager.GetVpeManager().GetVpeByIpaddr(ip)
+			if err != nil {
+				return "", fmt.Errorf("unauthorized access to device %s", ip)
+			}
+			return vpe.GetTacacsSecret(), nil
+		},
+		Authenticate: tm.Authenticate,
+		Authorize:    tm.Authorize,
+		OnAccounting: func(nasip string, req *Request) {
+			if err := tm.AddTacacsAccounting(NewAccounting(nasip, req)); err != nil {
+				log.Errorf("add tacacs accounting error, %s", err.Error())
+			}
+		},
+	}
+}
+
+// NewAccounting
+// the accounting record of the request
+func NewAccounting(nasip string, req *Request) *models.TacacsAccounting {
+	acct := &models.TacacsAccounting{
+		Username:   req.User,
+		NasAddr:    nasip,
+		Port:       req.Port,
+		RemAddr:    req.RemAddr,
+		PrivLvl:    int(req.PrivLvl),
+		Command:    req.Command(),
+		Args:       req.Args,
+		CreateTime: time.Now(),
+	}
+	acct.TaskId, _ = req.Arg("task_id")
+	acct.Service, _ = req.Arg("service")
+	switch {
+	case req.Flags&AcctFlagStart != 0:
+		acct.Type = "start"
+	case req.Flags&AcctFlagStop != 0:
+		acct.Type = "stop"
+	default:
+		acct.Type = "watchdog"
+	}
+	return acct
+}
+
+// ListenAndServe
+func (s *TacacsServer) ListenAndServe(ctx context.Context) error {
+	ln, err := net.Listen("tcp", net.JoinHostPort(s.Config.Host, strconv.Itoa(s.Config.Port)))
+	if err != nil {
+		return err
+	}
+	log.Infof("Starting Tacacs+ server on %s", ln.Addr())
+	return s.Serve(ctx, ln)
+}
+
+// Serve
+// Accept connections until the context is done
+func (s *TacacsServer) Serve(ctx context.Context, ln net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		_ = ln.Close()
+	}()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				s.wg.Wait()
+				return nil
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			return err
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handleConn(ctx, conn)
+		}()
+	}
+}
+
+func (s *TacacsServer) handleConn(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	nasip, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	secret, err := s.GetSecret(nasip)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	// without a key the packets are clear text
+	if secret == "" {
+		log.Warningf("tacacs+ client %s has no shared key, refused", nasip)
+		return
+	}
+	key := []byte(secret)
+	sessions := make(map[uint32]*authenSession)
+	r := bufio.NewReader(conn)
+	go func() {
+		<-ctx.Done()
+		_ = conn.SetReadDeadline(time.Now())
+	}()
+	for ctx.Err() == nil {
+		_ = conn.SetReadDeadline(time.Now().Add(idleTimeout))
+		p, err := ReadPacket(r, key)
+		if err != nil {
+			return
+		}
+		// a clear packet would skip the shared key
+		if p.Flags&FlagUnencrypted != 0 {
+			log.Warningf("tacacs+ unencrypted packet from %s refused", nasip)
+			return
+		}
+		if s.Config.Debug {
+			log.Debugf("tacacs+ packet from %s type=%d seq=%d session=%d", nasip, p.Type, p.SeqNo, p.SessionId)
+		}
+		var body []byte
+		switch p.Type {
+		case TypeAuthen:
+			body = s.handleAuthen(p, sessions)
+		case TypeAuthor:
+			body = s.handleAuthor(p)
+		case TypeAcct:
+			body = s.handleAcct(nasip, p)
+		}
+		if body == nil {
+			continue
+		}
+		reply := &Packet{Header: Header{
+			Version:   p.Version,
+			Type:      p.Type,
+			SeqNo:     p.SeqNo + 1,
+			Flags:     p.Flags &^ FlagUnencrypted,
+			SessionId: p.SessionId,
+		}, Body: body}
+		if err = WritePacket(conn, key, reply); err != nil {
+			log.Errorf("write tacacs+ reply to %s error, %s", nasip, err.Error())
+			return
+		}
+	}
+}
+
+func authenReply(status byte, msg string) []byte {
+	reply := &AuthenReply{Status: status, ServerMsg: msg}
+	if status == AuthenStatusGetPass {
+		reply.Flags = AuthenReplyFlagNoEcho
+	}
+	return reply.Encode()
+}
+
+func (s *TacacsServer) login(username, password string) []byte {
+	if err := s.Authenticate(username, password); err != nil {
+		log.Errorf("tacacs+ authentication failure, %s", err.Error())
+		return authenReply(AuthenStatusFail, "Authentication failed")
+	}
+	log.Infof("tacacs+ user %s login", username)
+	return authenReply(AuthenStatusPass, "")
+}
+
+// handleAuthen
+// PAP in the start packet, ASCII by GETUSER/GETPASS continues
+func (s *TacacsServer) handleAuthen(p *Packet, sessions map[uint32]*authenSession) []byte {
+	if p.SeqNo == 1 {
+		start, err := DecodeAuthenStart(p.Body)
+		if err != nil {
+			return authenReply(AuthenStatusError, err.Error())
+		}
+		if start.Action != AuthenLogin {
+			return authenReply(AuthenStatusError, "authentication action not support")
+		}
+		switch start.Type {
+		case AuthenTypePAP:
+			return s.login(start.User, start.Data)
+		case AuthenTypeASCII:
+			if start.User == "" {
+				sessions[p.SessionId] = &authenSession{state: stateGetUser}
+				return authenReply(AuthenStatusGetUser, "Username: ")
+			}
+			sessions[p.SessionId] = &authenSession{state: stateGetPass, user: start.User}
+			return authenReply(AuthenStatusGetPass, "Password: ")
+		default:
+			return authenReply(AuthenStatusFail, "authentication type not support")
+		}
+	}
+	session, ok := sessions[p.SessionId]
+	if !ok {
+		return authenReply(AuthenStatusError, "session not exists")
+	}
+	cont, err := DecodeAuthenContinue(p.Body)
+	if err != nil {
+		delete(sessions, p.SessionId)
+		return authenReply(AuthenStatusError, err.Error())
+	}
+	if cont.Flags&AuthenContinueFlagAbort != 0 {
+		delete(sessions, p.SessionId)
+		return nil
+	}
+	if session.state == stateGetUser {
+		session.user = cont.UserMsg
+		session.state = stateGetPass
+		return authenReply(AuthenStatusGetPass, "Password: ")
+	}
+	delete(sessions, p.SessionId)
+	return s.login(session.user, cont.UserMsg)
+}
+
+// handleAuthor
+// the shell start returns the privilege level, commands are checked by the command set
+func (s *TacacsServer) handleAuthor(p *Packet) []byte {
+	req, err := DecodeAuthorRequest(p.Body)
+	if err != nil {
+		return (&AuthorReply{Status: AuthorStatusError, ServerMsg: err.Error()}).Encode()
+	}
+	cmd := strings.TrimSpace(req.Command())
+	priv, ok, err := s.Authorize(req.User, cmd)
+	if err != nil {
+		log.Errorf("tacacs+ authorization error, %s", err.Error())
+		return (&AuthorReply{Status: AuthorStatusFail, ServerMsg: "Authorization failed"}).Encode()
+	}
+	if !ok {
+		log.Warningf("tacacs+ user %s command denied: %s", req.User, cmd)
+		return (&AuthorReply{Status: AuthorStatusFail, ServerMsg: "Command denied"}).Encode()
+	}
+	if cmd == "" {
+		return (&AuthorReply{Status: AuthorStatusPassAdd, Args: []string{fmt.Sprintf("priv-lvl=%d", priv)}}).Encode()
+	}
+	return (&AuthorReply{Status: AuthorStatusPassAdd}).Encode()
+}
+
+func (s *TacacsServer) handleAcct(nasip string, p *Packet) []byte {
+	req, err := DecodeAcctRequest(p.Body)
+	if err != nil {
+		return (&AcctReply{Status: AcctStatusError, ServerMsg: err.Error()}).Encode()
+	}
+	s.OnAccounting(nasip, req)
+	return (&AcctReply{Status: AcctStatusSuccess}).Encode()
+}
@@ -0,0 +1,280 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package tacacsd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ca17/teamsacs/config"
+)
+
+type testClient struct {
+	conn net.Conn
+	r    *bufio.Reader
+	key  []byte
+}
+
+func (c *testClient) exchange(ptype, seq byte, session uint32, body []byte) (*Packet, error) {
+	req := &Packet{Header: Header{Version: VersionDefault, Type: ptype, SeqNo: seq, SessionId: session}, Body: body}
+	if ptype == TypeAuthen {
+		req.Version = VersionOne
+	}
+	if err := WritePacket(c.conn, c.key, req); err != nil {
+		return nil, err
+	}
+	_ = c.conn.SetReadDeadline(time.Now().Add(time.Second * 2))
+	resp, err := ReadPacket(c.r, c.key)
+	if err != nil {
+		return nil, err
+	}
+	if resp.SeqNo != seq+1 || resp.SessionId != session {
+		return nil, fmt.Errorf("reply header error %+v", resp.Header)
+	}
+	return resp, nil
+}
+
+func startTestServer(t *testing.T) (*TacacsServer, string, func()) {
+	var lock sync.Mutex
+	var records []*Request
+	s := &TacacsServer{
+		Config: config.TacacsdConfig{},
+		GetSecret: func(ip string) (string, error) {
+			return "tackey", nil
+		},
+		Authenticate: func(username, password string) error {
+			if username == "admin" && password == "admin123" {
+				return nil
+			}
+			return fmt.Errorf("user:%s password error", username)
+		},
+		Authorize: func(username, cmd string) (int, bool, error) {
+			return 15, cmd == "" || cmd == "show running-config", nil
+		},
+		OnAccounting: func(nasip string, req *Request) {
+			lock.Lock()
+			records = append(records, req)
+			lock.Unlock()
+		},
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.Serve(ctx, ln) }()
+	return s, ln.Addr().String(), func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func dialTest(t *testing.T, addr string) *testClient {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testClient{conn: conn, r: bufio.NewReader(conn), key: []byte("tackey")}
+}
+
+func TestObfuscate(t *testing.T) {
+	h := Header{Version: VersionDefault, Type: TypeAuthen, SeqNo: 1, SessionId: 0x1234}
+	body := []byte("a body longer than one md5 block of sixteen bytes")
+	enc := obfuscate(h, []byte("key"), body)
+	if string(enc) == string(body) {
+		t.Fatal("body not obfuscated")
+	}
+	if string(obfuscate(h, []byte("key"), enc)) != string(body) {
+		t.Fatal("obfuscate is not reversible")
+	}
+	h.Flags = FlagUnencrypted
+	if string(obfuscate(h, []byte("key"), body)) != string(body) {
+		t.Fatal("unencrypted body must not change")
+	}
+}
+
+func TestPapAuthentication(t *testing.T) {
+	_, addr, stop := startTestServer(t)
+	defer stop()
+	c := dialTest(t, addr)
+	defer c.conn.Close()
+
+	for i, tt := range []struct {
+		password string
+		status   byte
+	}{{"admin123", AuthenStatusPass}, {"wrong", AuthenStatusFail}} {
+		start := &AuthenStart{Action: AuthenLogin, PrivLvl: 1, Type: AuthenTypePAP, Service: 1, User: "admin", Port: "tty0", Data: tt.password}
+		resp, err := c.exchange(TypeAuthen, 1, uint32(100+i), start.Encode())
+		if err != nil {
+			t.Fatal(err)
+		}
+		reply, err := DecodeAuthenReply(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reply.Status != tt.status {
+			t.Fatalf("password %s status %d != %d", tt.password, reply.Status, tt.status)
+		}
+	}
+}
+
+func TestUnencryptedRefused(t *testing.T) {
+	_, addr, stop := startTestServer(t)
+	defer stop()
+	c := dialTest(t, addr)
+	defer c.conn.Close()
+
+	start := &AuthenStart{Action: AuthenLogin, PrivLvl: 1, Type: AuthenTypePAP, Service: 1, User: "admin", Port: "tty0", Data: "admin123"}
+	req := &Packet{Header: Header{Version: VersionOne, Type: TypeAuthen, SeqNo: 1, Flags: FlagUnencrypted, SessionId: 300}, Body: start.Encode()}
+	if err := WritePacket(c.conn, c.key, req); err != nil {
+		t.Fatal(err)
+	}
+	_ = c.conn.SetReadDeadline(time.Now().Add(time.Second * 2))
+	if resp, err := ReadPacket(c.r, c.key); err == nil {
+		t.Fatalf("unencrypted packet replied %+v", resp.Header)
+	}
+}
+
+func TestEmptyKeyRefused(t *testing.T) {
+	s := &TacacsServer{
+		Config: config.TacacsdConfig{},
+		GetSecret: func(ip string) (string, error) {
+			return "", nil
+		},
+		Authenticate: func(username, password string) error {
+			return nil
+		},
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.Serve(ctx, ln) }()
+	defer func() {
+		cancel()
+		<-done
+	}()
+	c := dialTest(t, ln.Addr().String())
+	defer c.conn.Close()
+	c.key = nil
+
+	start := &AuthenStart{Action: AuthenLogin, PrivLvl: 1, Type: AuthenTypePAP, Service: 1, User: "admin", Port: "tty0", Data: "admin123"}
+	req := &Packet{Header: Header{Version: VersionOne, Type: TypeAuthen, SeqNo: 1, Flags: FlagUnencrypted, SessionId: 400}, Body: start.Encode()}
+	if err := WritePacket(c.conn, c.key, req); err != nil {
+		t.Fatal(err)
+	}
+	_ = c.conn.SetReadDeadline(time.Now().Add(time.Second * 2))
+	if resp, err := ReadPacket(c.r, c.key); err == nil {
+		t.Fatalf("client without a key replied %+v", resp.Header)
+	}
+}
+
+func TestAsciiAuthentication(t *testing.T) {
+	_, addr, stop := startTestServer(t)
+	defer stop()
+	c := dialTest(t, addr)
+	defer c.conn.Close()
+
+	start := &AuthenStart{Action: AuthenLogin, PrivLvl: 1, Type: AuthenTypeASCII, Service: 1, Port: "vty0"}
+	resp, err := c.exchange(TypeAuthen, 1, 200, start.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply, _ := DecodeAuthenReply(resp.Body); reply.Status != AuthenStatusGetUser {
+		t.Fatalf("expected GETUSER, status %d", reply.Status)
+	}
+	resp, err = c.exchange(TypeAuthen, 3, 200, (&AuthenContinue{UserMsg: "admin"}).Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply, _ := DecodeAuthenReply(resp.Body); reply.Status != AuthenStatusGetPass || reply.Flags&AuthenReplyFlagNoEcho == 0 {
+		t.Fatalf("expected GETPASS with noecho, %+v", reply)
+	}
+	resp, err = c.exchange(TypeAuthen, 5, 200, (&AuthenContinue{UserMsg: "admin123"}).Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply, _ := DecodeAuthenReply(resp.Body); reply.Status != AuthenStatusPass {
+		t.Fatalf("expected PASS, status %d", reply.Status)
+	}
+}
+
+func TestAuthorization(t *testing.T) {
+	_, addr, stop := startTestServer(t)
+	defer stop()
+	c := dialTest(t, addr)
+	defer c.conn.Close()
+
+	tests := []struct {
+		args   []string
+		status byte
+	}{
+		{[]string{"service=shell", "cmd="}, AuthorStatusPassAdd},
+		{[]string{"service=shell", "cmd=show", "cmd-arg=running-config", "cmd-arg=<cr>"}, AuthorStatusPassAdd},
+		{[]string{"service=shell", "cmd=reload", "cmd-arg=<cr>"}, AuthorStatusFail},
+	}
+	for i, tt := range tests {
+		req := &Request{AuthenMethod: 6, PrivLvl: 1, AuthenType: AuthenTypeASCII, Service: 1, User: "admin", Port: "vty0", Args: tt.args}
+		resp, err := c.exchange(TypeAuthor, 1, uint32(300+i), req.EncodeAuthor())
+		if err != nil {
+			t.Fatal(err)
+		}
+		reply, err := DecodeAuthorReply(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reply.Status != tt.status {
+			t.Fatalf("%v status %d != %d", tt.args, reply.Status, tt.status)
+		}
+		if i == 0 && (len(reply.Args) != 1 || reply.Args[0] != "priv-lvl=15") {
+			t.Fatalf("shell start args %v", reply.Args)
+		}
+	}
+}
+
+func TestAccounting(t *testing.T) {
+	_, addr, stop := startTestServer(t)
+	defer stop()
+	c := dialTest(t, addr)
+	defer c.conn.Close()
+
+	req := &Request{Flags: AcctFlagStop, AuthenMethod: 6, PrivLvl: 15, AuthenType: AuthenTypeASCII, Service: 1,
+		User: "admin", Port: "vty0", RemAddr: "10.0.0.2",
+		Args: []string{"task_id=12", "service=shell", "cmd=configure", "cmd-arg=terminal", "cmd-arg=<cr>"}}
+	resp, err := c.exchange(TypeAcct, 1, 400, req.EncodeAcct())
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply, err := DecodeAcctReply(resp.Body)
+	if err != nil || reply.Status != AcctStatusSuccess {
+		t.Fatalf("accounting reply %+v %v", reply, err)
+	}
+	acct := NewAccounting("127.0.0.1", req)
+	if acct.Type != "stop" || acct.Command != "configure terminal" || acct.TaskId != "12" {
+		t.Fatalf("accounting record error %+v", acct)
+	}
+}